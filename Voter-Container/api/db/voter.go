@@ -149,8 +149,13 @@ func (v *Voters) DeleteVoter(id uint64) error {
 // DeleteAll removes all items from the DB.
 // It will be exposed via a DELETE /voters endpoint
 func (v *Voters) DeleteAll() error {
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := v.cacheClient.Keys(v.context, pattern).Result()
+	ks, err := v.scanKeys()
+	if err != nil {
+		return err
+	}
+	if len(ks) == 0 {
+		return nil
+	}
 	//Note delete can take a collection of keys.  In go we can
 	//expand a slice into individual arguments by using the ...
 	//operator
@@ -189,9 +194,11 @@ func (v *Voters) GetAllItems() ([]Voter, error) {
 	var voters []Voter
 	var voter Voter
 
-	//Now lets iterate over our map and add each item to our slice
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := v.cacheClient.Keys(v.context, pattern).Result()
+	//Now lets iterate over our keys and add each item to our slice
+	ks, err := v.scanKeys()
+	if err != nil {
+		return nil, err
+	}
 	for _, key := range ks {
 		err := v.getItemFromRedis(key, &voter)
 		if err != nil {
@@ -204,6 +211,17 @@ func (v *Voters) GetAllItems() ([]Voter, error) {
 	return voters, nil
 }
 
+// scanKeys returns all voter keys. It uses SCAN rather than KEYS, so redis
+// is not blocked while a large keyspace is walked.
+func (v *Voters) scanKeys() ([]string, error) {
+	var ks []string
+	iter := v.cacheClient.Scan(v.context, 0, RedisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(v.context) {
+		ks = append(ks, iter.Val())
+	}
+	return ks, iter.Err()
+}
+
 func (v *Voters) GetPoll(id uint64, pollsid uint64) (voterPoll, error) {
 	// Check if item exists before trying to get it
 	// this is a good practice, return an error if the
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The states of a Breaker. It is closed while the api works. After
+// Threshold failures in a row it opens and calls fail straight away. Once
+// Cooldown has passed it is half open and lets a single call through: if
+// that works it closes again, otherwise it opens for another Cooldown.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Breaker is a circuit breaker for one api, it is safe for concurrent use
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// a trial call is running while half open
+	trial bool
+}
+
+// NewBreaker returns a closed breaker that opens after threshold failures
+// in a row and stays open for cooldown
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// CircuitOpenError is returned instead of calling an api whose breaker is open
+type CircuitOpenError struct {
+	Breaker    string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry after %s", e.Breaker, e.RetryAfter)
+}
+
+// RetryAfter returns how long to wait if err is a *CircuitOpenError
+func RetryAfter(err error) (time.Duration, bool) {
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return open.RetryAfter, true
+	}
+	return 0, false
+}
+
+// BreakerStatus is a snapshot of a breaker, for health checks
+type BreakerStatus struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	// seconds until a call is let through again, only while open
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{Name: b.name, State: b.currentState(), Failures: b.failures}
+	if status.State == BreakerOpen {
+		status.RetryAfter = int((b.remaining() + time.Second - 1) / time.Second)
+	}
+	return status
+}
+
+// currentState moves an open breaker to half open once the cooldown is over
+func (b *Breaker) currentState() string {
+	if b.state == BreakerOpen && b.remaining() <= 0 {
+		b.state = BreakerHalfOpen
+		b.trial = false
+	}
+	return b.state
+}
+
+func (b *Breaker) remaining() time.Duration {
+	return b.cooldown - time.Since(b.openedAt)
+}
+
+// allow returns a *CircuitOpenError if the call may not go ahead
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerOpen:
+		return &CircuitOpenError{Breaker: b.name, RetryAfter: b.remaining()}
+	case BreakerHalfOpen:
+		if b.trial {
+			// another call is already trying the api
+			return &CircuitOpenError{Breaker: b.name, RetryAfter: time.Second}
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// release lets another trial call through when a call that allow let
+// through ended without an outcome, because its context was cancelled
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// record counts the outcome of a call that allow let through
+func (b *Breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.trial = false
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.trial = false
+	}
+}
@@ -0,0 +1,334 @@
+// Package client is a typed Go client for the voter, poll and votes apis.
+// There is a method for every route the three services register, except
+// /crash and /polls/:pollId/results/ws, the websocket variant of
+// StreamResults. Every call takes a context, and a response other than 2xx is
+// returned as an *APIError carrying the status and body. A Breaker can be
+// set to stop calling an api that keeps failing.
+//
+//	c := client.New(client.Config{
+//		PollsURL:  "http://localhost:1082/polls",
+//		VotersURL: "http://localhost:1081/voters",
+//		VotesURL:  "http://localhost:1080/votes",
+//	})
+//	poll, err := c.GetPoll(ctx, 1, nil)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/schema"
+	"github.com/go-resty/resty/v2"
+)
+
+// Config holds the urls of the three collections and the retry settings.
+// Only the urls of the apis that are called have to be set.
+type Config struct {
+	PollsURL  string // e.g. http://localhost:1082/polls
+	VotersURL string // e.g. http://localhost:1081/voters
+	VotesURL  string // e.g. http://localhost:1080/votes
+	// the poll api serves the webhooks, e.g. http://localhost:1082/webhooks
+	WebhooksURL string
+
+	// Timeout limits each attempt, 0 means no limit
+	Timeout time.Duration
+
+	// Retries is how many times a failed GET is tried again. A request is
+	// retried when it could not be sent or the api answered 502, 503 or 504.
+	Retries int
+	// RetryIdempotent also retries PUT and DELETE. POSTs are only retried
+	// when they carry an Idempotency-Key, see CreatePoll.
+	RetryIdempotent bool
+	// RetryWait is the wait before the first retry, it doubles up to
+	// RetryMaxWait. Each wait is picked at random between half and all of
+	// it, so clients that failed together do not retry together.
+	RetryWait    time.Duration
+	RetryMaxWait time.Duration
+
+	// Breaker, if set, fails calls fast with a *CircuitOpenError while the
+	// api is down. Use one breaker per api.
+	Breaker *Breaker
+}
+
+type Client struct {
+	config Config
+	http   *resty.Client
+}
+
+func New(config Config) *Client {
+	httpClient := resty.New().
+		SetTimeout(config.Timeout).
+		SetRetryCount(config.Retries)
+	if config.RetryWait > 0 {
+		httpClient.SetRetryWaitTime(config.RetryWait)
+	}
+	if config.RetryMaxWait > 0 {
+		httpClient.SetRetryMaxWaitTime(config.RetryMaxWait)
+	}
+
+	return &Client{config: config, http: httpClient}
+}
+
+// APIError is returned when an api answers with a status other than 2xx
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if m := e.Message(); m != "" {
+		msg += ": " + m
+	}
+	return msg
+}
+
+// Message is the error message the api sent, the apis use either "error",
+// "msg" or "message" for it
+func (e *APIError) Message() string {
+	var body map[string]any
+	if json.Unmarshal(e.Body, &body) != nil {
+		return strings.TrimSpace(string(e.Body))
+	}
+	for _, key := range []string{"error", "msg", "message"} {
+		if m, ok := body[key].(string); ok {
+			return m
+		}
+	}
+	return ""
+}
+
+// IsNotFound reports whether err is an *APIError with status 404
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// StatusCode returns the status of an *APIError, or 0 for any other error
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// GetOptions are the ?embed= and ?fields= query parameters of GET routes.
+// Empty values are left out, so the api uses its defaults.
+type GetOptions struct {
+	Embed  string // none, voter, poll or all
+	Fields []string
+}
+
+// ListOptions adds ?limit= and ?cursor= to GetOptions. A cursor of 0 asks
+// for the first page.
+type ListOptions struct {
+	GetOptions
+	Limit  int
+	Cursor int
+}
+
+func (o *GetOptions) query() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+	if o.Embed != "" {
+		q.Set("embed", o.Embed)
+	}
+	if len(o.Fields) > 0 {
+		q.Set("fields", strings.Join(o.Fields, ","))
+	}
+	return q
+}
+
+func (o *ListOptions) query() url.Values {
+	if o == nil {
+		return url.Values{}
+	}
+	q := o.GetOptions.query()
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Cursor > 0 {
+		q.Set("cursor", strconv.Itoa(o.Cursor))
+	}
+	return q
+}
+
+// Page is one page of a list route
+type Page[T any] struct {
+	Items []T
+	Links schema.Links
+	Total int
+}
+
+// NextCursor returns the cursor of the next page, false on the last page
+func (p Page[T]) NextCursor() (int, bool) {
+	if p.Links.Next == nil {
+		return 0, false
+	}
+	next, err := url.Parse(p.Links.Next.Href)
+	if err != nil {
+		return 0, false
+	}
+	cursor, err := strconv.Atoi(next.Query().Get("cursor"))
+	if err != nil {
+		return 0, false
+	}
+	return cursor, true
+}
+
+// Health is the body of the /health routes
+type Health map[string]any
+
+// DeleteResult is the body of DELETE /polls/:pollId and /voters/:voterId.
+// Cascade is only set when the delete was made with cascade.
+type DeleteResult struct {
+	Message string         `json:"message"`
+	Cascade *CascadeReport `json:"cascade,omitempty"`
+}
+
+func (d *DeleteResult) UnmarshalJSON(data []byte) error {
+	// the voter api calls the message "msg"
+	var body struct {
+		Message string         `json:"message"`
+		Msg     string         `json:"msg"`
+		Cascade *CascadeReport `json:"cascade"`
+	}
+	err := json.Unmarshal(data, &body)
+	if err != nil {
+		return err
+	}
+
+	d.Message = body.Message
+	if d.Message == "" {
+		d.Message = body.Msg
+	}
+	d.Cascade = body.Cascade
+	return nil
+}
+
+// CascadeReport lists what the votes api removed or changed when deleting
+// the votes of a poll or voter
+type CascadeReport struct {
+	VotesDeleted  []int    `json:"votesDeleted"`
+	VotersUpdated []int    `json:"votersUpdated"`
+	PollsUpdated  []int    `json:"pollsUpdated"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// request is one call to an api. The url may hold {name} placeholders that
+// are filled from params.
+type request struct {
+	method string
+	url    string
+	params map[string]string
+	query  url.Values
+	body   any
+	// sent as the Idempotency-Key header if set
+	idempotencyKey string
+}
+
+func id(n int) string {
+	return strconv.Itoa(n)
+}
+
+func (c *Client) do(ctx context.Context, r request, result any) error {
+	req := c.http.R().
+		SetContext(ctx).
+		SetPathParams(r.params).
+		SetQueryParamsFromValues(r.query)
+	if r.body != nil {
+		req.SetBody(r.body)
+	}
+	if r.idempotencyKey != "" {
+		req.SetHeader("Idempotency-Key", r.idempotencyKey)
+	}
+
+	retry := r.method == http.MethodGet ||
+		(c.config.RetryIdempotent && (r.method == http.MethodPut || r.method == http.MethodDelete)) ||
+		(r.method == http.MethodPost && r.idempotencyKey != "")
+	req.AddRetryCondition(func(resp *resty.Response, err error) bool {
+		if !retry {
+			return false
+		}
+		if err != nil {
+			return true
+		}
+		switch resp.StatusCode() {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		case http.StatusConflict:
+			// the first request with the same Idempotency-Key is still running,
+			// other conflicts come without Retry-After
+			return r.idempotencyKey != "" && resp.Header().Get("Retry-After") != ""
+		}
+		return false
+	})
+
+	breaker := c.config.Breaker
+	if breaker != nil {
+		err := breaker.allow()
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := req.Execute(r.method, r.url)
+	if breaker != nil {
+		if ctx.Err() != nil {
+			breaker.release()
+		} else {
+			// errors of the api itself count, a 4xx answer is the api working
+			breaker.record(err == nil && resp.StatusCode() < http.StatusInternalServerError)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return &APIError{
+			Method:     r.method,
+			URL:        resp.Request.URL,
+			StatusCode: resp.StatusCode(),
+			Body:       resp.Body(),
+		}
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Body(), result)
+}
+
+// list fetches a page of a list route, name is the key of the items in _embedded
+func list[T any](ctx context.Context, c *Client, url string, name string, opts *ListOptions) (Page[T], error) {
+	var body struct {
+		Embedded map[string][]T `json:"_embedded"`
+		Links    schema.Links   `json:"_links"`
+		Meta     schema.Meta    `json:"_meta"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, url: url, query: opts.query()}, &body)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Items: body.Embedded[name], Links: body.Links}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if body.Meta.Total != nil {
+		page.Total = *body.Meta.Total
+	}
+	return page, nil
+}
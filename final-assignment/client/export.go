@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ExportResults calls GET /polls/:pollId/results?format= and copies the file
+// to w. format is one of schema.ExportCSV, ExportNDJSON or ExportXLSX.
+func (c *Client) ExportResults(ctx context.Context, pollId int, format string, w io.Writer) error {
+	return c.download(ctx, c.config.PollsURL+"/"+id(pollId)+"/results?format="+url.QueryEscape(format), w)
+}
+
+// ExportVotes calls GET /votes/polls/:pollId/export?format= and copies the
+// file to w as it arrives, a row per vote
+func (c *Client) ExportVotes(ctx context.Context, pollId int, format string, w io.Writer) error {
+	return c.download(ctx, c.config.VotesURL+"/polls/"+id(pollId)+"/export?format="+url.QueryEscape(format), w)
+}
+
+// download copies the body of a GET to w. Like StreamResults it does not
+// use Config.Timeout or the retries, a large export takes as long as it
+// takes.
+func (c *Client) download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &APIError{Method: http.MethodGet, URL: url, StatusCode: resp.StatusCode, Body: body}
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
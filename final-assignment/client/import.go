@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"drexel.edu/schema"
+)
+
+// ImportVoters calls POST /voters/import with the rows read from r, in
+// format schema.ExportCSV or ExportNDJSON. With dryRun the rows are only
+// checked.
+func (c *Client) ImportVoters(ctx context.Context, r io.Reader, format string, dryRun bool) (schema.ImportReport, error) {
+	return c.importRows(ctx, c.config.VotersURL+"/import", r, format, dryRun)
+}
+
+// ImportPolls calls POST /polls/import, see ImportVoters
+func (c *Client) ImportPolls(ctx context.Context, r io.Reader, format string, dryRun bool) (schema.ImportReport, error) {
+	return c.importRows(ctx, c.config.PollsURL+"/import", r, format, dryRun)
+}
+
+// ImportVotes calls POST /votes/import, see ImportVoters
+func (c *Client) ImportVotes(ctx context.Context, r io.Reader, format string, dryRun bool) (schema.ImportReport, error) {
+	return c.importRows(ctx, c.config.VotesURL+"/import", r, format, dryRun)
+}
+
+// importRows streams r to an import route. Like download it does not use
+// Config.Timeout or the retries, the body cannot be sent twice.
+func (c *Client) importRows(ctx context.Context, route string, r io.Reader, format string, dryRun bool) (schema.ImportReport, error) {
+	var report schema.ImportReport
+	q := url.Values{}
+	q.Set("format", format)
+	if dryRun {
+		q.Set("dryRun", "true")
+	}
+	route += "?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, route, r)
+	if err != nil {
+		return report, err
+	}
+	req.Header.Set("Content-Type", schema.ExportContentType(format))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return report, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return report, &APIError{Method: http.MethodPost, URL: route, StatusCode: resp.StatusCode, Body: body}
+	}
+
+	err = json.Unmarshal(body, &report)
+	return report, err
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"drexel.edu/schema"
+)
+
+// Results is the body of GET /polls/:pollId/results
+type Results struct {
+	Results  []schema.Results        `json:"results"`
+	Outcome  schema.Outcome          `json:"outcome"`
+	State    string                  `json:"state"`
+	Snapshot *schema.ResultsSnapshot `json:"snapshot,omitempty"`
+	Meta     schema.Meta             `json:"_meta"`
+	Links    schema.Links            `json:"_links"`
+}
+
+// PollsHealth calls GET /polls/health
+func (c *Client) PollsHealth(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.do(ctx, request{method: http.MethodGet, url: c.config.PollsURL + "/health"}, &health)
+	return health, err
+}
+
+// GetPoll calls GET /polls/:pollId, opts may be nil
+func (c *Client) GetPoll(ctx context.Context, pollId int, opts *GetOptions) (schema.Poll, error) {
+	var poll schema.Poll
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}",
+		params: map[string]string{"pollId": id(pollId)},
+		query:  opts.query(),
+	}, &poll)
+	return poll, err
+}
+
+// GetResults calls GET /polls/:pollId/results. The outcome is counted
+// with the tallier called tally, or the poll's own when tally is "".
+func (c *Client) GetResults(ctx context.Context, pollId int, tally string) (Results, error) {
+	query := url.Values{}
+	if tally != "" {
+		query.Set("tally", tally)
+	}
+
+	var results Results
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/results",
+		params: map[string]string{"pollId": id(pollId)},
+		query:  query,
+	}, &results)
+	return results, err
+}
+
+// ListPolls calls GET /polls, opts may be nil
+func (c *Client) ListPolls(ctx context.Context, opts *ListOptions) (Page[schema.Poll], error) {
+	return list[schema.Poll](ctx, c, c.config.PollsURL, "polls", opts)
+}
+
+// PostPoll calls POST /polls/:pollId with poll.Id
+func (c *Client) PostPoll(ctx context.Context, poll schema.Poll) (schema.Poll, error) {
+	var created schema.Poll
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.PollsURL + "/{pollId}",
+		params: map[string]string{"pollId": id(poll.Id)},
+		body:   poll,
+	}, &created)
+	return created, err
+}
+
+// CreatePoll calls POST /polls, the api picks the id. With an
+// idempotencyKey the call is retried like a GET, and a retry that reaches
+// the api twice still creates a single poll. Pass "" to send no key.
+func (c *Client) CreatePoll(ctx context.Context, poll schema.Poll, idempotencyKey string) (schema.Poll, error) {
+	var created schema.Poll
+	err := c.do(ctx, request{
+		method:         http.MethodPost,
+		url:            c.config.PollsURL,
+		body:           poll,
+		idempotencyKey: idempotencyKey,
+	}, &created)
+	return created, err
+}
+
+// UpdatePoll calls PUT /polls/:pollId with poll.Id, and returns the poll
+// as it is after the update
+func (c *Client) UpdatePoll(ctx context.Context, poll schema.Poll) (schema.Poll, error) {
+	var updated schema.Poll
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		url:    c.config.PollsURL + "/{pollId}",
+		params: map[string]string{"pollId": id(poll.Id)},
+		body:   poll,
+	}, &updated)
+	return updated, err
+}
+
+// UpdateOptionCounts calls PUT /polls/counts/:pollId with poll.Id
+func (c *Client) UpdateOptionCounts(ctx context.Context, poll schema.Poll) (schema.Poll, error) {
+	var updated schema.Poll
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		url:    c.config.PollsURL + "/counts/{pollId}",
+		params: map[string]string{"pollId": id(poll.Id)},
+		body:   poll,
+	}, &updated)
+	return updated, err
+}
+
+// IncrementOptionCount calls POST /polls/:pollId/results/:option/increment,
+// option is the option id and by may be negative. With an idempotencyKey
+// the call is retried like a GET, and the api counts it once. Pass "" to
+// send no key.
+func (c *Client) IncrementOptionCount(ctx context.Context, pollId int, option int, by int, idempotencyKey string) (schema.Poll, error) {
+	return c.changeOptionCount(ctx, pollId, option, "increment", map[string]any{"by": by}, idempotencyKey)
+}
+
+// UndoOptionCount takes back the IncrementOptionCount made with
+// idempotencyKey, with the same option and by. If the increment was not
+// made, it is not made later either.
+func (c *Client) UndoOptionCount(ctx context.Context, pollId int, option int, by int, idempotencyKey string) (schema.Poll, error) {
+	return c.changeOptionCount(ctx, pollId, option, "increment", map[string]any{"by": by, "undo": true}, idempotencyKey)
+}
+
+// MoveOptionCount calls POST /polls/:pollId/results/:option/move, it moves
+// one vote from the option with id option to the one with id to. It takes
+// an idempotencyKey like IncrementOptionCount.
+func (c *Client) MoveOptionCount(ctx context.Context, pollId int, option int, to int, idempotencyKey string) (schema.Poll, error) {
+	return c.changeOptionCount(ctx, pollId, option, "move", map[string]any{"to": to}, idempotencyKey)
+}
+
+// UndoMoveOptionCount takes back the MoveOptionCount made with
+// idempotencyKey, with the same option and to
+func (c *Client) UndoMoveOptionCount(ctx context.Context, pollId int, option int, to int, idempotencyKey string) (schema.Poll, error) {
+	return c.changeOptionCount(ctx, pollId, option, "move", map[string]any{"to": to, "undo": true}, idempotencyKey)
+}
+
+func (c *Client) changeOptionCount(ctx context.Context, pollId int, option int, action string, body map[string]any, idempotencyKey string) (schema.Poll, error) {
+	var poll schema.Poll
+	err := c.do(ctx, request{
+		method:         http.MethodPost,
+		url:            c.config.PollsURL + "/{pollId}/results/{option}/" + action,
+		params:         map[string]string{"pollId": id(pollId), "option": id(option)},
+		body:           body,
+		idempotencyKey: idempotencyKey,
+	}, &poll)
+	return poll, err
+}
+
+// OpenPoll calls POST /polls/:pollId/open
+func (c *Client) OpenPoll(ctx context.Context, pollId int) (schema.Poll, error) {
+	return c.changePollState(ctx, pollId, "open")
+}
+
+// ClosePoll calls POST /polls/:pollId/close, the poll keeps a snapshot of
+// its results
+func (c *Client) ClosePoll(ctx context.Context, pollId int) (schema.Poll, error) {
+	return c.changePollState(ctx, pollId, "close")
+}
+
+// ArchivePoll calls POST /polls/:pollId/archive
+func (c *Client) ArchivePoll(ctx context.Context, pollId int) (schema.Poll, error) {
+	return c.changePollState(ctx, pollId, "archive")
+}
+
+func (c *Client) changePollState(ctx context.Context, pollId int, action string) (schema.Poll, error) {
+	var poll schema.Poll
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.PollsURL + "/{pollId}/" + action,
+		params: map[string]string{"pollId": id(pollId)},
+	}, &poll)
+	return poll, err
+}
+
+// GetRevisions calls GET /polls/:pollId/revisions, oldest first
+func (c *Client) GetRevisions(ctx context.Context, pollId int) ([]schema.PollRevision, error) {
+	var list struct {
+		Embedded struct {
+			Revisions []schema.PollRevision `json:"revisions"`
+		} `json:"_embedded"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/revisions",
+		params: map[string]string{"pollId": id(pollId)},
+	}, &list)
+	return list.Embedded.Revisions, err
+}
+
+// GetRevision calls GET /polls/:pollId/revisions/:rev
+func (c *Client) GetRevision(ctx context.Context, pollId int, rev int) (schema.PollRevision, error) {
+	var revision schema.PollRevision
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/revisions/{rev}",
+		params: map[string]string{"pollId": id(pollId), "rev": id(rev)},
+	}, &revision)
+	return revision, err
+}
+
+// DiffRevisions calls GET /polls/:pollId/revisions/:rev/diff?from=, which
+// lists what changed from the revision from to the revision to
+func (c *Client) DiffRevisions(ctx context.Context, pollId int, from int, to int) (schema.RevisionDiff, error) {
+	var diff schema.RevisionDiff
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/revisions/{rev}/diff",
+		params: map[string]string{"pollId": id(pollId), "rev": id(to)},
+		query:  url.Values{"from": {id(from)}},
+	}, &diff)
+	return diff, err
+}
+
+// GetLedger calls GET /polls/:pollId/ledger, oldest entry first
+func (c *Client) GetLedger(ctx context.Context, pollId int) ([]schema.LedgerEntry, error) {
+	var list struct {
+		Embedded struct {
+			Entries []schema.LedgerEntry `json:"entries"`
+		} `json:"_embedded"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/ledger",
+		params: map[string]string{"pollId": id(pollId)},
+	}, &list)
+	return list.Embedded.Entries, err
+}
+
+// VerifyLedger calls GET /polls/:pollId/ledger/verify. A ledger that does
+// not verify is not an error, see LedgerVerification.Valid.
+func (c *Client) VerifyLedger(ctx context.Context, pollId int) (schema.LedgerVerification, error) {
+	var verification schema.LedgerVerification
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/ledger/verify",
+		params: map[string]string{"pollId": id(pollId)},
+	}, &verification)
+	return verification, err
+}
+
+// Recount calls POST /polls/:pollId/recount, with ?repair=true if repair is
+// set
+func (c *Client) Recount(ctx context.Context, pollId int, repair bool) (schema.Recount, error) {
+	q := url.Values{}
+	if repair {
+		q.Set("repair", "true")
+	}
+	var recount schema.Recount
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.PollsURL + "/{pollId}/recount",
+		params: map[string]string{"pollId": id(pollId)},
+		query:  q,
+	}, &recount)
+	return recount, err
+}
+
+// PollAnalytics calls GET /polls/:pollId/analytics, the timeline is by
+// interval, or by hour if it is empty
+func (c *Client) PollAnalytics(ctx context.Context, pollId int, interval string) (schema.PollAnalytics, error) {
+	var analytics schema.PollAnalytics
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.PollsURL + "/{pollId}/analytics",
+		params: map[string]string{"pollId": id(pollId)},
+		query:  intervalQuery(interval),
+	}, &analytics)
+	return analytics, err
+}
+
+func intervalQuery(interval string) url.Values {
+	q := url.Values{}
+	if interval != "" {
+		q.Set("interval", interval)
+	}
+	return q
+}
+
+// DeletePoll calls DELETE /polls/:pollId, with ?cascade=true if cascade is set
+func (c *Client) DeletePoll(ctx context.Context, pollId int, cascade bool) (DeleteResult, error) {
+	var result DeleteResult
+	err := c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.PollsURL + "/{pollId}",
+		params: map[string]string{"pollId": id(pollId)},
+		query:  cascadeQuery(cascade),
+	}, &result)
+	return result, err
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"drexel.edu/schema"
+)
+
+// StreamResults calls GET /polls/:pollId/results/stream and calls fn with
+// every update until ctx is done, fn returns an error, or the poll is
+// deleted. Pass the id of the last update seen as lastEventId to skip the
+// results it already has, or -1. The return value is the id of the last
+// update, so the stream can be resumed with it.
+//
+// The stream does not use Config.Timeout or the retries, it stays open
+// until it ends.
+func (c *Client) StreamResults(ctx context.Context, pollId int, lastEventId int64, fn func(schema.ResultsUpdate) error) (int64, error) {
+	url := c.config.PollsURL + "/" + id(pollId) + "/results/stream"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return lastEventId, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventId >= 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventId, 10))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return lastEventId, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return lastEventId, &APIError{Method: http.MethodGet, URL: url, StatusCode: resp.StatusCode, Body: body}
+	}
+
+	// an event is a group of "field: value" lines ended by an empty line,
+	// lines starting with ":" are heartbeats
+	var event, data string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data += value
+			}
+			continue
+		}
+
+		switch event {
+		case "results":
+			var update schema.ResultsUpdate
+			err = json.Unmarshal([]byte(data), &update)
+			if err != nil {
+				return lastEventId, err
+			}
+			lastEventId = update.Id
+			err = fn(update)
+			if err != nil {
+				return lastEventId, err
+			}
+		case "deleted":
+			return lastEventId, nil
+		}
+		event, data = "", ""
+	}
+
+	if ctx.Err() != nil {
+		return lastEventId, ctx.Err()
+	}
+	return lastEventId, scanner.Err()
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"drexel.edu/schema"
+)
+
+// VotersHealth calls GET /voters/health
+func (c *Client) VotersHealth(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.do(ctx, request{method: http.MethodGet, url: c.config.VotersURL + "/health"}, &health)
+	return health, err
+}
+
+// GetVoter calls GET /voters/:voterId, opts may be nil
+func (c *Client) GetVoter(ctx context.Context, voterId int, opts *GetOptions) (schema.Voter, error) {
+	var voter schema.Voter
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.VotersURL + "/{voterId}",
+		params: map[string]string{"voterId": id(voterId)},
+		query:  opts.query(),
+	}, &voter)
+	return voter, err
+}
+
+// ListVoters calls GET /voters, opts may be nil
+func (c *Client) ListVoters(ctx context.Context, opts *ListOptions) (Page[schema.Voter], error) {
+	return list[schema.Voter](ctx, c, c.config.VotersURL, "voters", opts)
+}
+
+// PostVoter calls POST /voters/:voterId with voter.Id
+func (c *Client) PostVoter(ctx context.Context, voter schema.Voter) (schema.Voter, error) {
+	var created schema.Voter
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.VotersURL + "/{voterId}",
+		params: map[string]string{"voterId": id(voter.Id)},
+		body:   voter,
+	}, &created)
+	return created, err
+}
+
+// CreateVoter calls POST /voters, the api picks the id. idempotencyKey
+// works as in CreatePoll.
+func (c *Client) CreateVoter(ctx context.Context, voter schema.Voter, idempotencyKey string) (schema.Voter, error) {
+	var created schema.Voter
+	err := c.do(ctx, request{
+		method:         http.MethodPost,
+		url:            c.config.VotersURL,
+		body:           voter,
+		idempotencyKey: idempotencyKey,
+	}, &created)
+	return created, err
+}
+
+// UpdateVoter calls PUT /voters/:voterId with voter.Id
+func (c *Client) UpdateVoter(ctx context.Context, voter schema.Voter) (schema.Voter, error) {
+	var updated schema.Voter
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		url:    c.config.VotersURL + "/{voterId}",
+		params: map[string]string{"voterId": id(voter.Id)},
+		body:   voter,
+	}, &updated)
+	return updated, err
+}
+
+// AddVoterPoll calls POST /voters/:voterId/polls
+func (c *Client) AddVoterPoll(ctx context.Context, voterId int, voterPoll schema.VoterPoll) (schema.Voter, error) {
+	var voter schema.Voter
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.VotersURL + "/{voterId}/polls",
+		params: map[string]string{"voterId": id(voterId)},
+		body:   voterPoll,
+	}, &voter)
+	return voter, err
+}
+
+// UpdateVoterPoll calls PUT /voters/:voterId/polls/:voteId with voterPoll.VoteId
+func (c *Client) UpdateVoterPoll(ctx context.Context, voterId int, voterPoll schema.VoterPoll) (schema.Voter, error) {
+	var voter schema.Voter
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		url:    c.config.VotersURL + "/{voterId}/polls/{voteId}",
+		params: map[string]string{"voterId": id(voterId), "voteId": id(voterPoll.VoteId)},
+		body:   voterPoll,
+	}, &voter)
+	return voter, err
+}
+
+// RemoveVoterPoll calls DELETE /voters/:voterId/polls/:voteId
+func (c *Client) RemoveVoterPoll(ctx context.Context, voterId int, voteId int) (schema.Voter, error) {
+	var voter schema.Voter
+	err := c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.VotersURL + "/{voterId}/polls/{voteId}",
+		params: map[string]string{"voterId": id(voterId), "voteId": id(voteId)},
+	}, &voter)
+	return voter, err
+}
+
+// VoterAnalytics calls GET /voters/:voterId/analytics, the timeline is by
+// interval, or by day if it is empty
+func (c *Client) VoterAnalytics(ctx context.Context, voterId int, interval string) (schema.VoterAnalytics, error) {
+	var analytics schema.VoterAnalytics
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.VotersURL + "/{voterId}/analytics",
+		params: map[string]string{"voterId": id(voterId)},
+		query:  intervalQuery(interval),
+	}, &analytics)
+	return analytics, err
+}
+
+// DeleteVoter calls DELETE /voters/:voterId, with ?cascade=true if cascade is set
+func (c *Client) DeleteVoter(ctx context.Context, voterId int, cascade bool) (DeleteResult, error) {
+	var result DeleteResult
+	err := c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.VotersURL + "/{voterId}",
+		params: map[string]string{"voterId": id(voterId)},
+		query:  cascadeQuery(cascade),
+	}, &result)
+	return result, err
+}
+
+func cascadeQuery(cascade bool) url.Values {
+	q := url.Values{}
+	if cascade {
+		q.Set("cascade", "true")
+	}
+	return q
+}
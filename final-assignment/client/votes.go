@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"drexel.edu/schema"
+)
+
+// VotesHealth calls GET /votes/health
+func (c *Client) VotesHealth(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.do(ctx, request{method: http.MethodGet, url: c.config.VotesURL + "/health"}, &health)
+	return health, err
+}
+
+// GetVote calls GET /votes/:voteId, opts may be nil
+func (c *Client) GetVote(ctx context.Context, voteId int, opts *GetOptions) (schema.Vote, error) {
+	var vote schema.Vote
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.VotesURL + "/{voteId}",
+		params: map[string]string{"voteId": id(voteId)},
+		query:  opts.query(),
+	}, &vote)
+	return vote, err
+}
+
+// ListVotes calls GET /votes, opts may be nil
+func (c *Client) ListVotes(ctx context.Context, opts *ListOptions) (Page[schema.Vote], error) {
+	return list[schema.Vote](ctx, c, c.config.VotesURL, "votes", opts)
+}
+
+// ListVotesByVoter calls GET /votes/voters/:voterId, opts may be nil
+func (c *Client) ListVotesByVoter(ctx context.Context, voterId int, opts *GetOptions) ([]schema.Vote, error) {
+	var votes []schema.Vote
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.VotesURL + "/voters/{voterId}",
+		params: map[string]string{"voterId": id(voterId)},
+		query:  opts.query(),
+	}, &votes)
+	return votes, err
+}
+
+// ListVotesByPoll calls GET /votes/polls/:pollId, opts may be nil
+func (c *Client) ListVotesByPoll(ctx context.Context, pollId int, opts *GetOptions) ([]schema.Vote, error) {
+	var votes []schema.Vote
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.VotesURL + "/polls/{pollId}",
+		params: map[string]string{"pollId": id(pollId)},
+		query:  opts.query(),
+	}, &votes)
+	return votes, err
+}
+
+// PostVote calls POST /votes/:voteId with vote.Id
+func (c *Client) PostVote(ctx context.Context, vote schema.Vote) (schema.Vote, error) {
+	var created schema.Vote
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.VotesURL + "/{voteId}",
+		params: map[string]string{"voteId": id(vote.Id)},
+		body:   vote,
+	}, &created)
+	return created, err
+}
+
+// CreateVote calls POST /votes, the api picks the id. idempotencyKey works
+// as in CreatePoll.
+func (c *Client) CreateVote(ctx context.Context, vote schema.Vote, idempotencyKey string) (schema.Vote, error) {
+	var created schema.Vote
+	err := c.do(ctx, request{
+		method:         http.MethodPost,
+		url:            c.config.VotesURL,
+		body:           vote,
+		idempotencyKey: idempotencyKey,
+	}, &created)
+	return created, err
+}
+
+// UpdateVote calls PUT /votes/:voteId with vote.Id, only VoteValue and
+// Ballot are changed
+func (c *Client) UpdateVote(ctx context.Context, vote schema.Vote) (schema.Vote, error) {
+	var updated schema.Vote
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		url:    c.config.VotesURL + "/{voteId}",
+		params: map[string]string{"voteId": id(vote.Id)},
+		body:   vote,
+	}, &updated)
+	return updated, err
+}
+
+// DeleteVote calls DELETE /votes/:voteId
+func (c *Client) DeleteVote(ctx context.Context, voteId int) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.VotesURL + "/{voteId}",
+		params: map[string]string{"voteId": id(voteId)},
+	}, nil)
+}
+
+// DeleteVotesByVoter calls DELETE /votes/voters/:voterId
+func (c *Client) DeleteVotesByVoter(ctx context.Context, voterId int) (CascadeReport, error) {
+	var report CascadeReport
+	err := c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.VotesURL + "/voters/{voterId}",
+		params: map[string]string{"voterId": id(voterId)},
+	}, &report)
+	return report, err
+}
+
+// DeleteVotesByPoll calls DELETE /votes/polls/:pollId
+func (c *Client) DeleteVotesByPoll(ctx context.Context, pollId int) (CascadeReport, error) {
+	var report CascadeReport
+	err := c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.VotesURL + "/polls/{pollId}",
+		params: map[string]string{"pollId": id(pollId)},
+	}, &report)
+	return report, err
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"drexel.edu/schema"
+)
+
+// ListWebhooks calls GET /webhooks, opts may be nil. The secrets are left out.
+func (c *Client) ListWebhooks(ctx context.Context, opts *ListOptions) (Page[schema.Webhook], error) {
+	return list[schema.Webhook](ctx, c, c.config.WebhooksURL, "webhooks", opts)
+}
+
+// GetWebhook calls GET /webhooks/:webhookId
+func (c *Client) GetWebhook(ctx context.Context, webhookId int) (schema.Webhook, error) {
+	var webhook schema.Webhook
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.WebhooksURL + "/{webhookId}",
+		params: map[string]string{"webhookId": id(webhookId)},
+	}, &webhook)
+	return webhook, err
+}
+
+// CreateWebhook calls POST /webhooks. The api makes a secret if the
+// webhook has none, the returned webhook is the only place it is shown.
+// idempotencyKey works as in CreatePoll.
+func (c *Client) CreateWebhook(ctx context.Context, webhook schema.Webhook, idempotencyKey string) (schema.Webhook, error) {
+	var created schema.Webhook
+	err := c.do(ctx, request{
+		method:         http.MethodPost,
+		url:            c.config.WebhooksURL,
+		body:           webhook,
+		idempotencyKey: idempotencyKey,
+	}, &created)
+	return created, err
+}
+
+// UpdateWebhook calls PUT /webhooks/:webhookId with webhook.Id. The secret
+// is kept if webhook.Secret is "".
+func (c *Client) UpdateWebhook(ctx context.Context, webhook schema.Webhook) (schema.Webhook, error) {
+	var updated schema.Webhook
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		url:    c.config.WebhooksURL + "/{webhookId}",
+		params: map[string]string{"webhookId": id(webhook.Id)},
+		body:   webhook,
+	}, &updated)
+	return updated, err
+}
+
+// DeleteWebhook calls DELETE /webhooks/:webhookId
+func (c *Client) DeleteWebhook(ctx context.Context, webhookId int) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		url:    c.config.WebhooksURL + "/{webhookId}",
+		params: map[string]string{"webhookId": id(webhookId)},
+	}, nil)
+}
+
+// PingWebhook calls POST /webhooks/:webhookId/ping, and returns the
+// delivery of the webhook.ping event
+func (c *Client) PingWebhook(ctx context.Context, webhookId int) (schema.WebhookDelivery, error) {
+	var delivery schema.WebhookDelivery
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.WebhooksURL + "/{webhookId}/ping",
+		params: map[string]string{"webhookId": id(webhookId)},
+	}, &delivery)
+	return delivery, err
+}
+
+// ListDeliveries calls GET /webhooks/:webhookId/deliveries, newest first
+func (c *Client) ListDeliveries(ctx context.Context, webhookId int) ([]schema.WebhookDelivery, error) {
+	var list struct {
+		Embedded struct {
+			Deliveries []schema.WebhookDelivery `json:"deliveries"`
+		} `json:"_embedded"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.WebhooksURL + "/{webhookId}/deliveries",
+		params: map[string]string{"webhookId": id(webhookId)},
+	}, &list)
+	return list.Embedded.Deliveries, err
+}
+
+// GetDelivery calls GET /webhooks/:webhookId/deliveries/:deliveryId
+func (c *Client) GetDelivery(ctx context.Context, webhookId int, deliveryId string) (schema.WebhookDelivery, error) {
+	var delivery schema.WebhookDelivery
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		url:    c.config.WebhooksURL + "/{webhookId}/deliveries/{deliveryId}",
+		params: map[string]string{"webhookId": id(webhookId), "deliveryId": deliveryId},
+	}, &delivery)
+	return delivery, err
+}
+
+// Redeliver calls POST /webhooks/:webhookId/deliveries/:deliveryId/redeliver,
+// a delivery that is still pending gets a 409 *APIError
+func (c *Client) Redeliver(ctx context.Context, webhookId int, deliveryId string) (schema.WebhookDelivery, error) {
+	var delivery schema.WebhookDelivery
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.config.WebhooksURL + "/{webhookId}/deliveries/{deliveryId}/redeliver",
+		params: map[string]string{"webhookId": id(webhookId), "deliveryId": deliveryId},
+	}, &delivery)
+	return delivery, err
+}
@@ -0,0 +1,234 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Handler handles one event. If it returns an error the event is not
+// acknowledged, and is delivered again later.
+type Handler func(ctx context.Context, e Event) error
+
+// ConsumerConfig sets up a Consumer, only Group is required
+type ConsumerConfig struct {
+	// Stream defaults to Stream, DeadLetter to DeadLetterStream
+	Stream     string
+	DeadLetter string
+
+	// Group is the consumer group, every group gets each event once. Name
+	// is this consumer in the group, by default the host name, so
+	// replicas of a consumer share the events of their group.
+	Group string
+	Name  string
+
+	// Count is how many events are read at once (default 10), Block how
+	// long a read waits for new ones (default 5s)
+	Count int64
+	Block time.Duration
+
+	// an event that was not acknowledged for RetryAfter (default 30s),
+	// because its handler failed or its consumer went away, is delivered
+	// again. After MaxDeliveries (default 5) it goes to the dead letter
+	// stream instead.
+	RetryAfter    time.Duration
+	MaxDeliveries int64
+}
+
+// Consumer reads the events of a stream in a consumer group and passes them
+// to its handler, one at a time
+type Consumer struct {
+	client  *redis.Client
+	config  ConsumerConfig
+	handler Handler
+}
+
+// NewConsumer fills in the defaults of config and returns the consumer
+func NewConsumer(client *redis.Client, config ConsumerConfig, handler Handler) *Consumer {
+	if config.Stream == "" {
+		config.Stream = Stream
+	}
+	if config.DeadLetter == "" {
+		config.DeadLetter = DeadLetterStream
+	}
+	if config.Name == "" {
+		config.Name, _ = os.Hostname()
+	}
+	if config.Count <= 0 {
+		config.Count = 10
+	}
+	if config.Block <= 0 {
+		config.Block = 5 * time.Second
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = 30 * time.Second
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = 5
+	}
+	return &Consumer{client: client, config: config, handler: handler}
+}
+
+// Run creates the group if needed and handles events until ctx is done. A
+// new group starts with the events that are still in the stream.
+func (c *Consumer) Run(ctx context.Context) error {
+	if c.config.Group == "" {
+		return errors.New("events: consumer group not set")
+	}
+	err := c.client.XGroupCreateMkStream(ctx, c.config.Stream, c.config.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	lastRetry := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastRetry) >= c.config.RetryAfter/2 {
+			err = c.retryPending(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error retrying events of group %s: %s", c.config.Group, err.Error())
+			}
+			lastRetry = time.Now()
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.config.Group,
+			Consumer: c.config.Name,
+			Streams:  []string{c.config.Stream, ">"},
+			Count:    c.config.Count,
+			Block:    c.config.Block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error reading events of group %s: %s", c.config.Group, err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				c.handle(ctx, msg, 1)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// retryPending takes over the events of the group that were not
+// acknowledged in time, from any consumer, and handles them again
+func (c *Consumer) retryPending(ctx context.Context) error {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.config.Stream,
+		Group:  c.config.Group,
+		Idle:   c.config.RetryAfter,
+		Start:  "-",
+		End:    "+",
+		Count:  c.config.Count,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		msgs, err := c.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   c.config.Stream,
+			Group:    c.config.Group,
+			Consumer: c.config.Name,
+			MinIdle:  c.config.RetryAfter,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			return err
+		}
+		// another consumer claimed it first
+		if len(msgs) == 0 {
+			continue
+		}
+
+		// claiming counts as a delivery
+		deliveries := p.RetryCount + 1
+		if deliveries > c.config.MaxDeliveries {
+			// the handler never returned, e.g. the event crashes the consumer
+			c.deadLetter(ctx, msgs[0], deliveries-1, errors.New("not acknowledged"))
+			continue
+		}
+		c.handle(ctx, msgs[0], deliveries)
+	}
+	return nil
+}
+
+// handle passes the event to the handler and acknowledges it if it
+// succeeds. An event that failed MaxDeliveries times, or cannot be read, is
+// moved to the dead letter stream.
+func (c *Consumer) handle(ctx context.Context, msg redis.XMessage, deliveries int64) {
+	event, err := decodeMessage(msg)
+	if err != nil {
+		c.deadLetter(ctx, msg, deliveries, err)
+		return
+	}
+
+	err = c.handler(ctx, event)
+	if err == nil {
+		err = c.client.XAck(ctx, c.config.Stream, c.config.Group, msg.ID).Err()
+		if err != nil {
+			log.Printf("Error acknowledging event %s: %s", msg.ID, err.Error())
+		}
+		return
+	}
+
+	log.Printf("Group %s: event %s (%s) failed, delivery %d of %d: %s",
+		c.config.Group, msg.ID, event.Type, deliveries, c.config.MaxDeliveries, err.Error())
+	if deliveries >= c.config.MaxDeliveries {
+		c.deadLetter(ctx, msg, deliveries, err)
+	}
+}
+
+// deadLetter copies the event to the dead letter stream, with why it
+// failed, and acknowledges it in one transaction
+func (c *Consumer) deadLetter(ctx context.Context, msg redis.XMessage, deliveries int64, reason error) {
+	values := map[string]any{
+		"stream":     c.config.Stream,
+		"group":      c.config.Group,
+		"messageId":  msg.ID,
+		"deliveries": strconv.FormatInt(deliveries, 10),
+		"error":      reason.Error(),
+	}
+	for _, field := range []string{"type", "event"} {
+		if value, ok := msg.Values[field]; ok {
+			values[field] = value
+		}
+	}
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: c.config.DeadLetter, MaxLen: streamMaxLen, Approx: true, Values: values})
+		pipe.XAck(ctx, c.config.Stream, c.config.Group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error moving event %s to %s: %s", msg.ID, c.config.DeadLetter, err.Error())
+		return
+	}
+	log.Printf("Group %s: event %s moved to %s: %s", c.config.Group, msg.ID, c.config.DeadLetter, reason.Error())
+}
+
+func decodeMessage(msg redis.XMessage) (Event, error) {
+	var event Event
+	raw, ok := msg.Values["event"].(string)
+	if !ok {
+		return event, fmt.Errorf("message %s has no event", msg.ID)
+	}
+	err := json.Unmarshal([]byte(raw), &event)
+	return event, err
+}
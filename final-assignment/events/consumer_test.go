@@ -0,0 +1,205 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// publish puts events on the stream of the test, as the relay does
+func publish(t *testing.T, client *redis.Client, stream string, events ...Event) {
+	t.Helper()
+	for _, event := range events {
+		raw, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.XAdd(context.Background(), &redis.XAddArgs{
+			Stream: stream,
+			Values: map[string]any{"type": event.Type, "event": string(raw)},
+		}).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newEvent(t *testing.T, eventType string) Event {
+	t.Helper()
+	event, err := New(eventType, "test-api", "votes/1", map[string]int{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// runConsumer runs a consumer with short timings until done returns true
+// or the test times out
+func runConsumer(t *testing.T, client *redis.Client, config ConsumerConfig, handler Handler, done func() bool) {
+	t.Helper()
+	config.Block = 50 * time.Millisecond
+	config.RetryAfter = 200 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- NewConsumer(client, config, handler).Run(ctx)
+	}()
+
+	for !done() {
+		if ctx.Err() != nil {
+			t.Fatal("consumer did not finish in time")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	<-result
+}
+
+// pending is the number of events the group has not acknowledged, or -1
+// if it cannot be read, e.g. before the group is created
+func pending(client *redis.Client, stream string, group string) int64 {
+	p, err := client.XPending(context.Background(), stream, group).Result()
+	if err != nil {
+		return -1
+	}
+	return p.Count
+}
+
+// handled counts the deliveries of each event id
+type handled struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (h *handled) add(id string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.calls == nil {
+		h.calls = map[string]int{}
+	}
+	h.calls[id]++
+	return h.calls[id]
+}
+
+func (h *handled) count(id string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls[id]
+}
+
+func TestConsumerAcknowledges(t *testing.T) {
+	client := testRedis(t)
+	config := ConsumerConfig{Stream: "test:events", DeadLetter: "test:dead", Group: "ack", Name: "one"}
+	first, second := newEvent(t, VoteCast), newEvent(t, PollClosed)
+	publish(t, client, config.Stream, first, second)
+
+	var h handled
+	runConsumer(t, client, config, func(ctx context.Context, e Event) error {
+		h.add(e.Id)
+		return nil
+	}, func() bool {
+		return h.count(first.Id) > 0 && h.count(second.Id) > 0 && pending(client, config.Stream, config.Group) == 0
+	})
+
+	if n := pending(client, config.Stream, config.Group); n != 0 {
+		t.Errorf("%d events pending, want all acknowledged", n)
+	}
+	if h.count(first.Id) != 1 || h.count(second.Id) != 1 {
+		t.Errorf("events handled %d and %d times, want once each", h.count(first.Id), h.count(second.Id))
+	}
+}
+
+func TestConsumerRetries(t *testing.T) {
+	client := testRedis(t)
+	config := ConsumerConfig{Stream: "test:events", DeadLetter: "test:dead", Group: "retry", Name: "one", MaxDeliveries: 5}
+	event := newEvent(t, VoteCast)
+	publish(t, client, config.Stream, event)
+
+	// the first delivery fails, the event comes back after RetryAfter
+	var h handled
+	runConsumer(t, client, config, func(ctx context.Context, e Event) error {
+		if h.add(e.Id) == 1 {
+			return errors.New("first delivery fails")
+		}
+		return nil
+	}, func() bool {
+		return h.count(event.Id) >= 2 && pending(client, config.Stream, config.Group) == 0
+	})
+
+	if h.count(event.Id) != 2 {
+		t.Errorf("event handled %d times, want 2", h.count(event.Id))
+	}
+	if n := pending(client, config.Stream, config.Group); n != 0 {
+		t.Errorf("%d events pending after the retry succeeded, want 0", n)
+	}
+	dead, err := client.XLen(context.Background(), config.DeadLetter).Result()
+	if err != nil || dead != 0 {
+		t.Errorf("%d events dead lettered, want 0", dead)
+	}
+}
+
+func TestConsumerDeadLetters(t *testing.T) {
+	client := testRedis(t)
+	ctx := context.Background()
+	config := ConsumerConfig{Stream: "test:events", DeadLetter: "test:dead", Group: "dead", Name: "one", MaxDeliveries: 3}
+	event := newEvent(t, VoteCast)
+	publish(t, client, config.Stream, event)
+	// a message without an event cannot be read, it goes straight to the
+	// dead letter stream
+	err := client.XAdd(ctx, &redis.XAddArgs{Stream: config.Stream, Values: map[string]any{"type": VoteCast}}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h handled
+	runConsumer(t, client, config, func(ctx context.Context, e Event) error {
+		h.add(e.Id)
+		return errors.New("always fails")
+	}, func() bool {
+		n, _ := client.XLen(ctx, config.DeadLetter).Result()
+		return n >= 2
+	})
+
+	if h.count(event.Id) != 3 {
+		t.Errorf("event handled %d times, want MaxDeliveries=3", h.count(event.Id))
+	}
+	if n := pending(client, config.Stream, config.Group); n != 0 {
+		t.Errorf("%d events pending, dead lettered events must be acknowledged", n)
+	}
+
+	msgs, err := client.XRange(ctx, config.DeadLetter, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, msg := range msgs {
+		if msg.Values["event"] == nil {
+			if msg.Values["deliveries"] != "1" {
+				t.Errorf("unreadable message dead lettered after %v deliveries, want 1", msg.Values["deliveries"])
+			}
+			continue
+		}
+		dead, err := decodeMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dead.Id != event.Id {
+			continue
+		}
+		found = true
+		if msg.Values["deliveries"] != "3" || msg.Values["error"] != "always fails" ||
+			msg.Values["group"] != config.Group || msg.Values["stream"] != config.Stream {
+			t.Errorf("dead letter entry is %v", msg.Values)
+		}
+	}
+	if !found {
+		t.Errorf("event %s not in %s: %v", event.Id, config.DeadLetter, msgs)
+	}
+}
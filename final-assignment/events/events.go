@@ -0,0 +1,124 @@
+// Package events carries the domain events of the voter, poll and votes
+// apis. Each api writes an event to its outbox in the same redis
+// transaction as the change itself, and a relay moves the outbox to the
+// stream "events", so an event is never lost once the change is stored.
+// Consumers read the stream in a consumer group:
+//
+//	consumer := events.NewConsumer(rdb, events.ConsumerConfig{Group: "mailer"},
+//		func(ctx context.Context, e events.Event) error {
+//			if e.Type != events.VoteCast {
+//				return nil
+//			}
+//			...
+//		})
+//	err := consumer.Run(ctx)
+//
+// Events are delivered at least once: a handler may see the same event
+// again after a crash, and should use Event.Id to notice it.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	// Stream is the redis stream all apis publish to
+	Stream = "events"
+	// DeadLetterStream gets the events a consumer group gave up on
+	DeadLetterStream = "events:dead-letter"
+
+	// the stream keeps about this many events, older ones are trimmed
+	streamMaxLen = 100000
+)
+
+// the event types, named <item>.<what happened>
+const (
+	VoteCast     = "vote.cast"
+	VoteChanged  = "vote.changed"
+	VoteDeleted  = "vote.deleted"
+	PollCreated  = "poll.created"
+	PollUpdated  = "poll.updated"
+	PollOpened   = "poll.opened"
+	PollClosed   = "poll.closed"
+	PollArchived = "poll.archived"
+	PollDeleted  = "poll.deleted"
+	VoterCreated = "voter.created"
+	VoterUpdated = "voter.updated"
+	VoterDeleted = "voter.deleted"
+
+	// WebhookPing is only sent to a webhook that asks for it with
+	// POST /webhooks/:webhookId/ping, it is not on the stream
+	WebhookPing = "webhook.ping"
+)
+
+// Types lists every event type
+var Types = []string{
+	VoteCast, VoteChanged, VoteDeleted,
+	PollCreated, PollUpdated, PollOpened, PollClosed, PollArchived, PollDeleted,
+	VoterCreated, VoterUpdated, VoterDeleted,
+}
+
+// ValidFilter reports whether filter is an event type, all types of an item
+// like "poll.*", or "*" for every type
+func ValidFilter(filter string) bool {
+	for _, eventType := range Types {
+		if Matches(filter, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether an event of type eventType passes filter
+func Matches(filter string, eventType string) bool {
+	if filter == "*" || filter == eventType {
+		return true
+	}
+	item, ok := strings.CutSuffix(filter, ".*")
+	return ok && strings.HasPrefix(eventType, item+".")
+}
+
+// Event is one change of a vote, poll or voter. Data holds the item under
+// its name, e.g. {"vote": {...}}, as it was after the change, or before it
+// for a delete. vote.changed also has the vote before the change under
+// "previous".
+type Event struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	Source     string          `json:"source"`  // the api that made the change
+	Subject    string          `json:"subject"` // e.g. votes/12
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// New makes an event with a new id, data is marshalled to JSON
+func New(eventType string, source string, subject string, data any) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		Id:         hex.EncodeToString(id),
+		Type:       eventType,
+		Source:     source,
+		Subject:    subject,
+		OccurredAt: time.Now().UTC(),
+		Data:       raw,
+	}, nil
+}
+
+// Decode unmarshals the data of the event into v
+func (e Event) Decode(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
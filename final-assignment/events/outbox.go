@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// how many events one run of the relay script moves
+	relayBatch = 100
+	// how often the relay looks for new events
+	relayInterval = 200 * time.Millisecond
+)
+
+// relayScript moves up to ARGV[1] events from the outbox list KEYS[1] to the
+// stream KEYS[2], trimmed to about ARGV[2] entries. It runs as one script,
+// so an event is either still in the outbox or in the stream, never in both
+// or neither. It returns the number of events moved.
+var relayScript = redis.NewScript(`
+local moved = 0
+for i = 1, tonumber(ARGV[1]) do
+	local event = redis.call("LPOP", KEYS[1])
+	if not event then
+		break
+	end
+	local eventType = cjson.decode(event)["type"]
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[2], "*", "type", eventType, "event", event)
+	moved = moved + 1
+end
+return moved
+`)
+
+// Outbox is the list an api writes its events to, in the transaction of the
+// change they describe. Relay moves them on to the stream.
+type Outbox struct {
+	client *redis.Client
+	key    string
+	source string
+}
+
+// NewOutbox returns the outbox kept under key, for the events of the api
+// named source
+func NewOutbox(client *redis.Client, key string, source string) *Outbox {
+	return &Outbox{client: client, key: key, source: source}
+}
+
+// Add queues the event in pipe, a MULTI pipeline that also holds the change.
+// The event is only stored if the change is.
+func (o *Outbox) Add(ctx context.Context, pipe redis.Pipeliner, eventType string, subject string, data any) error {
+	event, err := New(eventType, o.source, subject, data)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	pipe.RPush(ctx, o.key, raw)
+	return nil
+}
+
+// relay moves the events in the outbox to the stream, until it is empty
+func (o *Outbox) relay(ctx context.Context) error {
+	for {
+		moved, err := relayScript.Run(ctx, o.client, []string{o.key, Stream}, relayBatch, streamMaxLen).Int()
+		if err != nil || moved < relayBatch {
+			return err
+		}
+	}
+}
+
+// Relay moves the events to the stream as they are added, until ctx is
+// done. Events added while the relay is not running wait in the outbox.
+func (o *Outbox) Relay(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+	for {
+		err := o.relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println("Error relaying events from " + o.key + ": " + err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// testRedis connects to the redis at REDIS_URL, like the apis do, and
+// empties database 15 for the test. Without one the test is skipped.
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+	ctx := context.Background()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		t.Skipf("redis at %s not reachable: %s", addr, err.Error())
+	}
+	err = client.FlushDB(ctx).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB(ctx)
+		client.Close()
+	})
+	return client
+}
+
+func TestOutboxRelay(t *testing.T) {
+	client := testRedis(t)
+	ctx := context.Background()
+	outbox := NewOutbox(client, "test:outbox", "test-api")
+
+	// an event added to a transaction that is discarded is never relayed
+	pipe := client.TxPipeline()
+	err := outbox.Add(ctx, pipe, PollCreated, "polls/1", map[string]int{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe.Discard()
+
+	n := relayBatch + 5
+	for i := 0; i < n; i++ {
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "test:item", i, 0)
+			return outbox.Add(ctx, pipe, VoteCast, "votes/1", map[string]int{"seq": i})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = outbox.relay(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left, err := client.LLen(ctx, "test:outbox").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d events left in the outbox, want 0", left)
+	}
+
+	msgs, err := client.XRange(ctx, Stream, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != n {
+		t.Fatalf("%d events in the stream, want %d", len(msgs), n)
+	}
+	for i, msg := range msgs {
+		event, err := decodeMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Values["type"] != VoteCast || event.Type != VoteCast || event.Source != "test-api" {
+			t.Errorf("event %d is %v", i, msg.Values)
+		}
+		var data struct{ Seq int }
+		err = json.Unmarshal(event.Data, &data)
+		if err != nil || data.Seq != i {
+			t.Errorf("event %d has data %s, events are out of order", i, event.Data)
+		}
+	}
+
+	// a second run finds nothing to move
+	err = outbox.relay(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err := client.XLen(ctx, Stream).Result()
+	if err != nil || total != int64(n) {
+		t.Errorf("stream has %d events after relaying an empty outbox, want %d", total, n)
+	}
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The poll api posts events to webhooks with these headers. The signature
+// is "sha256=" and the hex HMAC-SHA256, keyed with the webhook's secret, of
+// the timestamp, a dot and the body, so a receiver can check both where the
+// request comes from and that it is not an old one sent again.
+const (
+	WebhookIdHeader        = "X-Webhook-Id"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	ErrBadSignature = errors.New("webhook signature does not match")
+	ErrOldTimestamp = errors.New("webhook timestamp is too old")
+)
+
+// Sign returns the signature of a webhook body sent at timestamp, in unix
+// seconds
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp and signature headers of a webhook request
+// against its body. Requests signed more than tolerance ago are rejected,
+// 0 accepts any age.
+func Verify(secret string, timestamp string, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return ErrBadSignature
+	}
+	age := time.Since(time.Unix(ts, 0))
+	if tolerance > 0 && math.Abs(float64(age)) > float64(tolerance) {
+		return ErrOldTimestamp
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+module drexel.edu/importer
+
+go 1.20
+
+require (
+	drexel.edu/client v0.0.0
+	drexel.edu/schema v0.0.0
+)
+
+require (
+	github.com/go-resty/resty/v2 v2.7.0 // indirect
+	golang.org/x/net v0.0.0-20211029224645-99673261e6eb // indirect
+)
+
+replace (
+	drexel.edu/client v0.0.0 => ../client
+	drexel.edu/schema v0.0.0 => ../schema
+)
@@ -0,0 +1,145 @@
+// Command importer loads voters, polls and votes from NDJSON or CSV files
+// through the import routes of the three apis, so every row is checked the
+// way a POST is. The files are imported in that order, so the votes can
+// refer to voters and polls from the same run:
+//
+//	go run . -voters voters.csv -polls polls.ndjson -votes votes.csv -dry-run
+//
+// The format of a file comes from its extension, .csv or .ndjson (.jsonl
+// works too). It prints every row that failed, and exits with 1 if any did.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"drexel.edu/client"
+	"drexel.edu/schema"
+)
+
+var (
+	votersFile string
+	pollsFile  string
+	votesFile  string
+	dryRun     bool
+
+	votersURL string
+	pollsURL  string
+	votesURL  string
+)
+
+func processCmdLineFlags() {
+	flag.StringVar(&votersFile, "voters", "", "File of voters to import")
+	flag.StringVar(&pollsFile, "polls", "", "File of polls to import")
+	flag.StringVar(&votesFile, "votes", "", "File of votes to import")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only check the rows, write nothing")
+
+	flag.StringVar(&votersURL, "voters-url", "http://localhost:1081/voters", "Default voters location")
+	flag.StringVar(&pollsURL, "polls-url", "http://localhost:1082/polls", "Default polls location")
+	flag.StringVar(&votesURL, "votes-url", "http://localhost:1080/votes", "Default votes location")
+	flag.Parse()
+}
+
+func envVarOrDefault(envVar string, defaultVal string) string {
+	envVal := os.Getenv(envVar)
+	if envVal != "" {
+		return envVal
+	}
+	return defaultVal
+}
+
+// formatOf is the import format of a file, from its extension
+func formatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return schema.ExportCSV, nil
+	case ".ndjson", ".jsonl":
+		return schema.ExportNDJSON, nil
+	}
+	return "", fmt.Errorf("%s: %w", path, schema.ErrImportFormat)
+}
+
+type importFunc func(ctx context.Context, r io.Reader, format string, dryRun bool) (schema.ImportReport, error)
+
+// importFile imports one file and prints its report, it returns the number
+// of rows that failed
+func importFile(ctx context.Context, path string, name string, fn importFunc) (int, error) {
+	format, err := formatOf(path)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	report, err := fn(ctx, f, format, dryRun)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s: %s %d of %d %s in %s\n", path, verb, report.Imported, report.Rows, name, time.Since(start).Round(time.Millisecond))
+	for _, rowErr := range report.Errors {
+		fmt.Printf("%s:%d: %s\n", path, rowErr.Line, rowErr.Error)
+	}
+	return report.Failed, nil
+}
+
+func main() {
+	processCmdLineFlags()
+	votersURL = envVarOrDefault("VOTER_API_URL", votersURL)
+	pollsURL = envVarOrDefault("POLL_API_URL", pollsURL)
+	votesURL = envVarOrDefault("VOTES_API_URL", votesURL)
+
+	c := client.New(client.Config{
+		VotersURL: votersURL,
+		PollsURL:  pollsURL,
+		VotesURL:  votesURL,
+	})
+	ctx := context.Background()
+
+	files := []struct {
+		path string
+		name string
+		fn   importFunc
+	}{
+		{votersFile, "voters", c.ImportVoters},
+		{pollsFile, "polls", c.ImportPolls},
+		{votesFile, "votes", c.ImportVotes},
+	}
+
+	failed := 0
+	given := false
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		given = true
+		n, err := importFile(ctx, file.path, file.name, file.fn)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		failed += n
+	}
+
+	if !given {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
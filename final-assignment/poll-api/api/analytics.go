@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// The analytics of a poll are read from aggregates that are kept as votes
+// come and go, see schema.PollAnalytics: the results of the poll, its
+// activity, kept by the votes api, and the index of voter ids, kept by the
+// voter api. The ids of the polls are kept in schema.PollIdsKey in the same
+// way, for the participation of a voter. A poll is added in the transaction
+// that creates it and removed, with its activity, in the one that deletes
+// it.
+
+// the timeline of a poll is by hour, unless ?interval= says otherwise
+const defaultPollInterval = schema.IntervalHour
+
+// GetAnalytics handles GET /polls/:pollId/analytics, the share of the votes
+// of each option, the margin of the leading option, the turnout and the
+// votes over time. ?interval= is minute, hour or day.
+func (p *PollsAPI) GetAnalytics(c *gin.Context) {
+	interval := c.DefaultQuery("interval", defaultPollInterval)
+	if err := schema.CheckInterval(interval); err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+
+	var activity *redis.StringStringMapCmd
+	var voters *redis.IntCmd
+	_, err := p.client.Pipelined(p.context, func(pipe redis.Pipeliner) error {
+		activity = pipe.HGetAll(p.context, schema.ActivityKey(poll.Id))
+		voters = pipe.ZCard(p.context, schema.VoterIdsKey)
+		return nil
+	})
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read the analytics of the poll\n" + err.Error()})
+		return
+	}
+
+	analytics := schema.NewPollAnalytics(&poll, int(voters.Val()), schema.ParseActivity(activity.Val()), interval)
+	id := strconv.Itoa(poll.Id)
+	analytics.Links.Self.Href = p.API.Self + "/polls/" + id + "/analytics"
+	analytics.Links.Poll.Href = p.API.Self + "/polls/" + id
+	analytics.Links.Results.Href = p.API.Self + "/polls/" + id + "/results"
+
+	p.validCall()
+	c.JSON(http.StatusOK, analytics)
+}
@@ -3,20 +3,33 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
-	"drexel.edu/polls/schema"
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"drexel.edu/service"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/go-resty/resty/v2"
 	"github.com/nitishm/go-rejson/v4"
 )
 
 const (
 	RedisKeyPrefix = "polls:"
+
+	// the events of poll changes wait here for the relay, the key does not
+	// end in a number so service.ScanIds skips it
+	outboxKey = RedisKeyPrefix + "outbox"
+
+	// changes of the counts made with an Idempotency-Key are marked here,
+	// see countScript
+	countChangeKeyPrefix = RedisKeyPrefix + "count-changes:"
 )
 
 type cache struct {
@@ -40,8 +53,19 @@ type API struct {
 
 type PollsAPI struct {
 	cache
-	health Health
-	API    API
+	health      Health
+	apiClient   *resty.Client
+	API         API
+	InternalAPI API
+
+	// edit policy of polls without their own, see revisions.go
+	editPolicy string
+	// the results streams open on this replica, see stream.go
+	hub *resultsHub
+	// poll.created, poll.updated and poll.deleted are written here
+	outbox *events.Outbox
+	// sends the webhook deliveries, see deliveries.go
+	webhookClient *http.Client
 }
 
 func (v *PollsAPI) validCall() {
@@ -56,8 +80,21 @@ func (v *PollsAPI) invalidCall() {
 	v.health.mu.Unlock()
 }
 
-func New(location string, api API) (*PollsAPI, error) {
+// Idempotent adds Idempotency-Key support to a POST handler, see
+// service.Idempotent
+func (p *PollsAPI) Idempotent(next gin.HandlerFunc) gin.HandlerFunc {
+	return service.Idempotent(p.context, p.client, RedisKeyPrefix, next)
+}
+
+// IdempotentWebhooks is Idempotent for POST /webhooks, its keys are kept
+// apart from those of the polls
+func (p *PollsAPI) IdempotentWebhooks(next gin.HandlerFunc) gin.HandlerFunc {
+	return service.Idempotent(p.context, p.client, WebhookKeyPrefix, next)
+}
+
+func New(location string, api API, internalAPI API) (*PollsAPI, error) {
 
+	apiClient := resty.New()
 	//Connect to redis.  Other options can be provided, but the
 	//defaults are OK
 	client := redis.NewClient(&redis.Options{
@@ -92,6 +129,11 @@ func New(location string, api API) (*PollsAPI, error) {
 			totalApiCallsWithErrors: 0,
 			totalValidApiCalls:      0,
 		},
+		InternalAPI:   internalAPI,
+		apiClient:     apiClient,
+		hub:           newResultsHub(),
+		outbox:        events.NewOutbox(client, outboxKey, "poll-api"),
+		webhookClient: &http.Client{Timeout: webhookTimeout},
 	}, nil
 }
 
@@ -129,6 +171,14 @@ func (p *PollsAPI) GetPoll(c *gin.Context) {
 		return
 	}
 
+	embed, err := service.ParseEmbed(c, service.EmbedNone)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := service.ParseFields(c)
+
 	err = getItemFromRedis(id, p, &poll)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
@@ -141,8 +191,24 @@ func (p *PollsAPI) GetPoll(c *gin.Context) {
 
 	genHalJSONResponse(&poll, p)
 
+	if embed.Voter {
+		err = p.embedVoters(&poll)
+		if err != nil {
+			p.invalidCall()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not embed voters\n" + err.Error()})
+			return
+		}
+	}
+
+	result, err := schema.SelectFields(poll, fields)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
+	}
+
 	p.validCall()
-	c.JSON(http.StatusOK, poll)
+	c.JSON(http.StatusOK, result)
 }
 
 func getItemFromRedis(id string, p *PollsAPI, poll *schema.Poll) error {
@@ -186,12 +252,65 @@ func (p *PollsAPI) GetResults(c *gin.Context) {
 		return
 	}
 
+	// ?format= downloads the results as a file, see export.go
+	if format := c.Query("format"); format != "" && format != "json" {
+		p.exportResults(c, &poll, format)
+		return
+	}
+
+	// the outcome is counted by the poll's tallier, or the one in ?tally=
+	method := c.Query("tally")
+	err = poll.CheckTally(method)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
+
+	// a closed poll keeps the outcome in its snapshot
+	var outcome schema.Outcome
+	if poll.Snapshot != nil && poll.Snapshot.Outcome != nil && method == "" {
+		outcome = *poll.Snapshot.Outcome
+	} else {
+		outcome, err = p.outcome(&poll, method)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"msg": "Could not count the ballots of the poll\n" + err.Error(),
+			})
+			p.invalidCall()
+			return
+		}
+	}
+
+	// the outcome is decided by the poll's decision rules, a snapshot was
+	// decided when the poll closed unless that was before polls had them
+	if outcome.Decision == nil {
+		registered, err := p.registeredVoters()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"msg": "Could not count the registered voters\n" + err.Error(),
+			})
+			p.invalidCall()
+			return
+		}
+		poll.Decide(&outcome, registered)
+	}
+
+	// a closed poll also has the snapshot taken when it closed
 	var result struct {
-		Results []schema.Results `json:"results"`
-		Meta    schema.Meta      `json:"_meta"`
-		Links   schema.Links     `json:"_links"`
+		Results  []schema.Results        `json:"results"`
+		Outcome  schema.Outcome          `json:"outcome"`
+		State    string                  `json:"state"`
+		Snapshot *schema.ResultsSnapshot `json:"snapshot,omitempty"`
+		Meta     schema.Meta             `json:"_meta"`
+		Links    schema.Links            `json:"_links"`
 	}
 	result.Results = poll.Results
+	result.Outcome = outcome
+	result.State = poll.CurrentState()
+	result.Snapshot = poll.Snapshot
 	result.Meta = poll.Meta
 	result.Links = poll.Links
 	p.validCall()
@@ -199,24 +318,59 @@ func (p *PollsAPI) GetResults(c *gin.Context) {
 }
 
 func (p *PollsAPI) GetPolls(c *gin.Context) {
-	var pollList []schema.Poll
-	var poll schema.Poll
-	pollKey := RedisKeyPrefix + "*"
-	ks, _ := p.client.Keys(p.context, pollKey).Result()
-	for _, key := range ks {
-		err := getItemFromRedis(key, p, &poll)
+	req, err := service.ParsePageRequest(c)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	embed, err := service.ParseEmbed(c, service.EmbedNone)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := service.ParseFields(c)
+
+	pg, err := service.PageIds(p.context, p.client, schema.PollIdsKey, req)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list polls in cache"})
+		return
+	}
+
+	pollList := make([]schema.Poll, 0, len(pg.Ids))
+	for _, id := range pg.Ids {
+		var poll schema.Poll
+		err := getItemFromRedis(strconv.Itoa(id), p, &poll)
 		if err != nil {
 			p.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find vote in cache with id=" + key})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find poll in cache with id=" + strconv.Itoa(id)})
 			return
 		}
 		//generate the latest HAL JSON response
 		genHalJSONResponse(&poll, p)
+		if embed.Voter {
+			err = p.embedVoters(&poll)
+			if err != nil {
+				p.invalidCall()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not embed voters\n" + err.Error()})
+				return
+			}
+		}
 		pollList = append(pollList, poll)
 	}
 
+	result, err := schema.SelectFieldsList(pollList, fields)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
+	}
+
 	p.validCall()
-	c.JSON(http.StatusOK, pollList)
+	c.JSON(http.StatusOK, schema.NewList(p.API.Self+"/polls", "polls", result, pg, req))
 }
 
 func (p *PollsAPI) PostPoll(c *gin.Context) {
@@ -228,7 +382,7 @@ func (p *PollsAPI) PostPoll(c *gin.Context) {
 		return
 	}
 
-	_, err := strconv.Atoi(id)
+	pollId, err := strconv.Atoi(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg": "Invalid poll id",
@@ -237,18 +391,35 @@ func (p *PollsAPI) PostPoll(c *gin.Context) {
 		return
 	}
 
-	// confirm that the poll does not exist
-	err = getItemFromRedis(id, p, &poll)
-	if err == nil {
+	// bind the request body into a poll struct
+	err = c.BindJSON(&poll)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"msg": "Poll already exists",
+			"msg": "Error unmarshalling poll\n" + err.Error(),
 		})
 		p.invalidCall()
 		return
 	}
 
-	// bind the request body into a poll struct
-	err = c.BindJSON(&poll)
+	// the id in the url is the one that counts
+	if poll.Id == 0 {
+		poll.Id = pollId
+	}
+	if poll.Id != pollId {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Poll id in the body does not match the url",
+		})
+		p.invalidCall()
+		return
+	}
+
+	p.createPoll(c, &poll, http.StatusOK)
+}
+
+// CreatePoll handles POST /polls, the id is allocated by the api
+func (p *PollsAPI) CreatePoll(c *gin.Context) {
+	var poll schema.Poll
+	err := c.BindJSON(&poll)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg": "Error unmarshalling poll\n" + err.Error(),
@@ -257,20 +428,55 @@ func (p *PollsAPI) PostPoll(c *gin.Context) {
 		return
 	}
 
-	poll.Results = make([]schema.Results, len(poll.Options))
-	for i, option := range poll.Options {
-		poll.Results[i].OptionId = option.Id
-		poll.Results[i].Votes = 0
+	if poll.Id != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "The poll id is assigned by the api, use POST /polls/:pollId to pick one",
+		})
+		p.invalidCall()
+		return
 	}
 
-	poll.Meta.TotalVotes = 0
-	poll.Meta.CreatedAt = time.Now()
-	poll.Meta.UpdatedAt = time.Now()
+	poll.Id, err = service.NextId(p.context, p.client, RedisKeyPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error allocating poll id\n" + err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
 
-	// generate the links and embedded
-	genHalJSONResponse(&poll, p)
+	p.createPoll(c, &poll, http.StatusCreated)
+}
+
+// createPoll sets up the results and meta of a new poll, saves it and sends
+// it with status. With 201 the Location header is set as well, and the poll
+// gets the next id if its id was taken in the meantime.
+func (p *PollsAPI) createPoll(c *gin.Context, poll *schema.Poll, status int) {
+	err := p.preparePoll(poll)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
 
-	err = p.savePoll(&poll)
+	err = p.savePoll(poll)
+	for status == http.StatusCreated && errors.Is(err, service.ErrExists) {
+		// a client picked the id with POST /polls/:pollId
+		poll.Id, err = service.NextId(p.context, p.client, RedisKeyPrefix)
+		if err == nil {
+			genHalJSONResponse(poll, p)
+			err = p.savePoll(poll)
+		}
+	}
+	if errors.Is(err, service.ErrExists) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Poll already exists",
+		})
+		p.invalidCall()
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"msg": "Error saving poll to cache",
@@ -279,16 +485,53 @@ func (p *PollsAPI) PostPoll(c *gin.Context) {
 		return
 	}
 
+	if status == http.StatusCreated {
+		c.Header("Location", poll.Links.Self.Href)
+	}
 	p.validCall()
-	c.JSON(http.StatusOK, poll)
+	c.JSON(status, poll)
+}
+
+// preparePoll sets up the results, meta and links of a new poll, and checks
+// its options, rules, schedule and edit policy
+func (p *PollsAPI) preparePoll(poll *schema.Poll) error {
+	poll.Meta.TotalVotes = 0
+	poll.Meta.CreatedAt = time.Now()
+	poll.Meta.UpdatedAt = time.Now()
+	poll.Revision = 1
+	poll.ResultsSince = 0
+
+	// votes refer to options by id, options without one get the next free id
+	err := poll.PrepareOptions()
+	if err == nil {
+		poll.ResetResults(nil)
+		err = poll.ValidateRules()
+	}
+	if err == nil {
+		err = poll.ValidateDecision()
+	}
+	if err == nil {
+		err = prepareLifecycle(poll, poll.Meta.CreatedAt)
+	}
+	if err == nil && poll.EditPolicy != "" && !schema.ValidEditPolicy(poll.EditPolicy) {
+		err = fmt.Errorf("%w %q", errInvalidPolicy, poll.EditPolicy)
+	}
+	if err != nil {
+		return err
+	}
+
+	// generate the links and embedded
+	genHalJSONResponse(poll, p)
+	return nil
+}
+
+// endpoints are the public urls the shared link builders work from
+func (p *PollsAPI) endpoints() schema.Endpoints {
+	return schema.Endpoints{Polls: p.API.Self + "/polls", Voters: p.API.Voters, Votes: p.API.Votes}
 }
 
 func genHalJSONResponse(poll *schema.Poll, p *PollsAPI) {
-	poll.Links.Self.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id)
-	poll.Links.Vote.Href = p.API.Votes
-	poll.Links.Votes.Href = p.API.Votes + "/polls/" + strconv.Itoa(poll.Id)
-	poll.Links.Voters.Href = p.API.Voters
-	poll.Links.Results.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id) + "/results"
+	schema.SetPollLinks(p.endpoints(), poll)
 }
 
 func (p *PollsAPI) UpdatePoll(c *gin.Context) {
@@ -309,9 +552,91 @@ func (p *PollsAPI) UpdatePoll(c *gin.Context) {
 		return
 	}
 
-	// get the old poll
-	err = getItemFromRedis(id, p, &poll)
+	// what happens to the votes is up to the edit policy, see revise
+	var newPoll schema.Poll
+	err = c.BindJSON(&newPoll)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Error unmarshalling poll\n" + err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
+
+	// the poll is replaced atomically, so a state change by the scheduler
+	// in the meantime is not lost. An edit that makes a new revision stores
+	// it in the same transaction.
+	var replaced, revision schema.PollRevision
+	var revised bool
+	poll, err = p.updatePollWith(id, func(old *schema.Poll) error {
+		// the results of a closed poll are final
+		state := old.CurrentState()
+		if state == schema.PollClosed || state == schema.PollArchived {
+			return errPollClosed
+		}
+		if newPoll.State == "" {
+			newPoll.State = state
+		}
+		if newPoll.State != state && !schema.CanTransition(state, newPoll.State) {
+			return fmt.Errorf("%w from %s to %s", errInvalidTransition, state, newPoll.State)
+		}
+		if newPoll.OpensAt == nil {
+			newPoll.OpensAt = old.OpensAt
+		}
+		if newPoll.ClosesAt == nil {
+			newPoll.ClosesAt = old.ClosesAt
+		}
+		err := newPoll.PrepareOptions()
+		if err != nil {
+			return err
+		}
+		newPoll.Id = old.Id
+		var policy string
+		revised, policy, err = p.revise(old, &newPoll)
+		if err != nil {
+			return err
+		}
+		err = newPoll.ValidateRules()
+		if err != nil {
+			return err
+		}
+		err = newPoll.ValidateDecision()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		err = prepareLifecycle(&newPoll, now)
+		if err != nil {
+			return err
+		}
+
+		if revised {
+			replaced, err = p.getRevision(old.Id, old.CurrentRevision())
+			if errors.Is(err, errRevisionNotFound) {
+				replaced, err = schema.RevisionOf(old, old.Meta.CreatedAt), nil
+			}
+			if err != nil {
+				return err
+			}
+			replaced.Results = old.Results
+			replaced.ReplacedAt = &now
+			revision = schema.RevisionOf(&newPoll, now)
+			revision.Policy = policy
+		}
+
+		newPoll.Meta.CreatedAt = old.Meta.CreatedAt
+		*old = newPoll
+		return nil
+	}, func(pipe redis.Pipeliner, poll *schema.Poll) error {
+		if revised {
+			err := p.writeRevision(pipe, replaced, revision)
+			if err != nil {
+				return err
+			}
+		}
+		return p.outbox.Add(p.context, pipe, events.PollUpdated, pollSubject(poll.Id), gin.H{"poll": poll})
+	})
+	if errors.Is(err, errPollNotFound) {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{
 				"msg": "No such poll in the cache\n" + err.Error(),
@@ -319,8 +644,52 @@ func (p *PollsAPI) UpdatePoll(c *gin.Context) {
 		p.invalidCall()
 		return
 	}
+	if errors.Is(err, errPollClosed) || errors.Is(err, errInvalidTransition) || errors.Is(err, errPollHasVotes) {
+		c.JSON(http.StatusConflict, gin.H{
+			"msg": "Poll cannot be changed, " + err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
+	if errors.Is(err, errInvalidLifecycle) || errors.Is(err, schema.ErrInvalidBallot) || errors.Is(err, schema.ErrInvalidOptions) ||
+		errors.Is(err, schema.ErrInvalidDecision) || errors.Is(err, errInvalidPolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error saving poll",
+		})
+		p.invalidCall()
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, poll)
+}
 
-	// updating the poll should reset the votes.
+func (p *PollsAPI) UpdateOptionCounts(c *gin.Context) {
+	var poll schema.Poll
+	id := c.Param("pollId")
+	if id == "" {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		return
+	}
+
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid poll id",
+		})
+		p.invalidCall()
+		return
+	}
+
+	// keep the previous votes, when updating count only.
 	var newPoll schema.Poll
 	err = c.BindJSON(&newPoll)
 	if err != nil {
@@ -331,19 +700,31 @@ func (p *PollsAPI) UpdatePoll(c *gin.Context) {
 		return
 	}
 
-	newPoll.Results = make([]schema.Results, len(newPoll.Options))
-	for i, option := range newPoll.Options {
-		newPoll.Results[i].OptionId = option.Id
-		newPoll.Results[i].Votes = 0
+	// the state, the snapshot and the revision are not part of the counts
+	_, err = p.updatePollAtomic(id, func(old *schema.Poll) error {
+		poll = *old
+		newPoll.Id = old.Id
+		newPoll.Ballot = old.Ballot
+		newPoll.Decision = old.Decision
+		newPoll.State = old.State
+		newPoll.OpensAt = old.OpensAt
+		newPoll.ClosesAt = old.ClosesAt
+		newPoll.Snapshot = old.Snapshot
+		newPoll.Revision = old.Revision
+		newPoll.ResultsSince = old.ResultsSince
+		newPoll.EditPolicy = old.EditPolicy
+		newPoll.Meta.CreatedAt = old.Meta.CreatedAt
+		*old = newPoll
+		return nil
+	})
+	if errors.Is(err, errPollNotFound) {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{
+				"msg": "No such poll in the cache\n" + err.Error(),
+			})
+		p.invalidCall()
+		return
 	}
-
-	newPoll.Meta.CreatedAt = poll.Meta.CreatedAt
-	newPoll.Meta.UpdatedAt = time.Now()
-
-	// generate the links and embedded
-	genHalJSONResponse(&newPoll, p)
-
-	err = p.savePoll(&newPoll)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"msg": "Error saving poll",
@@ -356,7 +737,12 @@ func (p *PollsAPI) UpdatePoll(c *gin.Context) {
 	c.JSON(http.StatusOK, poll)
 }
 
-func (p *PollsAPI) UpdateOptionCounts(c *gin.Context) {
+// IncrementOptionCount atomically adjusts the vote count of a single option,
+// :option is the option id. The votes-api uses this instead of a
+// read-modify-write of the whole poll, so concurrent votes on the same poll
+// can no longer overwrite each other. With an Idempotency-Key a retry is not
+// counted twice, and {"undo": true} with the same key takes the change back.
+func (p *PollsAPI) IncrementOptionCount(c *gin.Context) {
 	var poll schema.Poll
 	id := c.Param("pollId")
 	if id == "" {
@@ -374,10 +760,31 @@ func (p *PollsAPI) UpdateOptionCounts(c *gin.Context) {
 		return
 	}
 
-	// get the old poll
+	option, err := strconv.Atoi(c.Param("option"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid option",
+		})
+		p.invalidCall()
+		return
+	}
+
+	var body struct {
+		By   int  `json:"by"`
+		Undo bool `json:"undo"`
+	}
+	err = c.ShouldBindJSON(&body)
+	if err != nil || body.By == 0 {
+		body.By = 1
+	}
+	key, ok := p.countChangeKey(c, body.Undo)
+	if !ok {
+		return
+	}
+
 	err = getItemFromRedis(id, p, &poll)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError,
+		c.JSON(http.StatusNotFound,
 			gin.H{
 				"msg": "No such poll in the cache\n" + err.Error(),
 			})
@@ -385,36 +792,262 @@ func (p *PollsAPI) UpdateOptionCounts(c *gin.Context) {
 		return
 	}
 
-	// keep the previous votes, when updating count only.
-	var newPoll schema.Poll
-	err = c.BindJSON(&newPoll)
+	if !p.hasResult(&poll, option) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid option",
+		})
+		p.invalidCall()
+		return
+	}
+
+	// the script runs inside redis, so no lock is needed here
+	err = p.changeCounts(id, key, body.Undo, countDelta{option, body.By})
+	if errors.Is(err, errPollClosed) {
+		c.JSON(http.StatusConflict, gin.H{
+			"msg": "Poll " + id + " does not take votes, it is not open",
+		})
+		p.invalidCall()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error updating poll count\n" + err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
+
+	// let the results streams know, and return the poll as it is after the increment
+	poll, err = p.publishResults(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error retrieving poll",
+		})
+		p.invalidCall()
+		return
+	}
+
+	genHalJSONResponse(&poll, p)
+
+	p.validCall()
+	c.JSON(http.StatusOK, poll)
+}
+
+// MoveOptionCount moves one vote from an option to another, for a voter that
+// changes their vote. Both counts change in the same script, so the total
+// number of votes never looks off to anyone reading the poll. It takes an
+// Idempotency-Key and {"undo": true} like IncrementOptionCount.
+func (p *PollsAPI) MoveOptionCount(c *gin.Context) {
+	var poll schema.Poll
+	id := c.Param("pollId")
+	if id == "" {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		return
+	}
+
+	_, err := strconv.Atoi(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"msg": "Error unmarshalling poll\n" + err.Error(),
+			"msg": "Invalid poll id",
 		})
 		p.invalidCall()
 		return
 	}
 
-	newPoll.Meta.CreatedAt = poll.Meta.CreatedAt
-	newPoll.Meta.UpdatedAt = time.Now()
+	from, err := strconv.Atoi(c.Param("option"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid option",
+		})
+		p.invalidCall()
+		return
+	}
 
-	// generate the links and embedded
-	genHalJSONResponse(&newPoll, p)
+	var body struct {
+		To   *int `json:"to"`
+		Undo bool `json:"undo"`
+	}
+	err = c.ShouldBindJSON(&body)
+	if err != nil || body.To == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "The option to move the vote to is missing",
+		})
+		p.invalidCall()
+		return
+	}
+	to := *body.To
+	key, ok := p.countChangeKey(c, body.Undo)
+	if !ok {
+		return
+	}
 
-	err = p.savePoll(&newPoll)
+	err = getItemFromRedis(id, p, &poll)
+	if err != nil {
+		c.JSON(http.StatusNotFound,
+			gin.H{
+				"msg": "No such poll in the cache\n" + err.Error(),
+			})
+		p.invalidCall()
+		return
+	}
+
+	if !p.hasResult(&poll, from) || !p.hasResult(&poll, to) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid option",
+		})
+		p.invalidCall()
+		return
+	}
+
+	err = p.changeCounts(id, key, body.Undo, countDelta{from, -1}, countDelta{to, 1})
+	if errors.Is(err, errPollClosed) {
+		c.JSON(http.StatusConflict, gin.H{
+			"msg": "Poll " + id + " does not take votes, it is not open",
+		})
+		p.invalidCall()
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"msg": "Error saving poll",
+			"msg": "Error updating poll count\n" + err.Error(),
+		})
+		p.invalidCall()
+		return
+	}
+
+	// let the results streams know, and return the poll as it is after the move
+	poll, err = p.publishResults(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error retrieving poll",
 		})
 		p.invalidCall()
 		return
 	}
 
+	genHalJSONResponse(&poll, p)
+
 	p.validCall()
 	c.JSON(http.StatusOK, poll)
 }
 
+// countScript adds to the counts of the poll KEYS[1], ARGV[3:] are pairs
+// of the JSONPath of a count and what to add to it, and sets
+// _meta.TotalVotes to the sum of the counts. KEYS[2], if it is given, is the
+// key of the change: the change is made once, and ARGV[1] "undo" takes it
+// back, with the opposite deltas, at most once. An undo that comes first
+// still marks the change, so a change that arrives late is not made.
+// ARGV[2] is how long the key is kept. It returns 0 if nothing was changed.
+// A change fails with pollNotOpenReply unless the poll is open, an undo is
+// taken even after the poll closed, as the vote it takes back failed.
+var countScript = redis.NewScript(`
+local undo = ARGV[1] == "undo"
+if KEYS[2] then
+	local state = redis.call("GET", KEYS[2])
+	if state == "undone" or (state and not undo) or (undo and not state) then
+		redis.call("SET", KEYS[2], "undone", "EX", ARGV[2])
+		return 0
+	end
+end
+if not undo then
+	local state = cjson.decode(redis.call("JSON.GET", KEYS[1], "$.state"))[1]
+	if state and state ~= "" and state ~= "open" then
+		return redis.error_reply("` + pollNotOpenReply + `")
+	end
+end
+local sign = 1
+if undo then
+	sign = -1
+end
+for i = 3, #ARGV, 2 do
+	redis.call("JSON.NUMINCRBY", KEYS[1], ARGV[i], sign * tonumber(ARGV[i + 1]))
+end
+local total = 0
+for _, votes in ipairs(cjson.decode(redis.call("JSON.GET", KEYS[1], "$.results[*].votes"))) do
+	total = total + votes
+end
+redis.call("JSON.SET", KEYS[1], "$._meta.TotalVotes", total)
+if KEYS[2] then
+	local state = "applied"
+	if undo then
+		state = "undone"
+	end
+	redis.call("SET", KEYS[2], state, "EX", ARGV[2])
+end
+return 1
+`)
+
+// pollNotOpenReply is the error countScript fails with on a poll that is
+// not open
+const pollNotOpenReply = "POLLNOTOPEN poll does not take votes"
+
+// countDelta is a change of the count of an option
+type countDelta struct {
+	optionId int
+	by       int
+}
+
+// changeCounts changes the counts of the poll with id in one script. With
+// a key the change is made at most once, and undo takes it back, see
+// countScript.
+func (p *PollsAPI) changeCounts(id string, key string, undo bool, deltas ...countDelta) error {
+	keys := []string{RedisKeyPrefix + id}
+	if key != "" {
+		keys = append(keys, countChangeKeyPrefix+key)
+	}
+	mode := "do"
+	if undo {
+		mode = "undo"
+	}
+
+	args := []any{mode, int(service.IdempotencyTTL.Seconds())}
+	for _, d := range deltas {
+		args = append(args, resultPath(d.optionId), d.by)
+	}
+	err := countScript.Run(p.context, p.client, keys, args...).Err()
+	if err != nil && err.Error() == pollNotOpenReply {
+		return errPollClosed
+	}
+	return err
+}
+
+// countChangeKey reads the Idempotency-Key of a change of the counts. A
+// retry with the same key is not counted twice, and an undo names the
+// change it takes back with it, so it cannot go without one.
+func (p *PollsAPI) countChangeKey(c *gin.Context, undo bool) (string, bool) {
+	key := c.GetHeader(service.IdempotencyHeader)
+	if len(key) > service.MaxIdempotencyKeyLen {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Idempotency-Key is too long"})
+		p.invalidCall()
+		return "", false
+	}
+	if undo && key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "An undo needs the Idempotency-Key of the change it takes back",
+		})
+		p.invalidCall()
+		return "", false
+	}
+	return key, true
+}
+
+// resultPath is the JSONPath of the vote count of an option, it matches
+// the result by option id rather than by position
+func resultPath(optionId int) string {
+	return "$.results[?(@.optionId==" + strconv.Itoa(optionId) + ")].votes"
+}
+
+// hasResult reports whether the poll counts votes for the option id
+func (p *PollsAPI) hasResult(poll *schema.Poll, optionId int) bool {
+	for _, result := range poll.Results {
+		if result.OptionId == optionId {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *PollsAPI) DeletePoll(c *gin.Context) {
 	// get the poll id
 	id := c.Param("pollId")
@@ -426,18 +1059,47 @@ func (p *PollsAPI) DeletePoll(c *gin.Context) {
 
 	// check if the poll exists
 	cacheKey := RedisKeyPrefix + id
-	_, err := p.helper.JSONGet(cacheKey, ".")
+	var poll schema.Poll
+	err := getItemFromRedis(id, p, &poll)
 	if err != nil {
 		p.invalidCall()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find poll in cache with id=" + cacheKey})
 		return
 	}
 
-	// recursive delete of votes will be tough, unless the ids are known...
-	// since deleting a poll would also require vote details being removed from voters vote list
-	// value search for a key in redis is reqiured :(
-	// delete the poll
-	_, err = p.helper.JSONDel(cacheKey, ".")
+	// with ?cascade=true the votes cast on the poll are deleted first, and
+	// removed from the voters' histories. If that fails the poll is kept, so
+	// the delete can be retried.
+	var report json.RawMessage
+	if c.Query("cascade") == "true" {
+		report, err = p.deleteVotes(id)
+		if err != nil {
+			p.invalidCall()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Could not delete the votes of the poll\n" + err.Error(),
+				"cascade": report,
+			})
+			return
+		}
+	}
+
+	// delete the poll with its revisions and take it off the schedule
+	genHalJSONResponse(&poll, p)
+	_, err = p.client.TxPipelined(p.context, func(pipe redis.Pipeliner) error {
+		pipe.Do(p.context, "JSON.DEL", cacheKey, ".")
+		for n := 1; n <= poll.CurrentRevision(); n++ {
+			pipe.Del(p.context, revisionKey(poll.Id, n))
+		}
+		pipe.Del(p.context, resultsSeqKey(id))
+		pipe.Del(p.context, schema.LedgerKey(poll.Id))
+		pipe.Del(p.context, schema.ActivityKey(poll.Id))
+		service.Unindex(p.context, pipe, schema.PollIdsKey, poll.Id)
+		// ends the results streams of the poll
+		pipe.Publish(p.context, resultsChannel(id), pollDeletedMessage)
+		pipe.ZRem(p.context, opensAtKey, id)
+		pipe.ZRem(p.context, closesAtKey, id)
+		return p.outbox.Add(p.context, pipe, events.PollDeleted, pollSubject(poll.Id), gin.H{"poll": poll})
+	})
 	if err != nil {
 		p.invalidCall()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete poll from cache"})
@@ -445,16 +1107,107 @@ func (p *PollsAPI) DeletePoll(c *gin.Context) {
 	}
 
 	p.validCall()
+	if report != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Poll deleted", "cascade": report})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Poll deleted"})
 }
 
-func (p *PollsAPI) savePoll(poll *schema.Poll) error {
-	// save vote in redis with votes:<id> as key
-	cacheKey := RedisKeyPrefix + strconv.Itoa(poll.Id)
-	_, err := p.helper.JSONSet(cacheKey, ".", poll)
+// embedVoters puts the voters that voted on the poll in _embedded. The
+// votes api resolves them, only the embedded voter of each vote is asked for.
+func (p *PollsAPI) embedVoters(poll *schema.Poll) error {
+	votesUrl := p.InternalAPI.Votes + "/polls/" + strconv.Itoa(poll.Id)
+	resp, err := p.apiClient.R().
+		SetQueryParams(map[string]string{"embed": "voter", "fields": "_embedded"}).
+		Get(votesUrl)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("votes api returned %d", resp.StatusCode())
+	}
+
+	var votes []struct {
+		Embedded struct {
+			Voter schema.Voter `json:"Voter"`
+		} `json:"_embedded"`
+	}
+	err = json.Unmarshal(resp.Body(), &votes)
 	if err != nil {
 		return err
 	}
 
+	voters := make([]schema.Voter, 0, len(votes))
+	for _, vote := range votes {
+		voters = append(voters, vote.Embedded.Voter)
+	}
+
+	poll.Embedded = gin.H{"voters": voters}
 	return nil
 }
+
+// deleteVotes asks the votes api to delete every vote of the poll, and
+// returns its report of what was removed.
+func (p *PollsAPI) deleteVotes(id string) (json.RawMessage, error) {
+	votesUrl := p.InternalAPI.Votes + "/polls/" + id
+	resp, err := p.apiClient.R().Delete(votesUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var report json.RawMessage
+	if len(resp.Body()) > 0 {
+		report = json.RawMessage(resp.Body())
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return report, fmt.Errorf("votes api returned %d", resp.StatusCode())
+	}
+
+	return report, nil
+}
+
+// savePoll stores a new poll, it returns service.ErrExists if the id is
+// taken
+func (p *PollsAPI) savePoll(poll *schema.Poll) error {
+	key := RedisKeyPrefix + strconv.Itoa(poll.Id)
+	_, err := service.Create(p.context, p.client, []string{key}, func(pipe redis.Pipeliner, taken []bool) error {
+		if taken[0] {
+			return service.ErrExists
+		}
+		return p.writePoll(pipe, poll)
+	})
+	return err
+}
+
+// writePoll adds the commands that store a new poll to pipe, the poll key
+// is only set if it does not exist
+func (p *PollsAPI) writePoll(pipe redis.Pipeliner, poll *schema.Poll) error {
+	pollJSON, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+	revJSON, err := json.Marshal(schema.RevisionOf(poll, poll.Meta.CreatedAt))
+	if err != nil {
+		return err
+	}
+
+	// save poll in redis with polls:<id> as key, with the record of its
+	// first revision and its event, and put it on the schedule
+	cacheKey := RedisKeyPrefix + strconv.Itoa(poll.Id)
+	pipe.Do(p.context, "JSON.SET", cacheKey, ".", string(pollJSON), "NX")
+	pipe.Do(p.context, "JSON.SET", revisionKey(poll.Id, poll.Revision), ".", string(revJSON))
+	p.schedulePoll(pipe, poll)
+	service.Index(p.context, pipe, schema.PollIdsKey, poll.Id)
+	return p.outbox.Add(p.context, pipe, events.PollCreated, pollSubject(poll.Id), gin.H{"poll": poll})
+}
+
+func pollSubject(id int) string {
+	return "polls/" + strconv.Itoa(id)
+}
+
+// StartEventRelay moves the events of the poll api to the event stream in
+// the background
+func (p *PollsAPI) StartEventRelay() {
+	go p.outbox.Relay(p.context)
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Every replica reads the event stream in the consumer group "webhooks"
+// and queues a delivery for each webhook that wants the event. A delivery
+// is kept as webhooks:deliveries:<webhookId>-<eventId>, so an event read
+// twice is still delivered once, and listed newest first in the sorted set
+// webhooks:<id>:deliveries.
+//
+// Deliveries due to be sent wait in the sorted set webhooks:retry, scored
+// by when. Once a second each replica takes the due ones, by moving them a
+// lease into the future, and posts them. A delivery that fails is put back
+// with an exponential backoff, until it has been tried maxWebhookTries
+// times.
+const (
+	deliveryKeyPrefix    = WebhookKeyPrefix + "deliveries:"
+	webhookRetryKey      = WebhookKeyPrefix + "retry"
+	webhookConsumerGroup = "webhooks"
+
+	maxWebhookTries  = 8
+	webhookRetryBase = 10 * time.Second
+	webhookRetryMax  = time.Hour
+	webhookTimeout   = 10 * time.Second
+
+	// a delivery taken by a replica that crashed is sent again after this
+	deliveryLease = time.Minute
+	// deliveries are kept for a week, and the last 100 of each webhook listed
+	deliveryTTL     = 7 * 24 * time.Hour
+	deliveryLogSize = 100
+
+	dispatchInterval = time.Second
+	dispatchBatch    = 20
+)
+
+var errDeliveryNotFound = errors.New("delivery not found")
+
+// queueScript stores the delivery KEYS[1] unless it exists already, and
+// queues it in webhooks:retry (KEYS[2]) and the log of its webhook
+// (KEYS[3]). It returns 1 if the delivery is new.
+var queueScript = redis.NewScript(`
+if not redis.call("JSON.SET", KEYS[1], ".", ARGV[1], "NX") then
+	return 0
+end
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[2])
+redis.call("ZREMRANGEBYRANK", KEYS[3], 0, -tonumber(ARGV[5]) - 1)
+return 1
+`)
+
+// claimScript takes up to ARGV[3] deliveries due by ARGV[1] from the
+// retry set KEYS[1], and leases them until ARGV[2]
+var claimScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, id in ipairs(due) do
+	redis.call("ZADD", KEYS[1], ARGV[2], id)
+end
+return due
+`)
+
+func deliveryKey(id string) string {
+	return deliveryKeyPrefix + id
+}
+
+func deliveryIndexKey(webhookId int) string {
+	return webhookKey(webhookId) + ":deliveries"
+}
+
+func (p *PollsAPI) getDelivery(id string) (schema.WebhookDelivery, error) {
+	var delivery schema.WebhookDelivery
+	raw, err := p.client.Do(p.context, "JSON.GET", deliveryKey(id), ".").Text()
+	if err == redis.Nil {
+		return delivery, errDeliveryNotFound
+	}
+	if err != nil {
+		return delivery, err
+	}
+	err = json.Unmarshal([]byte(raw), &delivery)
+	return delivery, err
+}
+
+// queueDelivery makes a delivery of the event to the webhook, due now. It
+// does nothing if there is one already.
+func (p *PollsAPI) queueDelivery(webhook *schema.Webhook, event events.Event) (schema.WebhookDelivery, error) {
+	now := time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return schema.WebhookDelivery{}, err
+	}
+	delivery := schema.WebhookDelivery{
+		Id:            strconv.Itoa(webhook.Id) + "-" + event.Id,
+		WebhookId:     webhook.Id,
+		EventId:       event.Id,
+		EventType:     event.Type,
+		Status:        schema.DeliveryPending,
+		Attempts:      []schema.WebhookAttempt{},
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		Payload:       payload,
+	}
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return delivery, err
+	}
+
+	err = queueScript.Run(p.context, p.client,
+		[]string{deliveryKey(delivery.Id), webhookRetryKey, deliveryIndexKey(webhook.Id)},
+		string(deliveryJSON), delivery.Id, now.UnixMilli(), int64(deliveryTTL.Seconds()), deliveryLogSize).Err()
+	return delivery, err
+}
+
+// queueDeliveries is the handler of the consumer group, it queues the
+// event for every active webhook that wants it
+func (p *PollsAPI) queueDeliveries(_ context.Context, event events.Event) error {
+	webhooks, err := p.listWebhooks()
+	if err != nil {
+		return err
+	}
+
+	for i := range webhooks {
+		if webhooks[i].Paused || !wants(&webhooks[i], event.Type) {
+			continue
+		}
+		_, err = p.queueDelivery(&webhooks[i], event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartWebhooks reads the event stream and sends the deliveries in the
+// background
+func (p *PollsAPI) StartWebhooks() {
+	consumer := events.NewConsumer(p.client, events.ConsumerConfig{Group: webhookConsumerGroup}, p.queueDeliveries)
+	go func() {
+		err := consumer.Run(p.context)
+		if err != nil {
+			log.Println("Webhooks stopped reading events: " + err.Error())
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(dispatchInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			p.dispatchDue(now)
+		}
+	}()
+}
+
+// dispatchDue sends the deliveries that are due, at the same time
+func (p *PollsAPI) dispatchDue(now time.Time) {
+	ids, err := claimScript.Run(p.context, p.client, []string{webhookRetryKey},
+		now.UnixMilli(), now.Add(deliveryLease).UnixMilli(), dispatchBatch).StringSlice()
+	if err != nil && err != redis.Nil {
+		log.Println("Error reading due webhook deliveries: " + err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			err := p.deliver(id)
+			if err != nil {
+				log.Printf("Error delivering %s: %s", id, err.Error())
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// deliver makes one attempt at a delivery, and records it
+func (p *PollsAPI) deliver(id string) error {
+	delivery, err := p.getDelivery(id)
+	if errors.Is(err, errDeliveryNotFound) {
+		return p.client.ZRem(p.context, webhookRetryKey, id).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	attempt := schema.WebhookAttempt{At: time.Now()}
+	// the first attempt after POST .../redeliver
+	attempt.Redelivery = delivery.Tries == 0 && len(delivery.Attempts) > 0
+
+	webhook, err := p.getWebhook(delivery.WebhookId)
+	switch {
+	case errors.Is(err, errWebhookNotFound):
+		// there is nothing left to retry, the delivery fails now
+		attempt.Error = "webhook deleted"
+		delivery.Tries = maxWebhookTries - 1
+	case err != nil:
+		return err
+	default:
+		p.post(&webhook, &delivery, &attempt)
+	}
+
+	delivery.Tries++
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.NextAttemptAt = nil
+	switch {
+	case attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300:
+		delivery.Status = schema.DeliverySucceeded
+	case delivery.Tries >= maxWebhookTries:
+		delivery.Status = schema.DeliveryFailed
+	default:
+		next := time.Now().Add(retryDelay(delivery.Tries))
+		delivery.NextAttemptAt = &next
+	}
+
+	return p.saveDelivery(&delivery)
+}
+
+// post sends the delivery to the webhook, signed with its secret
+func (p *PollsAPI) post(webhook *schema.Webhook, delivery *schema.WebhookDelivery, attempt *schema.WebhookAttempt) {
+	start := time.Now()
+	defer func() {
+		attempt.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(p.context, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	timestamp := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "poll-api-webhooks")
+	req.Header.Set(events.WebhookIdHeader, strconv.Itoa(webhook.Id))
+	req.Header.Set(events.WebhookDeliveryHeader, delivery.Id)
+	req.Header.Set(events.WebhookEventHeader, delivery.EventType)
+	req.Header.Set(events.WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(events.WebhookSignatureHeader, events.Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := p.webhookClient.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	attempt.StatusCode = resp.StatusCode
+}
+
+// retryDelay is the wait after the given number of tries, it doubles from
+// webhookRetryBase up to webhookRetryMax. The wait is picked at random
+// between half and all of it, so deliveries that failed together do not
+// retry together.
+func retryDelay(tries int) time.Duration {
+	delay := webhookRetryMax
+	if tries < 20 {
+		delay = webhookRetryBase << (tries - 1)
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// saveDelivery stores the delivery, and queues it again if it has a next
+// attempt
+func (p *PollsAPI) saveDelivery(delivery *schema.WebhookDelivery) error {
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.client.TxPipelined(p.context, func(pipe redis.Pipeliner) error {
+		pipe.Do(p.context, "JSON.SET", deliveryKey(delivery.Id), ".", string(deliveryJSON))
+		pipe.Expire(p.context, deliveryKey(delivery.Id), deliveryTTL)
+		if delivery.NextAttemptAt == nil {
+			pipe.ZRem(p.context, webhookRetryKey, delivery.Id)
+		} else {
+			pipe.ZAdd(p.context, webhookRetryKey, &redis.Z{Score: float64(delivery.NextAttemptAt.UnixMilli()), Member: delivery.Id})
+		}
+		return nil
+	})
+	return err
+}
+
+// deliveryForRequest reads the delivery in :deliveryId of the webhook, and
+// answers the request itself if it cannot
+func (p *PollsAPI) deliveryForRequest(c *gin.Context, webhook *schema.Webhook) (schema.WebhookDelivery, bool) {
+	id := c.Param("deliveryId")
+	delivery, err := p.getDelivery(id)
+	if errors.Is(err, errDeliveryNotFound) || (err == nil && delivery.WebhookId != webhook.Id) {
+		p.invalidCall()
+		c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find delivery with id=" + id})
+		return delivery, false
+	}
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read delivery\n" + err.Error()})
+		return delivery, false
+	}
+	return delivery, true
+}
+
+// GetDeliveries handles GET /webhooks/:webhookId/deliveries, newest first
+func (p *PollsAPI) GetDeliveries(c *gin.Context) {
+	webhook, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+
+	ids, err := p.client.ZRevRange(p.context, deliveryIndexKey(webhook.Id), 0, -1).Result()
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not list deliveries\n" + err.Error()})
+		return
+	}
+
+	deliveries := make([]schema.WebhookDelivery, 0, len(ids))
+	for _, id := range ids {
+		delivery, err := p.getDelivery(id)
+		// expired
+		if errors.Is(err, errDeliveryNotFound) {
+			continue
+		}
+		if err != nil {
+			p.invalidCall()
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read delivery\n" + err.Error()})
+			return
+		}
+		schema.SetDeliveryLinks(p.webhooksURL(), &delivery)
+		deliveries = append(deliveries, delivery)
+	}
+
+	total := len(deliveries)
+	var list schema.List
+	list.Embedded = map[string]any{"deliveries": deliveries}
+	list.Links.Self.Href = p.webhooksURL() + "/" + strconv.Itoa(webhook.Id) + "/deliveries"
+	list.Links.Webhook = &schema.Link{Href: p.webhooksURL() + "/" + strconv.Itoa(webhook.Id)}
+	list.Meta.Total = &total
+
+	p.validCall()
+	c.JSON(http.StatusOK, list)
+}
+
+// GetDelivery handles GET /webhooks/:webhookId/deliveries/:deliveryId
+func (p *PollsAPI) GetDelivery(c *gin.Context) {
+	webhook, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+	delivery, ok := p.deliveryForRequest(c, &webhook)
+	if !ok {
+		return
+	}
+
+	schema.SetDeliveryLinks(p.webhooksURL(), &delivery)
+	p.validCall()
+	c.JSON(http.StatusOK, delivery)
+}
+
+// Redeliver handles POST /webhooks/:webhookId/deliveries/:deliveryId/redeliver.
+// The delivery is sent again right away, with a new round of tries. A
+// delivery that is still pending gets 409.
+func (p *PollsAPI) Redeliver(c *gin.Context) {
+	webhook, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+	delivery, ok := p.deliveryForRequest(c, &webhook)
+	if !ok {
+		return
+	}
+	if delivery.Status == schema.DeliveryPending {
+		p.invalidCall()
+		c.JSON(http.StatusConflict, gin.H{"msg": "Delivery is still pending"})
+		return
+	}
+
+	now := time.Now()
+	delivery.Status = schema.DeliveryPending
+	delivery.Tries = 0
+	delivery.NextAttemptAt = &now
+	err := p.saveDelivery(&delivery)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not queue delivery\n" + err.Error()})
+		return
+	}
+
+	schema.SetDeliveryLinks(p.webhooksURL(), &delivery)
+	p.validCall()
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// PingWebhook handles POST /webhooks/:webhookId/ping, it queues a
+// webhook.ping event for the webhook, even if it is paused
+func (p *PollsAPI) PingWebhook(c *gin.Context) {
+	webhook, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+
+	public := p.publicWebhook(webhook, false)
+	event, err := events.New(events.WebhookPing, "poll-api", "webhooks/"+strconv.Itoa(webhook.Id), gin.H{"webhook": public})
+	var delivery schema.WebhookDelivery
+	if err == nil {
+		delivery, err = p.queueDelivery(&webhook, event)
+	}
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not queue ping\n" + err.Error()})
+		return
+	}
+
+	schema.SetDeliveryLinks(p.webhooksURL(), &delivery)
+	p.validCall()
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// receiver is a webhook endpoint that answers with the statuses in turn,
+// and keeps every request it got
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := http.StatusOK
+	if len(r.requests) < len(r.statuses) {
+		status = r.statuses[len(r.requests)]
+	}
+	r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+// checkSigned checks the headers of a delivery against the secret
+func checkSigned(t *testing.T, req receivedRequest, webhook *schema.Webhook, delivery *schema.WebhookDelivery) {
+	t.Helper()
+	err := events.Verify(webhook.Secret, req.header.Get(events.WebhookTimestampHeader),
+		req.header.Get(events.WebhookSignatureHeader), req.body, time.Minute)
+	if err != nil {
+		t.Errorf("signature does not verify: %s", err.Error())
+	}
+	err = events.Verify("not the secret", req.header.Get(events.WebhookTimestampHeader),
+		req.header.Get(events.WebhookSignatureHeader), req.body, time.Minute)
+	if err != events.ErrBadSignature {
+		t.Errorf("signature verifies with another secret, got %v", err)
+	}
+
+	want := map[string]string{
+		events.WebhookIdHeader:       strconv.Itoa(webhook.Id),
+		events.WebhookDeliveryHeader: delivery.Id,
+		events.WebhookEventHeader:    delivery.EventType,
+		"Content-Type":               "application/json",
+	}
+	for header, value := range want {
+		if got := req.header.Get(header); got != value {
+			t.Errorf("%s is %q, want %q", header, got, value)
+		}
+	}
+	if string(req.body) != string(delivery.Payload) {
+		t.Errorf("body is %s, want the payload %s", req.body, delivery.Payload)
+	}
+}
+
+func TestPostSignsDelivery(t *testing.T) {
+	rec := &receiver{statuses: []int{http.StatusOK, http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	p := &PollsAPI{webhookClient: srv.Client()}
+	p.context = context.Background()
+	webhook := schema.Webhook{Id: 3, URL: srv.URL, Secret: "s3cret"}
+	delivery := schema.WebhookDelivery{
+		Id:        "3-abc",
+		WebhookId: 3,
+		EventType: events.VoteCast,
+		Payload:   []byte(`{"id":"abc","type":"vote.cast"}`),
+	}
+
+	var ok, failed schema.WebhookAttempt
+	p.post(&webhook, &delivery, &ok)
+	p.post(&webhook, &delivery, &failed)
+
+	if ok.StatusCode != http.StatusOK || ok.Error != "" {
+		t.Errorf("first attempt is %+v, want 200", ok)
+	}
+	if failed.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second attempt is %+v, want 503", failed)
+	}
+	requests := rec.received()
+	if len(requests) != 2 {
+		t.Fatalf("receiver got %d requests, want 2", len(requests))
+	}
+	for _, req := range requests {
+		checkSigned(t, req, &webhook, &delivery)
+	}
+
+	// a receiver that cannot be reached is an error, not a status
+	srv.Close()
+	var down schema.WebhookAttempt
+	p.post(&webhook, &delivery, &down)
+	if down.Error == "" || down.StatusCode != 0 {
+		t.Errorf("attempt to a closed server is %+v, want an error", down)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for tries := 1; tries <= 25; tries++ {
+		full := webhookRetryMax
+		if tries < 20 && webhookRetryBase<<(tries-1) < webhookRetryMax {
+			full = webhookRetryBase << (tries - 1)
+		}
+		for i := 0; i < 20; i++ {
+			delay := retryDelay(tries)
+			if delay < full/2 || delay > full {
+				t.Fatalf("retryDelay(%d) = %s, want between %s and %s", tries, delay, full/2, full)
+			}
+		}
+	}
+}
+
+// testPollsAPI is a PollsAPI on database 15 of the redis at REDIS_URL,
+// which needs RedisJSON. Without one the test is skipped.
+func testPollsAPI(t *testing.T, webhookClient *http.Client) *PollsAPI {
+	t.Helper()
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		t.Skipf("redis at %s not reachable: %s", addr, err.Error())
+	}
+	err = client.FlushDB(ctx).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB(ctx)
+		client.Close()
+	})
+
+	p := &PollsAPI{webhookClient: webhookClient}
+	p.client = client
+	p.context = ctx
+	return p
+}
+
+func TestDeliverRetriesAndRedelivers(t *testing.T) {
+	rec := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	p := testPollsAPI(t, srv.Client())
+
+	webhook := schema.Webhook{Id: 1, URL: srv.URL, Events: []string{"vote.*"}, Secret: "s3cret"}
+	err := p.saveWebhook(&webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := events.New(events.VoteCast, "votes-api", "votes/7", gin.H{"vote": gin.H{"id": 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := p.queueDelivery(&webhook, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an event read twice is queued once
+	_, err = p.queueDelivery(&webhook, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := p.client.ZCard(p.context, deliveryIndexKey(webhook.Id)).Result()
+	if err != nil || entries != 1 {
+		t.Fatalf("webhook has %d deliveries, want 1", entries)
+	}
+
+	// 5xx answers are retried with a growing wait
+	for try := 1; try <= 2; try++ {
+		before := time.Now()
+		err = p.deliver(queued.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		delivery, err := p.getDelivery(queued.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != schema.DeliveryPending || delivery.Tries != try || len(delivery.Attempts) != try {
+			t.Fatalf("after try %d the delivery is %s with %d tries", try, delivery.Status, delivery.Tries)
+		}
+		if delivery.NextAttemptAt == nil {
+			t.Fatalf("after try %d the delivery has no next attempt", try)
+		}
+		full := webhookRetryBase << (try - 1)
+		wait := delivery.NextAttemptAt.Sub(before)
+		if wait < full/2-time.Second || wait > full+time.Second {
+			t.Errorf("after try %d the next attempt is in %s, want %s to %s", try, wait, full/2, full)
+		}
+		score, err := p.client.ZScore(p.context, webhookRetryKey, queued.Id).Result()
+		if err != nil || int64(score) != delivery.NextAttemptAt.UnixMilli() {
+			t.Errorf("retry set has %v for the delivery, want %d", score, delivery.NextAttemptAt.UnixMilli())
+		}
+	}
+
+	err = p.deliver(queued.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := p.getDelivery(queued.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != schema.DeliverySucceeded || delivery.NextAttemptAt != nil {
+		t.Fatalf("after a 200 the delivery is %s, next attempt %v", delivery.Status, delivery.NextAttemptAt)
+	}
+	if _, err := p.client.ZScore(p.context, webhookRetryKey, queued.Id).Result(); err != redis.Nil {
+		t.Errorf("a delivery that succeeded is still in the retry set")
+	}
+
+	// redelivering sends it again right away, with a new round of tries. A
+	// second redelivery while it is pending is refused.
+	if code := redeliver(p, webhook.Id, queued.Id); code != http.StatusAccepted {
+		t.Fatalf("redeliver answered %d, want 202", code)
+	}
+	if code := redeliver(p, webhook.Id, queued.Id); code != http.StatusConflict {
+		t.Errorf("redeliver of a pending delivery answered %d, want 409", code)
+	}
+	err = p.deliver(queued.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err = p.getDelivery(queued.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := delivery.Attempts[len(delivery.Attempts)-1]
+	if delivery.Status != schema.DeliverySucceeded || delivery.Tries != 1 || len(delivery.Attempts) != 4 || !last.Redelivery {
+		t.Errorf("after redelivering the delivery is %s with %d tries and %d attempts, last %+v",
+			delivery.Status, delivery.Tries, len(delivery.Attempts), last)
+	}
+
+	requests := rec.received()
+	if len(requests) != 4 {
+		t.Fatalf("receiver got %d requests, want 4", len(requests))
+	}
+	for _, req := range requests {
+		checkSigned(t, req, &webhook, &delivery)
+	}
+}
+
+// redeliver calls POST /webhooks/:webhookId/deliveries/:deliveryId/redeliver
+// and returns the status
+func redeliver(p *PollsAPI, webhookId int, deliveryId string) int {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	c.Params = gin.Params{{Key: "webhookId", Value: strconv.Itoa(webhookId)}, {Key: "deliveryId", Value: deliveryId}}
+	p.Redeliver(c)
+	return w.Code
+}
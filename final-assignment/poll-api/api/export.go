@@ -0,0 +1,42 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+)
+
+// exportResults answers GET /polls/:pollId/results?format=csv|ndjson|xlsx
+// with one row per option: its id, text and number of votes. ?format=json
+// is the usual response.
+func (p *PollsAPI) exportResults(c *gin.Context, poll *schema.Poll, format string) {
+	err := schema.CheckExportFormat(format)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", schema.ExportContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="poll-`+strconv.Itoa(poll.Id)+`-results.`+format+`"`)
+	w, err := schema.NewTableWriter(c.Writer, format, "optionId", "option", "votes")
+	for _, result := range poll.Results {
+		if err != nil {
+			break
+		}
+		err = w.WriteRow(result.OptionId, poll.OptionText(result.OptionId), result.Votes)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		// the headers are sent, all that is left is to stop
+		log.Printf("Could not export results of poll %d: %v", poll.Id, err)
+		p.invalidCall()
+		return
+	}
+	p.validCall()
+}
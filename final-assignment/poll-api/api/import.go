@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// ImportPolls handles POST /polls/import. The body is NDJSON or CSV (see
+// schema.ImportReader), a poll per row, with its options as JSON in CSV. A
+// row with an id is created as POST /polls/:pollId would, one without as
+// POST /polls, and is checked the same way. Every batch of rows is written
+// in one transaction. With ?dryRun=true the rows are only checked.
+func (p *PollsAPI) ImportPolls(c *gin.Context) {
+	format, err := schema.ImportFormat(c.Query("format"), c.ContentType())
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	reader, err := schema.NewImportReader(c.Request.Body, format, &schema.Poll{})
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Could not read import\n" + err.Error()})
+		return
+	}
+
+	report := schema.NewImportReport(c.Query("dryRun") == "true")
+	seen := map[int]bool{}
+	check := func(poll *schema.Poll) error {
+		if poll.Id < 0 {
+			return errors.New("invalid poll id")
+		}
+		err := p.preparePoll(poll)
+		if err != nil || poll.Id == 0 {
+			return err
+		}
+		if seen[poll.Id] {
+			return fmt.Errorf("poll %d is in the import twice", poll.Id)
+		}
+		seen[poll.Id] = true
+
+		// confirm that the poll does not exist
+		exists, err := p.client.Exists(p.context, RedisKeyPrefix+strconv.Itoa(poll.Id)).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return errors.New("poll already exists")
+		}
+		return nil
+	}
+	write := func(polls []*schema.Poll) []error {
+		for _, poll := range polls {
+			if poll.Id != 0 {
+				continue
+			}
+			// an id the counter hands out may be picked by a later row
+			var err error
+			for poll.Id == 0 || seen[poll.Id] {
+				poll.Id, err = service.NextId(p.context, p.client, RedisKeyPrefix)
+				if err != nil {
+					return schema.BatchErrors(len(polls), err)
+				}
+			}
+			seen[poll.Id] = true
+			// the links were made before the poll had its id
+			genHalJSONResponse(poll, p)
+		}
+
+		keys := make([]string, len(polls))
+		for i, poll := range polls {
+			keys[i] = RedisKeyPrefix + strconv.Itoa(poll.Id)
+		}
+		// a row whose key was taken since it was checked fails on its own
+		taken, err := service.Create(p.context, p.client, keys, func(pipe redis.Pipeliner, taken []bool) error {
+			for i, poll := range polls {
+				if taken[i] {
+					continue
+				}
+				err := p.writePoll(pipe, poll)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return schema.BatchErrors(len(polls), err)
+		}
+		return schema.ClaimErrors(taken, errors.New("poll already exists"))
+	}
+
+	err = schema.ImportRows(reader, &report, check, write, func(poll *schema.Poll) int { return poll.Id })
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Could not read import\n" + err.Error(), "report": report})
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, report)
+}
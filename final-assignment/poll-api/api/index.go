@@ -0,0 +1,22 @@
+package api
+
+import (
+	"drexel.edu/schema"
+	"drexel.edu/service"
+)
+
+// The ids of the polls are kept in the sorted set schema.PollIdsKey, and the
+// ids of the webhooks in webhookIdsKey. The lists of polls and webhooks are
+// paged from them, see service.PageIds. An id is added in the transaction
+// that creates the item and removed in the one that deletes it.
+
+// TrackIds adds the polls and webhooks that are stored to their indexes,
+// for those created before the indexes were kept. Run it before the api
+// serves requests, see service.TrackIds.
+func (p *PollsAPI) TrackIds() error {
+	err := service.TrackIds(p.context, p.client, RedisKeyPrefix, schema.PollIdsKey)
+	if err != nil {
+		return err
+	}
+	return service.TrackIds(p.context, p.client, WebhookKeyPrefix, webhookIdsKey)
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// The votes api appends every vote that is cast, changed or deleted to the
+// ledger of its poll, see schema.LedgerEntry. The poll api serves the
+// ledger and checks it against the results. The ledger is deleted with the
+// poll.
+
+// readLedger reads the poll and its ledger at the same moment, so no vote
+// can land in between. An entry that is not valid JSON is returned empty,
+// and fails verification.
+func (p *PollsAPI) readLedger(poll *schema.Poll) ([]schema.LedgerEntry, error) {
+	var pollJSON *redis.Cmd
+	var ledger *redis.StringSliceCmd
+	_, err := p.client.TxPipelined(p.context, func(pipe redis.Pipeliner) error {
+		pollJSON = pipe.Do(p.context, "JSON.GET", RedisKeyPrefix+strconv.Itoa(poll.Id), ".")
+		ledger = pipe.LRange(p.context, schema.LedgerKey(poll.Id), 0, -1)
+		return nil
+	})
+	// deleted since the request started
+	if err == redis.Nil {
+		return nil, errPollNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := pollJSON.Text()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(raw), poll)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]schema.LedgerEntry, len(ledger.Val()))
+	for i, raw := range ledger.Val() {
+		json.Unmarshal([]byte(raw), &entries[i])
+	}
+	return entries, nil
+}
+
+func (p *PollsAPI) ledgerURL(pollId int) string {
+	return p.API.Self + "/polls/" + strconv.Itoa(pollId) + "/ledger"
+}
+
+// sendLedgerError answers a request whose ledger could not be read
+func (p *PollsAPI) sendLedgerError(c *gin.Context, poll *schema.Poll, err error) {
+	p.invalidCall()
+	if errors.Is(err, errPollNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find poll with id=" + strconv.Itoa(poll.Id)})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read ledger\n" + err.Error()})
+}
+
+// GetLedger handles GET /polls/:pollId/ledger, oldest entry first
+func (p *PollsAPI) GetLedger(c *gin.Context) {
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+
+	entries, err := p.readLedger(&poll)
+	if err != nil {
+		p.sendLedgerError(c, &poll, err)
+		return
+	}
+
+	total := len(entries)
+	var list schema.List
+	list.Embedded = map[string]any{"entries": entries}
+	list.Links.Self.Href = p.ledgerURL(poll.Id)
+	list.Links.Poll.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id)
+	list.Links.Verify = &schema.Link{Href: p.ledgerURL(poll.Id) + "/verify"}
+	list.Meta.Total = &total
+
+	p.validCall()
+	c.JSON(http.StatusOK, list)
+}
+
+// VerifyLedger handles GET /polls/:pollId/ledger/verify. It recomputes the
+// hash chain of the ledger and checks the results of the poll against the
+// votes in it. A ledger that does not verify is still answered with 200,
+// with valid set to false.
+func (p *PollsAPI) VerifyLedger(c *gin.Context) {
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+
+	entries, err := p.readLedger(&poll)
+	if err != nil {
+		p.sendLedgerError(c, &poll, err)
+		return
+	}
+
+	verification := schema.VerifyLedger(&poll, entries)
+	verification.Links.Self.Href = p.ledgerURL(poll.Id) + "/verify"
+	verification.Links.Poll.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id)
+	verification.Links.Ledger = &schema.Link{Href: p.ledgerURL(poll.Id)}
+
+	p.validCall()
+	c.JSON(http.StatusOK, verification)
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// A poll goes from draft or scheduled to open, closed and archived, see
+// schema/lifecycle.go. POST /polls/:pollId/open, /close and /archive move a
+// poll by hand, and the scheduler opens and closes polls when their opensAt
+// and closesAt come. The polls waiting for either are kept in two sorted
+// sets scored by that time, so the scheduler does not read every poll.
+// Closing a poll freezes a snapshot of its results, which never changes
+// after that.
+const (
+	opensAtKey  = RedisKeyPrefix + "schedule:opens-at"
+	closesAtKey = RedisKeyPrefix + "schedule:closes-at"
+
+	// how often the scheduler looks for polls to open or close
+	schedulerInterval = time.Second
+
+	// number of times an optimistic update is retried before giving up
+	maxUpdateRetries = 50
+
+	// number of times the ballots of a poll that is closed are read, and
+	// the wait before reading them again
+	maxBallotReads   = 5
+	ballotRetryDelay = 100 * time.Millisecond
+)
+
+var (
+	errPollNotFound      = errors.New("poll not found")
+	errInvalidTransition = errors.New("invalid poll state change")
+	errInvalidLifecycle  = errors.New("invalid poll lifecycle")
+	errNotDue            = errors.New("poll is not due yet")
+	errPollClosed        = errors.New("poll is closed")
+	errBallotsChanged    = errors.New("the ballots read do not match the results")
+)
+
+// stateEvents is the event of moving a poll to each state
+var stateEvents = map[string]string{
+	schema.PollOpen:     events.PollOpened,
+	schema.PollClosed:   events.PollClosed,
+	schema.PollArchived: events.PollArchived,
+}
+
+// prepareLifecycle sets the state of a poll that is created or updated. A
+// poll without a state is scheduled if it opens in the future, and open
+// otherwise. Only draft, scheduled and open can be asked for.
+func prepareLifecycle(poll *schema.Poll, now time.Time) error {
+	// the snapshot is only ever taken by closing the poll
+	poll.Snapshot = nil
+
+	if poll.State == "" {
+		poll.State = schema.PollOpen
+		if poll.OpensAt != nil && poll.OpensAt.After(now) {
+			poll.State = schema.PollScheduled
+		}
+	}
+
+	switch poll.State {
+	case schema.PollDraft:
+	case schema.PollScheduled:
+		if poll.OpensAt == nil {
+			return fmt.Errorf("%w, a scheduled poll needs opensAt", errInvalidLifecycle)
+		}
+		if !poll.OpensAt.After(now) {
+			poll.State = schema.PollOpen
+		}
+	case schema.PollOpen:
+		if poll.OpensAt == nil || poll.OpensAt.After(now) {
+			poll.OpensAt = &now
+		}
+	default:
+		return fmt.Errorf("%w, a poll cannot be saved as %q, use the open, close and archive routes", errInvalidLifecycle, poll.State)
+	}
+
+	if poll.OpensAt != nil && poll.ClosesAt != nil && !poll.ClosesAt.After(*poll.OpensAt) {
+		return fmt.Errorf("%w, closesAt must be after opensAt", errInvalidLifecycle)
+	}
+	return nil
+}
+
+// schedulePoll queues the writes that keep the schedule sets in step with
+// the state of the poll
+func (p *PollsAPI) schedulePoll(pipe redis.Pipeliner, poll *schema.Poll) {
+	id := poll.Id
+	pipe.ZRem(p.context, opensAtKey, id)
+	pipe.ZRem(p.context, closesAtKey, id)
+
+	switch poll.CurrentState() {
+	case schema.PollScheduled:
+		pipe.ZAdd(p.context, opensAtKey, &redis.Z{Score: float64(poll.OpensAt.UnixMilli()), Member: id})
+	case schema.PollOpen:
+		if poll.ClosesAt != nil {
+			pipe.ZAdd(p.context, closesAtKey, &redis.Z{Score: float64(poll.ClosesAt.UnixMilli()), Member: id})
+		}
+	}
+}
+
+// updatePollAtomic applies fn to the stored poll using WATCH/MULTI, so the
+// write only goes through if nobody else changed the poll in the meantime.
+// On a conflict the poll is re-read and fn is applied again.
+func (p *PollsAPI) updatePollAtomic(id string, fn func(poll *schema.Poll) error) (schema.Poll, error) {
+	return p.updatePollWith(id, fn, nil)
+}
+
+// updatePollWith is updatePollAtomic, and write adds more commands to the
+// transaction that saves the poll, if it is not nil
+func (p *PollsAPI) updatePollWith(id string, fn func(poll *schema.Poll) error, write func(pipe redis.Pipeliner, poll *schema.Poll) error) (schema.Poll, error) {
+	var poll schema.Poll
+	pollKey := RedisKeyPrefix + id
+
+	txf := func(tx *redis.Tx) error {
+		get := redis.NewStringCmd(p.context, "JSON.GET", pollKey, ".")
+		err := tx.Process(p.context, get)
+		if err == redis.Nil {
+			return errPollNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		poll = schema.Poll{}
+		err = json.Unmarshal([]byte(get.Val()), &poll)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&poll)
+		if err != nil {
+			return err
+		}
+		poll.Meta.UpdatedAt = time.Now()
+		poll.Meta.TotalVotes = poll.CountVotes()
+		genHalJSONResponse(&poll, p)
+
+		newJSON, err := json.Marshal(poll)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(p.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(p.context, "JSON.SET", pollKey, ".", string(newJSON))
+			p.schedulePoll(pipe, &poll)
+			if write != nil {
+				return write(pipe, &poll)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := p.client.Watch(p.context, txf, pollKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err == nil {
+			// the results streams send the poll as it is now, which may
+			// already include a later change
+			_, err = p.publishResults(id)
+			if err != nil {
+				log.Printf("Could not publish the results of poll %s: %s", id, err.Error())
+			}
+			return poll, nil
+		}
+		return poll, err
+	}
+
+	return poll, redis.TxFailedErr
+}
+
+// transition moves the poll to the state to. With due set, it is the
+// scheduler asking, and the poll only moves if its opensAt or closesAt has
+// come. Closing a poll counts its ballots for the snapshot.
+func (p *PollsAPI) transition(id string, to string, now time.Time, due bool) (schema.Poll, error) {
+	if to != schema.PollClosed {
+		return p.moveTo(id, to, now, due, nil, 0)
+	}
+
+	// the votes api is asked for the ballots before the poll is watched, so
+	// the WATCH is not held during the call. A vote counted in the meantime
+	// makes them differ from the results the snapshot is taken from, then
+	// they are read again.
+	for i := 0; ; i++ {
+		var poll schema.Poll
+		err := getItemFromRedis(id, p, &poll)
+		if err == redis.Nil {
+			return poll, errPollNotFound
+		}
+		if err != nil {
+			return poll, err
+		}
+		registered, err := p.registeredVoters()
+		if err != nil {
+			return poll, err
+		}
+		var votes []schema.Vote
+		if poll.Rules().Type != schema.BallotSingle {
+			votes, err = p.countedVotes(&poll)
+			if err != nil {
+				return poll, fmt.Errorf("could not read the ballots: %w", err)
+			}
+		}
+
+		closed, err := p.moveTo(id, to, now, due, votes, registered)
+		if !errors.Is(err, errBallotsChanged) {
+			return closed, err
+		}
+		if i == maxBallotReads-1 {
+			return closed, fmt.Errorf("%w %d times, a recount may be needed", err, maxBallotReads)
+		}
+		time.Sleep(ballotRetryDelay)
+	}
+}
+
+// moveTo is transition with the counted votes of a poll that is closed, and
+// the number of registered voters for the turnout quorum
+func (p *PollsAPI) moveTo(id string, to string, now time.Time, due bool, votes []schema.Vote, registered int) (schema.Poll, error) {
+	eventType, ok := stateEvents[to]
+	if !ok {
+		eventType = events.PollUpdated
+	}
+
+	return p.updatePollWith(id, func(poll *schema.Poll) error {
+		if due {
+			at := poll.OpensAt
+			if to == schema.PollClosed {
+				at = poll.ClosesAt
+			}
+			if at == nil || at.After(now) {
+				return errNotDue
+			}
+		}
+
+		from := poll.CurrentState()
+		if !schema.CanTransition(from, to) {
+			return fmt.Errorf("%w from %s to %s", errInvalidTransition, from, to)
+		}
+		poll.State = to
+
+		switch to {
+		case schema.PollScheduled:
+			if poll.OpensAt == nil || !poll.OpensAt.After(now) {
+				return fmt.Errorf("%w, opensAt is not in the future", errInvalidTransition)
+			}
+		case schema.PollOpen:
+			if poll.OpensAt == nil || poll.OpensAt.After(now) {
+				poll.OpensAt = &now
+			}
+		case schema.PollClosed:
+			if poll.ClosesAt == nil || poll.ClosesAt.After(now) {
+				poll.ClosesAt = &now
+			}
+			ballots := poll.BallotsFromResults()
+			if poll.Rules().Type != schema.BallotSingle {
+				if !matchesResults(poll, votes) {
+					return errBallotsChanged
+				}
+				ballots = ballotsOf(votes)
+			}
+			snapshot := poll.TakeSnapshot(now)
+			if poll.DecisionRules().TieBreak == schema.TieBreakLot {
+				seed, err := schema.NewLotSeed()
+				if err != nil {
+					return err
+				}
+				snapshot.LotSeed = &seed
+			}
+			poll.Snapshot = &snapshot
+			outcome, err := poll.Tally("", ballots)
+			if err != nil {
+				return err
+			}
+			poll.Decide(&outcome, registered)
+			snapshot.Outcome = &outcome
+		}
+		return nil
+	}, func(pipe redis.Pipeliner, poll *schema.Poll) error {
+		return p.outbox.Add(p.context, pipe, eventType, pollSubject(poll.Id), gin.H{"poll": poll})
+	})
+}
+
+// OpenPoll handles POST /polls/:pollId/open
+func (p *PollsAPI) OpenPoll(c *gin.Context) {
+	p.changeState(c, schema.PollOpen)
+}
+
+// ClosePoll handles POST /polls/:pollId/close, it freezes the results
+func (p *PollsAPI) ClosePoll(c *gin.Context) {
+	p.changeState(c, schema.PollClosed)
+}
+
+// ArchivePoll handles POST /polls/:pollId/archive
+func (p *PollsAPI) ArchivePoll(c *gin.Context) {
+	p.changeState(c, schema.PollArchived)
+}
+
+func (p *PollsAPI) changeState(c *gin.Context, to string) {
+	id := c.Param("pollId")
+	if id == "" {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		return
+	}
+
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid poll id",
+		})
+		p.invalidCall()
+		return
+	}
+
+	poll, err := p.transition(id, to, time.Now(), false)
+	if errors.Is(err, errPollNotFound) {
+		p.invalidCall()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find poll in cache with id=" + RedisKeyPrefix + id})
+		return
+	}
+	if errors.Is(err, errInvalidTransition) {
+		p.invalidCall()
+		c.JSON(http.StatusConflict, gin.H{"error": "Poll cannot be moved to " + to + ", " + err.Error()})
+		return
+	}
+	if errors.Is(err, errBallotsChanged) {
+		p.invalidCall()
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{"error": "Votes are still being counted, try again\n" + err.Error()})
+		return
+	}
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update poll state\n" + err.Error()})
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, poll)
+}
+
+// StartScheduler opens and closes polls on time in the background. Every
+// replica runs it, the WATCH in updatePollAtomic makes sure each poll is
+// only moved once.
+func (p *PollsAPI) StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			p.runDue(opensAtKey, schema.PollOpen, now)
+			p.runDue(closesAtKey, schema.PollClosed, now)
+		}
+	}()
+}
+
+// runDue moves every poll in the schedule set key whose time has come to
+// the state to
+func (p *PollsAPI) runDue(key string, to string, now time.Time) {
+	ids, err := p.client.ZRangeByScore(p.context, key, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		log.Println("Error reading poll schedule: " + err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		_, err := p.transition(id, to, now, true)
+		switch {
+		case err == nil:
+			log.Printf("Poll %s is now %s", id, to)
+		case errors.Is(err, errNotDue):
+			// the poll was rescheduled, its new time is in the set already
+		case errors.Is(err, errPollNotFound), errors.Is(err, errInvalidTransition):
+			// nothing left to do for this poll
+			p.client.ZRem(p.context, key, id)
+		default:
+			log.Printf("Error moving poll %s to %s: %s", id, to, err.Error())
+		}
+	}
+}
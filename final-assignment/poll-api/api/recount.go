@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+)
+
+// Recount handles POST /polls/:pollId/recount. It counts the votes the votes
+// api has for the poll again and reports where the results and total votes
+// of the poll differ. With ?repair=true the results are replaced by the
+// recount, the snapshot of a closed poll is left as it is.
+func (p *PollsAPI) Recount(c *gin.Context) {
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+	repair := c.Query("repair") == "true"
+
+	votes, err := p.votesOf(poll.Id, "id", "pollId", "voteValue", "pollRevision")
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadGateway, gin.H{"msg": "Could not read the votes of the poll\n" + err.Error()})
+		return
+	}
+
+	recount := schema.RecountPoll(&poll, votes)
+	if repair && len(recount.Discrepancies) > 0 {
+		id := strconv.Itoa(poll.Id)
+		_, err = p.updatePollAtomic(id, func(poll *schema.Poll) error {
+			// the options may have changed since the votes were read
+			recount = schema.RecountPoll(poll, votes)
+			poll.Results = recount.Results
+			return nil
+		})
+		if err == nil {
+			_, err = p.publishResults(id)
+		}
+		if errors.Is(err, errPollNotFound) {
+			p.invalidCall()
+			c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find poll with id=" + id})
+			return
+		}
+		if err != nil {
+			p.invalidCall()
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not save the recount\n" + err.Error()})
+			return
+		}
+
+		recount.Repaired = true
+		for i := range recount.Discrepancies {
+			recount.Discrepancies[i].Repaired = true
+		}
+	}
+
+	recount.Links.Self.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id) + "/recount"
+	recount.Links.Poll.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id)
+	recount.Links.Results.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id) + "/results"
+
+	p.validCall()
+	c.JSON(http.StatusOK, recount)
+}
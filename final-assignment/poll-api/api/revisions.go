@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Revision n of poll <id> is kept as polls:<id>:rev:<n>. The record of the
+// current revision is written when the revision is made, and again with the
+// final results when it is replaced. These keys do not end in a number
+// after the prefix, so service.ScanIds skips them.
+
+var (
+	errPollHasVotes     = errors.New("poll has votes")
+	errRevisionNotFound = errors.New("no such revision")
+	errInvalidPolicy    = errors.New("invalid edit policy")
+)
+
+func revisionKey(pollId int, revision int) string {
+	return RedisKeyPrefix + strconv.Itoa(pollId) + ":rev:" + strconv.Itoa(revision)
+}
+
+// SetEditPolicy sets the edit policy of polls that do not have their own
+func (p *PollsAPI) SetEditPolicy(policy string) error {
+	if !schema.ValidEditPolicy(policy) {
+		return fmt.Errorf("%w %q", errInvalidPolicy, policy)
+	}
+	p.editPolicy = policy
+	return nil
+}
+
+// policyOf is the edit policy that applies to the poll
+func (p *PollsAPI) policyOf(poll *schema.Poll) string {
+	if poll.EditPolicy != "" {
+		return poll.EditPolicy
+	}
+	if p.editPolicy != "" {
+		return p.editPolicy
+	}
+	return schema.EditNewRevision
+}
+
+// revise sets the revision and the results of newPoll, an edit of the poll
+// old. If the title, question, options or ballot changed, newPoll is the
+// next revision, and the policy of old decides what happens to the votes.
+// It returns whether there is a new revision, and the policy applied to the
+// votes, which is empty when there were none.
+func (p *PollsAPI) revise(old *schema.Poll, newPoll *schema.Poll) (bool, string, error) {
+	if newPoll.EditPolicy == "" {
+		newPoll.EditPolicy = old.EditPolicy
+	}
+	if newPoll.EditPolicy != "" && !schema.ValidEditPolicy(newPoll.EditPolicy) {
+		return false, "", fmt.Errorf("%w %q", errInvalidPolicy, newPoll.EditPolicy)
+	}
+
+	newPoll.Revision = old.Revision
+	newPoll.ResultsSince = old.ResultsSince
+	newPoll.ResetResults(old.Results)
+
+	diff := schema.Diff(schema.RevisionOf(old, time.Time{}), schema.RevisionOf(newPoll, time.Time{}))
+	if diff.Empty() {
+		return false, "", nil
+	}
+	newPoll.Revision = old.CurrentRevision() + 1
+	if !old.HasVotes() {
+		return true, "", nil
+	}
+
+	policy := p.policyOf(old)
+	switch policy {
+	case schema.EditReject:
+		return false, policy, fmt.Errorf("%w, its edit policy is %s", errPollHasVotes, policy)
+	case schema.EditReset:
+		newPoll.ResetResults(nil)
+		newPoll.ResultsSince = newPoll.Revision
+	}
+	return true, policy, nil
+}
+
+// getRevision reads the stored record of a revision
+func (p *PollsAPI) getRevision(pollId int, revision int) (schema.PollRevision, error) {
+	var rev schema.PollRevision
+	raw, err := p.client.Do(p.context, "JSON.GET", revisionKey(pollId, revision), ".").Text()
+	if err == redis.Nil {
+		return rev, errRevisionNotFound
+	}
+	if err != nil {
+		return rev, err
+	}
+	err = json.Unmarshal([]byte(raw), &rev)
+	return rev, err
+}
+
+// revision is revision n of the poll. The current revision carries the live
+// results, and a poll stored before there were revisions has only the
+// current one.
+func (p *PollsAPI) revision(poll *schema.Poll, n int) (schema.PollRevision, error) {
+	current := poll.CurrentRevision()
+	if n < 1 || n > current {
+		return schema.PollRevision{}, errRevisionNotFound
+	}
+
+	rev, err := p.getRevision(poll.Id, n)
+	if n == current && errors.Is(err, errRevisionNotFound) {
+		rev, err = schema.RevisionOf(poll, poll.Meta.CreatedAt), nil
+	}
+	if err != nil {
+		return rev, err
+	}
+	if n == current {
+		rev.Results = poll.Results
+	}
+
+	schema.SetRevisionLinks(p.endpoints(), &rev)
+	return rev, nil
+}
+
+// writeRevision stores the record of a new revision, and the final results
+// of the revision it replaces, in the transaction of the edit
+func (p *PollsAPI) writeRevision(pipe redis.Pipeliner, replaced schema.PollRevision, rev schema.PollRevision) error {
+	replacedJSON, err := json.Marshal(replaced)
+	if err != nil {
+		return err
+	}
+	revJSON, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	pipe.Do(p.context, "JSON.SET", revisionKey(replaced.PollId, replaced.Revision), ".", string(replacedJSON))
+	pipe.Do(p.context, "JSON.SET", revisionKey(rev.PollId, rev.Revision), ".", string(revJSON))
+	return nil
+}
+
+// pollForRevisions reads the poll of a revisions request, and answers the
+// request itself if it cannot
+func (p *PollsAPI) pollForRevisions(c *gin.Context) (schema.Poll, bool) {
+	var poll schema.Poll
+	id := c.Param("pollId")
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid poll id",
+		})
+		p.invalidCall()
+		return poll, false
+	}
+
+	err = getItemFromRedis(id, p, &poll)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"msg": "Could not find poll with id=" + id,
+		})
+		p.invalidCall()
+		return poll, false
+	}
+	return poll, true
+}
+
+// sendRevisionError answers a request for a revision that failed
+func (p *PollsAPI) sendRevisionError(c *gin.Context, err error) {
+	p.invalidCall()
+	if errors.Is(err, errRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"msg": "Could not read revision\n" + err.Error(),
+	})
+}
+
+// GetRevisions handles GET /polls/:pollId/revisions, oldest first
+func (p *PollsAPI) GetRevisions(c *gin.Context) {
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+
+	revisions := make([]schema.PollRevision, 0, poll.CurrentRevision())
+	for n := 1; n <= poll.CurrentRevision(); n++ {
+		rev, err := p.revision(&poll, n)
+		// polls stored before there were revisions have no older records
+		if errors.Is(err, errRevisionNotFound) {
+			continue
+		}
+		if err != nil {
+			p.sendRevisionError(c, err)
+			return
+		}
+		revisions = append(revisions, rev)
+	}
+
+	total := len(revisions)
+	var list schema.List
+	list.Embedded = map[string]any{"revisions": revisions}
+	list.Links.Self.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id) + "/revisions"
+	list.Links.Poll.Href = p.API.Self + "/polls/" + strconv.Itoa(poll.Id)
+	list.Meta.Total = &total
+
+	p.validCall()
+	c.JSON(http.StatusOK, list)
+}
+
+// GetRevision handles GET /polls/:pollId/revisions/:rev
+func (p *PollsAPI) GetRevision(c *gin.Context) {
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+
+	n, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid revision"})
+		return
+	}
+
+	rev, err := p.revision(&poll, n)
+	if err != nil {
+		p.sendRevisionError(c, err)
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, rev)
+}
+
+// DiffRevisions handles GET /polls/:pollId/revisions/:rev/diff, which
+// compares the revision with the one in ?from=, by default the one before
+func (p *PollsAPI) DiffRevisions(c *gin.Context) {
+	poll, ok := p.pollForRevisions(c)
+	if !ok {
+		return
+	}
+
+	to, err := strconv.Atoi(c.Param("rev"))
+	from := to - 1
+	if err == nil && c.Query("from") != "" {
+		from, err = strconv.Atoi(c.Query("from"))
+	}
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid revision"})
+		return
+	}
+
+	toRev, err := p.revision(&poll, to)
+	if err != nil {
+		p.sendRevisionError(c, err)
+		return
+	}
+	fromRev, err := p.revision(&poll, from)
+	if err != nil {
+		p.sendRevisionError(c, err)
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, schema.Diff(fromRev, toRev))
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/net/websocket"
+)
+
+// Every change of the results or the state of a poll is published on the
+// redis channel polls:results:<id>. Each replica subscribes to all of them
+// once, in StartResultsFeed, and hands the updates to the streams its own
+// clients have open, so a vote counted by one replica reaches the clients
+// of all of them.
+//
+// The update ids come from the counter polls:<id>:results:seq. They are
+// taken in the same script that reads the poll and publishes it, so a
+// higher id never carries older results. An update carries the whole
+// results, so a client that missed some only needs the latest one.
+const (
+	resultsChannelPrefix = RedisKeyPrefix + "results:"
+	// sent in place of an update when the poll is deleted
+	pollDeletedMessage = "deleted"
+
+	// how often an idle stream sends something, so proxies keep it open
+	heartbeatInterval = 15 * time.Second
+)
+
+// resultsScript reads the poll and the id of its latest update. With
+// ARGV[1] set to "publish" it takes a new id and publishes the poll under
+// it first. It returns false if the poll does not exist.
+var resultsScript = redis.NewScript(`
+local poll = redis.call("JSON.GET", KEYS[1], ".")
+if not poll then
+	return false
+end
+local seq
+if ARGV[1] == "publish" then
+	seq = redis.call("INCR", KEYS[2])
+	redis.call("PUBLISH", ARGV[2], seq .. " " .. poll)
+else
+	seq = tonumber(redis.call("GET", KEYS[2]) or "0")
+end
+return {seq, poll}
+`)
+
+func resultsSeqKey(id string) string {
+	return RedisKeyPrefix + id + ":results:seq"
+}
+
+func resultsChannel(id string) string {
+	return resultsChannelPrefix + id
+}
+
+// readResults runs resultsScript for the poll with the id
+func (p *PollsAPI) readResults(id string, publish bool) (int64, schema.Poll, error) {
+	var poll schema.Poll
+	mode := "read"
+	if publish {
+		mode = "publish"
+	}
+
+	res, err := resultsScript.Run(p.context, p.client,
+		[]string{RedisKeyPrefix + id, resultsSeqKey(id)}, mode, resultsChannel(id)).Slice()
+	if err == redis.Nil {
+		return 0, poll, errPollNotFound
+	}
+	if err != nil {
+		return 0, poll, err
+	}
+
+	seq, _ := res[0].(int64)
+	pollJSON, _ := res[1].(string)
+	err = json.Unmarshal([]byte(pollJSON), &poll)
+	return seq, poll, err
+}
+
+// publishResults tells the streams of all replicas about a change of the
+// poll, and returns the poll as it is now
+func (p *PollsAPI) publishResults(id string) (schema.Poll, error) {
+	_, poll, err := p.readResults(id, true)
+	return poll, err
+}
+
+// resultsHub hands the updates of each poll to the streams open on this
+// replica. An update replaces one the stream has not sent yet, since it
+// carries all of the results.
+type resultsHub struct {
+	mu      sync.Mutex
+	streams map[int]map[chan schema.ResultsUpdate]struct{}
+}
+
+func newResultsHub() *resultsHub {
+	return &resultsHub{streams: make(map[int]map[chan schema.ResultsUpdate]struct{})}
+}
+
+// subscribe opens a stream of the updates of the poll. The channel is
+// closed when the poll is deleted, the returned func ends the stream.
+func (h *resultsHub) subscribe(pollId int) (chan schema.ResultsUpdate, func()) {
+	ch := make(chan schema.ResultsUpdate, 1)
+
+	h.mu.Lock()
+	if h.streams[pollId] == nil {
+		h.streams[pollId] = make(map[chan schema.ResultsUpdate]struct{})
+	}
+	h.streams[pollId][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.streams[pollId][ch]; ok {
+			delete(h.streams[pollId], ch)
+			if len(h.streams[pollId]) == 0 {
+				delete(h.streams, pollId)
+			}
+		}
+	}
+}
+
+func (h *resultsHub) publish(update schema.ResultsUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.streams[update.PollId] {
+		// drop the update still waiting, the new one is more recent
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- update
+	}
+}
+
+// closePoll ends the streams of a deleted poll
+func (h *resultsHub) closePoll(pollId int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.streams[pollId] {
+		close(ch)
+	}
+	delete(h.streams, pollId)
+}
+
+// StartResultsFeed subscribes to the results channels of all polls and
+// passes the updates on to the streams of this replica
+func (p *PollsAPI) StartResultsFeed() {
+	pubsub := p.client.PSubscribe(p.context, resultsChannelPrefix+"*")
+	go func() {
+		for msg := range pubsub.Channel() {
+			pollId, err := strconv.Atoi(strings.TrimPrefix(msg.Channel, resultsChannelPrefix))
+			if err != nil {
+				continue
+			}
+			if msg.Payload == pollDeletedMessage {
+				p.hub.closePoll(pollId)
+				continue
+			}
+
+			seq, pollJSON, _ := strings.Cut(msg.Payload, " ")
+			id, err := strconv.ParseInt(seq, 10, 64)
+			var poll schema.Poll
+			if err == nil {
+				err = json.Unmarshal([]byte(pollJSON), &poll)
+			}
+			if err != nil {
+				log.Printf("Bad results update on %s: %s", msg.Channel, err.Error())
+				continue
+			}
+			p.hub.publish(schema.NewResultsUpdate(id, &poll))
+		}
+	}()
+}
+
+// lastEventId is the id of the last update the client saw, from the
+// Last-Event-ID header an EventSource sends when it reconnects, or from
+// ?lastEventId= for clients that cannot set headers. -1 if there is none.
+func lastEventId(c *gin.Context) (int64, error) {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("lastEventId")
+	}
+	if value == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// openResultsStream subscribes to the updates of the poll in the request
+// and returns the update to send first: the current results, unless the
+// client saw them already. It answers the request itself if it fails.
+func (p *PollsAPI) openResultsStream(c *gin.Context) (chan schema.ResultsUpdate, func(), *schema.ResultsUpdate, bool) {
+	id := c.Param("pollId")
+	pollId, err := strconv.Atoi(id)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid poll id"})
+		return nil, nil, nil, false
+	}
+	last, err := lastEventId(c)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid last event id"})
+		return nil, nil, nil, false
+	}
+
+	// subscribe before reading, so no update falls in between
+	updates, unsubscribe := p.hub.subscribe(pollId)
+	seq, poll, err := p.readResults(id, false)
+	if err != nil {
+		unsubscribe()
+		p.invalidCall()
+		if errors.Is(err, errPollNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find poll with id=" + id})
+			return nil, nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read poll\n" + err.Error()})
+		return nil, nil, nil, false
+	}
+
+	var first *schema.ResultsUpdate
+	if seq != last {
+		update := schema.NewResultsUpdate(seq, &poll)
+		first = &update
+	}
+	p.validCall()
+	return updates, unsubscribe, first, true
+}
+
+// StreamResults handles GET /polls/:pollId/results/stream, a stream of
+// server sent events. Each update is a "results" event with the update id
+// as its id, and a comment is sent as a heartbeat.
+func (p *PollsAPI) StreamResults(c *gin.Context) {
+	updates, unsubscribe, first, ok := p.openResultsStream(c)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	sent := int64(-1)
+	send := func(update schema.ResultsUpdate) error {
+		if update.Id <= sent {
+			return nil
+		}
+		data, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: results\ndata: %s\n\n", update.Id, data)
+		c.Writer.Flush()
+		sent = update.Id
+		return err
+	}
+
+	if first != nil {
+		if send(*first) != nil {
+			return
+		}
+	} else {
+		// let the client know the stream is open
+		c.Writer.Flush()
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case update, open := <-updates:
+			if !open {
+				fmt.Fprint(c.Writer, "event: deleted\ndata: {}\n\n")
+				c.Writer.Flush()
+				return
+			}
+			if send(update) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// resultsMessage is a message of the results websocket, Update is only set
+// for "results"
+type resultsMessage struct {
+	Event  string                `json:"event"` // results, heartbeat or deleted
+	Update *schema.ResultsUpdate `json:"update,omitempty"`
+}
+
+// StreamResultsWS handles GET /polls/:pollId/results/ws, the websocket
+// variant of StreamResults. Every message is a JSON resultsMessage, and the
+// last update seen is sent with ?lastEventId= when reconnecting.
+func (p *PollsAPI) StreamResultsWS(c *gin.Context) {
+	updates, unsubscribe, first, ok := p.openResultsStream(c)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	// the api answers any origin, like its other routes
+	server := websocket.Server{Handshake: func(*websocket.Config, *http.Request) error { return nil }}
+	server.Handler = func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		// the client sends nothing, reading only notices when it goes away
+		gone := make(chan struct{})
+		go func() {
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			close(gone)
+		}()
+
+		sent := int64(-1)
+		send := func(update schema.ResultsUpdate) error {
+			if update.Id <= sent {
+				return nil
+			}
+			sent = update.Id
+			return websocket.JSON.Send(ws, resultsMessage{Event: "results", Update: &update})
+		}
+
+		if first != nil && send(*first) != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-gone:
+				return
+			case update, open := <-updates:
+				if !open {
+					websocket.JSON.Send(ws, resultsMessage{Event: "deleted"})
+					return
+				}
+				if send(update) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if websocket.JSON.Send(ws, resultsMessage{Event: "heartbeat"}) != nil {
+					return
+				}
+			}
+		}
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"drexel.edu/schema"
+)
+
+// ballots are the ballots cast on the poll. A single choice poll is counted
+// from its results, for the other ballot types every counted vote is read
+// from the votes api.
+func (p *PollsAPI) ballots(poll *schema.Poll) ([]schema.Ballot, error) {
+	if poll.Rules().Type == schema.BallotSingle {
+		return poll.BallotsFromResults(), nil
+	}
+
+	votes, err := p.countedVotes(poll)
+	if err != nil {
+		return nil, err
+	}
+	return ballotsOf(votes), nil
+}
+
+// countedVotes are the votes of the poll that are in its results, votes
+// cast against a revision before the results were reset are left out
+func (p *PollsAPI) countedVotes(poll *schema.Poll) ([]schema.Vote, error) {
+	votes, err := p.votesOf(poll.Id, "voteValue", "ballot", "pollRevision")
+	if err != nil {
+		return nil, err
+	}
+
+	counted := votes[:0]
+	for _, vote := range votes {
+		if poll.Counts(&vote) {
+			counted = append(counted, vote)
+		}
+	}
+	return counted, nil
+}
+
+func ballotsOf(votes []schema.Vote) []schema.Ballot {
+	ballots := make([]schema.Ballot, 0, len(votes))
+	for _, vote := range votes {
+		ballots = append(ballots, vote.Cast())
+	}
+	return ballots
+}
+
+// matchesResults reports whether the votes are the ones counted in the
+// results of the poll, each option has as many votes as its count. A vote
+// cast or changed after the votes were read makes them differ.
+func matchesResults(poll *schema.Poll, votes []schema.Vote) bool {
+	counts := map[int]int{}
+	for _, vote := range votes {
+		counts[vote.VoteValue]++
+	}
+	for _, result := range poll.Results {
+		if counts[result.OptionId] != result.Votes {
+			return false
+		}
+		delete(counts, result.OptionId)
+	}
+	return len(counts) == 0
+}
+
+// votesOf reads the votes cast on the poll from the votes api, with only
+// the given fields set
+func (p *PollsAPI) votesOf(pollId int, fields ...string) ([]schema.Vote, error) {
+	votesUrl := p.InternalAPI.Votes + "/polls/" + strconv.Itoa(pollId)
+	resp, err := p.apiClient.R().
+		SetQueryParams(map[string]string{"embed": "none", "fields": strings.Join(fields, ",")}).
+		Get(votesUrl)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("votes api returned %d", resp.StatusCode())
+	}
+
+	var votes []schema.Vote
+	err = json.Unmarshal(resp.Body(), &votes)
+	return votes, err
+}
+
+// outcome counts the ballots of the poll with the tallier called method,
+// or the poll's own when method is empty. It is decided by GetResults.
+func (p *PollsAPI) outcome(poll *schema.Poll, method string) (schema.Outcome, error) {
+	ballots, err := p.ballots(poll)
+	if err != nil {
+		return schema.Outcome{}, err
+	}
+	return poll.Tally(method, ballots)
+}
+
+// registeredVoters is the number of voters, for the turnout of a poll. The
+// voter api keeps their ids in schema.VoterIdsKey.
+func (p *PollsAPI) registeredVoters() (int, error) {
+	n, err := p.client.ZCard(p.context, schema.VoterIdsKey).Result()
+	return int(n), err
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// The poll api serves /webhooks, the subscriptions to the events of all
+// three apis. A webhook is kept as webhooks:<id>, with its id taken from
+// webhooks:next-id, and listed from the index webhooks:ids. The deliveries
+// are in deliveries.go.
+const (
+	WebhookKeyPrefix = "webhooks:"
+
+	webhookIdsKey = WebhookKeyPrefix + "ids"
+)
+
+var (
+	errWebhookNotFound = errors.New("webhook not found")
+	errInvalidWebhook  = errors.New("invalid webhook")
+)
+
+func webhookKey(id int) string {
+	return WebhookKeyPrefix + strconv.Itoa(id)
+}
+
+// webhooksURL is the public url of the webhooks collection
+func (p *PollsAPI) webhooksURL() string {
+	return p.API.Self + "/webhooks"
+}
+
+// validateWebhook checks the url and event filters of a webhook
+func validateWebhook(webhook *schema.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w, url must be an http or https url", errInvalidWebhook)
+	}
+	if len(webhook.Events) == 0 {
+		return fmt.Errorf("%w, events must list at least one event type", errInvalidWebhook)
+	}
+	for _, filter := range webhook.Events {
+		if !events.ValidFilter(filter) {
+			return fmt.Errorf("%w, unknown event type %q", errInvalidWebhook, filter)
+		}
+	}
+	return nil
+}
+
+// wants reports whether the webhook subscribed to events of type eventType
+func wants(webhook *schema.Webhook, eventType string) bool {
+	for _, filter := range webhook.Events {
+		if events.Matches(filter, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+func (p *PollsAPI) getWebhook(id int) (schema.Webhook, error) {
+	var webhook schema.Webhook
+	raw, err := p.client.Do(p.context, "JSON.GET", webhookKey(id), ".").Text()
+	if err == redis.Nil {
+		return webhook, errWebhookNotFound
+	}
+	if err != nil {
+		return webhook, err
+	}
+	err = json.Unmarshal([]byte(raw), &webhook)
+	return webhook, err
+}
+
+func (p *PollsAPI) saveWebhook(webhook *schema.Webhook) error {
+	webhookJSON, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.TxPipelined(p.context, func(pipe redis.Pipeliner) error {
+		pipe.Do(p.context, "JSON.SET", webhookKey(webhook.Id), ".", string(webhookJSON))
+		service.Index(p.context, pipe, webhookIdsKey, webhook.Id)
+		return nil
+	})
+	return err
+}
+
+// listWebhooks reads all webhooks, ordered by id
+func (p *PollsAPI) listWebhooks() ([]schema.Webhook, error) {
+	ids, err := service.Ids(p.context, p.client, webhookIdsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]schema.Webhook, 0, len(ids))
+	for _, id := range ids {
+		webhook, err := p.getWebhook(id)
+		// deleted since the scan
+		if errors.Is(err, errWebhookNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// publicWebhook is the webhook as it is sent, the secret is left out
+// unless withSecret is set
+func (p *PollsAPI) publicWebhook(webhook schema.Webhook, withSecret bool) schema.Webhook {
+	if !withSecret {
+		webhook.Secret = ""
+	}
+	schema.SetWebhookLinks(p.webhooksURL(), &webhook)
+	return webhook
+}
+
+// webhookForRequest reads the webhook in :webhookId, and answers the
+// request itself if it cannot
+func (p *PollsAPI) webhookForRequest(c *gin.Context) (schema.Webhook, bool) {
+	id, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid webhook id"})
+		return schema.Webhook{}, false
+	}
+
+	webhook, err := p.getWebhook(id)
+	if errors.Is(err, errWebhookNotFound) {
+		p.invalidCall()
+		c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find webhook with id=" + strconv.Itoa(id)})
+		return webhook, false
+	}
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read webhook\n" + err.Error()})
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// GetWebhooks handles GET /webhooks
+func (p *PollsAPI) GetWebhooks(c *gin.Context) {
+	req, err := service.ParsePageRequest(c)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pg, err := service.PageIds(p.context, p.client, webhookIdsKey, req)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list webhooks in cache"})
+		return
+	}
+
+	webhooks := make([]schema.Webhook, 0, len(pg.Ids))
+	for _, id := range pg.Ids {
+		webhook, err := p.getWebhook(id)
+		if err != nil {
+			p.invalidCall()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find webhook in cache with id=" + strconv.Itoa(id)})
+			return
+		}
+		webhooks = append(webhooks, p.publicWebhook(webhook, false))
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, schema.NewList(p.webhooksURL(), "webhooks", webhooks, pg, req))
+}
+
+// GetWebhook handles GET /webhooks/:webhookId
+func (p *PollsAPI) GetWebhook(c *gin.Context) {
+	webhook, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, p.publicWebhook(webhook, false))
+}
+
+// CreateWebhook handles POST /webhooks. A webhook sent without a secret
+// gets a new one, the response is the only time it is shown.
+func (p *PollsAPI) CreateWebhook(c *gin.Context) {
+	var webhook schema.Webhook
+	err := c.BindJSON(&webhook)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Error unmarshalling webhook\n" + err.Error()})
+		return
+	}
+	if webhook.Id != 0 {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "The webhook id is assigned by the api"})
+		return
+	}
+	err = validateWebhook(&webhook)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = newWebhookSecret()
+	}
+	if err == nil {
+		webhook.Id, err = service.NextId(p.context, p.client, WebhookKeyPrefix)
+	}
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Error creating webhook\n" + err.Error()})
+		return
+	}
+	webhook.Meta = schema.Meta{CreatedAt: time.Now(), UpdatedAt: time.Now()}
+
+	err = p.saveWebhook(&webhook)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Error saving webhook to cache"})
+		return
+	}
+
+	created := p.publicWebhook(webhook, true)
+	c.Header("Location", created.Links.Self.Href)
+	p.validCall()
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateWebhook handles PUT /webhooks/:webhookId. The secret is kept
+// unless the body has a new one.
+func (p *PollsAPI) UpdateWebhook(c *gin.Context) {
+	old, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+
+	var webhook schema.Webhook
+	err := c.BindJSON(&webhook)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Error unmarshalling webhook\n" + err.Error()})
+		return
+	}
+	if webhook.Id != 0 && webhook.Id != old.Id {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Webhook id in the body does not match the url"})
+		return
+	}
+	err = validateWebhook(&webhook)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	newSecret := webhook.Secret != ""
+	if !newSecret {
+		webhook.Secret = old.Secret
+	}
+	webhook.Id = old.Id
+	webhook.Meta = schema.Meta{CreatedAt: old.Meta.CreatedAt, UpdatedAt: time.Now()}
+
+	err = p.saveWebhook(&webhook)
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Error saving webhook to cache"})
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, p.publicWebhook(webhook, newSecret))
+}
+
+// DeleteWebhook handles DELETE /webhooks/:webhookId. Deliveries still
+// waiting for a retry fail, the delivery log expires by itself.
+func (p *PollsAPI) DeleteWebhook(c *gin.Context) {
+	webhook, ok := p.webhookForRequest(c)
+	if !ok {
+		return
+	}
+
+	_, err := p.client.TxPipelined(p.context, func(pipe redis.Pipeliner) error {
+		pipe.Del(p.context, webhookKey(webhook.Id))
+		service.Unindex(p.context, pipe, webhookIdsKey, webhook.Id)
+		pipe.Del(p.context, deliveryIndexKey(webhook.Id))
+		return nil
+	})
+	if err != nil {
+		p.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not delete webhook from cache"})
+		return
+	}
+
+	p.validCall()
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
@@ -3,11 +3,15 @@ module drexel.edu/polls
 go 1.20
 
 require (
+	drexel.edu/events v0.0.0
+	drexel.edu/schema v0.0.0
+	drexel.edu/service v0.0.0
 	github.com/gin-contrib/cors v1.4.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/go-resty/resty/v2 v2.7.0
 	github.com/nitishm/go-rejson/v4 v4.1.0
+	golang.org/x/net v0.12.0
 )
 
 require (
@@ -33,9 +37,14 @@ require (
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.4.0 // indirect
 	golang.org/x/crypto v0.11.0 // indirect
-	golang.org/x/net v0.12.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	drexel.edu/events v0.0.0 => ../events
+	drexel.edu/schema v0.0.0 => ../schema
+	drexel.edu/service v0.0.0 => ../service
+)
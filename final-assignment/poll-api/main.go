@@ -15,6 +15,9 @@ var (
 	hostFlag string
 	cacheURL string
 	portFlag uint
+	votesURL string
+
+	editPolicy string
 )
 
 func processCmdLineFlags() {
@@ -24,6 +27,11 @@ func processCmdLineFlags() {
 	flag.UintVar(&portFlag, "p", 1082, "Default Port (cannot be changed)")
 	flag.StringVar(&cacheURL, "c", "localhost:6379", "Default cache location")
 
+	// flags for internal api
+	flag.StringVar(&votesURL, "votes", "http://localhost:1080/votes", "Default votes location")
+
+	// what an edit does to the votes of a poll without its own edit policy
+	flag.StringVar(&editPolicy, "edit-policy", "new-revision", "Edit policy of polls with votes: reject, new-revision or reset")
 	flag.Parse()
 }
 
@@ -42,6 +50,8 @@ func setupParms() {
 	//now process any environment variables
 	cacheURL = envVarOrDefault("REDIS_URL", cacheURL)
 	hostFlag = envVarOrDefault("RLAPI_HOST", hostFlag)
+	votesURL = envVarOrDefault("VOTES_API_URL", votesURL)
+	editPolicy = envVarOrDefault("POLL_EDIT_POLICY", editPolicy)
 
 	// pfNew, err := strconv.Atoi(envVarOrDefault("RLAPI_PORT", fmt.Sprintf("%d", portFlag)))
 	// //only update the port if we were able to convert the env var to an int, else
@@ -65,22 +75,75 @@ func main() {
 		Self:   "http://localhost:1082",
 		Voters: "http://localhost:1081/voters",
 		Votes:  "http://localhost:1080/votes",
-	})
+	},
+		api.API{
+			Votes: votesURL,
+		},
+	)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = apiHandler.SetEditPolicy(editPolicy)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	// pass results changes made by any replica to the streams of this one
+	apiHandler.StartResultsFeed()
+
+	// open and close polls on time
+	apiHandler.StartScheduler()
+
+	// count the polls created before their ids were kept
+	err = apiHandler.TrackIds()
+	if err != nil {
+		log.Println("Could not track the poll ids: " + err.Error())
+	}
+
+	// move poll events from the outbox to the event stream
+	apiHandler.StartEventRelay()
+
+	// send the events to the webhooks that subscribed to them
+	apiHandler.StartWebhooks()
+
 	r.GET("/", apiHandler.GetPolls)
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/polls/health", apiHandler.HealthCheck)
 	r.GET("/polls/:pollId", apiHandler.GetPoll)
 	r.GET("/polls/:pollId/results", apiHandler.GetResults)
+	r.GET("/polls/:pollId/results/stream", apiHandler.StreamResults)
+	r.GET("/polls/:pollId/results/ws", apiHandler.StreamResultsWS)
 	r.GET("/polls", apiHandler.GetPolls)
+	r.POST("/polls", apiHandler.Idempotent(apiHandler.CreatePoll))
+	r.POST("/polls/import", apiHandler.ImportPolls)
 	r.POST("/polls/:pollId", apiHandler.PostPoll)
 	r.PUT("/polls/:pollId", apiHandler.UpdatePoll)
 	r.PUT("/polls/counts/:pollId", apiHandler.UpdateOptionCounts)
+	r.POST("/polls/:pollId/results/:option/increment", apiHandler.IncrementOptionCount)
+	r.POST("/polls/:pollId/results/:option/move", apiHandler.MoveOptionCount)
+	r.POST("/polls/:pollId/open", apiHandler.OpenPoll)
+	r.POST("/polls/:pollId/close", apiHandler.ClosePoll)
+	r.POST("/polls/:pollId/archive", apiHandler.ArchivePoll)
+	r.POST("/polls/:pollId/recount", apiHandler.Recount)
+	r.GET("/polls/:pollId/revisions", apiHandler.GetRevisions)
+	r.GET("/polls/:pollId/revisions/:rev", apiHandler.GetRevision)
+	r.GET("/polls/:pollId/revisions/:rev/diff", apiHandler.DiffRevisions)
+	r.GET("/polls/:pollId/ledger", apiHandler.GetLedger)
+	r.GET("/polls/:pollId/ledger/verify", apiHandler.VerifyLedger)
+	r.GET("/polls/:pollId/analytics", apiHandler.GetAnalytics)
 	r.DELETE("/polls/:pollId", apiHandler.DeletePoll)
+	r.GET("/webhooks", apiHandler.GetWebhooks)
+	r.POST("/webhooks", apiHandler.IdempotentWebhooks(apiHandler.CreateWebhook))
+	r.GET("/webhooks/:webhookId", apiHandler.GetWebhook)
+	r.PUT("/webhooks/:webhookId", apiHandler.UpdateWebhook)
+	r.DELETE("/webhooks/:webhookId", apiHandler.DeleteWebhook)
+	r.POST("/webhooks/:webhookId/ping", apiHandler.PingWebhook)
+	r.GET("/webhooks/:webhookId/deliveries", apiHandler.GetDeliveries)
+	r.GET("/webhooks/:webhookId/deliveries/:deliveryId", apiHandler.GetDelivery)
+	r.POST("/webhooks/:webhookId/deliveries/:deliveryId/redeliver", apiHandler.Redeliver)
 
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	r.Run(serverPath)
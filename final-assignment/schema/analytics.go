@@ -0,0 +1,253 @@
+package schema
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Analytics are computed from aggregates that are kept up to date as votes
+// are cast and deleted, so reading them never reads the votes:
+//
+//   - the results and TotalVotes of a poll
+//   - the activity of a poll (ActivityKey), the number of its votes cast in
+//     each minute, kept by the votes api
+//   - the ids of the registered voters (VoterIdsKey) and of the polls
+//     (PollIdsKey), kept by the voter and poll apis
+//   - the history of a voter, its VoterPolls
+const (
+	VoterIdsKey = "voters:ids"
+	PollIdsKey  = "polls:ids"
+)
+
+// ActivityKey is the redis hash that holds the activity of a poll, the
+// start of a minute (unix seconds) to the number of votes that were cast in
+// it and are still there. It does not end in a number, so listing the polls
+// skips it.
+func ActivityKey(pollId int) string {
+	return "polls:" + strconv.Itoa(pollId) + ":activity"
+}
+
+// ActivityField is the field of the activity hash that counts a vote cast at t
+func ActivityField(t time.Time) string {
+	return strconv.FormatInt(t.UTC().Truncate(time.Minute).Unix(), 10)
+}
+
+// CastAt is when the vote was cast. A change does not move it. Votes stored
+// before they had a CreatedAt fall back to votedAt, from the voter's history.
+func CastAt(vote *Vote, votedAt time.Time) time.Time {
+	if vote.Meta.CreatedAt.IsZero() {
+		return votedAt
+	}
+	return vote.Meta.CreatedAt
+}
+
+// the intervals a timeline can be bucketed by
+const (
+	IntervalMinute = "minute"
+	IntervalHour   = "hour"
+	IntervalDay    = "day"
+)
+
+var ErrInterval = errors.New("interval must be minute, hour or day")
+
+var intervals = map[string]time.Duration{
+	IntervalMinute: time.Minute,
+	IntervalHour:   time.Hour,
+	IntervalDay:    24 * time.Hour,
+}
+
+// CheckInterval returns ErrInterval unless interval is empty or known
+func CheckInterval(interval string) error {
+	if _, ok := intervals[interval]; interval != "" && !ok {
+		return ErrInterval
+	}
+	return nil
+}
+
+// Bucket is one interval of a timeline. Only intervals with votes are
+// listed, Cumulative is the number of votes up to the end of the interval.
+type Bucket struct {
+	Start      time.Time `json:"start"`
+	Votes      int       `json:"votes"`
+	Cumulative int       `json:"cumulative"`
+}
+
+// Timeline buckets counts, by the unix second they were counted at, by
+// interval. The buckets start at UTC midnight for days.
+func Timeline(counts map[int64]int, interval string) []Bucket {
+	size := intervals[interval]
+	byStart := map[int64]int{}
+	for at, n := range counts {
+		start := time.Unix(at, 0).UTC().Truncate(size).Unix()
+		byStart[start] += n
+	}
+
+	starts := make([]int64, 0, len(byStart))
+	for start, n := range byStart {
+		if n > 0 {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	timeline := make([]Bucket, 0, len(starts))
+	total := 0
+	for _, start := range starts {
+		total += byStart[start]
+		timeline = append(timeline, Bucket{Start: time.Unix(start, 0).UTC(), Votes: byStart[start], Cumulative: total})
+	}
+	return timeline
+}
+
+// ParseActivity reads the fields of an activity hash, fields that are not
+// numbers are skipped
+func ParseActivity(hash map[string]string) map[int64]int {
+	counts := make(map[int64]int, len(hash))
+	for field, value := range hash {
+		at, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		counts[at] += n
+	}
+	return counts
+}
+
+// OptionShare is the part of the counted votes an option has
+type OptionShare struct {
+	OptionId int    `json:"optionId"`
+	Option   string `json:"option"`
+	Votes    int    `json:"votes"`
+	// Share is from 0 to 1, Percentage from 0 to 100 rounded to 2 places
+	Share      float64 `json:"share"`
+	Percentage float64 `json:"percentage"`
+}
+
+// Leader is the option with the most votes. Margin is how many votes it is
+// ahead of the next option, a tie has a Margin of 0.
+type Leader struct {
+	OptionShare
+	Margin      int     `json:"margin"`
+	MarginShare float64 `json:"marginShare"`
+	Tied        bool    `json:"tied"`
+}
+
+// PollAnalytics are the statistics of a poll. The shares are of the counted
+// votes, the sum of the results, so for a poll whose ballots count several
+// options they can differ from TotalVotes. Turnout is TotalVotes as a part
+// of the registered voters.
+type PollAnalytics struct {
+	PollId           int           `json:"pollId"`
+	TotalVotes       int           `json:"totalVotes"`
+	CountedVotes     int           `json:"countedVotes"`
+	RegisteredVoters int           `json:"registeredVoters"`
+	Turnout          float64       `json:"turnout"`
+	Options          []OptionShare `json:"options"`
+	// nil while the poll has no votes
+	Leader   *Leader  `json:"leader"`
+	Interval string   `json:"interval"`
+	Timeline []Bucket `json:"timeline"`
+	Links    Links    `json:"_links"`
+}
+
+// NewPollAnalytics computes the analytics of the poll from its results, the
+// number of registered voters and its activity, see ParseActivity
+func NewPollAnalytics(poll *Poll, registered int, activity map[int64]int, interval string) PollAnalytics {
+	a := PollAnalytics{
+		PollId:           poll.Id,
+		TotalVotes:       poll.Meta.TotalVotes,
+		RegisteredVoters: registered,
+		Turnout:          fraction(poll.Meta.TotalVotes, registered),
+		Options:          make([]OptionShare, 0, len(poll.Results)),
+		Interval:         interval,
+		Timeline:         Timeline(activity, interval),
+	}
+
+	for _, result := range poll.Results {
+		a.CountedVotes += result.Votes
+	}
+	for _, result := range poll.Results {
+		share := fraction(result.Votes, a.CountedVotes)
+		a.Options = append(a.Options, OptionShare{
+			OptionId:   result.OptionId,
+			Option:     poll.OptionText(result.OptionId),
+			Votes:      result.Votes,
+			Share:      share,
+			Percentage: math.Round(share*10000) / 100,
+		})
+	}
+	if a.CountedVotes == 0 {
+		return a
+	}
+
+	ranked := make([]OptionShare, len(a.Options))
+	copy(ranked, a.Options)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Votes > ranked[j].Votes })
+	leader := &Leader{OptionShare: ranked[0], Margin: ranked[0].Votes}
+	if len(ranked) > 1 {
+		leader.Margin -= ranked[1].Votes
+	}
+	leader.MarginShare = fraction(leader.Margin, a.CountedVotes)
+	leader.Tied = leader.Margin == 0
+	a.Leader = leader
+	return a
+}
+
+// VoterAnalytics are the statistics of a voter across polls, from their
+// history. Participation is PollsVoted as a part of all polls.
+type VoterAnalytics struct {
+	VoterId       int        `json:"voterId"`
+	Votes         int        `json:"votes"`
+	PollsVoted    int        `json:"pollsVoted"`
+	TotalPolls    int        `json:"totalPolls"`
+	Participation float64    `json:"participation"`
+	FirstVotedAt  *time.Time `json:"firstVotedAt"`
+	LastVotedAt   *time.Time `json:"lastVotedAt"`
+	Interval      string     `json:"interval"`
+	Timeline      []Bucket   `json:"timeline"`
+	Links         Links      `json:"_links"`
+}
+
+// NewVoterAnalytics computes the analytics of the voter from their history
+// and the number of polls
+func NewVoterAnalytics(voter *Voter, totalPolls int, interval string) VoterAnalytics {
+	a := VoterAnalytics{
+		VoterId:    voter.Id,
+		Votes:      len(voter.VoterPolls),
+		TotalPolls: totalPolls,
+		Interval:   interval,
+	}
+
+	polls := map[int]bool{}
+	counts := map[int64]int{}
+	for _, vp := range voter.VoterPolls {
+		polls[vp.PollId] = true
+		counts[vp.VotedAt.Unix()]++
+		at := vp.VotedAt.UTC()
+		if a.FirstVotedAt == nil || at.Before(*a.FirstVotedAt) {
+			a.FirstVotedAt = &at
+		}
+		if a.LastVotedAt == nil || at.After(*a.LastVotedAt) {
+			a.LastVotedAt = &at
+		}
+	}
+	a.PollsVoted = len(polls)
+	a.Participation = fraction(a.PollsVoted, totalPolls)
+	a.Timeline = Timeline(counts, interval)
+	return a
+}
+
+// fraction is n/of, or 0 when of is 0
+func fraction(n int, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
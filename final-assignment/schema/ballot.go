@@ -0,0 +1,185 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The ballot types a poll can declare. A single choice vote only has
+// VoteValue, the id of the chosen option, the others carry a Ballot as well:
+//   - multi: Choices are the selected options, between MinChoices and MaxChoices
+//   - ranked: Choices are options in order of preference, the first is liked best
+//   - score: Scores has a score from 0 to MaxScore per option, a missing option scores 0
+//   - approval: Choices are the options the voter approves of, at least one
+const (
+	BallotSingle   = "single"
+	BallotMulti    = "multi"
+	BallotRanked   = "ranked"
+	BallotScore    = "score"
+	BallotApproval = "approval"
+)
+
+// defaultMaxScore is the highest score of a score ballot, unless the poll
+// sets MaxScore
+const defaultMaxScore = 5
+
+// defaultTally is how each ballot type is counted, unless the poll sets Tally
+var defaultTally = map[string]string{
+	BallotSingle:   TallyPlurality,
+	BallotMulti:    TallyApproval,
+	BallotRanked:   TallyInstantRunoff,
+	BallotScore:    TallyScore,
+	BallotApproval: TallyApproval,
+}
+
+var ErrInvalidBallot = errors.New("invalid ballot")
+
+// BallotRules is the ballot a poll declares. A poll without one is single choice.
+type BallotRules struct {
+	Type       string `json:"type"`
+	MinChoices int    `json:"minChoices,omitempty"`
+	MaxChoices int    `json:"maxChoices,omitempty"`
+	MaxScore   int    `json:"maxScore,omitempty"`
+	// name of the Tallier that counts the ballots
+	Tally string `json:"tally,omitempty"`
+}
+
+// Ballot is the payload of a vote on a poll that is not single choice.
+// Options are referred to by their id, Scores maps an option id to its score.
+type Ballot struct {
+	Choices []int       `json:"choices,omitempty"`
+	Scores  map[int]int `json:"scores,omitempty"`
+}
+
+// Rules are the ballot rules of the poll, with the defaults filled in
+func (p *Poll) Rules() BallotRules {
+	var rules BallotRules
+	if p.Ballot != nil {
+		rules = *p.Ballot
+	}
+
+	if rules.Type == "" {
+		rules.Type = BallotSingle
+	}
+	if rules.Tally == "" {
+		rules.Tally = defaultTally[rules.Type]
+	}
+
+	switch rules.Type {
+	case BallotMulti, BallotRanked, BallotApproval:
+		if rules.MinChoices == 0 {
+			rules.MinChoices = 1
+		}
+		if rules.MaxChoices == 0 {
+			rules.MaxChoices = len(p.Options)
+		}
+	case BallotScore:
+		if rules.MaxScore == 0 {
+			rules.MaxScore = defaultMaxScore
+		}
+	}
+	return rules
+}
+
+// ValidateRules checks the ballot rules a poll is created or updated with
+func (p *Poll) ValidateRules() error {
+	if p.Ballot == nil {
+		return nil
+	}
+
+	rules := p.Rules()
+	if _, ok := defaultTally[rules.Type]; !ok {
+		return fmt.Errorf("%w, unknown ballot type %q", ErrInvalidBallot, rules.Type)
+	}
+
+	err := p.CheckTally("")
+	if err != nil {
+		return err
+	}
+
+	if rules.MinChoices < 0 || rules.MinChoices > rules.MaxChoices || rules.MaxChoices > len(p.Options) {
+		return fmt.Errorf("%w, choices must be 1 <= minChoices <= maxChoices <= number of options", ErrInvalidBallot)
+	}
+	if rules.MaxScore < 0 {
+		return fmt.Errorf("%w, maxScore must be positive", ErrInvalidBallot)
+	}
+	return nil
+}
+
+// ValidateVote checks that the vote matches the ballot of the poll. For the
+// ballot types other than single choice it also sets VoteValue to the
+// option the ballot favours most, the first choice or the highest score,
+// so the live results still count every ballot once.
+func (p *Poll) ValidateVote(vote *Vote) error {
+	rules := p.Rules()
+	if len(p.Options) == 0 {
+		return fmt.Errorf("%w, the poll has no options", ErrInvalidBallot)
+	}
+
+	if rules.Type == BallotSingle {
+		if vote.Ballot != nil {
+			return fmt.Errorf("%w, a single choice poll takes voteValue only", ErrInvalidBallot)
+		}
+		if !p.HasOption(vote.VoteValue) {
+			return fmt.Errorf("%w, no option with id %d", ErrInvalidBallot, vote.VoteValue)
+		}
+		return nil
+	}
+
+	if vote.Ballot == nil {
+		return fmt.Errorf("%w, a %s poll needs a ballot", ErrInvalidBallot, rules.Type)
+	}
+	ballot := vote.Ballot
+
+	if rules.Type == BallotScore {
+		if len(ballot.Choices) != 0 || len(ballot.Scores) == 0 {
+			return fmt.Errorf("%w, a score ballot has scores only", ErrInvalidBallot)
+		}
+		for id, score := range ballot.Scores {
+			if !p.HasOption(id) {
+				return fmt.Errorf("%w, no option with id %d", ErrInvalidBallot, id)
+			}
+			if score < 0 || score > rules.MaxScore {
+				return fmt.Errorf("%w, scores go from 0 to %d", ErrInvalidBallot, rules.MaxScore)
+			}
+		}
+		// the first of the options with the highest score
+		best := p.Options[0].Id
+		for _, option := range p.Options {
+			if ballot.Scores[option.Id] > ballot.Scores[best] {
+				best = option.Id
+			}
+		}
+		vote.VoteValue = best
+		return nil
+	}
+
+	if len(ballot.Scores) != 0 {
+		return fmt.Errorf("%w, a %s ballot has no scores", ErrInvalidBallot, rules.Type)
+	}
+	if len(ballot.Choices) < rules.MinChoices || len(ballot.Choices) > rules.MaxChoices {
+		return fmt.Errorf("%w, pick between %d and %d options", ErrInvalidBallot, rules.MinChoices, rules.MaxChoices)
+	}
+	seen := make(map[int]bool, len(ballot.Choices))
+	for _, choice := range ballot.Choices {
+		if !p.HasOption(choice) {
+			return fmt.Errorf("%w, no option with id %d", ErrInvalidBallot, choice)
+		}
+		if seen[choice] {
+			return fmt.Errorf("%w, option %d is picked twice", ErrInvalidBallot, choice)
+		}
+		seen[choice] = true
+	}
+
+	// there is at least one choice, MinChoices is never below 1
+	vote.VoteValue = ballot.Choices[0]
+	return nil
+}
+
+// Cast is the ballot of the vote, for a single choice vote it has one choice
+func (v *Vote) Cast() Ballot {
+	if v.Ballot != nil {
+		return *v.Ballot
+	}
+	return Ballot{Choices: []int{v.VoteValue}}
+}
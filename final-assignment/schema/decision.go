@@ -0,0 +1,286 @@
+package schema
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+)
+
+// A poll can declare how its outcome is decided. The tally finds the
+// leading option, the decision rules say whether it wins:
+//   - plurality: the option with the most votes wins
+//   - majority: it needs more than half of the votes
+//   - supermajority: it needs at least Threshold of the votes, e.g. "2/3"
+//
+// With a quorum no option wins unless enough ballots were cast, at least
+// QuorumVotes, and at least QuorumTurnout of the registered voters. Options
+// that tie for the lead are broken by TieBreak:
+//   - none: nobody wins
+//   - order: the option listed first wins
+//   - lot: one of them is drawn with a seed picked at random when the poll
+//     closes, kept in its snapshot so every recount draws the same one. A
+//     poll that is not closed has no seed, its tie is not broken yet.
+//
+// The votes of the majority rules are the ballots for approval, where a
+// ballot can vote for several options, and the total of the counts
+// otherwise (for irv, of the last round). They cannot be used with the
+// talliers that count points, borda and score.
+const (
+	DecisionPlurality     = "plurality"
+	DecisionMajority      = "majority"
+	DecisionSupermajority = "supermajority"
+)
+
+const (
+	TieBreakNone  = "none"
+	TieBreakOrder = "order"
+	TieBreakLot   = "lot"
+)
+
+var ErrInvalidDecision = errors.New("invalid decision rules")
+
+// DecisionRules is how a poll declares its outcome is decided. A poll
+// without them is decided by plurality, and a tie has no winner.
+type DecisionRules struct {
+	Rule string `json:"rule"`
+	// a fraction, "2/3" or "0.6", only for supermajority
+	Threshold     string `json:"threshold,omitempty"`
+	QuorumVotes   int    `json:"quorumVotes,omitempty"`
+	QuorumTurnout string `json:"quorumTurnout,omitempty"`
+	TieBreak      string `json:"tieBreak,omitempty"`
+}
+
+// Decision is the outcome of a count by the decision rules of the poll.
+// Winner is only set when the poll passed, Reason says why it did not.
+type Decision struct {
+	Rule      string `json:"rule"`
+	Threshold string `json:"threshold,omitempty"`
+	Passed    bool   `json:"passed"`
+	Winner    *int   `json:"winner"`
+	Reason    string `json:"reason,omitempty"`
+	// the votes of the leading option, and the votes they are a share of
+	Support int    `json:"support"`
+	Votes   int    `json:"votes"`
+	Quorum  Quorum `json:"quorum"`
+	// only set when options tied for the lead
+	Tie *Tie `json:"tie,omitempty"`
+}
+
+// Quorum is whether enough ballots were cast for the poll to be decided,
+// Votes is the number of ballots
+type Quorum struct {
+	Met              bool    `json:"met"`
+	Votes            int     `json:"votes"`
+	MinVotes         int     `json:"minVotes,omitempty"`
+	RegisteredVoters int     `json:"registeredVoters"`
+	Turnout          float64 `json:"turnout"`
+	MinTurnout       string  `json:"minTurnout,omitempty"`
+}
+
+// Tie is the options that tied for the lead. BrokenBy is the tie break that
+// picked the winner among them, empty if it did not. Seed is the seed of
+// the lot, so the draw can be checked.
+type Tie struct {
+	Options  []int  `json:"options"`
+	BrokenBy string `json:"brokenBy,omitempty"`
+	Seed     *int64 `json:"seed,omitempty"`
+}
+
+// DecisionRules are the decision rules of the poll, with the defaults
+// filled in
+func (p *Poll) DecisionRules() DecisionRules {
+	var rules DecisionRules
+	if p.Decision != nil {
+		rules = *p.Decision
+	}
+
+	if rules.Rule == "" {
+		rules.Rule = DecisionPlurality
+	}
+	if rules.TieBreak == "" {
+		rules.TieBreak = TieBreakNone
+	}
+	return rules
+}
+
+// ValidateDecision checks the decision rules a poll is created or updated
+// with
+func (p *Poll) ValidateDecision() error {
+	if p.Decision == nil {
+		return nil
+	}
+
+	rules := p.DecisionRules()
+	switch rules.Rule {
+	case DecisionPlurality, DecisionMajority:
+		if rules.Threshold != "" {
+			return fmt.Errorf("%w, only a supermajority has a threshold", ErrInvalidDecision)
+		}
+	case DecisionSupermajority:
+		threshold, ok := parseRatio(rules.Threshold)
+		if !ok || threshold.Cmp(big.NewRat(1, 2)) <= 0 {
+			return fmt.Errorf("%w, a supermajority needs a threshold above 1/2 and at most 1", ErrInvalidDecision)
+		}
+	default:
+		return fmt.Errorf("%w, unknown rule %q", ErrInvalidDecision, rules.Rule)
+	}
+
+	if rules.Rule != DecisionPlurality {
+		switch tally := p.Rules().Tally; tally {
+		case TallyPlurality, TallyInstantRunoff, TallyApproval:
+		default:
+			return fmt.Errorf("%w, a %s cannot be decided by the points of %s", ErrInvalidDecision, rules.Rule, tally)
+		}
+	}
+
+	if rules.QuorumVotes < 0 {
+		return fmt.Errorf("%w, quorumVotes cannot be negative", ErrInvalidDecision)
+	}
+	if _, ok := parseRatio(rules.QuorumTurnout); rules.QuorumTurnout != "" && !ok {
+		return fmt.Errorf("%w, quorumTurnout must be a fraction above 0 and at most 1", ErrInvalidDecision)
+	}
+
+	switch rules.TieBreak {
+	case TieBreakNone, TieBreakOrder, TieBreakLot:
+	default:
+		return fmt.Errorf("%w, unknown tie break %q", ErrInvalidDecision, rules.TieBreak)
+	}
+	return nil
+}
+
+// parseRatio reads a fraction above 0 and at most 1
+func parseRatio(s string) (*big.Rat, bool) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() <= 0 || r.Cmp(big.NewRat(1, 1)) > 0 {
+		return nil, false
+	}
+	return r, true
+}
+
+// Decide decides the outcome of the poll by its decision rules, and sets
+// the Decision of the outcome. registered is the number of registered
+// voters, for the turnout.
+func (p *Poll) Decide(outcome *Outcome, registered int) {
+	rules := p.DecisionRules()
+	d := &Decision{
+		Rule:      rules.Rule,
+		Threshold: rules.Threshold,
+		Quorum: Quorum{
+			Votes:            outcome.Ballots,
+			MinVotes:         rules.QuorumVotes,
+			RegisteredVoters: registered,
+			Turnout:          fraction(outcome.Ballots, registered),
+			MinTurnout:       rules.QuorumTurnout,
+		},
+	}
+	outcome.Decision = d
+
+	d.Quorum.Met = outcome.Ballots >= rules.QuorumVotes
+	if least, ok := parseRatio(rules.QuorumTurnout); ok && d.Quorum.Met {
+		d.Quorum.Met = registered > 0 && big.NewRat(int64(outcome.Ballots), int64(registered)).Cmp(least) >= 0
+	}
+
+	if outcome.Method == TallyApproval {
+		d.Votes = outcome.Ballots
+	} else {
+		for _, t := range outcome.Totals {
+			d.Votes += t.Total
+		}
+	}
+
+	leader, found := 0, false
+	switch len(outcome.Winners) {
+	case 0:
+	case 1:
+		leader, found = outcome.Winners[0], true
+	default:
+		d.Tie = &Tie{Options: outcome.Winners}
+		leader, found = p.breakTie(rules.TieBreak, outcome.Winners)
+		if found {
+			d.Tie.BrokenBy = rules.TieBreak
+			if rules.TieBreak == TieBreakLot {
+				d.Tie.Seed = p.Snapshot.LotSeed
+			}
+		}
+	}
+	if len(outcome.Winners) > 0 {
+		d.Support = totalOf(outcome.Totals, outcome.Winners[0])
+	}
+
+	switch {
+	case !d.Quorum.Met:
+		d.Reason = "the quorum was not met"
+	case len(outcome.Winners) == 0:
+		d.Reason = "no votes were counted"
+	case !found && rules.TieBreak == TieBreakLot:
+		d.Reason = fmt.Sprintf("options %v tied, the lot is drawn when the poll closes", outcome.Winners)
+	case !found:
+		d.Reason = fmt.Sprintf("options %v tied", outcome.Winners)
+	case rules.Rule != DecisionPlurality && (outcome.Method == TallyBorda || outcome.Method == TallyScore):
+		// counted another way with ?tally=
+		d.Reason = fmt.Sprintf("a %s cannot be decided by the points of %s", rules.Rule, outcome.Method)
+	case rules.Rule == DecisionMajority && d.Support*2 <= d.Votes:
+		d.Reason = fmt.Sprintf("option %d has %d of %d votes, it needs more than half", leader, d.Support, d.Votes)
+	case rules.Rule == DecisionSupermajority && !reaches(d.Support, d.Votes, rules.Threshold):
+		d.Reason = fmt.Sprintf("option %d has %d of %d votes, it needs at least %s", leader, d.Support, d.Votes, rules.Threshold)
+	default:
+		d.Passed = true
+		d.Winner = &leader
+	}
+}
+
+// breakTie picks the winner among the tied options, it returns false if the
+// tie break does not
+func (p *Poll) breakTie(tieBreak string, tied []int) (int, bool) {
+	switch tieBreak {
+	case TieBreakOrder:
+		for _, option := range p.Options {
+			for _, id := range tied {
+				if option.Id == id {
+					return id, true
+				}
+			}
+		}
+	case TieBreakLot:
+		// seeded from the snapshot, so a recount draws the same option
+		if p.Snapshot == nil || p.Snapshot.LotSeed == nil {
+			return 0, false
+		}
+		ids := append([]int{}, tied...)
+		sort.Ints(ids)
+		return ids[rand.New(rand.NewSource(*p.Snapshot.LotSeed)).Intn(len(ids))], true
+	}
+	return 0, false
+}
+
+// NewLotSeed draws the seed of the lot of a poll that closes
+func NewLotSeed() (int64, error) {
+	var b [8]byte
+	_, err := crand.Read(b[:])
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+// reaches reports whether support is at least threshold of votes
+func reaches(support int, votes int, threshold string) bool {
+	least, ok := parseRatio(threshold)
+	if !ok || votes == 0 {
+		return false
+	}
+	return big.NewRat(int64(support), int64(votes)).Cmp(least) >= 0
+}
+
+func totalOf(totals []OptionTotal, optionId int) int {
+	for _, t := range totals {
+		if t.OptionId == optionId {
+			return t.Total
+		}
+	}
+	return 0
+}
@@ -0,0 +1,277 @@
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      *DecisionRules
+		outcome    Outcome
+		registered int
+		// the decision, Quorum and Tie are checked on their own
+		want      Decision
+		quorumMet bool
+		tie       *Tie
+	}{
+		{
+			name:      "plurality by default",
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 5, Totals: []OptionTotal{{1, 2}, {2, 1}, {3, 2}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionPlurality, Passed: true, Winner: intPtr(1), Support: 2, Votes: 5},
+			quorumMet: true,
+		},
+		{
+			name:      "no votes",
+			outcome:   Outcome{Method: TallyPlurality, Totals: []OptionTotal{{1, 0}, {2, 0}}, Winners: []int{}},
+			want:      Decision{Rule: DecisionPlurality, Reason: "no votes were counted"},
+			quorumMet: true,
+		},
+		{
+			name:      "majority of more than half",
+			rules:     &DecisionRules{Rule: DecisionMajority},
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 7, Totals: []OptionTotal{{1, 4}, {2, 3}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionMajority, Passed: true, Winner: intPtr(1), Support: 4, Votes: 7},
+			quorumMet: true,
+		},
+		{
+			name:    "majority of exactly half fails",
+			rules:   &DecisionRules{Rule: DecisionMajority},
+			outcome: Outcome{Method: TallyPlurality, Ballots: 6, Totals: []OptionTotal{{1, 3}, {2, 2}, {3, 1}}, Winners: []int{1}},
+			want: Decision{Rule: DecisionMajority, Support: 3, Votes: 6,
+				Reason: "option 1 has 3 of 6 votes, it needs more than half"},
+			quorumMet: true,
+		},
+		{
+			name:      "majority of the approval ballots",
+			rules:     &DecisionRules{Rule: DecisionMajority},
+			outcome:   Outcome{Method: TallyApproval, Ballots: 4, Totals: []OptionTotal{{1, 3}, {2, 3}, {3, 1}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionMajority, Passed: true, Winner: intPtr(1), Support: 3, Votes: 4},
+			quorumMet: true,
+		},
+		{
+			name:  "majority of the last irv round",
+			rules: &DecisionRules{Rule: DecisionMajority},
+			// one of 6 ballots was exhausted
+			outcome:   Outcome{Method: TallyInstantRunoff, Ballots: 6, Totals: []OptionTotal{{1, 3}, {2, 2}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionMajority, Passed: true, Winner: intPtr(1), Support: 3, Votes: 5},
+			quorumMet: true,
+		},
+		{
+			name:      "supermajority exactly at the threshold",
+			rules:     &DecisionRules{Rule: DecisionSupermajority, Threshold: "2/3"},
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 6, Totals: []OptionTotal{{1, 4}, {2, 2}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionSupermajority, Threshold: "2/3", Passed: true, Winner: intPtr(1), Support: 4, Votes: 6},
+			quorumMet: true,
+		},
+		{
+			name:    "supermajority below the threshold",
+			rules:   &DecisionRules{Rule: DecisionSupermajority, Threshold: "2/3"},
+			outcome: Outcome{Method: TallyPlurality, Ballots: 5, Totals: []OptionTotal{{1, 3}, {2, 2}}, Winners: []int{1}},
+			want: Decision{Rule: DecisionSupermajority, Threshold: "2/3", Support: 3, Votes: 5,
+				Reason: "option 1 has 3 of 5 votes, it needs at least 2/3"},
+			quorumMet: true,
+		},
+		{
+			name:      "supermajority with a decimal threshold",
+			rules:     &DecisionRules{Rule: DecisionSupermajority, Threshold: "0.6"},
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 5, Totals: []OptionTotal{{1, 3}, {2, 2}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionSupermajority, Threshold: "0.6", Passed: true, Winner: intPtr(1), Support: 3, Votes: 5},
+			quorumMet: true,
+		},
+		{
+			name:    "majority counted by points with ?tally=",
+			rules:   &DecisionRules{Rule: DecisionMajority},
+			outcome: Outcome{Method: TallyBorda, Ballots: 2, Totals: []OptionTotal{{1, 3}, {2, 1}}, Winners: []int{1}},
+			want: Decision{Rule: DecisionMajority, Support: 3, Votes: 4,
+				Reason: "a majority cannot be decided by the points of borda"},
+			quorumMet: true,
+		},
+		{
+			name:    "quorum of votes not met",
+			rules:   &DecisionRules{QuorumVotes: 5},
+			outcome: Outcome{Method: TallyPlurality, Ballots: 4, Totals: []OptionTotal{{1, 4}}, Winners: []int{1}},
+			want:    Decision{Rule: DecisionPlurality, Support: 4, Votes: 4, Reason: "the quorum was not met"},
+		},
+		{
+			name:      "quorum of votes exactly met",
+			rules:     &DecisionRules{QuorumVotes: 5},
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 5, Totals: []OptionTotal{{1, 5}}, Winners: []int{1}},
+			want:      Decision{Rule: DecisionPlurality, Passed: true, Winner: intPtr(1), Support: 5, Votes: 5},
+			quorumMet: true,
+		},
+		{
+			name:       "turnout exactly at the quorum",
+			rules:      &DecisionRules{QuorumTurnout: "1/2"},
+			outcome:    Outcome{Method: TallyPlurality, Ballots: 5, Totals: []OptionTotal{{1, 5}}, Winners: []int{1}},
+			registered: 10,
+			want:       Decision{Rule: DecisionPlurality, Passed: true, Winner: intPtr(1), Support: 5, Votes: 5},
+			quorumMet:  true,
+		},
+		{
+			name:       "turnout below the quorum",
+			rules:      &DecisionRules{QuorumTurnout: "1/2"},
+			outcome:    Outcome{Method: TallyPlurality, Ballots: 4, Totals: []OptionTotal{{1, 4}}, Winners: []int{1}},
+			registered: 10,
+			want:       Decision{Rule: DecisionPlurality, Support: 4, Votes: 4, Reason: "the quorum was not met"},
+		},
+		{
+			name:    "turnout without registered voters",
+			rules:   &DecisionRules{QuorumTurnout: "1/2"},
+			outcome: Outcome{Method: TallyPlurality, Ballots: 4, Totals: []OptionTotal{{1, 4}}, Winners: []int{1}},
+			want:    Decision{Rule: DecisionPlurality, Support: 4, Votes: 4, Reason: "the quorum was not met"},
+		},
+		{
+			name:      "tie without a tie break",
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 4, Totals: []OptionTotal{{1, 2}, {2, 2}}, Winners: []int{1, 2}},
+			want:      Decision{Rule: DecisionPlurality, Support: 2, Votes: 4, Reason: "options [1 2] tied"},
+			quorumMet: true,
+			tie:       &Tie{Options: []int{1, 2}},
+		},
+		{
+			name:      "tie broken by the order of the options",
+			rules:     &DecisionRules{TieBreak: TieBreakOrder},
+			outcome:   Outcome{Method: TallyPlurality, Ballots: 4, Totals: []OptionTotal{{3, 2}, {1, 0}, {2, 2}}, Winners: []int{2, 3}},
+			want:      Decision{Rule: DecisionPlurality, Passed: true, Winner: intPtr(3), Support: 2, Votes: 4},
+			quorumMet: true,
+			tie:       &Tie{Options: []int{2, 3}, BrokenBy: TieBreakOrder},
+		},
+		{
+			name:    "tie broken by the order still needs a majority",
+			rules:   &DecisionRules{Rule: DecisionMajority, TieBreak: TieBreakOrder},
+			outcome: Outcome{Method: TallyPlurality, Ballots: 4, Totals: []OptionTotal{{3, 2}, {1, 0}, {2, 2}}, Winners: []int{2, 3}},
+			want: Decision{Rule: DecisionMajority, Support: 2, Votes: 4,
+				Reason: "option 3 has 2 of 4 votes, it needs more than half"},
+			quorumMet: true,
+			tie:       &Tie{Options: []int{2, 3}, BrokenBy: TieBreakOrder},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// options are listed 3, 1, 2 so the order tie break differs from
+			// the ids
+			p := Poll{Id: 1, Options: options(3, 1, 2), Decision: tt.rules}
+			outcome := tt.outcome
+			p.Decide(&outcome, tt.registered)
+			got := outcome.Decision
+			if got == nil {
+				t.Fatal("Decide did not set the decision")
+			}
+
+			if got.Quorum.Met != tt.quorumMet || got.Quorum.Votes != tt.outcome.Ballots || got.Quorum.RegisteredVoters != tt.registered {
+				t.Errorf("quorum = %+v, want met %v", got.Quorum, tt.quorumMet)
+			}
+			if !reflect.DeepEqual(got.Tie, tt.tie) {
+				t.Errorf("tie = %+v, want %+v", got.Tie, tt.tie)
+			}
+			decision := *got
+			decision.Quorum, decision.Tie = Quorum{}, nil
+			if !reflect.DeepEqual(decision, tt.want) {
+				t.Errorf("decision = %+v, want %+v", decision, tt.want)
+				if got.Winner != nil {
+					t.Errorf("winner = %d", *got.Winner)
+				}
+			}
+		})
+	}
+}
+
+func TestDecideLot(t *testing.T) {
+	tests := []struct {
+		seed int64
+		tied []int
+		want int
+	}{
+		{1, []int{1, 2, 3}, 3},
+		{2, []int{1, 2, 3}, 2},
+		{7, []int{1, 2, 3}, 3},
+		{1, []int{2, 5}, 5},
+		{2, []int{2, 5}, 2},
+	}
+
+	for _, tt := range tests {
+		seed := tt.seed
+		p := Poll{Id: 1, Options: options(1, 2, 3, 5), Decision: &DecisionRules{TieBreak: TieBreakLot},
+			Snapshot: &ResultsSnapshot{LotSeed: &seed}}
+		totals := []OptionTotal{}
+		for _, id := range tt.tied {
+			totals = append(totals, OptionTotal{OptionId: id, Total: 1})
+		}
+
+		// the draw is seeded from the snapshot, it does not depend on the
+		// order of the tied options or on how often the poll is counted
+		reversed := make([]int, 0, len(tt.tied))
+		for i := len(tt.tied) - 1; i >= 0; i-- {
+			reversed = append(reversed, tt.tied[i])
+		}
+		for _, winners := range [][]int{tt.tied, reversed, tt.tied} {
+			outcome := Outcome{Method: TallyPlurality, Ballots: len(winners), Totals: totals, Winners: winners}
+			p.Decide(&outcome, 0)
+			d := outcome.Decision
+			if !d.Passed || d.Winner == nil || *d.Winner != tt.want {
+				t.Errorf("seed %d with %v tied decided %+v, want option %d", tt.seed, winners, d, tt.want)
+				continue
+			}
+			if d.Tie == nil || d.Tie.BrokenBy != TieBreakLot || d.Tie.Seed == nil || *d.Tie.Seed != tt.seed {
+				t.Errorf("seed %d with %v tied has tie %+v, want broken by lot with the seed", tt.seed, winners, d.Tie)
+			}
+		}
+	}
+
+	// a poll that has not closed has no seed, its tie is not broken yet
+	p := Poll{Id: 1, Options: options(1, 2), Decision: &DecisionRules{TieBreak: TieBreakLot}}
+	outcome := Outcome{Method: TallyPlurality, Ballots: 2, Totals: []OptionTotal{{1, 1}, {2, 1}}, Winners: []int{1, 2}}
+	p.Decide(&outcome, 0)
+	if d := outcome.Decision; d.Passed || d.Tie == nil || d.Tie.BrokenBy != "" {
+		t.Errorf("open poll with a tie decided %+v, want it not broken", d)
+	}
+}
+
+func TestValidateDecision(t *testing.T) {
+	tests := []struct {
+		name    string
+		ballot  *BallotRules
+		rules   *DecisionRules
+		wantErr bool
+	}{
+		{"no rules", nil, nil, false},
+		{"plurality", nil, &DecisionRules{Rule: DecisionPlurality}, false},
+		{"default rule", nil, &DecisionRules{TieBreak: TieBreakLot}, false},
+		{"majority", nil, &DecisionRules{Rule: DecisionMajority}, false},
+		{"majority with a threshold", nil, &DecisionRules{Rule: DecisionMajority, Threshold: "2/3"}, true},
+		{"supermajority 2/3", nil, &DecisionRules{Rule: DecisionSupermajority, Threshold: "2/3"}, false},
+		{"supermajority of all", nil, &DecisionRules{Rule: DecisionSupermajority, Threshold: "1"}, false},
+		{"supermajority just above half", nil, &DecisionRules{Rule: DecisionSupermajority, Threshold: "0.51"}, false},
+		{"supermajority of half", nil, &DecisionRules{Rule: DecisionSupermajority, Threshold: "1/2"}, true},
+		{"supermajority above all", nil, &DecisionRules{Rule: DecisionSupermajority, Threshold: "4/3"}, true},
+		{"supermajority without threshold", nil, &DecisionRules{Rule: DecisionSupermajority}, true},
+		{"unknown rule", nil, &DecisionRules{Rule: "unanimous"}, true},
+		{"majority of irv", &BallotRules{Type: BallotRanked}, &DecisionRules{Rule: DecisionMajority}, false},
+		{"majority of borda", &BallotRules{Type: BallotRanked, Tally: TallyBorda}, &DecisionRules{Rule: DecisionMajority}, true},
+		{"majority of score", &BallotRules{Type: BallotScore}, &DecisionRules{Rule: DecisionMajority}, true},
+		{"plurality of score", &BallotRules{Type: BallotScore}, &DecisionRules{Rule: DecisionPlurality}, false},
+		{"negative quorum", nil, &DecisionRules{QuorumVotes: -1}, true},
+		{"turnout of all", nil, &DecisionRules{QuorumTurnout: "1"}, false},
+		{"turnout of zero", nil, &DecisionRules{QuorumTurnout: "0"}, true},
+		{"turnout that is not a fraction", nil, &DecisionRules{QuorumTurnout: "half"}, true},
+		{"unknown tie break", nil, &DecisionRules{TieBreak: "coin"}, true},
+	}
+
+	for _, tt := range tests {
+		p := Poll{Options: options(1, 2, 3), Ballot: tt.ballot, Decision: tt.rules}
+		err := p.ValidateDecision()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidateDecision() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidDecision) {
+			t.Errorf("%s: error %v is not ErrInvalidDecision", tt.name, err)
+		}
+	}
+}
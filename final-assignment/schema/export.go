@@ -0,0 +1,249 @@
+package schema
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Results and votes can be downloaded as a table, one row at a time, so a
+// large export is never held in memory. A TableWriter writes the header row
+// when it is made, and the closing parts of the file in Close.
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson" // one JSON object per row, keyed by column
+	ExportXLSX   = "xlsx"
+)
+
+var ErrExportFormat = errors.New("unknown export format, use csv, ndjson or xlsx")
+
+// TableWriter writes the rows of an export. The values of a row are in the
+// order of the columns, and are strings, numbers or time.Time. Rows are
+// buffered until Flush or Close.
+type TableWriter interface {
+	WriteRow(values ...any) error
+	Flush() error
+	Close() error
+}
+
+// CheckExportFormat returns ErrExportFormat if format is not one of the
+// export formats
+func CheckExportFormat(format string) error {
+	switch format {
+	case ExportCSV, ExportNDJSON, ExportXLSX:
+		return nil
+	}
+	return ErrExportFormat
+}
+
+// ExportContentType is the Content-Type of an export in format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "application/octet-stream"
+}
+
+// NewTableWriter starts an export in format on w
+func NewTableWriter(w io.Writer, format string, columns ...string) (TableWriter, error) {
+	switch format {
+	case ExportCSV:
+		t := &csvWriter{w: csv.NewWriter(w)}
+		return t, t.w.Write(columns)
+	case ExportNDJSON:
+		return &ndjsonWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case ExportXLSX:
+		return newXLSXWriter(w, columns)
+	}
+	return nil, ErrExportFormat
+}
+
+// cellText is a value as text, times are RFC 3339 in UTC
+func cellText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (t *csvWriter) WriteRow(values ...any) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = cellText(value)
+	}
+	return t.w.Write(record)
+}
+
+func (t *csvWriter) Flush() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvWriter) Close() error {
+	return t.Flush()
+}
+
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+// WriteRow writes the row as an object with the keys in column order, which
+// a map would not keep
+func (t *ndjsonWriter) WriteRow(values ...any) error {
+	t.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			t.w.WriteByte(',')
+		}
+		key, err := json.Marshal(t.columns[i])
+		if err != nil {
+			return err
+		}
+		if v, ok := value.(time.Time); ok {
+			value = cellText(v)
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		t.w.Write(key)
+		t.w.WriteByte(':')
+		t.w.Write(raw)
+	}
+	t.w.WriteByte('}')
+	return t.w.WriteByte('\n')
+}
+
+func (t *ndjsonWriter) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *ndjsonWriter) Close() error {
+	return t.Flush()
+}
+
+// An xlsx file is a zip of a few fixed xml parts and the sheet. The sheet is
+// the last entry, so its rows are streamed into the zip as they come. Text
+// is written as inline strings, so no shared string table is needed.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+func newXLSXWriter(w io.Writer, columns []string) (*xlsxWriter, error) {
+	t := &xlsxWriter{zip: zip.NewWriter(w)}
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := t.zip.Create(part.name)
+		if err == nil {
+			_, err = io.WriteString(f, part.body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := t.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	t.sheet = bufio.NewWriter(sheet)
+	t.sheet.WriteString(xlsxSheetStart)
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	return t, t.WriteRow(header...)
+}
+
+func (t *xlsxWriter) WriteRow(values ...any) error {
+	t.rows++
+	row := strconv.Itoa(t.rows)
+	t.sheet.WriteString(`<row r="` + row + `">`)
+	for i, value := range values {
+		ref := xlsxColumn(i) + row
+		switch value.(type) {
+		case int, int64, float64:
+			t.sheet.WriteString(`<c r="` + ref + `"><v>` + cellText(value) + `</v></c>`)
+		default:
+			t.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			err := xml.EscapeText(t.sheet, []byte(cellText(value)))
+			if err != nil {
+				return err
+			}
+			t.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := t.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (t *xlsxWriter) Flush() error {
+	err := t.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return t.zip.Flush()
+}
+
+func (t *xlsxWriter) Close() error {
+	t.sheet.WriteString(xlsxSheetEnd)
+	err := t.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return t.zip.Close()
+}
+
+// xlsxColumn is the letter of the column at index i: A to Z, then AA
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
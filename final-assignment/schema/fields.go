@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"encoding/json"
+)
+
+// SelectFields returns item with only the listed top level properties, for
+// ?fields=. The names are the JSON names, e.g. id or _links. With no fields
+// the item is returned as it is.
+func SelectFields(item any, fields []string) (any, error) {
+	if fields == nil {
+		return item, nil
+	}
+
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(raw, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, name := range fields {
+		if value, ok := all[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected, nil
+}
+
+// SelectFieldsList applies SelectFields to each item of a list
+func SelectFieldsList[T any](items []T, fields []string) (any, error) {
+	if fields == nil {
+		return items, nil
+	}
+
+	selected := make([]any, 0, len(items))
+	for _, item := range items {
+		s, err := SelectFields(item, fields)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
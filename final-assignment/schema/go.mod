@@ -0,0 +1,3 @@
+module drexel.edu/schema
+
+go 1.20
@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"strconv"
+)
+
+// Endpoints are the public urls of the three collections, for example
+// http://localhost:1082/polls. Links are built from them.
+type Endpoints struct {
+	Polls  string
+	Voters string
+	Votes  string
+}
+
+// SetPollLinks sets the HAL links of a poll
+func SetPollLinks(e Endpoints, poll *Poll) {
+	id := strconv.Itoa(poll.Id)
+	poll.Links.Self.Href = e.Polls + "/" + id
+	poll.Links.Vote.Href = e.Votes
+	poll.Links.Votes.Href = e.Votes + "/polls/" + id
+	poll.Links.Voters.Href = e.Voters
+	poll.Links.Results.Href = e.Polls + "/" + id + "/results"
+	poll.Links.Revisions = &Link{Href: e.Polls + "/" + id + "/revisions"}
+	poll.Links.Ledger = &Link{Href: e.Polls + "/" + id + "/ledger"}
+	poll.Links.Analytics = &Link{Href: e.Polls + "/" + id + "/analytics"}
+}
+
+// SetVoterLinks sets the HAL links of a voter
+func SetVoterLinks(e Endpoints, voter *Voter) {
+	id := strconv.Itoa(voter.Id)
+	voter.Links.Self.Href = e.Voters + "/" + id
+	voter.Links.Polls.Href = e.Polls
+	voter.Links.Votes.Href = e.Votes + "/voters/" + id
+	voter.Links.Vote.Href = e.Votes + "/voters/" + id
+	voter.Links.Analytics = &Link{Href: e.Voters + "/" + id + "/analytics"}
+}
+
+// SetVoteLinks sets the HAL links of a vote
+func SetVoteLinks(e Endpoints, vote *Vote) {
+	var links Links
+	links.Self.Href = e.Votes + "/" + strconv.Itoa(vote.Id)
+	links.Voter.Href = e.Voters + "/" + strconv.Itoa(vote.VoterId)
+	links.Poll.Href = e.Polls + "/" + strconv.Itoa(vote.PollId)
+	links.Results.Href = e.Polls + "/" + strconv.Itoa(vote.PollId) + "/results"
+	vote.Links = links
+}
+
+// SetVoteLinksAndEmbedded sets the links of the vote and embeds the voter
+// and poll that are not nil. With neither, the vote has no _embedded.
+func SetVoteLinksAndEmbedded(e Endpoints, vote *Vote, voter *Voter, poll *Poll) {
+	SetVoteLinks(e, vote)
+
+	if voter == nil && poll == nil {
+		vote.Embedded = nil
+		return
+	}
+
+	vote.Embedded = VoteEmbedded{Voter: voter, Poll: poll}
+}
@@ -0,0 +1,289 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Voters, polls and votes can be imported in bulk as NDJSON, one JSON object
+// per line as the item is sent to POST, or as CSV with a header row naming
+// the fields by their JSON names. A CSV cell is taken as text for a string
+// field and as JSON for any other, so options are written as
+// [{"text":"Yes"},{"text":"No"}], and times need no quotes. Empty cells are
+// left out.
+
+// the rows of an import are checked and written in batches of this size
+const ImportBatch = 100
+
+var (
+	ErrImportFormat = errors.New("unknown import format, use csv or ndjson")
+	// ErrImportRow is wrapped by the errors of a row that could not be read,
+	// the rows after it can still be read
+	ErrImportRow = errors.New("invalid row")
+)
+
+// ImportFormat is the format of an import, from format if it is set (the
+// ?format= of the request), or else from the Content-Type. NDJSON is the
+// default.
+func ImportFormat(format string, contentType string) (string, error) {
+	switch {
+	case format == ExportCSV || format == ExportNDJSON:
+		return format, nil
+	case format != "":
+		return "", ErrImportFormat
+	case strings.Contains(contentType, "csv"):
+		return ExportCSV, nil
+	}
+	return ExportNDJSON, nil
+}
+
+// ImportReader reads the rows of an import one at a time
+type ImportReader struct {
+	lines *bufio.Scanner
+	csv   *csv.Reader
+	// the struct field of each csv column
+	fields []string
+	// the last line read of an NDJSON import
+	line int
+}
+
+// NewImportReader starts reading an import in format from r. For CSV the
+// header row is read, and every column must name a field of item.
+func NewImportReader(r io.Reader, format string, item any) (*ImportReader, error) {
+	ir := &ImportReader{}
+	switch format {
+	case ExportNDJSON:
+		ir.lines = bufio.NewScanner(r)
+		ir.lines.Buffer(make([]byte, 64*1024), 1024*1024)
+		return ir, nil
+	case ExportCSV:
+		ir.csv = csv.NewReader(r)
+		ir.csv.FieldsPerRecord = -1
+		header, err := ir.csv.Read()
+		if err == io.EOF {
+			return ir, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names := jsonFields(reflect.TypeOf(item).Elem())
+		for _, column := range header {
+			field, ok := names[strings.ToLower(strings.TrimSpace(column))]
+			if !ok {
+				return nil, fmt.Errorf("unknown column %q", column)
+			}
+			ir.fields = append(ir.fields, field)
+		}
+		return ir, nil
+	}
+	return nil, ErrImportFormat
+}
+
+// Next reads the next row into item, a pointer to a struct, and returns the
+// line it started on. It returns io.EOF after the last row. An error that
+// wraps ErrImportRow is about that row only, reading can go on.
+func (r *ImportReader) Next(item any) (int, error) {
+	if r.lines != nil {
+		for r.lines.Scan() {
+			r.line++
+			raw := bytes.TrimSpace(r.lines.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			err := json.Unmarshal(raw, item)
+			if err != nil {
+				return r.line, fmt.Errorf("%w, %s", ErrImportRow, err)
+			}
+			return r.line, nil
+		}
+		if r.lines.Err() != nil {
+			return r.line, r.lines.Err()
+		}
+		return r.line, io.EOF
+	}
+
+	record, err := r.csv.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, fmt.Errorf("%w, %s", ErrImportRow, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	line, _ := r.csv.FieldPos(0)
+	if len(record) != len(r.fields) {
+		return line, fmt.Errorf("%w, it has %d columns, the header has %d", ErrImportRow, len(record), len(r.fields))
+	}
+
+	value := reflect.ValueOf(item).Elem()
+	for i, cell := range record {
+		if cell == "" {
+			continue
+		}
+		field := value.FieldByName(r.fields[i])
+		if field.Kind() == reflect.String {
+			field.SetString(cell)
+			continue
+		}
+		err = json.Unmarshal([]byte(cell), field.Addr().Interface())
+		if err != nil {
+			// times are strings in JSON, but are not quoted in the cell
+			quoted, _ := json.Marshal(cell)
+			if json.Unmarshal(quoted, field.Addr().Interface()) == nil {
+				continue
+			}
+			return line, fmt.Errorf("%w, column %d: %s", ErrImportRow, i+1, err)
+		}
+	}
+	return line, nil
+}
+
+// jsonFields maps the JSON names of the fields of a struct type to the
+// fields, the _links, _embedded and _meta fields are left out
+func jsonFields(t reflect.Type) map[string]string {
+	names := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" || strings.HasPrefix(name, "_") {
+			continue
+		}
+		names[strings.ToLower(name)] = field.Name
+	}
+	return names
+}
+
+// ImportRows reads every row of r into a new T, and counts it in report.
+// check is called with each row that could be read, and write with each
+// batch of rows that passed it, unless the report is for a dry run. write
+// returns nil if every item was written, or an error for each item. id is
+// the id of an item for the report. The error is nil unless r could not be
+// read any further.
+func ImportRows[T any](r *ImportReader, report *ImportReport, check func(item *T) error, write func(items []*T) []error, id func(item *T) int) error {
+	var batch []*T
+	var lines []int
+	flush := func() {
+		var errs []error
+		if !report.DryRun && len(batch) > 0 {
+			errs = write(batch)
+		}
+		for i, item := range batch {
+			if errs != nil && errs[i] != nil {
+				report.Fail(lines[i], errs[i])
+				continue
+			}
+			report.Add(id(item))
+		}
+		batch, lines = batch[:0], lines[:0]
+	}
+
+	var err error
+	for {
+		item := new(T)
+		var line int
+		line, err = r.Next(item)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if errors.Is(err, ErrImportRow) {
+			report.Fail(line, err)
+			continue
+		}
+		if err != nil {
+			break
+		}
+
+		err = check(item)
+		if err != nil {
+			report.Fail(line, err)
+			continue
+		}
+		batch = append(batch, item)
+		lines = append(lines, line)
+		if len(batch) == ImportBatch {
+			flush()
+		}
+	}
+	flush()
+
+	// rows that failed the check are reported before the batch they were in
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	return err
+}
+
+// BatchErrors is the result of a write of n items that either all worked
+// or all failed with err, see ImportRows
+func BatchErrors(n int, err error) []error {
+	if err == nil {
+		return nil
+	}
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// ClaimErrors is the result of a write that skipped the items whose keys
+// were taken, each of them fails with exists, see ImportRows
+func ClaimErrors(taken []bool, exists error) []error {
+	var errs []error
+	for i := range taken {
+		if !taken[i] {
+			continue
+		}
+		if errs == nil {
+			errs = make([]error, len(taken))
+		}
+		errs[i] = exists
+	}
+	return errs
+}
+
+// ImportReport is the outcome of an import. On a dry run nothing is
+// written, Imported is the number of rows that would be.
+type ImportReport struct {
+	DryRun   bool `json:"dryRun"`
+	Rows     int  `json:"rows"`
+	Imported int  `json:"imported"`
+	Failed   int  `json:"failed"`
+	// the ids of the imported items in the order of their rows, a dry run
+	// only has the ids the rows set themselves
+	Ids    []int         `json:"ids"`
+	Errors []ImportError `json:"errors"`
+}
+
+// ImportError is a row that was not imported
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+func NewImportReport(dryRun bool) ImportReport {
+	return ImportReport{DryRun: dryRun, Ids: []int{}, Errors: []ImportError{}}
+}
+
+// Add counts a row that was imported as the item with the id
+func (r *ImportReport) Add(id int) {
+	r.Rows++
+	r.Imported++
+	if id != 0 {
+		r.Ids = append(r.Ids, id)
+	}
+}
+
+// Fail counts a row that was not imported
+func (r *ImportReport) Fail(line int, err error) {
+	r.Rows++
+	r.Failed++
+	r.Errors = append(r.Errors, ImportError{Line: line, Error: err.Error()})
+}
@@ -0,0 +1,205 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Every vote that is cast, changed or deleted is appended to the ledger of
+// its poll. Each entry holds the hash of the entry before it, so changing,
+// dropping or reordering an entry breaks the chain from there on. The votes
+// api appends to the ledger and the poll api verifies it.
+//
+// A delete takes the vote out of the results. A vote that is deleted while
+// it stays in the results, because its poll closed and keeps them as they
+// were, is a remove instead, and still counts when the ledger is replayed.
+const (
+	LedgerCast   = "cast"
+	LedgerChange = "change"
+	LedgerDelete = "delete"
+	LedgerRemove = "remove"
+)
+
+// LedgerGenesis is the PrevHash of the first entry of a ledger
+const LedgerGenesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// LedgerKey is the redis list that holds the ledger of a poll, oldest entry
+// first. It does not end in a number, so listing the polls skips it.
+func LedgerKey(pollId int) string {
+	return "polls:" + strconv.Itoa(pollId) + ":ledger"
+}
+
+// LedgerEntry is one change of a vote. The vote is as it was after the
+// change, or before it for a delete.
+type LedgerEntry struct {
+	Seq          int       `json:"seq"` // from 1
+	PollId       int       `json:"pollId"`
+	VoteId       int       `json:"voteId"`
+	VoterId      int       `json:"voterId"`
+	Action       string    `json:"action"`
+	VoteValue    int       `json:"voteValue"`
+	Ballot       *Ballot   `json:"ballot,omitempty"`
+	PollRevision int       `json:"pollRevision,omitempty"`
+	At           time.Time `json:"at"`
+	PrevHash     string    `json:"prevHash"`
+	// hex SHA-256 of the entry as JSON with Hash left empty
+	Hash string `json:"hash"`
+}
+
+// NewLedgerEntry makes the entry that follows prev, which is nil for the
+// first entry of a ledger
+func NewLedgerEntry(prev *LedgerEntry, action string, vote *Vote, at time.Time) (LedgerEntry, error) {
+	entry := LedgerEntry{
+		Seq:          1,
+		PollId:       vote.PollId,
+		VoteId:       vote.Id,
+		VoterId:      vote.VoterId,
+		Action:       action,
+		VoteValue:    vote.VoteValue,
+		Ballot:       vote.Ballot,
+		PollRevision: vote.PollRevision,
+		At:           at.UTC(),
+		PrevHash:     LedgerGenesis,
+	}
+	if prev != nil {
+		entry.Seq = prev.Seq + 1
+		entry.PrevHash = prev.Hash
+	}
+
+	hash, err := entry.ComputeHash()
+	entry.Hash = hash
+	return entry, err
+}
+
+// ComputeHash returns what the Hash of the entry should be
+func (e LedgerEntry) ComputeHash() (string, error) {
+	e.Hash = ""
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// LedgerVerification is the outcome of checking a ledger against its poll
+type LedgerVerification struct {
+	PollId  int    `json:"pollId"`
+	Valid   bool   `json:"valid"` // the chain and the results are both valid
+	Entries int    `json:"entries"`
+	Head    string `json:"head,omitempty"` // hash of the last entry
+
+	// ChainValid is false from the first entry whose seq, hash or link to
+	// the entry before is wrong, BrokenAt is its position from 1
+	ChainValid bool   `json:"chainValid"`
+	BrokenAt   int    `json:"brokenAt,omitempty"`
+	Problem    string `json:"problem,omitempty"`
+
+	// Ledger are the results the entries add up to, Poll those of the poll
+	ResultsValid bool              `json:"resultsValid"`
+	Ledger       []Results         `json:"ledger"`
+	Poll         []Results         `json:"poll"`
+	Mismatches   []ResultsMismatch `json:"mismatches,omitempty"`
+
+	Links Links `json:"_links"`
+}
+
+// ResultsMismatch is an option whose count differs between the ledger and
+// the poll
+type ResultsMismatch struct {
+	OptionId int `json:"optionId"`
+	Ledger   int `json:"ledger"`
+	Poll     int `json:"poll"`
+}
+
+// VerifyLedger recomputes the hash chain of the entries, and replays them to
+// check the results of the poll. A vote counts in the replayed results as it
+// does in the poll, see Poll.Counts.
+func VerifyLedger(poll *Poll, entries []LedgerEntry) LedgerVerification {
+	v := LedgerVerification{
+		PollId:     poll.Id,
+		Entries:    len(entries),
+		ChainValid: true,
+		Poll:       poll.Results,
+	}
+	if v.Poll == nil {
+		v.Poll = []Results{}
+	}
+
+	prevHash := LedgerGenesis
+	live := map[int]LedgerEntry{}
+	for i, entry := range entries {
+		if v.ChainValid {
+			problem := checkLedgerEntry(poll.Id, i+1, prevHash, &entry)
+			if problem != "" {
+				v.ChainValid = false
+				v.BrokenAt = i + 1
+				v.Problem = problem
+			}
+		}
+		prevHash = entry.Hash
+
+		switch entry.Action {
+		case LedgerCast, LedgerChange:
+			live[entry.VoteId] = entry
+		case LedgerDelete:
+			delete(live, entry.VoteId)
+		case LedgerRemove:
+			// the vote is gone, its count is not
+		}
+	}
+	if len(entries) > 0 {
+		v.Head = entries[len(entries)-1].Hash
+	}
+
+	votes := make([]Vote, 0, len(live))
+	for _, entry := range live {
+		votes = append(votes, Vote{Id: entry.VoteId, PollId: poll.Id, VoteValue: entry.VoteValue, PollRevision: entry.PollRevision})
+	}
+	v.Ledger = poll.CountResults(votes)
+
+	polled := map[int]int{}
+	for _, result := range poll.Results {
+		polled[result.OptionId] += result.Votes
+	}
+	for _, result := range v.Ledger {
+		if polled[result.OptionId] != result.Votes {
+			v.Mismatches = append(v.Mismatches, ResultsMismatch{OptionId: result.OptionId, Ledger: result.Votes, Poll: polled[result.OptionId]})
+		}
+		delete(polled, result.OptionId)
+	}
+	// counts the poll has for options it does not have anymore
+	for _, result := range poll.Results {
+		if votes, ok := polled[result.OptionId]; ok && votes != 0 {
+			v.Mismatches = append(v.Mismatches, ResultsMismatch{OptionId: result.OptionId, Poll: votes})
+			delete(polled, result.OptionId)
+		}
+	}
+
+	v.ResultsValid = len(v.Mismatches) == 0
+	v.Valid = v.ChainValid && v.ResultsValid
+	return v
+}
+
+// checkLedgerEntry returns what is wrong with the entry at position seq, or
+// "" if it is fine
+func checkLedgerEntry(pollId int, seq int, prevHash string, entry *LedgerEntry) string {
+	if entry.Seq != seq {
+		return fmt.Sprintf("entry %d has seq %d", seq, entry.Seq)
+	}
+	if entry.PollId != pollId {
+		return fmt.Sprintf("entry %d is for poll %d", seq, entry.PollId)
+	}
+	if entry.PrevHash != prevHash {
+		return fmt.Sprintf("entry %d does not follow the entry before it", seq)
+	}
+	hash, err := entry.ComputeHash()
+	if err != nil || hash != entry.Hash {
+		return fmt.Sprintf("entry %d does not match its hash", seq)
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package schema
+
+import (
+	"testing"
+	"time"
+)
+
+// ledgerOf chains entries for the actions, each on the vote with the same
+// index in votes
+func ledgerOf(t *testing.T, votes []Vote, actions []string) []LedgerEntry {
+	t.Helper()
+	var entries []LedgerEntry
+	var prev *LedgerEntry
+	for i, action := range actions {
+		entry, err := NewLedgerEntry(prev, action, &votes[i], time.Unix(int64(i), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+		prev = &entries[len(entries)-1]
+	}
+	return entries
+}
+
+func TestVerifyLedger(t *testing.T) {
+	one := Vote{Id: 1, PollId: 1, VoteValue: 1}
+	two := Vote{Id: 2, PollId: 1, VoteValue: 2}
+	moved := Vote{Id: 2, PollId: 1, VoteValue: 1}
+
+	tests := []struct {
+		name    string
+		votes   []Vote
+		actions []string
+		results []Results
+		valid   bool
+	}{
+		{"casts", []Vote{one, two}, []string{LedgerCast, LedgerCast}, []Results{{1, 1}, {2, 1}}, true},
+		{"change", []Vote{one, two, moved}, []string{LedgerCast, LedgerCast, LedgerChange}, []Results{{1, 2}, {2, 0}}, true},
+		{"delete takes the vote out", []Vote{one, two, one}, []string{LedgerCast, LedgerCast, LedgerDelete}, []Results{{1, 0}, {2, 1}}, true},
+		{"remove keeps the count", []Vote{one, two, one}, []string{LedgerCast, LedgerCast, LedgerRemove}, []Results{{1, 1}, {2, 1}}, true},
+		{"delete of a vote the poll still counts", []Vote{one, two, one}, []string{LedgerCast, LedgerCast, LedgerDelete}, []Results{{1, 1}, {2, 1}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poll := Poll{Id: 1, Options: options(1, 2), Results: tt.results, State: PollClosed}
+			v := VerifyLedger(&poll, ledgerOf(t, tt.votes, tt.actions))
+			if !v.ChainValid {
+				t.Fatalf("chain broken at %d: %s", v.BrokenAt, v.Problem)
+			}
+			if v.Valid != tt.valid {
+				t.Errorf("valid = %v, want %v, mismatches %+v", v.Valid, tt.valid, v.Mismatches)
+			}
+		})
+	}
+}
+
+func TestVerifyLedgerBrokenChain(t *testing.T) {
+	one := Vote{Id: 1, PollId: 1, VoteValue: 1}
+	entries := ledgerOf(t, []Vote{one, one, one}, []string{LedgerCast, LedgerRemove, LedgerCast})
+	entries[1].Action = LedgerDelete
+
+	v := VerifyLedger(&Poll{Id: 1, Options: options(1)}, entries)
+	if v.ChainValid || v.BrokenAt != 2 {
+		t.Errorf("changing the action of entry 2 gives chain valid %v broken at %d, want broken at 2", v.ChainValid, v.BrokenAt)
+	}
+}
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"time"
+)
+
+// The states a poll goes through. A draft can still be edited and is not
+// shown to voters yet, a scheduled poll opens by itself at OpensAt, only an
+// open poll takes votes, and a closed poll keeps the snapshot of its results
+// taken when it closed. Archived polls are closed polls nobody looks at
+// anymore.
+const (
+	PollDraft     = "draft"
+	PollScheduled = "scheduled"
+	PollOpen      = "open"
+	PollClosed    = "closed"
+	PollArchived  = "archived"
+)
+
+// pollTransitions lists the states a poll can move to from each state
+var pollTransitions = map[string][]string{
+	PollDraft:     {PollScheduled, PollOpen},
+	PollScheduled: {PollDraft, PollOpen},
+	PollOpen:      {PollClosed},
+	PollClosed:    {PollArchived},
+	PollArchived:  {},
+}
+
+// ResultsSnapshot is the frozen result of a poll, taken when it closed.
+// LotSeed is drawn then for a poll whose ties are broken by lot.
+type ResultsSnapshot struct {
+	Results    []Results `json:"results"`
+	TotalVotes int       `json:"totalVotes"`
+	Outcome    *Outcome  `json:"outcome,omitempty"`
+	LotSeed    *int64    `json:"lotSeed,omitempty"`
+	TakenAt    time.Time `json:"takenAt"`
+}
+
+// CurrentState is the state of the poll. Polls stored before polls had a
+// state have none, they were always open.
+func (p *Poll) CurrentState() string {
+	if p.State == "" {
+		return PollOpen
+	}
+	return p.State
+}
+
+// AcceptsVotes reports whether votes can be cast, changed or deleted
+func (p *Poll) AcceptsVotes() bool {
+	return p.CurrentState() == PollOpen
+}
+
+// CanTransition reports whether a poll in state from may move to state to
+func CanTransition(from string, to string) bool {
+	for _, next := range pollTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPollState reports whether state is one of the poll states
+func ValidPollState(state string) bool {
+	_, ok := pollTransitions[state]
+	return ok
+}
+
+// TakeSnapshot copies the current results of the poll
+func (p *Poll) TakeSnapshot(now time.Time) ResultsSnapshot {
+	results := make([]Results, len(p.Results))
+	copy(results, p.Results)
+
+	return ResultsSnapshot{Results: results, TotalVotes: p.CountVotes(), TakenAt: now}
+}
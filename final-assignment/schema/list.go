@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// PageRequest asks for one page of a list. The cursor is the id of the last
+// item of the previous page, so pages stay stable while items are added or
+// removed.
+type PageRequest struct {
+	Limit  int
+	Cursor int
+	// false on the first page, when there is no cursor
+	HasCursor bool
+}
+
+// Page holds the ids on the requested page and the cursors of its neighbours
+type Page struct {
+	Ids     []int
+	Total   int
+	HasNext bool
+	Next    int
+	HasPrev bool
+	// PrevFirst is set when the previous page is the first page
+	PrevFirst bool
+	Prev      int
+}
+
+// NewList wraps a page of items in a HAL list with first/next/prev links.
+// base is the url of the collection, name the key of the items in _embedded.
+func NewList(base string, name string, items any, p Page, req PageRequest) List {
+	link := func(cursor int, withCursor bool) *Link {
+		q := url.Values{}
+		q.Set("limit", strconv.Itoa(req.Limit))
+		if withCursor {
+			q.Set("cursor", strconv.Itoa(cursor))
+		}
+		return &Link{Href: base + "?" + q.Encode()}
+	}
+
+	var list List
+	list.Embedded = map[string]any{name: items}
+	list.Links.Self = *link(req.Cursor, req.HasCursor)
+	list.Links.First = link(0, false)
+	if p.HasNext {
+		list.Links.Next = link(p.Next, true)
+	}
+	if p.HasPrev {
+		list.Links.Prev = link(p.Prev, !p.PrevFirst)
+	}
+	total := p.Total
+	list.Meta.Total = &total
+
+	return list
+}
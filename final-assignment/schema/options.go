@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Votes and results refer to an option by its id, never by its position in
+// Poll.Options, so options can be reordered, added or removed without
+// moving the votes of the others.
+
+var ErrInvalidOptions = errors.New("invalid options")
+
+// PrepareOptions gives options without an id the next free one, and checks
+// that no two options share an id
+func (p *Poll) PrepareOptions() error {
+	next := 0
+	seen := make(map[int]bool, len(p.Options))
+	for _, option := range p.Options {
+		if option.Id < 0 {
+			return fmt.Errorf("%w, option id %d is negative", ErrInvalidOptions, option.Id)
+		}
+		if option.Id == 0 {
+			continue
+		}
+		if seen[option.Id] {
+			return fmt.Errorf("%w, option id %d is used twice", ErrInvalidOptions, option.Id)
+		}
+		seen[option.Id] = true
+		if option.Id > next {
+			next = option.Id
+		}
+	}
+
+	for i := range p.Options {
+		if p.Options[i].Id == 0 {
+			next++
+			p.Options[i].Id = next
+		}
+	}
+	return nil
+}
+
+// HasOption reports whether the poll has an option with the id
+func (p *Poll) HasOption(id int) bool {
+	return p.OptionIndex(id) >= 0
+}
+
+// OptionIndex is the position of the option with the id in Options, or -1
+func (p *Poll) OptionIndex(id int) int {
+	for i, option := range p.Options {
+		if option.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// OptionText is the text of the option with the id, or "" if the poll does
+// not have it
+func (p *Poll) OptionText(id int) string {
+	if i := p.OptionIndex(id); i >= 0 {
+		return p.Options[i].Text
+	}
+	return ""
+}
+
+// ResetResults sets up a result for every option. The counts in prev are
+// kept for the options that are still there, prev may be nil.
+func (p *Poll) ResetResults(prev []Results) {
+	counts := make(map[int]int, len(prev))
+	for _, result := range prev {
+		counts[result.OptionId] = result.Votes
+	}
+
+	p.Results = make([]Results, len(p.Options))
+	for i, option := range p.Options {
+		p.Results[i] = Results{OptionId: option.Id, Votes: counts[option.Id]}
+	}
+}
@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The results of a poll, the histories of the voters and the vote indexes
+// are kept apart from the vote documents, and drift from them when a
+// request fails half way. A recount rebuilds them from the votes and lists
+// every Discrepancy it found.
+
+// the kinds of discrepancy
+const (
+	DiscrepancyResults    = "poll.results"
+	DiscrepancyPollTotal  = "poll.totalVotes"
+	DiscrepancyHistory    = "voter.history"
+	DiscrepancyVoterTotal = "voter.totalVotes"
+	DiscrepancyActivity   = "poll.activity"
+	DiscrepancyPollIds    = "poll.ids"
+	DiscrepancyVoterIds   = "voter.ids"
+	DiscrepancyIndex      = "vote.index"
+	DiscrepancyOrphan     = "vote.orphan"
+	DiscrepancyDuplicate  = "vote.duplicate"
+	DiscrepancyUnreadable = "vote.unreadable"
+)
+
+// Discrepancy is one aggregate that does not match the votes. Id is the
+// poll, voter or vote it is about, depending on Kind.
+type Discrepancy struct {
+	Kind     string `json:"kind"`
+	Id       int    `json:"id"`
+	Message  string `json:"message"`
+	Repaired bool   `json:"repaired"`
+}
+
+// String is the discrepancy as a line of the fsck report, e.g.
+// "poll 3: option 2 has 5 votes, the votes add up to 4"
+func (d Discrepancy) String() string {
+	item, _, _ := strings.Cut(d.Kind, ".")
+	s := item + " " + strconv.Itoa(d.Id) + ": " + d.Message
+	if d.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// Recount is the outcome of recounting one poll
+type Recount struct {
+	PollId int `json:"pollId"`
+	// the number of votes read
+	Votes         int           `json:"votes"`
+	Results       []Results     `json:"results"`
+	TotalVotes    int           `json:"totalVotes"`
+	Repaired      bool          `json:"repaired"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	Links         Links         `json:"_links"`
+}
+
+// CountVotes is the number of votes counted in the results
+func (p *Poll) CountVotes() int {
+	total := 0
+	for _, result := range p.Results {
+		total += result.Votes
+	}
+	return total
+}
+
+// CountResults counts the votes the way the results of the poll count them,
+// see Counts. The results are in the order of the options.
+func (p *Poll) CountResults(votes []Vote) []Results {
+	counts := map[int]int{}
+	for i := range votes {
+		if votes[i].PollId == p.Id && p.Counts(&votes[i]) {
+			counts[votes[i].VoteValue]++
+		}
+	}
+
+	results := make([]Results, 0, len(p.Options))
+	for _, option := range p.Options {
+		results = append(results, Results{OptionId: option.Id, Votes: counts[option.Id]})
+	}
+	return results
+}
+
+// RecountPoll counts the votes of the poll again, and lists where its
+// results and Meta.TotalVotes differ from them
+func RecountPoll(p *Poll, votes []Vote) Recount {
+	recount := Recount{PollId: p.Id, Votes: len(votes), Discrepancies: []Discrepancy{}}
+	recount.Results = p.CountResults(votes)
+	for _, result := range recount.Results {
+		recount.TotalVotes += result.Votes
+	}
+
+	stored := map[int]int{}
+	for _, result := range p.Results {
+		stored[result.OptionId] = result.Votes
+	}
+	for _, result := range recount.Results {
+		votes, ok := stored[result.OptionId]
+		delete(stored, result.OptionId)
+		if ok && votes == result.Votes {
+			continue
+		}
+		recount.Discrepancies = append(recount.Discrepancies, Discrepancy{
+			Kind:    DiscrepancyResults,
+			Id:      p.Id,
+			Message: fmt.Sprintf("option %d has %d votes, the votes add up to %d", result.OptionId, votes, result.Votes),
+		})
+	}
+	// results of options the poll does not have anymore
+	for _, result := range p.Results {
+		if _, ok := stored[result.OptionId]; ok {
+			recount.Discrepancies = append(recount.Discrepancies, Discrepancy{
+				Kind:    DiscrepancyResults,
+				Id:      p.Id,
+				Message: fmt.Sprintf("results have option %d, which the poll does not", result.OptionId),
+			})
+		}
+	}
+
+	if p.Meta.TotalVotes != recount.TotalVotes {
+		recount.Discrepancies = append(recount.Discrepancies, Discrepancy{
+			Kind:    DiscrepancyPollTotal,
+			Id:      p.Id,
+			Message: fmt.Sprintf("total votes is %d, the votes add up to %d", p.Meta.TotalVotes, recount.TotalVotes),
+		})
+	}
+	return recount
+}
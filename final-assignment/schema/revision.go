@@ -0,0 +1,184 @@
+package schema
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Every edit of the title, question, options, ballot or decision rules of a
+// poll makes a new revision, numbered from 1. Votes record the revision they were cast
+// against. What happens to the votes already cast when a poll is edited is
+// the poll's EditPolicy:
+//   - reject: a poll with votes cannot be edited
+//   - new-revision: the votes for options that are still there stay counted
+//   - reset: the results start from zero, the earlier votes are kept but no
+//     longer counted
+const (
+	EditReject      = "reject"
+	EditNewRevision = "new-revision"
+	EditReset       = "reset"
+)
+
+// ValidEditPolicy reports whether policy is one of the edit policies
+func ValidEditPolicy(policy string) bool {
+	return policy == EditReject || policy == EditNewRevision || policy == EditReset
+}
+
+// PollRevision is the editable part of a poll as it was in one revision
+type PollRevision struct {
+	PollId   int            `json:"pollId"`
+	Revision int            `json:"revision"`
+	Title    string         `json:"title"`
+	Question string         `json:"question"`
+	Options  []PollOption   `json:"options"`
+	Ballot   *BallotRules   `json:"ballot,omitempty"`
+	Decision *DecisionRules `json:"decision,omitempty"`
+	// the results when the revision was replaced, or the live results of
+	// the current revision
+	Results []Results `json:"results"`
+	// the edit policy applied to the votes when the revision was made, only
+	// set if the poll had votes then
+	Policy     string     `json:"policy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ReplacedAt *time.Time `json:"replacedAt,omitempty"`
+	Links      Links      `json:"_links"`
+}
+
+// CurrentRevision is the revision of the poll. Polls stored before polls
+// had revisions are in their first.
+func (p *Poll) CurrentRevision() int {
+	if p.Revision == 0 {
+		return 1
+	}
+	return p.Revision
+}
+
+// HasVotes reports whether any vote is counted in the results
+func (p *Poll) HasVotes() bool {
+	for _, result := range p.Results {
+		if result.Votes != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts reports whether the vote is counted in the results of the poll. A
+// vote cast before the results were reset, or for an option that was
+// removed since, is not.
+func (p *Poll) Counts(vote *Vote) bool {
+	return vote.PollRevision >= p.ResultsSince && p.HasOption(vote.VoteValue)
+}
+
+// RevisionOf copies the editable part of the poll
+func RevisionOf(p *Poll, createdAt time.Time) PollRevision {
+	options := make([]PollOption, len(p.Options))
+	copy(options, p.Options)
+	results := make([]Results, len(p.Results))
+	copy(results, p.Results)
+
+	return PollRevision{
+		PollId:    p.Id,
+		Revision:  p.CurrentRevision(),
+		Title:     p.Title,
+		Question:  p.Question,
+		Options:   options,
+		Ballot:    p.Ballot,
+		Decision:  p.Decision,
+		Results:   results,
+		CreatedAt: createdAt,
+	}
+}
+
+// SetRevisionLinks sets the HAL links of a poll revision
+func SetRevisionLinks(e Endpoints, rev *PollRevision) {
+	poll := e.Polls + "/" + strconv.Itoa(rev.PollId)
+	rev.Links = Links{}
+	rev.Links.Self.Href = poll + "/revisions/" + strconv.Itoa(rev.Revision)
+	rev.Links.Poll.Href = poll
+	rev.Links.Revisions = &Link{Href: poll + "/revisions"}
+}
+
+// Change is a value that differs between two revisions
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// OptionChange is an option whose text differs between two revisions
+type OptionChange struct {
+	Id   int    `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RevisionDiff lists what changed from one revision of a poll to another
+type RevisionDiff struct {
+	PollId   int            `json:"pollId"`
+	From     int            `json:"from"`
+	To       int            `json:"to"`
+	Title    *Change        `json:"title,omitempty"`
+	Question *Change        `json:"question,omitempty"`
+	Ballot   *Change        `json:"ballot,omitempty"`
+	Decision *Change        `json:"decision,omitempty"`
+	Added    []PollOption   `json:"added,omitempty"`
+	Removed  []PollOption   `json:"removed,omitempty"`
+	Renamed  []OptionChange `json:"renamed,omitempty"`
+	// the options both revisions have are in another order
+	Reordered bool `json:"reordered,omitempty"`
+}
+
+// Empty reports whether the two revisions are the same
+func (d RevisionDiff) Empty() bool {
+	return d.Title == nil && d.Question == nil && d.Ballot == nil && d.Decision == nil &&
+		len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Renamed) == 0 && !d.Reordered
+}
+
+// Diff compares the revision from with the revision to. Options are matched
+// by id.
+func Diff(from PollRevision, to PollRevision) RevisionDiff {
+	d := RevisionDiff{PollId: to.PollId, From: from.Revision, To: to.Revision}
+
+	if from.Title != to.Title {
+		d.Title = &Change{From: from.Title, To: to.Title}
+	}
+	if from.Question != to.Question {
+		d.Question = &Change{From: from.Question, To: to.Question}
+	}
+	if !reflect.DeepEqual(from.Ballot, to.Ballot) {
+		d.Ballot = &Change{From: from.Ballot, To: to.Ballot}
+	}
+	if !reflect.DeepEqual(from.Decision, to.Decision) {
+		d.Decision = &Change{From: from.Decision, To: to.Decision}
+	}
+
+	before := make(map[int]PollOption, len(from.Options))
+	for _, option := range from.Options {
+		before[option.Id] = option
+	}
+	after := make(map[int]bool, len(to.Options))
+	var keptBefore, keptAfter []int
+	for _, option := range to.Options {
+		after[option.Id] = true
+		old, ok := before[option.Id]
+		if !ok {
+			d.Added = append(d.Added, option)
+			continue
+		}
+		keptAfter = append(keptAfter, option.Id)
+		if old.Text != option.Text {
+			d.Renamed = append(d.Renamed, OptionChange{Id: option.Id, From: old.Text, To: option.Text})
+		}
+	}
+	for _, option := range from.Options {
+		if !after[option.Id] {
+			d.Removed = append(d.Removed, option)
+			continue
+		}
+		keptBefore = append(keptBefore, option.Id)
+	}
+	d.Reordered = !reflect.DeepEqual(keptBefore, keptAfter)
+
+	return d
+}
@@ -0,0 +1,131 @@
+// Package schema holds the domain types shared by the voter, poll and votes
+// apis, and the helpers that build their HAL links. All three services import
+// this module, so a change to a type or a link is made in one place.
+//
+// The module is only used through the replace directives in the services'
+// go.mod files, so its version is pinned at v0.0.0 and never bumped. A
+// change to a type or a link takes effect in all three at the next build.
+package schema
+
+import (
+	"time"
+)
+
+type Vote struct {
+	Id        int     `json:"id"`
+	PollId    int     `json:"pollId"`
+	VoterId   int     `json:"voterId"`
+	VoteValue int     `json:"voteValue"` // chosen option
+	Ballot    *Ballot `json:"ballot,omitempty"`
+	// revision of the poll the vote was cast against, see revision.go
+	PollRevision int   `json:"pollRevision,omitempty"`
+	Links        Links `json:"_links"`
+	Embedded     any   `json:"_embedded,omitempty"`
+	Meta         Meta  `json:"_meta,omitempty"`
+}
+
+type VoterPoll struct {
+	PollId  int       `json:"pollId"`
+	VoteId  int       `json:"voteId"`
+	VotedAt time.Time `json:"votedAt"`
+}
+
+type Voter struct {
+	Id         int         `json:"id"`
+	Name       string      `json:"name"`
+	Email      string      `json:"email"`
+	VoterPolls []VoterPoll `json:"voterPolls"`
+	Links      Links       `json:"_links"`
+	Embedded   any         `json:"_embedded,omitempty"`
+	Meta       Meta        `json:"_meta,omitempty"`
+}
+
+type PollOption struct {
+	Id   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+type Results struct {
+	OptionId int `json:"optionId"`
+	Votes    int `json:"votes"`
+}
+
+type Poll struct {
+	Id       int          `json:"id"`
+	Title    string       `json:"title"`
+	Question string       `json:"question"`
+	Options  []PollOption `json:"options"`
+	Results  []Results    `json:"results"`
+
+	// single choice if not set, see ballot.go
+	Ballot *BallotRules `json:"ballot,omitempty"`
+	// plurality if not set, see decision.go
+	Decision *DecisionRules `json:"decision,omitempty"`
+
+	// lifecycle, see lifecycle.go
+	State    string           `json:"state,omitempty"`
+	OpensAt  *time.Time       `json:"opensAt,omitempty"`
+	ClosesAt *time.Time       `json:"closesAt,omitempty"`
+	Snapshot *ResultsSnapshot `json:"snapshot,omitempty"`
+
+	// revisions, see revision.go. ResultsSince is the revision the results
+	// were last reset at, votes cast against earlier ones are not counted.
+	Revision     int    `json:"revision,omitempty"`
+	ResultsSince int    `json:"resultsSince,omitempty"`
+	EditPolicy   string `json:"editPolicy,omitempty"`
+
+	Links    Links `json:"_links"`
+	Embedded any   `json:"_embedded,omitempty"`
+	Meta     Meta  `json:"_meta,omitempty"`
+}
+
+// VoteEmbedded is what a vote carries in _embedded, either part may be left out
+type VoteEmbedded struct {
+	Voter *Voter `json:"Voter,omitempty"`
+	Poll  *Poll  `json:"Poll,omitempty"`
+}
+
+type Link struct {
+	Href string `json:"href"`
+}
+
+type Links struct {
+	Self    Link  `json:"self"`
+	First   *Link `json:"first,omitempty"`
+	Next    *Link `json:"next,omitempty"`
+	Prev    *Link `json:"prev,omitempty"`
+	Poll    Link  `json:"poll,omitempty"`
+	Vote    Link  `json:"vote,omitempty"`
+	Votes   Link  `json:"votes,omitempty"`
+	Voter   Link  `json:"voter,omitempty"`
+	Voters  Link  `json:"voters,omitempty"`
+	Polls   Link  `json:"polls,omitempty"`
+	Results Link  `json:"results,omitempty"`
+	// Revisions is only set on polls and their revisions
+	Revisions *Link `json:"revisions,omitempty"`
+	// Ledger is only set on polls and their ledgers, see ledger.go
+	Ledger *Link `json:"ledger,omitempty"`
+	Verify *Link `json:"verify,omitempty"`
+	// only set on polls and voters, see analytics.go
+	Analytics *Link `json:"analytics,omitempty"`
+	// only set on webhooks and their deliveries
+	Webhook    *Link `json:"webhook,omitempty"`
+	Deliveries *Link `json:"deliveries,omitempty"`
+	Redeliver  *Link `json:"redeliver,omitempty"`
+}
+
+type Meta struct {
+	Total      *int      `json:"total,omitempty"` // number of items in a list, across all pages
+	TotalPolls int       `json:"TotalPolls,omitempty"`
+	TotalVotes int       `json:"TotalVotes,omitempty"`
+	CreatedAt  time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt  time.Time `json:"UpdatedAt,omitempty"`
+}
+
+// List is a page of a list response, the items are in Embedded under the
+// name of the collection (polls, voters or votes)
+type List struct {
+	Embedded map[string]any `json:"_embedded"`
+	Links    Links          `json:"_links"`
+	Meta     Meta           `json:"_meta"`
+}
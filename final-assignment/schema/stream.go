@@ -0,0 +1,25 @@
+package schema
+
+// ResultsUpdate is sent by the results streams of the poll api every time
+// the results or the state of a poll change. Id grows with every change of
+// the poll, a client that reconnects sends the last one it saw.
+type ResultsUpdate struct {
+	Id         int64     `json:"id"`
+	PollId     int       `json:"pollId"`
+	Revision   int       `json:"revision"`
+	State      string    `json:"state"`
+	Results    []Results `json:"results"`
+	TotalVotes int       `json:"totalVotes"`
+}
+
+// NewResultsUpdate is the update with the id for the poll as it is now
+func NewResultsUpdate(id int64, p *Poll) ResultsUpdate {
+	return ResultsUpdate{
+		Id:         id,
+		PollId:     p.Id,
+		Revision:   p.CurrentRevision(),
+		State:      p.CurrentState(),
+		Results:    p.Results,
+		TotalVotes: p.CountVotes(),
+	}
+}
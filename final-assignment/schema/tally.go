@@ -0,0 +1,314 @@
+package schema
+
+import (
+	"fmt"
+)
+
+// A Tallier turns the ballots of a poll into an outcome. The poll names its
+// tallier in BallotRules.Tally, and other talliers can be added with
+// RegisterTallier. Ballots and outcomes refer to options by their id.
+type Tallier interface {
+	// Accepts reports whether the tallier can count ballots of the type
+	Accepts(ballotType string) bool
+	// Tally counts the ballots
+	Tally(options []PollOption, ballots []Ballot) Outcome
+}
+
+// the talliers that come with the schema
+const (
+	TallyPlurality     = "plurality"
+	TallyInstantRunoff = "irv"
+	TallyBorda         = "borda"
+	TallyApproval      = "approval"
+	TallyScore         = "score"
+)
+
+var talliers = map[string]Tallier{
+	TallyPlurality:     plurality{},
+	TallyInstantRunoff: instantRunoff{},
+	TallyBorda:         borda{},
+	TallyApproval:      approval{},
+	TallyScore:         score{},
+}
+
+// RegisterTallier adds a tallier under name, replacing one with the same
+// name. Call it from an init func, the talliers are not locked.
+func RegisterTallier(name string, t Tallier) {
+	talliers[name] = t
+}
+
+// TallierFor returns the tallier registered under name
+func TallierFor(name string) (Tallier, bool) {
+	t, ok := talliers[name]
+	return t, ok
+}
+
+// OptionTotal is the count or the points of one option
+type OptionTotal struct {
+	OptionId int `json:"optionId"`
+	Total    int `json:"total"`
+}
+
+// Round is one round of an instant runoff count
+type Round struct {
+	Round  int           `json:"round"`
+	Totals []OptionTotal `json:"totals"`
+	// options dropped after this round
+	Eliminated []int `json:"eliminated,omitempty"`
+	// ballots without any option left in the count
+	Exhausted int `json:"exhausted"`
+}
+
+// Outcome is the result of a count. There is more than one winner when
+// options tie, and none when there were no ballots.
+type Outcome struct {
+	Method  string        `json:"method"`
+	Ballots int           `json:"ballots"`
+	Totals  []OptionTotal `json:"totals"`
+	Winners []int         `json:"winners"`
+	Rounds  []Round       `json:"rounds,omitempty"`
+	// set by Poll.Decide
+	Decision *Decision `json:"decision,omitempty"`
+}
+
+// CheckTally reports whether the tallier called method can count the
+// ballots of the poll. An empty method is the poll's own.
+func (p *Poll) CheckTally(method string) error {
+	_, _, err := p.tallier(method)
+	return err
+}
+
+func (p *Poll) tallier(method string) (string, Tallier, error) {
+	rules := p.Rules()
+	if method == "" {
+		method = rules.Tally
+	}
+
+	tallier, ok := TallierFor(method)
+	if !ok {
+		return method, nil, fmt.Errorf("%w, unknown tally %q", ErrInvalidBallot, method)
+	}
+	if !tallier.Accepts(rules.Type) {
+		return method, nil, fmt.Errorf("%w, %s cannot count %s ballots", ErrInvalidBallot, method, rules.Type)
+	}
+	return method, tallier, nil
+}
+
+// Tally counts the ballots with the tallier called method, or with the
+// poll's own when method is empty
+func (p *Poll) Tally(method string, ballots []Ballot) (Outcome, error) {
+	method, tallier, err := p.tallier(method)
+	if err != nil {
+		return Outcome{}, err
+	}
+
+	outcome := tallier.Tally(p.Options, ballots)
+	outcome.Method = method
+	outcome.Ballots = len(ballots)
+	return outcome, nil
+}
+
+// BallotsFromResults rebuilds the ballots of a single choice poll from its
+// counts, so it can be tallied without reading every vote
+func (p *Poll) BallotsFromResults() []Ballot {
+	var ballots []Ballot
+	for _, result := range p.Results {
+		for n := 0; n < result.Votes; n++ {
+			ballots = append(ballots, Ballot{Choices: []int{result.OptionId}})
+		}
+	}
+	return ballots
+}
+
+// totals pairs the options with their counts, leaving out the options that
+// are not in the count anymore when active is given
+func totals(options []PollOption, counts map[int]int, active map[int]bool) []OptionTotal {
+	result := make([]OptionTotal, 0, len(options))
+	for _, option := range options {
+		if active != nil && !active[option.Id] {
+			continue
+		}
+		result = append(result, OptionTotal{OptionId: option.Id, Total: counts[option.Id]})
+	}
+	return result
+}
+
+// leaders are the option ids with the highest non zero total
+func leaders(totals []OptionTotal) []int {
+	best := 0
+	for _, t := range totals {
+		if t.Total > best {
+			best = t.Total
+		}
+	}
+
+	winners := []int{}
+	if best == 0 {
+		return winners
+	}
+	for _, t := range totals {
+		if t.Total == best {
+			winners = append(winners, t.OptionId)
+		}
+	}
+	return winners
+}
+
+// countPoints adds up the points each ballot gives the options. Points for
+// an option the poll does not have (anymore) are dropped.
+func countPoints(options []PollOption, ballots []Ballot, points func(b Ballot, add func(option int, n int))) Outcome {
+	counts := make(map[int]int, len(options))
+	for _, option := range options {
+		counts[option.Id] = 0
+	}
+	for _, b := range ballots {
+		points(b, func(option int, n int) {
+			if _, ok := counts[option]; ok {
+				counts[option] += n
+			}
+		})
+	}
+
+	t := totals(options, counts, nil)
+	return Outcome{Totals: t, Winners: leaders(t)}
+}
+
+// plurality counts the first choice of every ballot
+type plurality struct{}
+
+func (plurality) Accepts(ballotType string) bool {
+	return ballotType == BallotSingle || ballotType == BallotRanked
+}
+
+func (plurality) Tally(options []PollOption, ballots []Ballot) Outcome {
+	return countPoints(options, ballots, func(b Ballot, add func(int, int)) {
+		if len(b.Choices) > 0 {
+			add(b.Choices[0], 1)
+		}
+	})
+}
+
+// approval counts every option a ballot picks
+type approval struct{}
+
+func (approval) Accepts(ballotType string) bool {
+	return ballotType == BallotSingle || ballotType == BallotMulti || ballotType == BallotApproval
+}
+
+func (approval) Tally(options []PollOption, ballots []Ballot) Outcome {
+	return countPoints(options, ballots, func(b Ballot, add func(int, int)) {
+		for _, choice := range b.Choices {
+			add(choice, 1)
+		}
+	})
+}
+
+// borda gives an option n-1 points for a first place out of n options, n-2
+// for a second place and so on. Options a ballot does not rank get nothing.
+type borda struct{}
+
+func (borda) Accepts(ballotType string) bool {
+	return ballotType == BallotRanked
+}
+
+func (borda) Tally(options []PollOption, ballots []Ballot) Outcome {
+	n := len(options)
+	return countPoints(options, ballots, func(b Ballot, add func(int, int)) {
+		for rank, choice := range b.Choices {
+			if rank < n {
+				add(choice, n-1-rank)
+			}
+		}
+	})
+}
+
+// score adds up the scores of every option
+type score struct{}
+
+func (score) Accepts(ballotType string) bool {
+	return ballotType == BallotScore
+}
+
+func (score) Tally(options []PollOption, ballots []Ballot) Outcome {
+	return countPoints(options, ballots, func(b Ballot, add func(int, int)) {
+		for option, s := range b.Scores {
+			add(option, s)
+		}
+	})
+}
+
+// instantRunoff counts the highest ranked option still in the count on
+// every ballot. An option with more than half of those ballots wins,
+// otherwise the option with the fewest is dropped and the ballots are
+// counted again. Options that tie for the fewest are dropped together, and
+// if all options left tie, they all win.
+type instantRunoff struct{}
+
+func (instantRunoff) Accepts(ballotType string) bool {
+	return ballotType == BallotRanked
+}
+
+func (instantRunoff) Tally(options []PollOption, ballots []Ballot) Outcome {
+	active := make(map[int]bool, len(options))
+	for _, option := range options {
+		active[option.Id] = true
+	}
+	left := len(options)
+
+	outcome := Outcome{Winners: []int{}}
+	for round := 1; left > 0; round++ {
+		counts := make(map[int]int, left)
+		exhausted := 0
+		for _, b := range ballots {
+			top, found := 0, false
+			for _, choice := range b.Choices {
+				if active[choice] {
+					top, found = choice, true
+					break
+				}
+			}
+			if !found {
+				exhausted++
+				continue
+			}
+			counts[top]++
+		}
+
+		r := Round{Round: round, Totals: totals(options, counts, active), Exhausted: exhausted}
+		outcome.Totals = r.Totals
+		continuing := len(ballots) - exhausted
+
+		if continuing == 0 {
+			outcome.Rounds = append(outcome.Rounds, r)
+			break
+		}
+
+		fewest, most := continuing, 0
+		for _, t := range r.Totals {
+			if t.Total < fewest {
+				fewest = t.Total
+			}
+			if t.Total > most {
+				most = t.Total
+			}
+		}
+
+		if most*2 > continuing || fewest == most {
+			// a majority, or everyone left is tied
+			outcome.Winners = leaders(r.Totals)
+			outcome.Rounds = append(outcome.Rounds, r)
+			break
+		}
+
+		for _, t := range r.Totals {
+			if t.Total == fewest {
+				active[t.OptionId] = false
+				left--
+				r.Eliminated = append(r.Eliminated, t.OptionId)
+			}
+		}
+		outcome.Rounds = append(outcome.Rounds, r)
+	}
+
+	return outcome
+}
@@ -0,0 +1,240 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+// options makes the options of a test poll, in the order given
+func options(ids ...int) []PollOption {
+	result := make([]PollOption, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, PollOption{Id: id})
+	}
+	return result
+}
+
+// ranked makes n ballots with the same choices
+func ranked(n int, choices ...int) []Ballot {
+	ballots := make([]Ballot, 0, n)
+	for i := 0; i < n; i++ {
+		ballots = append(ballots, Ballot{Choices: choices})
+	}
+	return ballots
+}
+
+func join(ballots ...[]Ballot) []Ballot {
+	var result []Ballot
+	for _, b := range ballots {
+		result = append(result, b...)
+	}
+	return result
+}
+
+func TestTally(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		options []PollOption
+		ballots []Ballot
+		totals  []OptionTotal
+		winners []int
+		rounds  []Round
+	}{
+		{
+			name:    "plurality counts first choices",
+			method:  TallyPlurality,
+			options: options(1, 2, 3),
+			ballots: join(ranked(2, 1, 2), ranked(1, 2, 1), ranked(1, 9)),
+			totals:  []OptionTotal{{1, 2}, {2, 1}, {3, 0}},
+			winners: []int{1},
+		},
+		{
+			name:    "plurality tie",
+			method:  TallyPlurality,
+			options: options(1, 2),
+			ballots: join(ranked(2, 1), ranked(2, 2)),
+			totals:  []OptionTotal{{1, 2}, {2, 2}},
+			winners: []int{1, 2},
+		},
+		{
+			name:    "plurality without ballots",
+			method:  TallyPlurality,
+			options: options(1, 2),
+			totals:  []OptionTotal{{1, 0}, {2, 0}},
+			winners: []int{},
+		},
+		{
+			name:    "approval counts every choice",
+			method:  TallyApproval,
+			options: options(1, 2, 3),
+			ballots: join(ranked(2, 1, 2), ranked(1, 2, 3)),
+			totals:  []OptionTotal{{1, 2}, {2, 3}, {3, 1}},
+			winners: []int{2},
+		},
+		{
+			name:    "borda points by rank",
+			method:  TallyBorda,
+			options: options(1, 2, 3),
+			// 1: 2+1+1, 2: 1+2+2, 3: 0+0+1, the points for 9 are dropped
+			ballots: join(ranked(1, 1, 2, 3), ranked(1, 2, 1), ranked(1, 2, 3), ranked(1, 9, 1)),
+			totals:  []OptionTotal{{1, 4}, {2, 5}, {3, 1}},
+			winners: []int{2},
+		},
+		{
+			name:    "borda tie",
+			method:  TallyBorda,
+			options: options(1, 2),
+			ballots: join(ranked(1, 1, 2), ranked(1, 2, 1)),
+			totals:  []OptionTotal{{1, 1}, {2, 1}},
+			winners: []int{1, 2},
+		},
+		{
+			name:    "score adds up the scores",
+			method:  TallyScore,
+			options: options(1, 2, 3),
+			ballots: []Ballot{
+				{Scores: map[int]int{1: 5, 2: 3}},
+				{Scores: map[int]int{2: 4, 3: 1}},
+				{Scores: map[int]int{1: 0, 9: 5}},
+			},
+			totals:  []OptionTotal{{1, 5}, {2, 7}, {3, 1}},
+			winners: []int{2},
+		},
+		{
+			name:    "score of zero everywhere has no winner",
+			method:  TallyScore,
+			options: options(1, 2),
+			ballots: []Ballot{{Scores: map[int]int{1: 0}}, {}},
+			totals:  []OptionTotal{{1, 0}, {2, 0}},
+			winners: []int{},
+		},
+		{
+			name:    "irv majority in the first round",
+			method:  TallyInstantRunoff,
+			options: options(1, 2, 3),
+			ballots: join(ranked(2, 1, 2), ranked(1, 2)),
+			totals:  []OptionTotal{{1, 2}, {2, 1}, {3, 0}},
+			winners: []int{1},
+			rounds: []Round{
+				{Round: 1, Totals: []OptionTotal{{1, 2}, {2, 1}, {3, 0}}},
+			},
+		},
+		{
+			name:    "irv transfers the ballots of the eliminated option",
+			method:  TallyInstantRunoff,
+			options: options(1, 2, 3),
+			ballots: join(ranked(2, 1, 2), ranked(2, 2), ranked(1, 3, 1)),
+			totals:  []OptionTotal{{1, 3}, {2, 2}},
+			winners: []int{1},
+			rounds: []Round{
+				{Round: 1, Totals: []OptionTotal{{1, 2}, {2, 2}, {3, 1}}, Eliminated: []int{3}},
+				{Round: 2, Totals: []OptionTotal{{1, 3}, {2, 2}}},
+			},
+		},
+		{
+			name:    "irv exhausted ballots leave a tie",
+			method:  TallyInstantRunoff,
+			options: options(1, 2, 3),
+			ballots: join(ranked(2, 1, 2), ranked(2, 2), ranked(1, 3)),
+			totals:  []OptionTotal{{1, 2}, {2, 2}},
+			winners: []int{1, 2},
+			rounds: []Round{
+				{Round: 1, Totals: []OptionTotal{{1, 2}, {2, 2}, {3, 1}}, Eliminated: []int{3}},
+				{Round: 2, Totals: []OptionTotal{{1, 2}, {2, 2}}, Exhausted: 1},
+			},
+		},
+		{
+			name:    "irv drops the options tied for the fewest together",
+			method:  TallyInstantRunoff,
+			options: options(1, 2, 3, 4),
+			ballots: join(ranked(3, 1), ranked(2, 2), ranked(1, 3, 2), ranked(1, 4, 2)),
+			totals:  []OptionTotal{{1, 3}, {2, 4}},
+			winners: []int{2},
+			rounds: []Round{
+				{Round: 1, Totals: []OptionTotal{{1, 3}, {2, 2}, {3, 1}, {4, 1}}, Eliminated: []int{3, 4}},
+				{Round: 2, Totals: []OptionTotal{{1, 3}, {2, 4}}},
+			},
+		},
+		{
+			name:    "irv with every ballot exhausted",
+			method:  TallyInstantRunoff,
+			options: options(1, 2),
+			ballots: ranked(2, 9),
+			totals:  []OptionTotal{{1, 0}, {2, 0}},
+			winners: []int{},
+			rounds: []Round{
+				{Round: 1, Totals: []OptionTotal{{1, 0}, {2, 0}}, Exhausted: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tallier, ok := TallierFor(tt.method)
+			if !ok {
+				t.Fatalf("no tallier %q", tt.method)
+			}
+			got := tallier.Tally(tt.options, tt.ballots)
+			if !reflect.DeepEqual(got.Totals, tt.totals) {
+				t.Errorf("totals = %v, want %v", got.Totals, tt.totals)
+			}
+			if !reflect.DeepEqual(got.Winners, tt.winners) {
+				t.Errorf("winners = %v, want %v", got.Winners, tt.winners)
+			}
+			if !reflect.DeepEqual(got.Rounds, tt.rounds) {
+				t.Errorf("rounds = %+v, want %+v", got.Rounds, tt.rounds)
+			}
+		})
+	}
+}
+
+func TestPollTally(t *testing.T) {
+	tests := []struct {
+		name    string
+		ballot  *BallotRules
+		method  string
+		want    string
+		wantErr bool
+	}{
+		{"single choice by default", nil, "", TallyPlurality, false},
+		{"ranked by default", &BallotRules{Type: BallotRanked}, "", TallyInstantRunoff, false},
+		{"ranked with ?tally=", &BallotRules{Type: BallotRanked}, TallyBorda, TallyBorda, false},
+		{"the poll's own tally", &BallotRules{Type: BallotRanked, Tally: TallyBorda}, "", TallyBorda, false},
+		{"single choice cannot be counted by points", nil, TallyBorda, "", true},
+		{"score cannot be counted by irv", &BallotRules{Type: BallotScore}, TallyInstantRunoff, "", true},
+		{"unknown tally", nil, "condorcet", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Poll{Options: options(1, 2), Ballot: tt.ballot}
+			ballots := ranked(3, 1, 2)
+			got, err := p.Tally(tt.method, ballots)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Tally(%q) error = %v, want error %v", tt.method, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Method != tt.want || got.Ballots != len(ballots) {
+				t.Errorf("Tally(%q) counted %d ballots by %q, want %d by %q", tt.method, got.Ballots, got.Method, len(ballots), tt.want)
+			}
+		})
+	}
+}
+
+func TestBallotsFromResults(t *testing.T) {
+	p := Poll{
+		Options: options(1, 2, 3),
+		Results: []Results{{OptionId: 1, Votes: 2}, {OptionId: 2, Votes: 0}, {OptionId: 3, Votes: 1}},
+	}
+	outcome, err := p.Tally("", p.BallotsFromResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OptionTotal{{1, 2}, {2, 0}, {3, 1}}
+	if outcome.Ballots != 3 || !reflect.DeepEqual(outcome.Totals, want) {
+		t.Errorf("tally of the results is %d ballots with %v, want 3 with %v", outcome.Ballots, outcome.Totals, want)
+	}
+}
@@ -0,0 +1,76 @@
+package schema
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Webhook is a subscription to the events of the apis. Each event whose
+// type passes one of the filters in Events is posted to URL, signed with
+// Secret. The secret is only returned when the webhook is created, or when
+// a PUT sets a new one.
+type Webhook struct {
+	Id          int      `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret,omitempty"`
+	Description string   `json:"description,omitempty"`
+	// a paused webhook gets no new deliveries
+	Paused bool  `json:"paused"`
+	Meta   Meta  `json:"_meta"`
+	Links  Links `json:"_links"`
+}
+
+// the states of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is the log of sending one event to one webhook
+type WebhookDelivery struct {
+	Id        string `json:"id"`
+	WebhookId int    `json:"webhookId"`
+	EventId   string `json:"eventId"`
+	EventType string `json:"eventType"`
+	Status    string `json:"status"`
+	// attempts since the delivery was queued or last redelivered, the
+	// backoff and the attempt limit count these
+	Tries         int              `json:"tries"`
+	Attempts      []WebhookAttempt `json:"attempts"`
+	NextAttemptAt *time.Time       `json:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	// the body that is posted, the event as JSON
+	Payload json.RawMessage `json:"payload"`
+	Links   Links           `json:"_links"`
+}
+
+// WebhookAttempt is one request of a delivery
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	// the first attempt after a manual redelivery
+	Redelivery bool `json:"redelivery,omitempty"`
+}
+
+// SetWebhookLinks sets the HAL links of a webhook, webhooks is the url of
+// the collection, e.g. http://localhost:1082/webhooks
+func SetWebhookLinks(webhooks string, webhook *Webhook) {
+	self := webhooks + "/" + strconv.Itoa(webhook.Id)
+	webhook.Links = Links{}
+	webhook.Links.Self.Href = self
+	webhook.Links.Deliveries = &Link{Href: self + "/deliveries"}
+}
+
+// SetDeliveryLinks sets the HAL links of a webhook delivery
+func SetDeliveryLinks(webhooks string, delivery *WebhookDelivery) {
+	webhook := webhooks + "/" + strconv.Itoa(delivery.WebhookId)
+	delivery.Links = Links{}
+	delivery.Links.Self.Href = webhook + "/deliveries/" + delivery.Id
+	delivery.Links.Webhook = &Link{Href: webhook}
+	delivery.Links.Redeliver = &Link{Href: delivery.Links.Self.Href + "/redeliver"}
+}
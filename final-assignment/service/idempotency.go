@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// POST routes that create something accept an Idempotency-Key header. The
+// first request with a key runs as usual and its response is kept under
+// <prefix>idempotency:<key>. A retry with the same key gets that response
+// back, marked with Idempotent-Replayed, instead of creating a second item.
+// Only successful responses are kept, after an error the key can be used
+// again.
+const (
+	IdempotencyHeader    = "Idempotency-Key"
+	idempotencyKeyPrefix = "idempotency:"
+	MaxIdempotencyKeyLen = 255
+
+	// IdempotencyTTL is how long a response is kept for retries
+	IdempotencyTTL = 24 * time.Hour
+	// a request still running after this is assumed to have crashed
+	idempotencyPendingTTL = time.Minute
+)
+
+// idempotentRecord is what is stored under the key
+type idempotentRecord struct {
+	// hash of the request body, a key cannot be reused for another request
+	RequestHash string          `json:"requestHash"`
+	Done        bool            `json:"done"`
+	Status      int             `json:"status,omitempty"`
+	Location    string          `json:"location,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// captureWriter keeps a copy of the response body
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotent wraps a POST handler with Idempotency-Key support. Keys are
+// stored with prefix, the redis key prefix of the service.
+func Idempotent(ctx context.Context, client *redis.Client, prefix string, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			next(c)
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+		record := idempotentRecord{RequestHash: hex.EncodeToString(hash[:])}
+
+		recordKey := prefix + idempotencyKeyPrefix + key
+		pending, err := json.Marshal(record)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		claimed, err := client.SetNX(ctx, recordKey, pending, idempotencyPendingTTL).Result()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read Idempotency-Key\n" + err.Error()})
+			return
+		}
+		if !claimed {
+			replay(ctx, client, c, recordKey, record.RequestHash)
+			return
+		}
+
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		next(c)
+
+		status := writer.Status()
+		if status < 200 || status >= 300 {
+			client.Del(ctx, recordKey)
+			return
+		}
+
+		record.Done = true
+		record.Status = status
+		record.Location = writer.Header().Get("Location")
+		record.Body = writer.body.Bytes()
+		done, err := json.Marshal(record)
+		if err == nil {
+			client.Set(ctx, recordKey, done, IdempotencyTTL)
+		}
+	}
+}
+
+// replay answers a request whose Idempotency-Key was used before
+func replay(ctx context.Context, client *redis.Client, c *gin.Context, recordKey string, requestHash string) {
+	raw, err := client.Get(ctx, recordKey).Bytes()
+	if err == redis.Nil {
+		// the first request failed in the meantime, let the client retry
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read Idempotency-Key\n" + err.Error()})
+		return
+	}
+
+	var record idempotentRecord
+	err = json.Unmarshal(raw, &record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read Idempotency-Key\n" + err.Error()})
+		return
+	}
+
+	if record.RequestHash != requestHash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if !record.Done {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+		return
+	}
+
+	if record.Location != "" {
+		c.Header("Location", record.Location)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.Status, "application/json; charset=utf-8", record.Body)
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// POST to a collection, without an id in the url, gets its id from the
+// counter <prefix>next-id. Ids that clients already picked themselves with
+// POST /<collection>/:id are skipped. The counter key does not end in a
+// number, so ScanIds skips it.
+const nextIdKey = "next-id"
+
+// a create is tried again this often when a watched key changes under it
+const maxCreateRetries = 50
+
+// ErrExists is returned by Create when the key of a new item is taken
+var ErrExists = errors.New("already exists")
+
+// NextId allocates a new id for a key named <prefix><id>. An id picked by a
+// client between NextId and the write of the item is not seen, the write
+// should claim its key with Create.
+func NextId(ctx context.Context, client *redis.Client, prefix string) (int, error) {
+	for {
+		id, err := client.Incr(ctx, prefix+nextIdKey).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		taken, err := client.Exists(ctx, prefix+strconv.FormatInt(id, 10)).Result()
+		if err != nil {
+			return 0, err
+		}
+		if taken == 0 {
+			return int(id), nil
+		}
+	}
+}
+
+// Create writes new items in one transaction, one per key. The keys are
+// watched and checked first, write is called with the ones that are taken
+// and adds the commands that store the others to pipe. It should set each
+// key with JSON.SET NX. If write returns an error nothing is written.
+// Create returns which keys were taken.
+func Create(ctx context.Context, client *redis.Client, keys []string, write func(pipe redis.Pipeliner, taken []bool) error) ([]bool, error) {
+	var taken []bool
+	txf := func(tx *redis.Tx) error {
+		taken = make([]bool, len(keys))
+		exists := make([]*redis.IntCmd, len(keys))
+		_, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				exists[i] = pipe.Exists(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, cmd := range exists {
+			taken[i] = cmd.Val() > 0
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return write(pipe, taken)
+		})
+		if err == redis.Nil {
+			// a JSON.SET NX did not set its key
+			return ErrExists
+		}
+		return err
+	}
+
+	for i := 0; i < maxCreateRetries; i++ {
+		err := client.Watch(ctx, txf, keys...)
+		if err != redis.TxFailedErr {
+			return taken, err
+		}
+	}
+	return taken, redis.TxFailedErr
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// testRedis connects to the redis at REDIS_URL and empties database 15 for
+// the test. Without one the test is skipped.
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+	ctx := context.Background()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		t.Skipf("redis at %s not reachable: %s", addr, err.Error())
+	}
+	err = client.FlushDB(ctx).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB(ctx)
+		client.Close()
+	})
+	return client
+}
+
+func TestCreateClaimsOnce(t *testing.T) {
+	client := testRedis(t)
+	ctx := context.Background()
+
+	// every create of items:1 writes an event, only one of them may
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = Create(ctx, client, []string{"items:1"}, func(pipe redis.Pipeliner, taken []bool) error {
+				if taken[0] {
+					return ErrExists
+				}
+				pipe.SetNX(ctx, "items:1", i, 0)
+				pipe.RPush(ctx, "events", i)
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrExists):
+			t.Fatal(err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("items:1 was created %d times, want once", created)
+	}
+	if n, _ := client.LLen(ctx, "events").Result(); n != 1 {
+		t.Errorf("%d events were written, want 1", n)
+	}
+
+	// the taken keys are reported, the free ones are written
+	taken, err := Create(ctx, client, []string{"items:1", "items:2"}, func(pipe redis.Pipeliner, taken []bool) error {
+		if !taken[1] {
+			pipe.SetNX(ctx, "items:2", 2, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(taken, []bool{true, false}) {
+		t.Errorf("taken is %v, want [true false]", taken)
+	}
+	if n, _ := client.Exists(ctx, "items:2").Result(); n != 1 {
+		t.Errorf("items:2 was not written")
+	}
+}
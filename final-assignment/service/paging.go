@@ -0,0 +1,215 @@
+// Package service has the parts the voter, poll and votes apis share that
+// are about serving requests rather than the domain: paging of list routes,
+// the ?embed= and ?fields= query parameters, server-assigned ids and
+// Idempotency-Key support. The domain types are in drexel.edu/schema.
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// List routes are paginated with ?limit=&cursor=. The cursor is the id of
+// the last item of the previous page, so pages stay stable while items are
+// added or removed. The ids of a collection are kept in a sorted set scored
+// by the id, the index, which is changed in the transaction that creates or
+// deletes an item. A page is read from the index with ZRANGEBYSCORE from the
+// cursor, so it costs the same however many items there are.
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+
+	// ScanCount is the number of keys redis looks at per SCAN call
+	ScanCount = 100
+)
+
+var ErrInvalidPage = errors.New("limit must be 1-500 and cursor must be an id")
+
+// ParsePageRequest reads ?limit=&cursor=
+func ParsePageRequest(c *gin.Context) (schema.PageRequest, error) {
+	req := schema.PageRequest{Limit: DefaultPageLimit}
+
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > MaxPageLimit {
+			return req, ErrInvalidPage
+		}
+		req.Limit = l
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.Atoi(cursor)
+		if err != nil {
+			return req, ErrInvalidPage
+		}
+		req.Cursor = id
+		req.HasCursor = true
+	}
+
+	return req, nil
+}
+
+// Index adds the id to the index key, in the transaction of pipe
+func Index(ctx context.Context, pipe redis.Cmdable, key string, id int) {
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(id), Member: id})
+}
+
+// Unindex removes the id from the index key, in the transaction of pipe
+func Unindex(ctx context.Context, pipe redis.Cmdable, key string, id int) {
+	pipe.ZRem(ctx, key, id)
+}
+
+// PageIds reads the page req asks for from the index key. The ids before
+// the cursor are only read as far as the cursor of the previous page.
+func PageIds(ctx context.Context, client *redis.Client, key string, req schema.PageRequest) (schema.Page, error) {
+	from := "-inf"
+	if req.HasCursor {
+		from = "(" + strconv.Itoa(req.Cursor)
+	}
+	count := int64(req.Limit + 1)
+
+	var after, before *redis.StringSliceCmd
+	var total *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		after = pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: from, Max: "+inf", Count: count})
+		if req.HasCursor {
+			before = pipe.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: strconv.Itoa(req.Cursor), Count: count})
+		}
+		total = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return schema.Page{}, err
+	}
+
+	ids, err := atois(after.Val())
+	if err != nil {
+		return schema.Page{}, err
+	}
+	p := schema.Page{Ids: ids, Total: int(total.Val())}
+	if len(ids) > req.Limit {
+		p.Ids = ids[:req.Limit]
+		p.HasNext = true
+		p.Next = ids[req.Limit-1]
+	}
+
+	if before != nil && len(before.Val()) > 0 {
+		p.HasPrev = true
+		if len(before.Val()) <= req.Limit {
+			p.PrevFirst = true
+		} else {
+			p.Prev, err = strconv.Atoi(before.Val()[req.Limit])
+			if err != nil {
+				return schema.Page{}, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// Ids returns all the ids in the index key, sorted
+func Ids(ctx context.Context, client *redis.Client, key string) ([]int, error) {
+	members, err := client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return atois(members)
+}
+
+// TrackIds fills the index key with the ids of the keys named <prefix><id>,
+// and removes the ids whose key is gone, for items stored before the index
+// was kept. An index kept as a plain set before is replaced. Run it before
+// the api serves requests, it reads every key.
+func TrackIds(ctx context.Context, client *redis.Client, prefix string, key string) error {
+	kind, err := client.Type(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if kind != "zset" && kind != "none" {
+		err = client.Del(ctx, key).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	ids, err := ScanIds(ctx, client, prefix)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		members := make([]*redis.Z, len(ids))
+		for i, id := range ids {
+			members[i] = &redis.Z{Score: float64(id), Member: id}
+		}
+		err = client.ZAdd(ctx, key, members...).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	indexed, err := client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	exists := make([]*redis.IntCmd, len(indexed))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range indexed {
+			exists[i] = pipe.Exists(ctx, prefix+id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var stale []any
+	for i, id := range indexed {
+		if exists[i].Val() == 0 {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return client.ZRem(ctx, key, stale...).Err()
+}
+
+// ScanIds returns the ids of all keys named <prefix><id>, sorted. Keys that
+// share the prefix but do not end in a number (indexes, counters) are
+// skipped. It reads every key, list routes read the index instead.
+func ScanIds(ctx context.Context, client *redis.Client, prefix string) ([]int, error) {
+	var ids []int
+
+	iter := client.Scan(ctx, 0, prefix+"*", ScanCount).Iterator()
+	for iter.Next(ctx) {
+		id, err := strconv.Atoi(strings.TrimPrefix(iter.Val(), prefix))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func atois(members []string) ([]int, error) {
+	ids := make([]int, len(members))
+	for i, member := range members {
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
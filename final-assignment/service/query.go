@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET routes accept ?embed= to choose which related resources are put in
+// _embedded, and ?fields= to return only some of the top level properties,
+// for example ?fields=id,_links. ?embed= takes one value or a comma list,
+// such as ?embed=voter,poll. The filtering itself is done by
+// schema.SelectFields.
+const (
+	EmbedNone  = "none"
+	EmbedVoter = "voter"
+	EmbedPoll  = "poll"
+	EmbedAll   = "all"
+)
+
+var ErrInvalidEmbed = errors.New("embed must be none, voter, poll or all, or a comma list of them")
+
+// Embed says which related resources to embed
+type Embed struct {
+	Voter bool
+	Poll  bool
+}
+
+func (e Embed) None() bool {
+	return !e.Voter && !e.Poll
+}
+
+// ParseEmbed reads ?embed=, using def when it is not given
+func ParseEmbed(c *gin.Context, def string) (Embed, error) {
+	var embed Embed
+	given := false
+	for _, name := range strings.Split(c.DefaultQuery("embed", def), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case EmbedNone:
+		case EmbedVoter:
+			embed.Voter = true
+		case EmbedPoll:
+			embed.Poll = true
+		case EmbedAll:
+			embed = Embed{Voter: true, Poll: true}
+		default:
+			return Embed{}, ErrInvalidEmbed
+		}
+		given = true
+	}
+	if !given {
+		return Embed{}, ErrInvalidEmbed
+	}
+	return embed, nil
+}
+
+// ParseFields reads ?fields= as a list of property names, nil means all
+func ParseFields(c *gin.Context) []string {
+	fields := c.Query("fields")
+	if fields == "" {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(fields, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
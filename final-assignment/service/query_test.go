@@ -0,0 +1,67 @@
+package service
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func queryContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/votes?"+query, nil)
+	return c
+}
+
+func TestParseEmbed(t *testing.T) {
+	tests := []struct {
+		query   string
+		def     string
+		want    Embed
+		wantErr bool
+	}{
+		{"", EmbedAll, Embed{Voter: true, Poll: true}, false},
+		{"", EmbedNone, Embed{}, false},
+		{"embed=none", EmbedAll, Embed{}, false},
+		{"embed=voter", EmbedAll, Embed{Voter: true}, false},
+		{"embed=poll", EmbedNone, Embed{Poll: true}, false},
+		{"embed=all", EmbedNone, Embed{Voter: true, Poll: true}, false},
+		{"embed=voter,poll", EmbedNone, Embed{Voter: true, Poll: true}, false},
+		{"embed=poll,+voter", EmbedNone, Embed{Voter: true, Poll: true}, false},
+		{"embed=voter,", EmbedNone, Embed{Voter: true}, false},
+		{"embed=", EmbedAll, Embed{}, true},
+		{"embed=votes", EmbedAll, Embed{}, true},
+		{"embed=voter,polls", EmbedAll, Embed{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEmbed(queryContext(tt.query), tt.def)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEmbed(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseEmbed(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"fields=", nil},
+		{"fields=id", []string{"id"}},
+		{"fields=id,+_links,,voteValue", []string{"id", "_links", "voteValue"}},
+	}
+
+	for _, tt := range tests {
+		got := ParseFields(queryContext(tt.query))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFields(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,26 @@
 import requests
 import json
-from datetime import datetime 
+from datetime import datetime, timedelta, timezone
+import time
 from jsonTypes import *
 import random 
+from concurrent.futures import ThreadPoolExecutor
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+import hashlib
+import hmac
+import os
+import queue
+import threading
+import csv
+import io
+import zipfile
 # Requester
-def request(url, method, data=None):
+def request(url, method, data=None, headers=None):
     match method:
         case "GET":
             return requests.get(url)
         case "POST":
-            return requests.post(url, json=data)
+            return requests.post(url, json=data, headers=headers)
         case "PUT":
             return requests.put(url, json=data)
         case "DELETE":
@@ -178,6 +189,9 @@ class PollTests:
         response = request(url, "PUT", poll.model_dump(mode='json'))
         if response.status_code != 200:
             raise Exception("Test 4 failed - poll not updated")
+        # the answer is the updated poll, in its second revision
+        if response.json()['title'] != poll.Title or response.json()['revision'] != 2:
+            raise Exception("Test 4 failed - old poll returned " + response.text)
         response = request(url, "GET")
         if response.status_code != 200:
             raise Exception("Test 4 failed - poll not updated")
@@ -208,6 +222,8 @@ class PollTests:
 # 3. Create a new vote with an valid voteID and invalid pollID
 # 4. Create a new vote with an valid voteID and invalid voterID
 # 5. Create a new vote with a valid voteID and then delete it
+# 6. Vote a second time on the same poll with the same voter
+# 7. Change the vote value and check the results moved
 class VoteTests:
     def __init__(self, url):
         self.url = url
@@ -243,7 +259,7 @@ class VoteTests:
             Id=1,
             PollId=1,
             VoterId=1,
-            VoteValue=1
+            VoteValue=2
         )
         url = self.url + "/" + str(vote.Id)
         response = request(url, "POST", vote.model_dump(mode='json'))
@@ -265,7 +281,7 @@ class VoteTests:
             Id=1,
             PollId=1,
             VoterId=1,
-            VoteValue=1
+            VoteValue=2
         )
         url = self.url + "/" + str(vote.Id)
         response = request(url, "POST", vote.model_dump(mode='json'))
@@ -278,7 +294,7 @@ class VoteTests:
             Id=2,
             PollId=2,
             VoterId=1,
-            VoteValue=1
+            VoteValue=2
         )
         url = self.url + "/" + str(vote.Id)
         response = request(url, "POST", vote.model_dump(mode='json'))
@@ -291,7 +307,7 @@ class VoteTests:
             Id=3,
             PollId=1,
             VoterId=2,
-            VoteValue=1
+            VoteValue=2
         )
         url = self.url + "/" + str(vote.Id)
         response = request(url, "POST", vote.model_dump(mode='json'))
@@ -299,11 +315,21 @@ class VoteTests:
             raise Exception("Test 4 failed")
     
     def test5(self):
+        # voter 1 already voted on poll 1, so use another voter
+        voter = Voter(
+            Id=5,
+            Name="Test",
+            Email=""
+        )
+        voterUrl = APIs['voters'] + "/" + str(voter.Id)
+        response = request(voterUrl, "POST", voter.model_dump(mode='json'))
+        if response.status_code != 200:
+            raise Exception("Test 5 failed - voter not created")
         vote = Votes(
             Id=5,
             PollId=1,
-            VoterId=1,
-            VoteValue=1
+            VoterId=5,
+            VoteValue=2
         )
         url = self.url + "/" + str(vote.Id)
         response = request(url, "POST", vote.model_dump(mode='json'))
@@ -315,6 +341,46 @@ class VoteTests:
         response = request(url, "GET")
         if response.status_code == 200:
             raise Exception("Test 5 failed - vote not deleted")
+        response = request(voterUrl, "DELETE")
+        if response.status_code != 200:
+            raise Exception("Test 5 failed - voter not deleted")
+
+    def test6(self):
+        # voter 1 already voted on poll 1 in test 1
+        vote = Votes(
+            Id=6,
+            PollId=1,
+            VoterId=1,
+            VoteValue=1
+        )
+        url = self.url + "/" + str(vote.Id)
+        response = request(url, "POST", vote.model_dump(mode='json'))
+        if response.status_code != 409:
+            raise Exception("Test 6 failed - second vote not rejected " + str(response.status_code) + " " + response.text)
+        response = request(url, "GET")
+        if response.status_code == 200:
+            raise Exception("Test 6 failed - second vote was saved")
+
+    def test7(self):
+        # change vote 1 from option 2 to option 1
+        url = self.url + "/1"
+        response = request(url, "PUT", {"voteValue": 1})
+        if response.status_code != 200:
+            raise Exception("Test 7 failed -" + str(response.status_code) + " " + response.text)
+        if response.json()['voteValue'] != 1:
+            raise Exception("Test 7 failed - vote value not changed " + response.text)
+        response = request(APIs['polls'] + "/1/results", "GET")
+        results = [r['votes'] for r in response.json()['results']]
+        if results != [1, 0]:
+            raise Exception("Test 7 failed - expected [1, 0] got " + str(results))
+        response = request(APIs['voters'] + "/1", "GET")
+        if len(response.json()['voterPolls']) != 1:
+            raise Exception("Test 7 failed - voter history is wrong " + response.text)
+        # an option that does not exist
+        response = request(url, "PUT", {"voteValue": 5})
+        if response.status_code != 400:
+            raise Exception("Test 7 failed - invalid vote value accepted")
+        print(json.dumps(results))
 
     def cleanup(self):
         # Since there is no recursive deletion, a vote cannot be deleted if a voter or poll is deleted
@@ -374,7 +440,7 @@ class IntegratedTests:
             Id=1,
             PollId=1,
             VoterId=1,
-            VoteValue=1
+            VoteValue=2
         )
         url = APIs['votes'] + "/" + str(vote.Id)
         response = request(url, "POST", vote.model_dump(mode='json'))
@@ -397,7 +463,7 @@ class IntegratedTests:
             Id=2,
             PollId=1,
             VoterId=2,
-            VoteValue=1
+            VoteValue=2
         )
         voter = Voter(
             Id=2,
@@ -430,14 +496,16 @@ class IntegratedTests:
         # pretty print the response
         print(json.dumps(response.json(), indent=4))
         
-        # delete the voterId=2, pollId=2, voteId=2
-        url = APIs['voters'] + "/2"
+        # delete the voterId=2, the cascade also deletes voteId=2
+        url = APIs['voters'] + "/2?cascade=true"
         response = request(url, "DELETE")
         if response.status_code != 200:
             raise Exception("Test 2 failed - voter not deleted")
+        if response.json()['cascade']['votesDeleted'] != [2]:
+            raise Exception("Test 2 failed - vote not deleted with the voter " + response.text)
         url = APIs['votes'] + "/2"
-        response = request(url, "DELETE")
-        if response.status_code != 200:
+        response = request(url, "GET")
+        if response.status_code == 200:
             raise Exception("Test 2 failed - vote not deleted")
         
     def test3(self):
@@ -454,24 +522,1378 @@ class IntegratedTests:
         # pretty print the response
         print(json.dumps(response.json(), indent=4))
 
+    def test4(self):
+        # only the id and links, nothing embedded
+        url = APIs['votes'] + "/1?embed=none&fields=id,_links"
+        response = request(url, "GET")
+        if response.status_code != 200:
+            raise Exception("Test 4 failed -" + str(response.status_code) + " " + response.text)
+        ret = response.json()
+        if sorted(ret.keys()) != ['_links', 'id']:
+            raise Exception("Test 4 failed - unexpected fields " + response.text)
+        # only the poll embedded
+        url = APIs['votes'] + "/1?embed=poll"
+        response = request(url, "GET")
+        ret = response.json()
+        if 'Poll' not in ret['_embedded'] or 'Voter' in ret['_embedded']:
+            raise Exception("Test 4 failed - unexpected embedded " + response.text)
+        url = APIs['votes'] + "/1?embed=everything"
+        response = request(url, "GET")
+        if response.status_code != 400:
+            raise Exception("Test 4 failed - invalid embed accepted")
+        print(json.dumps(ret['_links'], indent=4))
+
     def cleanup(self):
+        # delete poll 1, the cascade deletes vote 1 and removes it from voter 1
+        url = APIs['polls'] + "/1?cascade=true"
+        response = request(url, "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        report = response.json()['cascade']
+        if report['votesDeleted'] != [1] or report['votersUpdated'] != [1]:
+            raise Exception("Cleanup failed - votes not deleted with the poll " + response.text)
         # delete voter 1
         url = APIs['voters'] + "/1"
+        response = request(url, "GET")
+        if response.json()['voterPolls'] != []:
+            raise Exception("Cleanup failed - vote not removed from voter " + response.text)
         response = request(url, "DELETE")
         if response.status_code != 200:
             raise Exception("Cleanup failed - voter not deleted")
-        # delete poll 1
-        url = APIs['polls'] + "/1"
+        
+        
+# Create tests, the api picks the ids
+# 1. Create a voter twice with the same Idempotency-Key, only one is created
+# 2. Reuse the Idempotency-Key for a different request
+# 3. Create a poll and cast a vote on it with server picked ids
+class CreateTests:
+    def __init__(self, url):
+        self.url = url
+        self.key = "create-tests-" + datetime.now().isoformat()
+        self.voterId = None
+        self.pollId = None
+        self.voteId = None
+
+    def test1(self):
+        body = {"name": "Created", "email": "created@example.com"}
+        headers = {"Idempotency-Key": self.key}
+        first = request(APIs['voters'], "POST", body, headers)
+        if first.status_code != 201:
+            raise Exception("Test 1 failed -" + str(first.status_code) + " " + first.text)
+        self.voterId = first.json()['id']
+        if not first.headers['Location'].endswith("/voters/" + str(self.voterId)):
+            raise Exception("Test 1 failed - wrong Location " + first.headers['Location'])
+        second = request(APIs['voters'], "POST", body, headers)
+        if second.status_code != 201 or second.json()['id'] != self.voterId:
+            raise Exception("Test 1 failed - retry created another voter " + second.text)
+        if second.headers.get('Idempotent-Replayed') != 'true':
+            raise Exception("Test 1 failed - retry was not replayed")
+        print(json.dumps(first.json(), indent=4))
+
+    def test2(self):
+        body = {"name": "Someone else", "email": ""}
+        response = request(APIs['voters'], "POST", body, {"Idempotency-Key": self.key})
+        if response.status_code != 422:
+            raise Exception("Test 2 failed - key reused for another request " + str(response.status_code))
+        # a client picked id is not allowed here
+        response = request(APIs['voters'], "POST", {"id": 5, "name": "Test", "email": ""})
+        if response.status_code != 400:
+            raise Exception("Test 2 failed - id in the body accepted " + str(response.status_code))
+
+    def test3(self):
+        poll = {"title": "Created", "question": "Created", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Test 3 failed -" + str(response.status_code) + " " + response.text)
+        self.pollId = response.json()['id']
+        vote = {"pollId": self.pollId, "voterId": self.voterId, "voteValue": 2}
+        response = request(self.url, "POST", vote)
+        if response.status_code != 201:
+            raise Exception("Test 3 failed -" + str(response.status_code) + " " + response.text)
+        self.voteId = response.json()['id']
+        if not response.headers['Location'].endswith("/votes/" + str(self.voteId)):
+            raise Exception("Test 3 failed - wrong Location " + response.headers['Location'])
+        response = request(self.url + "/" + str(self.voteId), "GET")
+        if response.status_code != 200 or response.json()['voterId'] != self.voterId:
+            raise Exception("Test 3 failed - vote not found")
+        print(json.dumps(response.json()['_links'], indent=4))
+
+    def cleanup(self):
+        url = APIs['polls'] + "/" + str(self.pollId) + "?cascade=true"
         response = request(url, "DELETE")
         if response.status_code != 200:
             raise Exception("Cleanup failed - poll not deleted")
-        # delete vote 1
-        url = APIs['votes'] + "/1"
+        url = APIs['voters'] + "/" + str(self.voterId)
         response = request(url, "DELETE")
         if response.status_code != 200:
-            raise Exception("Cleanup failed - vote not deleted")
-        
-        
+            raise Exception("Cleanup failed - voter not deleted")
+
+
+# Lifecycle tests
+# 1. A draft poll does not take votes until it is opened
+# 2. A closed poll keeps a snapshot of its results and takes no more votes
+# 3. A scheduled poll opens and closes by itself
+# 4. Only a closed poll can be archived
+class LifecycleTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.polls = []
+        self.voteId = None
+
+    def createPoll(self, **lifecycle):
+        poll = {"title": "Lifecycle", "question": "Lifecycle", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        poll.update(lifecycle)
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Could not create poll - " + str(response.status_code) + " " + response.text)
+        self.polls.append(response.json()['id'])
+        return response.json()
+
+    def vote(self, pollId, voterId):
+        return request(self.url, "POST", {"pollId": pollId, "voterId": voterId, "voteValue": 2})
+
+    def startup(self):
+        for i in range(3):
+            response = request(APIs['voters'], "POST", {"name": "Lifecycle", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+
+    def test1(self):
+        poll = self.createPoll(state="draft")
+        if poll['state'] != "draft":
+            raise Exception("Test 1 failed - poll is " + poll['state'])
+        response = self.vote(poll['id'], self.voters[0])
+        if response.status_code != 409:
+            raise Exception("Test 1 failed - vote on a draft poll " + str(response.status_code))
+        response = request(APIs['polls'] + "/" + str(poll['id']) + "/open", "POST")
+        if response.status_code != 200 or response.json()['state'] != "open":
+            raise Exception("Test 1 failed - poll not opened " + response.text)
+        response = self.vote(poll['id'], self.voters[0])
+        if response.status_code != 201:
+            raise Exception("Test 1 failed - vote on an open poll " + response.text)
+        self.voteId = response.json()['id']
+
+    def test2(self):
+        pollUrl = APIs['polls'] + "/" + str(self.polls[0])
+        response = request(pollUrl + "/close", "POST")
+        if response.status_code != 200:
+            raise Exception("Test 2 failed - poll not closed " + response.text)
+        snapshot = response.json()['snapshot']
+        if snapshot['totalVotes'] != 1 or snapshot['results'][1]['votes'] != 1:
+            raise Exception("Test 2 failed - wrong snapshot " + json.dumps(snapshot))
+        # nothing about the votes can change anymore
+        response = self.vote(self.polls[0], self.voters[1])
+        if response.status_code != 409:
+            raise Exception("Test 2 failed - vote on a closed poll " + str(response.status_code))
+        response = request(self.url + "/" + str(self.voteId), "PUT", {"voteValue": 1})
+        if response.status_code != 409:
+            raise Exception("Test 2 failed - vote changed on a closed poll " + str(response.status_code))
+        response = request(self.url + "/" + str(self.voteId), "DELETE")
+        if response.status_code != 409:
+            raise Exception("Test 2 failed - vote deleted on a closed poll " + str(response.status_code))
+        response = request(pollUrl, "PUT", {"title": "Changed", "question": "Changed", "options": []})
+        if response.status_code != 409:
+            raise Exception("Test 2 failed - closed poll updated " + str(response.status_code))
+        response = request(pollUrl + "/close", "POST")
+        if response.status_code != 409:
+            raise Exception("Test 2 failed - poll closed twice " + str(response.status_code))
+        response = request(pollUrl + "/results", "GET")
+        if response.json()['state'] != "closed" or response.json()['snapshot'] != snapshot:
+            raise Exception("Test 2 failed - snapshot changed " + response.text)
+        print(json.dumps(response.json(), indent=4))
+
+    def test3(self):
+        now = datetime.now(timezone.utc)
+        poll = self.createPoll(opensAt=(now + timedelta(seconds=2)).isoformat(), closesAt=(now + timedelta(seconds=5)).isoformat())
+        if poll['state'] != "scheduled":
+            raise Exception("Test 3 failed - poll is " + poll['state'])
+        response = self.vote(poll['id'], self.voters[2])
+        if response.status_code != 409:
+            raise Exception("Test 3 failed - vote before the poll opened " + str(response.status_code))
+        time.sleep(3.5)
+        response = self.vote(poll['id'], self.voters[2])
+        if response.status_code != 201:
+            raise Exception("Test 3 failed - poll did not open " + response.text)
+        time.sleep(3.5)
+        response = request(APIs['polls'] + "/" + str(poll['id']), "GET")
+        if response.json()['state'] != "closed" or response.json()['snapshot']['totalVotes'] != 1:
+            raise Exception("Test 3 failed - poll did not close " + response.text)
+
+    def test4(self):
+        poll = self.createPoll()
+        response = request(APIs['polls'] + "/" + str(poll['id']) + "/archive", "POST")
+        if response.status_code != 409:
+            raise Exception("Test 4 failed - open poll archived " + str(response.status_code))
+        response = request(APIs['polls'] + "/" + str(self.polls[0]) + "/archive", "POST")
+        if response.status_code != 200 or response.json()['state'] != "archived":
+            raise Exception("Test 4 failed - closed poll not archived " + response.text)
+
+    def cleanup(self):
+        for pollId in self.polls:
+            response = request(APIs['polls'] + "/" + str(pollId) + "?cascade=true", "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Ballot tests
+# 1. Ranked choice poll counted with instant runoff, and with borda
+# 2. Ballots that do not match the poll are rejected
+# 3. Approval poll counts every option a voter picks
+# 4. Changing a ranked ballot changes the outcome
+class BallotTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.polls = []
+        self.votes = []
+
+    def createPoll(self, ballot):
+        poll = {"title": "Ballot", "question": "Ballot", "ballot": ballot,
+                "options": [{"id": 1, "text": "A"}, {"id": 2, "text": "B"}, {"id": 3, "text": "C"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Could not create poll - " + str(response.status_code) + " " + response.text)
+        self.polls.append(response.json()['id'])
+        return response.json()['id']
+
+    def vote(self, pollId, voterId, ballot):
+        return request(self.url, "POST", {"pollId": pollId, "voterId": voterId, "ballot": ballot})
+
+    def outcome(self, pollId, tally=None):
+        url = APIs['polls'] + "/" + str(pollId) + "/results"
+        if tally:
+            url += "?tally=" + tally
+        return request(url, "GET")
+
+    def startup(self):
+        for i in range(5):
+            response = request(APIs['voters'], "POST", {"name": "Ballot", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+
+    def test1(self):
+        pollId = self.createPoll({"type": "ranked"})
+        rankings = [[1, 2], [1, 2], [2, 1], [3, 2], [3, 2]]
+        for voterId, choices in zip(self.voters, rankings):
+            response = self.vote(pollId, voterId, {"choices": choices})
+            if response.status_code != 201:
+                raise Exception("Test 1 failed - ballot not cast " + response.text)
+            self.votes.append(response.json()['id'])
+        # B has the fewest first choices and goes, its ballot moves to A
+        outcome = self.outcome(pollId).json()['outcome']
+        if outcome['method'] != "irv" or outcome['winners'] != [1] or len(outcome['rounds']) != 2:
+            raise Exception("Test 1 failed - wrong instant runoff " + json.dumps(outcome))
+        if outcome['rounds'][0]['eliminated'] != [2]:
+            raise Exception("Test 1 failed - wrong option eliminated " + json.dumps(outcome))
+        outcome = self.outcome(pollId, "borda").json()['outcome']
+        if outcome['winners'] != [2]:
+            raise Exception("Test 1 failed - wrong borda count " + json.dumps(outcome))
+        response = self.outcome(pollId, "approval")
+        if response.status_code != 400:
+            raise Exception("Test 1 failed - approval counted ranked ballots " + str(response.status_code))
+        print(json.dumps(self.outcome(pollId).json()['outcome'], indent=4))
+
+    def test2(self):
+        pollId = self.createPoll({"type": "ranked", "maxChoices": 2})
+        for ballot in [{"choices": [1, 1]}, {"choices": [1, 2, 3]}, {"choices": [5]}, {"scores": {"1": 1}}, None]:
+            response = self.vote(pollId, self.voters[0], ballot)
+            if response.status_code != 400:
+                raise Exception("Test 2 failed - ballot accepted " + json.dumps(ballot))
+        # borda cannot count approval ballots
+        poll = {"title": "Ballot", "question": "Ballot", "ballot": {"type": "approval", "tally": "borda"},
+                "options": [{"id": 1, "text": "A"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 400:
+            raise Exception("Test 2 failed - poll with a wrong tally created " + str(response.status_code))
+
+    def test3(self):
+        pollId = self.createPoll({"type": "approval"})
+        for voterId, choices in zip(self.voters, [[1, 2], [2], [2, 3]]):
+            response = self.vote(pollId, voterId, {"choices": choices})
+            if response.status_code != 201:
+                raise Exception("Test 3 failed - ballot not cast " + response.text)
+        outcome = self.outcome(pollId).json()['outcome']
+        totals = [t['total'] for t in outcome['totals']]
+        if totals != [1, 3, 1] or outcome['winners'] != [2]:
+            raise Exception("Test 3 failed - wrong approval count " + json.dumps(outcome))
+
+    def test4(self):
+        # the first voter now ranks C first, which gives C a majority
+        response = request(self.url + "/" + str(self.votes[0]), "PUT", {"ballot": {"choices": [3, 1]}})
+        if response.status_code != 200 or response.json()['voteValue'] != 3:
+            raise Exception("Test 4 failed - ballot not changed " + response.text)
+        outcome = self.outcome(self.polls[0]).json()['outcome']
+        if outcome['winners'] != [3]:
+            raise Exception("Test 4 failed - wrong instant runoff " + json.dumps(outcome))
+
+    def cleanup(self):
+        for pollId in self.polls:
+            response = request(APIs['polls'] + "/" + str(pollId) + "?cascade=true", "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Option tests
+# 1. Options without an id get one, and votes refer to the id
+# 2. Reordering, adding and removing options keeps the votes of the others
+class OptionTests:
+    def __init__(self, url):
+        self.url = url
+        self.voterId = None
+        self.pollId = None
+
+    def startup(self):
+        response = request(APIs['voters'], "POST", {"name": "Options", "email": ""})
+        if response.status_code != 201:
+            raise Exception("Startup failed - voter not created")
+        self.voterId = response.json()['id']
+
+    def test1(self):
+        poll = {"title": "Options", "question": "Options", "options": [{"text": "A"}, {"id": 5, "text": "B"}, {"text": "C"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Test 1 failed -" + str(response.status_code) + " " + response.text)
+        self.pollId = response.json()['id']
+        ids = [option['id'] for option in response.json()['options']]
+        if ids != [6, 5, 7]:
+            raise Exception("Test 1 failed - wrong option ids " + str(ids))
+        response = request(self.url, "POST", {"pollId": self.pollId, "voterId": self.voterId, "voteValue": 5})
+        if response.status_code != 201:
+            raise Exception("Test 1 failed - vote not cast " + response.text)
+        # 1 is neither a position nor an id now
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "/results/1/increment", "POST", {"by": 1})
+        if response.status_code != 400:
+            raise Exception("Test 1 failed - unknown option counted " + str(response.status_code))
+
+    def test2(self):
+        pollUrl = APIs['polls'] + "/" + str(self.pollId)
+        poll = {"title": "Options", "question": "Options", "options": [{"id": 5, "text": "B"}, {"id": 7, "text": "C"}, {"text": "D"}]}
+        response = request(pollUrl, "PUT", poll)
+        if response.status_code != 200:
+            raise Exception("Test 2 failed -" + str(response.status_code) + " " + response.text)
+        response = request(pollUrl + "/results", "GET")
+        results = {r['optionId']: r['votes'] for r in response.json()['results']}
+        if results != {5: 1, 7: 0, 8: 0}:
+            raise Exception("Test 2 failed - votes not kept " + str(results))
+        print(json.dumps(response.json()['results'], indent=4))
+
+    def cleanup(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        response = request(APIs['voters'] + "/" + str(self.voterId), "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - voter not deleted")
+
+
+# Revision tests
+# 1. Editing a poll makes a new revision and keeps the votes of the options that are left
+# 2. The revisions are listed, and the diff shows what changed
+# 3. A poll with the reject policy cannot be edited once it has votes
+# 4. A poll with the reset policy starts from zero, the older votes are no longer counted
+class RevisionTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.polls = []
+        self.voteId = None
+
+    def createPoll(self, policy=None):
+        poll = {"title": "Revision", "question": "Revision", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        if policy:
+            poll["editPolicy"] = policy
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Could not create poll - " + str(response.status_code) + " " + response.text)
+        self.polls.append(response.json()['id'])
+        return response.json()['id']
+
+    def edit(self, pollId):
+        poll = {"title": "Revised", "question": "Revision", "options": [{"id": 2, "text": "Nope"}, {"id": 3, "text": "Maybe"}]}
+        return request(APIs['polls'] + "/" + str(pollId), "PUT", poll)
+
+    def results(self, pollId):
+        response = request(APIs['polls'] + "/" + str(pollId) + "/results", "GET")
+        return {r['optionId']: r['votes'] for r in response.json()['results']}
+
+    def startup(self):
+        for i in range(3):
+            response = request(APIs['voters'], "POST", {"name": "Revision", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+
+    def test1(self):
+        pollId = self.createPoll()
+        response = request(self.url, "POST", {"pollId": pollId, "voterId": self.voters[0], "voteValue": 2})
+        if response.status_code != 201 or response.json()['pollRevision'] != 1:
+            raise Exception("Test 1 failed - vote not cast " + response.text)
+        self.voteId = response.json()['id']
+        response = self.edit(pollId)
+        if response.status_code != 200 or response.json()['revision'] != 2 or response.json()['title'] != "Revised":
+            raise Exception("Test 1 failed - poll not revised " + response.text)
+        if self.results(pollId) != {2: 1, 3: 0}:
+            raise Exception("Test 1 failed - votes not kept " + str(self.results(pollId)))
+        # the same edit again is not a new revision
+        response = self.edit(pollId)
+        if response.json()['revision'] != 2:
+            raise Exception("Test 1 failed - unchanged poll revised " + response.text)
+
+    def test2(self):
+        pollUrl = APIs['polls'] + "/" + str(self.polls[0])
+        response = request(pollUrl + "/revisions", "GET")
+        revisions = response.json()['_embedded']['revisions']
+        if len(revisions) != 2 or revisions[0]['replacedAt'] is None or revisions[0]['title'] != "Revision":
+            raise Exception("Test 2 failed - wrong revisions " + response.text)
+        response = request(pollUrl + "/revisions/2/diff", "GET")
+        diff = response.json()
+        if (diff['title'] != {"from": "Revision", "to": "Revised"} or [o['id'] for o in diff['added']] != [3]
+                or [o['id'] for o in diff['removed']] != [1] or diff['renamed'][0]['to'] != "Nope"):
+            raise Exception("Test 2 failed - wrong diff " + response.text)
+        response = request(pollUrl + "/revisions/3", "GET")
+        if response.status_code != 404:
+            raise Exception("Test 2 failed - missing revision found " + str(response.status_code))
+        print(json.dumps(diff, indent=4))
+
+    def test3(self):
+        pollId = self.createPoll("reject")
+        response = self.edit(pollId)
+        if response.status_code != 200:
+            raise Exception("Test 3 failed - poll without votes not edited " + response.text)
+        request(self.url, "POST", {"pollId": pollId, "voterId": self.voters[1], "voteValue": 2})
+        response = request(APIs['polls'] + "/" + str(pollId), "PUT", {"title": "Again", "question": "Revision", "options": [{"id": 2, "text": "No"}]})
+        if response.status_code != 409:
+            raise Exception("Test 3 failed - poll with votes edited " + str(response.status_code))
+
+    def test4(self):
+        pollId = self.createPoll("reset")
+        response = request(self.url, "POST", {"pollId": pollId, "voterId": self.voters[2], "voteValue": 2})
+        voteId = response.json()['id']
+        response = self.edit(pollId)
+        if response.status_code != 200 or response.json()['resultsSince'] != 2:
+            raise Exception("Test 4 failed - poll not reset " + response.text)
+        if self.results(pollId) != {2: 0, 3: 0}:
+            raise Exception("Test 4 failed - results not reset " + str(self.results(pollId)))
+        # the old vote is not counted, deleting it does not change the results
+        response = request(self.url + "/" + str(voteId), "DELETE")
+        if response.status_code != 200 or self.results(pollId) != {2: 0, 3: 0}:
+            raise Exception("Test 4 failed - old vote counted " + str(self.results(pollId)))
+        response = request(self.url, "POST", {"pollId": pollId, "voterId": self.voters[2], "voteValue": 3})
+        if response.status_code != 201 or response.json()['pollRevision'] != 2 or self.results(pollId) != {2: 0, 3: 1}:
+            raise Exception("Test 4 failed - new vote not counted " + response.text)
+
+    def cleanup(self):
+        for pollId in self.polls:
+            response = request(APIs['polls'] + "/" + str(pollId) + "?cascade=true", "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Stream tests
+# 1. Open the results stream of a poll, read the current results, cast a vote and read the update
+# 2. Resume the stream with the last event id, only updates after it are sent
+# 3. Delete the poll, the stream gets a deleted event and ends
+class StreamTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.pollId = None
+        self.lastEventId = None
+
+    def open(self, lastEventId=None):
+        headers = {"Accept": "text/event-stream"}
+        if lastEventId is not None:
+            headers["Last-Event-ID"] = str(lastEventId)
+        url = APIs['polls'] + "/" + str(self.pollId) + "/results/stream"
+        response = requests.get(url, headers=headers, stream=True, timeout=20)
+        if response.status_code != 200:
+            raise Exception("Could not open stream - " + str(response.status_code) + " " + response.text)
+        return response
+
+    # nextEvent reads the stream up to the next event, skipping heartbeats
+    def nextEvent(self, lines):
+        event = {}
+        for line in lines:
+            if line == "":
+                if event:
+                    return event
+                continue
+            if line.startswith(":"):
+                continue
+            field, _, value = line.partition(":")
+            event[field] = value.strip()
+        raise Exception("Stream ended")
+
+    def startup(self):
+        for i in range(2):
+            response = request(APIs['voters'], "POST", {"name": "Stream", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+        poll = {"title": "Stream", "question": "Stream", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Startup failed - poll not created")
+        self.pollId = response.json()['id']
+
+    def test1(self):
+        stream = self.open()
+        lines = stream.iter_lines(decode_unicode=True)
+        event = self.nextEvent(lines)
+        if event['event'] != "results" or json.loads(event['data'])['totalVotes'] != 0:
+            raise Exception("Test 1 failed - no current results " + str(event))
+        response = request(self.url, "POST", {"pollId": self.pollId, "voterId": self.voters[0], "voteValue": 1})
+        if response.status_code != 201:
+            raise Exception("Test 1 failed - vote not cast " + response.text)
+        event = self.nextEvent(lines)
+        update = json.loads(event['data'])
+        if int(event['id']) != update['id'] or update['totalVotes'] != 1:
+            raise Exception("Test 1 failed - vote not streamed " + str(event))
+        stream.close()
+        self.lastEventId = update['id']
+        print(json.dumps(update, indent=4))
+
+    def test2(self):
+        stream = self.open(self.lastEventId)
+        lines = stream.iter_lines(decode_unicode=True)
+        request(self.url, "POST", {"pollId": self.pollId, "voterId": self.voters[1], "voteValue": 2})
+        event = self.nextEvent(lines)
+        update = json.loads(event['data'])
+        if update['id'] <= self.lastEventId:
+            raise Exception("Test 2 failed - results sent again " + str(event))
+        stream.close()
+
+    def test3(self):
+        stream = self.open()
+        lines = stream.iter_lines(decode_unicode=True)
+        self.nextEvent(lines)
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Test 3 failed - poll not deleted")
+        event = self.nextEvent(lines)
+        while event['event'] == "results":
+            event = self.nextEvent(lines)
+        if event['event'] != "deleted":
+            raise Exception("Test 3 failed - no deleted event " + str(event))
+        stream.close()
+
+    def cleanup(self):
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Webhook tests
+# 1. A vote is posted to the webhook with a valid signature
+# 2. Closing the poll is posted as poll.closed, events that were not asked for are not
+# 3. The delivery log shows the delivery, and a redelivery posts it again
+# 4. A receiver that fails gets retried later, a pending delivery cannot be redelivered
+class WebhookTests:
+    secret = "whsec_integration"
+
+    def __init__(self, url):
+        self.url = url
+        self.voterId = None
+        self.pollId = None
+        self.webhookId = None
+        self.server = None
+        self.received = queue.Queue()
+        self.status = 200
+        self.voteDelivery = None
+
+    # receiver is the http server the api posts to. The poll api has to reach it at
+    # WEBHOOK_RECEIVER_HOST, host.docker.internal when the apis run in docker.
+    def receiver(self):
+        tests = self
+
+        class Handler(BaseHTTPRequestHandler):
+            def do_POST(self):
+                body = self.rfile.read(int(self.headers['Content-Length']))
+                tests.received.put((dict(self.headers), body))
+                self.send_response(tests.status)
+                self.end_headers()
+
+            def log_message(self, format, *args):
+                pass
+
+        self.server = ThreadingHTTPServer(("0.0.0.0", 0), Handler)
+        threading.Thread(target=self.server.serve_forever, daemon=True).start()
+        host = os.environ.get("WEBHOOK_RECEIVER_HOST", "host.docker.internal")
+        return "http://" + host + ":" + str(self.server.server_address[1]) + "/hook"
+
+    # waitFor returns the next request for an event of eventType, skipping others
+    def waitFor(self, eventType, timeout=15):
+        deadline = time.time() + timeout
+        while time.time() < deadline:
+            try:
+                headers, body = self.received.get(timeout=deadline - time.time())
+            except queue.Empty:
+                break
+            if headers['X-Webhook-Event'] == eventType:
+                return headers, body
+        raise Exception("No " + eventType + " delivery within " + str(timeout) + " seconds")
+
+    def verify(self, headers, body):
+        signed = headers['X-Webhook-Timestamp'].encode() + b"." + body
+        expected = "sha256=" + hmac.new(self.secret.encode(), signed, hashlib.sha256).hexdigest()
+        return hmac.compare_digest(expected, headers['X-Webhook-Signature'])
+
+    def delivery(self, deliveryId):
+        response = request(self.url + "/" + str(self.webhookId) + "/deliveries/" + deliveryId, "GET")
+        if response.status_code != 200:
+            raise Exception("Could not read delivery - " + str(response.status_code) + " " + response.text)
+        return response.json()
+
+    # waitForAttempts reads the delivery until its attempt number count is logged
+    def waitForAttempts(self, deliveryId, count, timeout=10):
+        deadline = time.time() + timeout
+        while time.time() < deadline:
+            delivery = self.delivery(deliveryId)
+            done = delivery['status'] != "pending" or delivery.get('nextAttemptAt')
+            if len(delivery['attempts']) >= count and done:
+                return delivery
+            time.sleep(0.5)
+        raise Exception("Delivery " + deliveryId + " has no attempt " + str(count))
+
+    def startup(self):
+        hook = self.receiver()
+        response = request(APIs['voters'], "POST", {"name": "Webhook", "email": ""})
+        if response.status_code != 201:
+            raise Exception("Startup failed - voter not created")
+        self.voterId = response.json()['id']
+        poll = {"title": "Webhook", "question": "Webhook", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Startup failed - poll not created")
+        self.pollId = response.json()['id']
+        webhook = {"url": hook, "events": ["vote.cast", "poll.closed"], "secret": self.secret}
+        response = request(self.url, "POST", webhook)
+        if response.status_code != 201:
+            raise Exception("Startup failed - webhook not created " + response.text)
+        self.webhookId = response.json()['id']
+
+    def test1(self):
+        response = request(APIs['votes'], "POST", {"pollId": self.pollId, "voterId": self.voterId, "voteValue": 1})
+        if response.status_code != 201:
+            raise Exception("Test 1 failed - vote not cast " + response.text)
+        headers, body = self.waitFor("vote.cast")
+        if not self.verify(headers, body):
+            raise Exception("Test 1 failed - bad signature " + str(headers))
+        event = json.loads(body)
+        if event['data']['vote']['pollId'] != self.pollId or headers['X-Webhook-Id'] != str(self.webhookId):
+            raise Exception("Test 1 failed - wrong event " + body.decode())
+        self.voteDelivery = headers['X-Webhook-Delivery']
+        print(json.dumps(event, indent=4))
+
+    def test2(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "/close", "POST")
+        if response.status_code != 200:
+            raise Exception("Test 2 failed - poll not closed " + response.text)
+        headers, body = self.waitFor("poll.closed")
+        if not self.verify(headers, body) or json.loads(body)['data']['poll']['state'] != "closed":
+            raise Exception("Test 2 failed - wrong poll.closed " + body.decode())
+        # poll.updated and the other events were not asked for
+        if not self.received.empty():
+            raise Exception("Test 2 failed - unexpected delivery " + str(self.received.get()[0]))
+
+    def test3(self):
+        delivery = self.waitForAttempts(self.voteDelivery, 1)
+        if delivery['status'] != "succeeded" or delivery['attempts'][0]['statusCode'] != 200:
+            raise Exception("Test 3 failed - wrong delivery " + json.dumps(delivery))
+        response = request(self.url + "/" + str(self.webhookId) + "/deliveries", "GET")
+        ids = [d['id'] for d in response.json()['_embedded']['deliveries']]
+        if self.voteDelivery not in ids:
+            raise Exception("Test 3 failed - delivery not listed " + response.text)
+        response = request(self.url + "/" + str(self.webhookId) + "/deliveries/" + self.voteDelivery + "/redeliver", "POST")
+        if response.status_code != 202:
+            raise Exception("Test 3 failed - not redelivered " + response.text)
+        headers, body = self.waitFor("vote.cast")
+        if headers['X-Webhook-Delivery'] != self.voteDelivery or not self.verify(headers, body):
+            raise Exception("Test 3 failed - wrong redelivery " + str(headers))
+        delivery = self.waitForAttempts(self.voteDelivery, 2)
+        if not delivery['attempts'][1].get('redelivery'):
+            raise Exception("Test 3 failed - redelivery not logged " + json.dumps(delivery))
+
+    def test4(self):
+        self.status = 500
+        response = request(self.url + "/" + str(self.webhookId) + "/ping", "POST")
+        if response.status_code != 202:
+            raise Exception("Test 4 failed - no ping " + response.text)
+        pingId = response.json()['id']
+        self.waitFor("webhook.ping")
+        delivery = self.waitForAttempts(pingId, 1)
+        if delivery['status'] != "pending" or delivery['attempts'][0]['statusCode'] != 500 or not delivery.get('nextAttemptAt'):
+            raise Exception("Test 4 failed - failed delivery not retried " + json.dumps(delivery))
+        response = request(self.url + "/" + str(self.webhookId) + "/deliveries/" + pingId + "/redeliver", "POST")
+        if response.status_code != 409:
+            raise Exception("Test 4 failed - pending delivery redelivered " + str(response.status_code))
+        self.status = 200
+
+    def cleanup(self):
+        response = request(self.url + "/" + str(self.webhookId), "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - webhook not deleted")
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        response = request(APIs['voters'] + "/" + str(self.voterId), "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - voter not deleted")
+        self.server.shutdown()
+
+
+# Ledger tests
+# 1. Casting, changing and deleting votes appends a chained entry for each
+# 2. The hashes can be recomputed from the entries and the ledger verifies
+# 3. Results changed behind the ledger's back fail verification
+class LedgerTests:
+    genesis = "0" * 64
+
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.pollId = None
+        self.entries = []
+
+    def ledger(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "/ledger", "GET")
+        if response.status_code != 200:
+            raise Exception("Could not read ledger - " + str(response.status_code) + " " + response.text)
+        return response.json()['_embedded']['entries']
+
+    def verify(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "/ledger/verify", "GET")
+        if response.status_code != 200:
+            raise Exception("Could not verify ledger - " + str(response.status_code) + " " + response.text)
+        return response.json()
+
+    def startup(self):
+        for i in range(2):
+            response = request(APIs['voters'], "POST", {"name": "Ledger", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+        poll = {"title": "Ledger", "question": "Ledger", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Startup failed - poll not created")
+        self.pollId = response.json()['id']
+
+    def test1(self):
+        votes = []
+        for voterId in self.voters:
+            response = request(self.url, "POST", {"pollId": self.pollId, "voterId": voterId, "voteValue": 1})
+            if response.status_code != 201:
+                raise Exception("Test 1 failed - vote not cast " + response.text)
+            votes.append(response.json()['id'])
+        response = request(self.url + "/" + str(votes[0]), "PUT", {"voteValue": 2})
+        if response.status_code != 200:
+            raise Exception("Test 1 failed - vote not changed " + response.text)
+        response = request(self.url + "/" + str(votes[1]), "DELETE")
+        if response.status_code != 200:
+            raise Exception("Test 1 failed - vote not deleted " + response.text)
+        self.entries = self.ledger()
+        actions = [(e['action'], e['voteId']) for e in self.entries]
+        if actions != [("cast", votes[0]), ("cast", votes[1]), ("change", votes[0]), ("delete", votes[1])]:
+            raise Exception("Test 1 failed - wrong entries " + str(actions))
+        prevHash = self.genesis
+        for i, entry in enumerate(self.entries):
+            if entry['seq'] != i + 1 or entry['prevHash'] != prevHash:
+                raise Exception("Test 1 failed - entry not chained " + json.dumps(entry))
+            prevHash = entry['hash']
+
+    def test2(self):
+        for entry in self.entries:
+            unsigned = dict(entry)
+            unsigned['hash'] = ""
+            raw = json.dumps(unsigned, separators=(",", ":")).encode()
+            if hashlib.sha256(raw).hexdigest() != entry['hash']:
+                raise Exception("Test 2 failed - hash does not match " + json.dumps(entry))
+        verification = self.verify()
+        if not verification['valid'] or verification['entries'] != 4 or verification['head'] != self.entries[-1]['hash']:
+            raise Exception("Test 2 failed - ledger not valid " + json.dumps(verification))
+        if verification['ledger'] != [{"optionId": 1, "votes": 0}, {"optionId": 2, "votes": 1}]:
+            raise Exception("Test 2 failed - wrong ledger totals " + json.dumps(verification))
+        print(json.dumps(verification, indent=4))
+
+    def test3(self):
+        poll = request(APIs['polls'] + "/" + str(self.pollId), "GET").json()
+        original = json.loads(json.dumps(poll))
+        poll['results'][0]['votes'] += 5
+        response = request(APIs['polls'] + "/counts/" + str(self.pollId), "PUT", poll)
+        if response.status_code != 200:
+            raise Exception("Test 3 failed - counts not changed " + response.text)
+        verification = self.verify()
+        if verification['valid'] or not verification['chainValid'] or verification['resultsValid']:
+            raise Exception("Test 3 failed - changed results verified " + json.dumps(verification))
+        if verification['mismatches'] != [{"optionId": 1, "ledger": 0, "poll": 5}]:
+            raise Exception("Test 3 failed - wrong mismatches " + json.dumps(verification))
+        request(APIs['polls'] + "/counts/" + str(self.pollId), "PUT", original)
+        if not self.verify()['valid']:
+            raise Exception("Test 3 failed - restored results do not verify")
+
+    def cleanup(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Recount tests
+# 1. Votes keep the poll's total votes up to date
+# 2. A recount reports results changed behind the votes' back, and leaves them
+# 3. A recount with ?repair=true rebuilds the results from the votes
+class RecountTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.pollId = None
+
+    def poll(self):
+        return request(APIs['polls'] + "/" + str(self.pollId), "GET").json()
+
+    def recount(self, repair=False):
+        url = APIs['polls'] + "/" + str(self.pollId) + "/recount"
+        if repair:
+            url += "?repair=true"
+        response = request(url, "POST")
+        if response.status_code != 200:
+            raise Exception("Could not recount poll - " + str(response.status_code) + " " + response.text)
+        return response.json()
+
+    def startup(self):
+        for i in range(2):
+            response = request(APIs['voters'], "POST", {"name": "Recount", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+        poll = {"title": "Recount", "question": "Recount", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Startup failed - poll not created")
+        self.pollId = response.json()['id']
+
+    def test1(self):
+        for voterId, option in zip(self.voters, [1, 2]):
+            response = request(self.url, "POST", {"pollId": self.pollId, "voterId": voterId, "voteValue": option})
+            if response.status_code != 201:
+                raise Exception("Test 1 failed - vote not cast " + response.text)
+        poll = self.poll()
+        if poll['_meta'].get('TotalVotes') != 2:
+            raise Exception("Test 1 failed - total votes not counted " + json.dumps(poll['_meta']))
+        recount = self.recount()
+        if recount['discrepancies'] != [] or recount['totalVotes'] != 2:
+            raise Exception("Test 1 failed - clean poll has discrepancies " + json.dumps(recount))
+
+    def test2(self):
+        poll = self.poll()
+        poll['results'][0]['votes'] += 3
+        response = request(APIs['polls'] + "/counts/" + str(self.pollId), "PUT", poll)
+        if response.status_code != 200:
+            raise Exception("Test 2 failed - counts not changed " + response.text)
+        recount = self.recount()
+        kinds = [d['kind'] for d in recount['discrepancies']]
+        if "poll.results" not in kinds or recount['repaired']:
+            raise Exception("Test 2 failed - drift not reported " + json.dumps(recount))
+        if self.poll()['results'][0]['votes'] != 4:
+            raise Exception("Test 2 failed - recount without repair changed the poll")
+        print(json.dumps(recount, indent=4))
+
+    def test3(self):
+        recount = self.recount(repair=True)
+        if not recount['repaired'] or recount['results'] != [{"optionId": 1, "votes": 1}, {"optionId": 2, "votes": 1}]:
+            raise Exception("Test 3 failed - poll not repaired " + json.dumps(recount))
+        poll = self.poll()
+        if poll['results'] != recount['results'] or poll['_meta'].get('TotalVotes') != 2:
+            raise Exception("Test 3 failed - repair not stored " + json.dumps(poll))
+        if self.recount()['discrepancies'] != []:
+            raise Exception("Test 3 failed - repaired poll still has discrepancies")
+
+    def cleanup(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Export tests
+# 1. The results download as csv, ndjson and xlsx with the option texts
+# 2. The votes of a poll download as csv and ndjson, a row per vote
+# 3. An unknown format is rejected
+class ExportTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.votes = []
+        self.pollId = None
+
+    def startup(self):
+        for i in range(3):
+            response = request(APIs['voters'], "POST", {"name": "Export", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+        poll = {"title": "Export", "question": "Export", "options": [{"id": 1, "text": "Yes, \"really\""}, {"id": 2, "text": "No & <never>"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Startup failed - poll not created")
+        self.pollId = response.json()['id']
+        for voterId, option in zip(self.voters, [1, 2, 2]):
+            response = request(self.url, "POST", {"pollId": self.pollId, "voterId": voterId, "voteValue": option})
+            if response.status_code != 201:
+                raise Exception("Startup failed - vote not cast " + response.text)
+            self.votes.append(response.json()['id'])
+
+    def test1(self):
+        url = APIs['polls'] + "/" + str(self.pollId) + "/results?format="
+        response = request(url + "csv", "GET")
+        if response.status_code != 200 or not response.headers['Content-Type'].startswith("text/csv"):
+            raise Exception("Test 1 failed - csv not exported " + response.text)
+        rows = list(csv.reader(io.StringIO(response.text)))
+        if rows != [["optionId", "option", "votes"], ["1", 'Yes, "really"', "1"], ["2", "No & <never>", "2"]]:
+            raise Exception("Test 1 failed - wrong csv " + str(rows))
+
+        response = request(url + "ndjson", "GET")
+        rows = [json.loads(line) for line in response.text.splitlines()]
+        if rows != [{"optionId": 1, "option": 'Yes, "really"', "votes": 1}, {"optionId": 2, "option": "No & <never>", "votes": 2}]:
+            raise Exception("Test 1 failed - wrong ndjson " + response.text)
+
+        response = request(url + "xlsx", "GET")
+        if response.status_code != 200:
+            raise Exception("Test 1 failed - xlsx not exported " + response.text)
+        sheet = zipfile.ZipFile(io.BytesIO(response.content)).read("xl/worksheets/sheet1.xml").decode()
+        if "No &amp; &lt;never&gt;" not in sheet or "<v>2</v>" not in sheet:
+            raise Exception("Test 1 failed - wrong xlsx " + sheet)
+
+    def test2(self):
+        url = APIs['votes'] + "/polls/" + str(self.pollId) + "/export"
+        response = request(url, "GET")
+        if response.status_code != 200:
+            raise Exception("Test 2 failed - votes not exported " + response.text)
+        rows = list(csv.DictReader(io.StringIO(response.text)))
+        if [int(row['voteId']) for row in rows] != sorted(self.votes):
+            raise Exception("Test 2 failed - wrong votes " + response.text)
+        for row, voterId in zip(rows, self.voters):
+            if int(row['voterId']) != voterId or row['votedAt'] == "":
+                raise Exception("Test 2 failed - wrong row " + str(row))
+        if [row['option'] for row in rows] != ['Yes, "really"', "No & <never>", "No & <never>"]:
+            raise Exception("Test 2 failed - option texts not resolved " + response.text)
+
+        response = request(url + "?format=ndjson", "GET")
+        rows = [json.loads(line) for line in response.text.splitlines()]
+        if [row['optionId'] for row in rows] != [1, 2, 2]:
+            raise Exception("Test 2 failed - wrong ndjson " + response.text)
+
+    def test3(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "/results?format=pdf", "GET")
+        if response.status_code != 400:
+            raise Exception("Test 3 failed - pdf results not rejected " + str(response.status_code))
+        response = request(APIs['votes'] + "/polls/" + str(self.pollId) + "/export?format=pdf", "GET")
+        if response.status_code != 400:
+            raise Exception("Test 3 failed - pdf votes not rejected " + str(response.status_code))
+
+    def cleanup(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Import tests
+# 1. Voters import from csv, a dry run checks the rows but writes nothing
+# 2. Polls import from ndjson, an invalid poll is reported by its line
+# 3. Votes import from csv, a second vote of a voter and an unknown option fail
+class ImportTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.pollId = None
+
+    def post(self, url, body, format, dryRun=False):
+        url += "/import?format=" + format
+        if dryRun:
+            url += "&dryRun=true"
+        return requests.post(url, data=body.encode())
+
+    def test1(self):
+        body = "name,email\nImport A,a@example.com\n\"Import, B\",b@example.com\nImport C\n"
+        response = self.post(APIs['voters'], body, "csv", dryRun=True)
+        report = response.json()
+        if response.status_code != 200 or not report['dryRun'] or report['imported'] != 2 or report['ids'] != []:
+            raise Exception("Test 1 failed - wrong dry run report " + response.text)
+        if [e['line'] for e in report['errors']] != [4]:
+            raise Exception("Test 1 failed - short row not reported " + response.text)
+
+        response = self.post(APIs['voters'], body, "csv")
+        report = response.json()
+        if response.status_code != 200 or report['imported'] != 2 or len(report['ids']) != 2:
+            raise Exception("Test 1 failed - voters not imported " + response.text)
+        self.voters = report['ids']
+        response = request(APIs['voters'] + "/" + str(self.voters[1]), "GET")
+        if response.status_code != 200 or response.json()['name'] != "Import, B":
+            raise Exception("Test 1 failed - voter not found " + response.text)
+
+    def test2(self):
+        lines = [
+            {"title": "Import", "question": "Import", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}]},
+            {"title": "Import", "question": "Import", "options": [{"text": "Yes"}], "editPolicy": "sometimes"},
+        ]
+        body = "\n".join(json.dumps(line) for line in lines) + "\nnot json\n"
+        response = self.post(APIs['polls'], body, "ndjson")
+        report = response.json()
+        if response.status_code != 200 or report['imported'] != 1 or report['failed'] != 2:
+            raise Exception("Test 2 failed - wrong report " + response.text)
+        if [e['line'] for e in report['errors']] != [2, 3]:
+            raise Exception("Test 2 failed - errors not reported by line " + response.text)
+        self.pollId = report['ids'][0]
+
+    def test3(self):
+        rows = [
+            [self.pollId, self.voters[0], 1],
+            [self.pollId, self.voters[1], 2],
+            [self.pollId, self.voters[0], 2],
+            [self.pollId, self.voters[1], 9],
+        ]
+        body = "pollId,voterId,voteValue\n" + "".join(",".join(map(str, row)) + "\n" for row in rows)
+        response = self.post(APIs['votes'], body, "csv")
+        report = response.json()
+        if response.status_code != 200 or report['imported'] != 2 or [e['line'] for e in report['errors']] != [4, 5]:
+            raise Exception("Test 3 failed - wrong report " + response.text)
+
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "/results", "GET")
+        votes = {r['optionId']: r['votes'] for r in response.json()['results']}
+        if votes != {1: 1, 2: 1}:
+            raise Exception("Test 3 failed - wrong results " + response.text)
+
+    def cleanup(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Analytics tests
+# 1. The shares of the options, the leader's margin and the turnout of a poll
+# 2. The timeline counts the votes, and a deleted vote is taken out again
+# 3. A voter's statistics across polls come from their history
+class AnalyticsTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.votes = []
+        self.pollId = None
+
+    def startup(self):
+        for i in range(4):
+            response = request(APIs['voters'], "POST", {"name": "Analytics", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+        poll = {"title": "Analytics", "question": "Analytics", "options": [{"id": 1, "text": "A"}, {"id": 2, "text": "B"}, {"id": 3, "text": "C"}]}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Startup failed - poll not created")
+        self.pollId = response.json()['id']
+        for voterId, option in zip(self.voters, [1, 1, 2]):
+            response = request(self.url, "POST", {"pollId": self.pollId, "voterId": voterId, "voteValue": option})
+            if response.status_code != 201:
+                raise Exception("Startup failed - vote not cast " + response.text)
+            self.votes.append(response.json()['id'])
+
+    def analytics(self, query=""):
+        return request(APIs['polls'] + "/" + str(self.pollId) + "/analytics" + query, "GET")
+
+    def test1(self):
+        response = self.analytics()
+        if response.status_code != 200:
+            raise Exception("Test 1 failed - no analytics " + response.text)
+        analytics = response.json()
+        if analytics['totalVotes'] != 3 or analytics['countedVotes'] != 3:
+            raise Exception("Test 1 failed - wrong totals " + response.text)
+        if [o['percentage'] for o in analytics['options']] != [66.67, 33.33, 0] or analytics['options'][1]['option'] != "B":
+            raise Exception("Test 1 failed - wrong shares " + response.text)
+        leader = analytics['leader']
+        if leader['optionId'] != 1 or leader['margin'] != 1 or leader['tied']:
+            raise Exception("Test 1 failed - wrong leader " + response.text)
+        if analytics['registeredVoters'] < 4 or abs(analytics['turnout'] - 3 / analytics['registeredVoters']) > 1e-9:
+            raise Exception("Test 1 failed - wrong turnout " + response.text)
+
+    def test2(self):
+        response = self.analytics("?interval=minute")
+        timeline = response.json()['timeline']
+        if sum(b['votes'] for b in timeline) != 3 or timeline[-1]['cumulative'] != 3:
+            raise Exception("Test 2 failed - wrong timeline " + response.text)
+        response = self.analytics("?interval=week")
+        if response.status_code != 400:
+            raise Exception("Test 2 failed - unknown interval not rejected " + str(response.status_code))
+
+        response = request(self.url + "/" + str(self.votes[0]), "DELETE")
+        if response.status_code != 200:
+            raise Exception("Test 2 failed - vote not deleted " + response.text)
+        self.votes.pop(0)
+        response = self.analytics("?interval=day")
+        analytics = response.json()
+        if analytics['timeline'][-1]['cumulative'] != 2 or analytics['totalVotes'] != 2:
+            raise Exception("Test 2 failed - deleted vote still counted " + response.text)
+        if analytics['leader']['margin'] != 0 or not analytics['leader']['tied']:
+            raise Exception("Test 2 failed - tie not found " + response.text)
+
+    def test3(self):
+        response = request(APIs['voters'] + "/" + str(self.voters[1]) + "/analytics", "GET")
+        if response.status_code != 200:
+            raise Exception("Test 3 failed - no voter analytics " + response.text)
+        analytics = response.json()
+        if analytics['votes'] != 1 or analytics['pollsVoted'] != 1 or analytics['firstVotedAt'] is None:
+            raise Exception("Test 3 failed - wrong voter analytics " + response.text)
+        if analytics['totalPolls'] < 1 or abs(analytics['participation'] - 1 / analytics['totalPolls']) > 1e-9:
+            raise Exception("Test 3 failed - wrong participation " + response.text)
+
+        response = request(APIs['voters'] + "/" + str(self.voters[3]) + "/analytics", "GET")
+        analytics = response.json()
+        if analytics['pollsVoted'] != 0 or analytics['lastVotedAt'] is not None or analytics['timeline'] != []:
+            raise Exception("Test 3 failed - voter without votes " + response.text)
+
+    def cleanup(self):
+        response = request(APIs['polls'] + "/" + str(self.pollId) + "?cascade=true", "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Outcome tests
+# 1. A supermajority of 2/3 passes with 2 of 3 votes, a majority needs more than half
+# 2. A tie is broken by the order of the options, invalid rules are rejected
+# 3. A poll without its quorum fails, and its snapshot keeps the decision
+class OutcomeTests:
+    def __init__(self, url):
+        self.url = url
+        self.voters = []
+        self.polls = []
+
+    def startup(self):
+        for i in range(3):
+            response = request(APIs['voters'], "POST", {"name": "Outcome", "email": ""})
+            if response.status_code != 201:
+                raise Exception("Startup failed - voter not created")
+            self.voters.append(response.json()['id'])
+
+    def poll(self, decision, options):
+        poll = {"title": "Outcome", "question": "Outcome", "options": [{"id": 1, "text": "Yes"}, {"id": 2, "text": "No"}], "decision": decision}
+        response = request(APIs['polls'], "POST", poll)
+        if response.status_code != 201:
+            raise Exception("Poll not created " + response.text)
+        pollId = response.json()['id']
+        self.polls.append(pollId)
+        for voterId, option in zip(self.voters, options):
+            response = request(self.url, "POST", {"pollId": pollId, "voterId": voterId, "voteValue": option})
+            if response.status_code != 201:
+                raise Exception("Vote not cast " + response.text)
+        return pollId
+
+    def decision(self, pollId):
+        response = request(APIs['polls'] + "/" + str(pollId) + "/results", "GET")
+        if response.status_code != 200:
+            raise Exception("No results " + response.text)
+        return response.json()['outcome']['decision']
+
+    def test1(self):
+        decision = self.decision(self.poll({"rule": "supermajority", "threshold": "2/3"}, [1, 1, 2]))
+        if not decision['passed'] or decision['winner'] != 1 or decision['support'] != 2 or decision['votes'] != 3:
+            raise Exception("Test 1 failed - supermajority not passed " + str(decision))
+
+        decision = self.decision(self.poll({"rule": "majority"}, [1, 2]))
+        if decision['passed'] or decision['winner'] is not None or decision['tie']['options'] != [1, 2]:
+            raise Exception("Test 1 failed - tie passed a majority " + str(decision))
+
+    def test2(self):
+        decision = self.decision(self.poll({"rule": "plurality", "tieBreak": "order"}, [2, 1]))
+        if not decision['passed'] or decision['winner'] != 1 or decision['tie']['brokenBy'] != "order":
+            raise Exception("Test 2 failed - tie not broken " + str(decision))
+
+        for rules in [{"rule": "supermajority", "threshold": "1/3"}, {"rule": "unanimous"}, {"tieBreak": "coin"}]:
+            poll = {"title": "Outcome", "question": "Outcome", "options": [{"text": "Yes"}], "decision": rules}
+            response = request(APIs['polls'], "POST", poll)
+            if response.status_code != 400:
+                raise Exception("Test 2 failed - invalid rules accepted " + str(rules))
+
+    def test3(self):
+        pollId = self.poll({"rule": "plurality", "quorumVotes": 5}, [1, 1, 2])
+        decision = self.decision(pollId)
+        if decision['passed'] or decision['quorum']['met'] or decision['quorum']['votes'] != 3:
+            raise Exception("Test 3 failed - quorum met " + str(decision))
+
+        response = request(APIs['polls'] + "/" + str(pollId) + "/close", "POST")
+        if response.status_code != 200:
+            raise Exception("Test 3 failed - poll not closed " + response.text)
+        snapshot = response.json()['snapshot']
+        if snapshot['outcome']['decision']['quorum']['met'] or snapshot['outcome']['decision']['reason'] == "":
+            raise Exception("Test 3 failed - decision not in the snapshot " + response.text)
+
+    def cleanup(self):
+        for pollId in self.polls:
+            response = request(APIs['polls'] + "/" + str(pollId) + "?cascade=true", "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - poll not deleted")
+        for voterId in self.voters:
+            response = request(APIs['voters'] + "/" + str(voterId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+
+
+# Concurrency tests
+# 1. Cast hundreds of votes on one poll in parallel and check the totals
+# 2. Delete all of those votes in parallel and check the totals are back to zero
+# 3. Let each voter vote twice at the same time, only one vote may count
+class ConcurrencyTests:
+    def __init__(self, url, count=300, workers=50):
+        self.url = url
+        self.count = count
+        self.workers = workers
+        self.pollId = 100
+        self.firstId = 1000
+
+    def startup(self):
+        poll = Poll(
+            Id=self.pollId,
+            Title="Concurrency",
+            Question="Concurrency",
+            Options=[
+                PollOption(Id=1, Text="Test"),
+                PollOption(Id=2, Text="Test")
+            ]
+        )
+        url = APIs['polls'] + "/" + str(poll.Id)
+        response = request(url, "POST", poll.model_dump(mode='json'))
+        if response.status_code != 200:
+            raise Exception("Startup failed")
+        for i in range(self.count):
+            voter = Voter(
+                Id=self.firstId + i,
+                Name="Test",
+                Email=""
+            )
+            url = APIs['voters'] + "/" + str(voter.Id)
+            response = request(url, "POST", voter.model_dump(mode='json'))
+            if response.status_code != 200:
+                raise Exception("Startup failed")
+
+    def castVote(self, i):
+        vote = Votes(
+            Id=self.firstId + i,
+            PollId=self.pollId,
+            VoterId=self.firstId + i,
+            VoteValue=i % 2 + 1
+        )
+        url = self.url + "/" + str(vote.Id)
+        return request(url, "POST", vote.model_dump(mode='json')).status_code
+
+    def deleteVote(self, i):
+        url = self.url + "/" + str(self.firstId + i)
+        return request(url, "DELETE").status_code
+
+    def results(self):
+        url = APIs['polls'] + "/" + str(self.pollId) + "/results"
+        response = request(url, "GET")
+        if response.status_code != 200:
+            raise Exception("Could not get results -" + str(response.status_code) + " " + response.text)
+        return [r['votes'] for r in response.json()['results']]
+
+    def test1(self):
+        with ThreadPoolExecutor(max_workers=self.workers) as pool:
+            codes = list(pool.map(self.castVote, range(self.count)))
+        if any(code != 200 for code in codes):
+            raise Exception("Test 1 failed - " + str(len([c for c in codes if c != 200])) + " votes were not cast")
+        results = self.results()
+        expected = [(self.count + 1) // 2, self.count // 2]
+        if results != expected:
+            raise Exception("Test 1 failed - expected " + str(expected) + " got " + str(results))
+        for i in range(self.count):
+            response = request(APIs['voters'] + "/" + str(self.firstId + i), "GET")
+            if len(response.json()['voterPolls']) != 1:
+                raise Exception("Test 1 failed - voter " + str(self.firstId + i) + " history is wrong")
+        print(json.dumps(results))
+
+    def test2(self):
+        with ThreadPoolExecutor(max_workers=self.workers) as pool:
+            codes = list(pool.map(self.deleteVote, range(self.count)))
+        if any(code != 200 for code in codes):
+            raise Exception("Test 2 failed - " + str(len([c for c in codes if c != 200])) + " votes were not deleted")
+        results = self.results()
+        if results != [0, 0]:
+            raise Exception("Test 2 failed - expected [0, 0] got " + str(results))
+        print(json.dumps(results))
+
+    def castDuplicate(self, job):
+        i, copy = job
+        vote = Votes(
+            Id=self.firstId + (copy + 1) * self.count + i,
+            PollId=self.pollId,
+            VoterId=self.firstId + i,
+            VoteValue=copy + 1
+        )
+        url = self.url + "/" + str(vote.Id)
+        return vote.Id, request(url, "POST", vote.model_dump(mode='json')).status_code
+
+    def test3(self):
+        voters = 50
+        jobs = [(i, copy) for i in range(voters) for copy in range(2)]
+        with ThreadPoolExecutor(max_workers=self.workers) as pool:
+            outcomes = list(pool.map(self.castDuplicate, jobs))
+        cast = [voteId for voteId, code in outcomes if code == 200]
+        rejected = [code for voteId, code in outcomes if code == 409]
+        if len(cast) != voters or len(rejected) != voters:
+            raise Exception("Test 3 failed - expected " + str(voters) + " votes and " + str(voters) + " conflicts, got " + str(len(cast)) + " and " + str(len(rejected)))
+        if sum(self.results()) != voters:
+            raise Exception("Test 3 failed - expected " + str(voters) + " votes in the results, got " + str(self.results()))
+        for voteId in cast:
+            response = request(self.url + "/" + str(voteId), "DELETE")
+            if response.status_code != 200:
+                raise Exception("Test 3 failed - vote " + str(voteId) + " not deleted")
+        print(json.dumps(self.results()))
+
+    def cleanup(self):
+        for i in range(self.count):
+            url = APIs['voters'] + "/" + str(self.firstId + i)
+            response = request(url, "DELETE")
+            if response.status_code != 200:
+                raise Exception("Cleanup failed - voter not deleted")
+        url = APIs['polls'] + "/" + str(self.pollId)
+        response = request(url, "DELETE")
+        if response.status_code != 200:
+            raise Exception("Cleanup failed - poll not deleted")
+
+
 def main():
     # run voter tests
     voterTests = VoterTests(APIs['voters'])
@@ -496,6 +1918,8 @@ def main():
     voteTests.test3()
     voteTests.test4()
     voteTests.test5()
+    voteTests.test6()
+    voteTests.test7()
     voteTests.cleanup()
     
     # run integrated tests
@@ -504,7 +1928,121 @@ def main():
     integratedTests.test1()
     integratedTests.test2()
     integratedTests.test3()
+    integratedTests.test4()
     integratedTests.cleanup()
+
+    # run create tests
+    createTests = CreateTests(APIs['votes'])
+    createTests.test1()
+    createTests.test2()
+    createTests.test3()
+    createTests.cleanup()
+
+    # run lifecycle tests
+    lifecycleTests = LifecycleTests(APIs['votes'])
+    lifecycleTests.startup()
+    lifecycleTests.test1()
+    lifecycleTests.test2()
+    lifecycleTests.test3()
+    lifecycleTests.test4()
+    lifecycleTests.cleanup()
+
+    # run ballot tests
+    ballotTests = BallotTests(APIs['votes'])
+    ballotTests.startup()
+    ballotTests.test1()
+    ballotTests.test2()
+    ballotTests.test3()
+    ballotTests.test4()
+    ballotTests.cleanup()
+
+    # run option tests
+    optionTests = OptionTests(APIs['votes'])
+    optionTests.startup()
+    optionTests.test1()
+    optionTests.test2()
+    optionTests.cleanup()
+
+    # run revision tests
+    revisionTests = RevisionTests(APIs['votes'])
+    revisionTests.startup()
+    revisionTests.test1()
+    revisionTests.test2()
+    revisionTests.test3()
+    revisionTests.test4()
+    revisionTests.cleanup()
+
+    # run stream tests
+    streamTests = StreamTests(APIs['votes'])
+    streamTests.startup()
+    streamTests.test1()
+    streamTests.test2()
+    streamTests.test3()
+    streamTests.cleanup()
+
+    # run webhook tests
+    webhookTests = WebhookTests(APIs['webhooks'])
+    webhookTests.startup()
+    webhookTests.test1()
+    webhookTests.test2()
+    webhookTests.test3()
+    webhookTests.test4()
+    webhookTests.cleanup()
+
+    # run ledger tests
+    ledgerTests = LedgerTests(APIs['votes'])
+    ledgerTests.startup()
+    ledgerTests.test1()
+    ledgerTests.test2()
+    ledgerTests.test3()
+    ledgerTests.cleanup()
+
+    # run recount tests
+    recountTests = RecountTests(APIs['votes'])
+    recountTests.startup()
+    recountTests.test1()
+    recountTests.test2()
+    recountTests.test3()
+    recountTests.cleanup()
+
+    # run export tests
+    exportTests = ExportTests(APIs['votes'])
+    exportTests.startup()
+    exportTests.test1()
+    exportTests.test2()
+    exportTests.test3()
+    exportTests.cleanup()
+
+    # run import tests
+    importTests = ImportTests(APIs['votes'])
+    importTests.test1()
+    importTests.test2()
+    importTests.test3()
+    importTests.cleanup()
+
+    # run analytics tests
+    analyticsTests = AnalyticsTests(APIs['votes'])
+    analyticsTests.startup()
+    analyticsTests.test1()
+    analyticsTests.test2()
+    analyticsTests.test3()
+    analyticsTests.cleanup()
+
+    # run outcome tests
+    outcomeTests = OutcomeTests(APIs['votes'])
+    outcomeTests.startup()
+    outcomeTests.test1()
+    outcomeTests.test2()
+    outcomeTests.test3()
+    outcomeTests.cleanup()
+
+    # run concurrency tests
+    concurrencyTests = ConcurrencyTests(APIs['votes'])
+    concurrencyTests.startup()
+    concurrencyTests.test1()
+    concurrencyTests.test2()
+    concurrencyTests.test3()
+    concurrencyTests.cleanup()
     
 
 def makeSampleDB():
@@ -8,6 +8,7 @@ APIs = {
     'polls': 'http://localhost:1082/polls',
     'voters': 'http://localhost:1081/voters',
     'votes': 'http://localhost:1080/votes',
+    'webhooks': 'http://localhost:1082/webhooks',
 }
 
 class Link(BaseModel):
@@ -42,6 +43,12 @@ class Poll(BaseModel):
     Question: str
     Options: Optional[List[PollOption]]
     Results: Optional[List[Results]] = None
+    Ballot: Any = None
+    State: Optional[str] = None
+    OpensAt: Optional[datetime] = None
+    ClosesAt: Optional[datetime] = None
+    Revision: Optional[int] = None
+    EditPolicy: Optional[str] = None
     Links: Optional[Links] = None
     Embedded:  Any = None
     Meta: Optional[Meta] = None
@@ -65,6 +72,8 @@ class Votes(BaseModel):
     PollId: int
     VoterId: int
     VoteValue: int
+    Ballot: Any = None
+    PollRevision: Optional[int] = None
     Links: Optional[Links] = None
     Embedded:  Any = None
     Meta: Optional[Meta] = None
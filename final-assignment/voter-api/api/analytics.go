@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+)
+
+// The number of registered voters, for the turnout of a poll, is one ZCARD
+// of the index of voter ids, see index.go.
+
+// the timeline of a voter is by day, unless ?interval= says otherwise
+const defaultVoterInterval = schema.IntervalDay
+
+// GetVoterAnalytics handles GET /voters/:voterId/analytics, the statistics
+// of the voter across polls, from their history. ?interval= is minute, hour
+// or day.
+func (v *VotersAPI) GetVoterAnalytics(c *gin.Context) {
+	id := c.Param("voterId")
+	if _, err := strconv.Atoi(id); err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid voter id"})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", defaultVoterInterval)
+	if err := schema.CheckInterval(interval); err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	var voter schema.Voter
+	err := getItemFromRedis(id, v, &voter)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find voter with id=" + id})
+		return
+	}
+
+	polls, err := v.client.ZCard(v.context, schema.PollIdsKey).Result()
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not count the polls\n" + err.Error()})
+		return
+	}
+
+	analytics := schema.NewVoterAnalytics(&voter, int(polls), interval)
+	analytics.Links.Self.Href = v.API.Self + "/voters/" + id + "/analytics"
+	analytics.Links.Voter.Href = v.API.Self + "/voters/" + id
+	analytics.Links.Votes.Href = v.API.Votes + "/voters/" + id
+
+	v.validCall()
+	c.JSON(http.StatusOK, analytics)
+}
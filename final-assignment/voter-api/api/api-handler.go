@@ -3,22 +3,36 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
-	"drexel.edu/voters/schema"
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"drexel.edu/service"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/go-resty/resty/v2"
 	"github.com/nitishm/go-rejson/v4"
 )
 
 const (
 	RedisKeyPrefix = "voters:"
+
+	// number of times an optimistic update is retried before giving up
+	maxUpdateRetries = 50
+
+	// the events of voter changes wait here for the relay, the key does
+	// not end in a number so service.ScanIds skips it
+	outboxKey = RedisKeyPrefix + "outbox"
 )
 
+var errVoteNotFound = errors.New("vote not found in voter history")
+
 type cache struct {
 	client  *redis.Client
 	helper  *rejson.Handler
@@ -40,8 +54,11 @@ type API struct {
 
 type VotersAPI struct {
 	cache
-	health Health
-	API    API
+	health      Health
+	apiClient   *resty.Client
+	outbox      *events.Outbox
+	API         API
+	InternalAPI API
 }
 
 func (v *VotersAPI) validCall() {
@@ -56,8 +73,15 @@ func (v *VotersAPI) invalidCall() {
 	v.health.mu.Unlock()
 }
 
-func New(location string, api API) (*VotersAPI, error) {
+// Idempotent adds Idempotency-Key support to a POST handler, see
+// service.Idempotent
+func (v *VotersAPI) Idempotent(next gin.HandlerFunc) gin.HandlerFunc {
+	return service.Idempotent(v.context, v.client, RedisKeyPrefix, next)
+}
+
+func New(location string, api API, internalAPI API) (*VotersAPI, error) {
 
+	apiClient := resty.New()
 	//Connect to redis.  Other options can be provided, but the
 	//defaults are OK
 	client := redis.NewClient(&redis.Options{
@@ -92,6 +116,9 @@ func New(location string, api API) (*VotersAPI, error) {
 			totalApiCallsWithErrors: 0,
 			totalValidApiCalls:      0,
 		},
+		InternalAPI: internalAPI,
+		apiClient:   apiClient,
+		outbox:      events.NewOutbox(client, outboxKey, "voter-api"),
 	}, nil
 }
 
@@ -131,6 +158,14 @@ func (v *VotersAPI) GetVoter(c *gin.Context) {
 		return
 	}
 
+	embed, err := service.ParseEmbed(c, service.EmbedNone)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := service.ParseFields(c)
+
 	err = getItemFromRedis(id, v, &voter)
 	if err != nil {
 		c.JSON(http.StatusBadRequest,
@@ -142,30 +177,82 @@ func (v *VotersAPI) GetVoter(c *gin.Context) {
 	}
 
 	genHalJSONResponse(&voter, v)
+
+	if embed.Poll {
+		err = v.embedPolls(&voter)
+		if err != nil {
+			v.invalidCall()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not embed polls\n" + err.Error()})
+			return
+		}
+	}
+
+	result, err := schema.SelectFields(voter, fields)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
+	}
+
 	v.validCall()
-	c.JSON(http.StatusOK, voter)
+	c.JSON(http.StatusOK, result)
 }
 func (v *VotersAPI) GetVoters(c *gin.Context) {
-	voterKey := RedisKeyPrefix + "*"
-	var voterList []schema.Voter
-	var voter schema.Voter
+	req, err := service.ParsePageRequest(c)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	embed, err := service.ParseEmbed(c, service.EmbedNone)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := service.ParseFields(c)
 
-	ks, _ := v.client.Keys(v.context, voterKey).Result()
-	for _, key := range ks {
-		err := getItemFromRedis(key, v, &voter)
+	pg, err := service.PageIds(v.context, v.client, schema.VoterIdsKey, req)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list voters in cache"})
+		return
+	}
+
+	voterList := make([]schema.Voter, 0, len(pg.Ids))
+	for _, id := range pg.Ids {
+		var voter schema.Voter
+		err := getItemFromRedis(strconv.Itoa(id), v, &voter)
 		if err != nil {
 			v.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find vote in cache with id=" + key})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter in cache with id=" + strconv.Itoa(id)})
 			return
 		}
 		//generate the latest HAL JSON response
 		genHalJSONResponse(&voter, v)
+		if embed.Poll {
+			err = v.embedPolls(&voter)
+			if err != nil {
+				v.invalidCall()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not embed polls\n" + err.Error()})
+				return
+			}
+		}
 		voterList = append(voterList, voter)
 	}
 
+	result, err := schema.SelectFieldsList(voterList, fields)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
+	}
+
 	v.validCall()
-	c.JSON(http.StatusOK, voterList)
+	c.JSON(http.StatusOK, schema.NewList(v.API.Self+"/voters", "voters", result, pg, req))
 }
+
 func (v *VotersAPI) PostVoter(c *gin.Context) {
 	var voter schema.Voter
 	id := c.Param("voterId")
@@ -175,7 +262,7 @@ func (v *VotersAPI) PostVoter(c *gin.Context) {
 		return
 	}
 
-	_, err := strconv.Atoi(id)
+	voterId, err := strconv.Atoi(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg": "Invalid voter id",
@@ -184,18 +271,35 @@ func (v *VotersAPI) PostVoter(c *gin.Context) {
 		return
 	}
 
-	// confirm that the voter does not exist
-	err = getItemFromRedis(id, v, &voter)
-	if err == nil {
+	// bind the request body into a voter struct
+	err = c.BindJSON(&voter)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"msg": "Voter already exists",
+			"msg": "Error unmarshalling voter\n" + err.Error(),
 		})
 		v.invalidCall()
 		return
 	}
 
-	// bind the request body into a voter struct
-	err = c.BindJSON(&voter)
+	// the id in the url is the one that counts
+	if voter.Id == 0 {
+		voter.Id = voterId
+	}
+	if voter.Id != voterId {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Voter id in the body does not match the url",
+		})
+		v.invalidCall()
+		return
+	}
+
+	v.createVoter(c, &voter, http.StatusOK)
+}
+
+// CreateVoter handles POST /voters, the id is allocated by the api
+func (v *VotersAPI) CreateVoter(c *gin.Context) {
+	var voter schema.Voter
+	err := c.BindJSON(&voter)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg": "Error unmarshalling voter\n" + err.Error(),
@@ -204,14 +308,48 @@ func (v *VotersAPI) PostVoter(c *gin.Context) {
 		return
 	}
 
-	voter.VoterPolls = []schema.VoterPoll{}
-	voter.Meta.TotalVotes = 0
-	voter.Meta.CreatedAt = time.Now()
-	voter.Meta.UpdatedAt = time.Now()
+	if voter.Id != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "The voter id is assigned by the api, use POST /voters/:voterId to pick one",
+		})
+		v.invalidCall()
+		return
+	}
 
-	genHalJSONResponse(&voter, v)
+	voter.Id, err = service.NextId(v.context, v.client, RedisKeyPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error allocating voter id\n" + err.Error(),
+		})
+		v.invalidCall()
+		return
+	}
+
+	v.createVoter(c, &voter, http.StatusCreated)
+}
 
-	err = v.saveVoter(&voter)
+// createVoter starts the history of a new voter, saves it and sends it with
+// status. With 201 the Location header is set as well, and the voter gets
+// the next id if its id was taken in the meantime.
+func (v *VotersAPI) createVoter(c *gin.Context, voter *schema.Voter, status int) {
+	v.prepareVoter(voter)
+
+	err := v.saveVoter(voter, events.VoterCreated)
+	for status == http.StatusCreated && errors.Is(err, service.ErrExists) {
+		// a client picked the id with POST /voters/:voterId
+		voter.Id, err = service.NextId(v.context, v.client, RedisKeyPrefix)
+		if err == nil {
+			genHalJSONResponse(voter, v)
+			err = v.saveVoter(voter, events.VoterCreated)
+		}
+	}
+	if errors.Is(err, service.ErrExists) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Voter already exists",
+		})
+		v.invalidCall()
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"msg": "Error saving voter to cache",
@@ -220,11 +358,16 @@ func (v *VotersAPI) PostVoter(c *gin.Context) {
 		return
 	}
 
+	if status == http.StatusCreated {
+		c.Header("Location", voter.Links.Self.Href)
+	}
 	v.validCall()
-	c.JSON(http.StatusOK, voter)
+	c.JSON(status, voter)
 }
+
+// UpdateVoter replaces the name and email of a voter. The history and the
+// vote count are kept as they are stored, they only change with the votes.
 func (v *VotersAPI) UpdateVoter(c *gin.Context) {
-	var voter schema.Voter
 	id := c.Param("voterId")
 	if id == "" {
 		v.invalidCall()
@@ -232,7 +375,7 @@ func (v *VotersAPI) UpdateVoter(c *gin.Context) {
 		return
 	}
 
-	_, err := strconv.Atoi(id)
+	voterId, err := strconv.Atoi(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg": "Invalid voter id",
@@ -241,17 +384,6 @@ func (v *VotersAPI) UpdateVoter(c *gin.Context) {
 		return
 	}
 
-	// get the old voter
-	err = getItemFromRedis(id, v, &voter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{
-				"msg": "No such voter in the cache\n" + err.Error(),
-			})
-		v.invalidCall()
-		return
-	}
-
 	var newVoter schema.Voter
 	err = c.BindJSON(&newVoter)
 	if err != nil {
@@ -262,25 +394,44 @@ func (v *VotersAPI) UpdateVoter(c *gin.Context) {
 		return
 	}
 
-	// updating the voter should reset the votes.
-	newVoter.Meta.TotalVotes = 0
-	newVoter.Meta.CreatedAt = voter.Meta.CreatedAt
-	newVoter.Meta.UpdatedAt = time.Now()
-
-	genHalJSONResponse(&newVoter, v)
+	// the id in the url is the one that counts
+	if newVoter.Id == 0 {
+		newVoter.Id = voterId
+	}
+	if newVoter.Id != voterId {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Voter id in the body does not match the url",
+		})
+		v.invalidCall()
+		return
+	}
 
-	err = v.saveVoter(&newVoter)
+	voter, err := v.updateVoterAtomic(id, events.VoterUpdated, func(voter *schema.Voter) error {
+		updated := newVoter
+		updated.VoterPolls = voter.VoterPolls
+		updated.Meta = voter.Meta
+		*voter = updated
+		return nil
+	})
+	if err == redis.Nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"msg": "No such voter in the cache",
+		})
+		v.invalidCall()
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"msg": "Error saving voter to cache",
+			"msg": "Error saving voter to cache\n" + err.Error(),
 		})
 		v.invalidCall()
 		return
 	}
 
 	v.validCall()
-	c.JSON(http.StatusOK, newVoter)
+	c.JSON(http.StatusOK, voter)
 }
+
 func (v *VotersAPI) DeleteVoter(c *gin.Context) {
 	id := c.Param("voterId")
 	if id == "" {
@@ -298,8 +449,23 @@ func (v *VotersAPI) DeleteVoter(c *gin.Context) {
 		return
 	}
 
-	voterKey := RedisKeyPrefix + id
-	_, err = v.helper.JSONDel(voterKey, ".")
+	// with ?cascade=true the votes of the voter are deleted first, and taken
+	// back out of the poll results. If that fails the voter is kept, so the
+	// delete can be retried.
+	var report json.RawMessage
+	if c.Query("cascade") == "true" {
+		report, err = v.deleteVotes(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"msg":     "Error deleting the votes of the voter\n" + err.Error(),
+				"cascade": report,
+			})
+			v.invalidCall()
+			return
+		}
+	}
+
+	err = v.deleteVoter(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"msg": "Error deleting voter from cache",
@@ -309,20 +475,408 @@ func (v *VotersAPI) DeleteVoter(c *gin.Context) {
 	}
 
 	v.validCall()
+	if report != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"msg":     "Voter deleted",
+			"cascade": report,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"msg": "Voter deleted",
 	})
 }
 
-func (v *VotersAPI) saveVoter(voter *schema.Voter) error {
-	// save vote in redis with votes:<id> as key
+// deleteVotes asks the votes api to delete every vote of the voter, and
+// returns its report of what was removed.
+func (v *VotersAPI) deleteVotes(id string) (json.RawMessage, error) {
+	votesUrl := v.InternalAPI.Votes + "/voters/" + id
+	resp, err := v.apiClient.R().Delete(votesUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var report json.RawMessage
+	if len(resp.Body()) > 0 {
+		report = json.RawMessage(resp.Body())
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return report, fmt.Errorf("votes api returned %d", resp.StatusCode())
+	}
+
+	return report, nil
+}
+
+// embedPolls puts the polls the voter voted on in _embedded. Polls that no
+// longer exist are left out.
+func (v *VotersAPI) embedPolls(voter *schema.Voter) error {
+	polls := []schema.Poll{}
+	seen := make(map[int]bool)
+	for _, vp := range voter.VoterPolls {
+		if seen[vp.PollId] {
+			continue
+		}
+		seen[vp.PollId] = true
+
+		pollUrl := v.InternalAPI.Polls + "/" + strconv.Itoa(vp.PollId)
+		resp, err := v.apiClient.R().Get(pollUrl)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode() != http.StatusOK {
+			continue
+		}
+
+		var poll schema.Poll
+		err = json.Unmarshal(resp.Body(), &poll)
+		if err != nil {
+			return err
+		}
+		polls = append(polls, poll)
+	}
+
+	voter.Embedded = gin.H{"polls": polls}
+	return nil
+}
+
+// AddVoterPoll appends a single poll/vote entry to the voter's history and
+// bumps the vote count, without the caller having to send the whole voter.
+// An entry for a vote that is already in the history is replaced.
+func (v *VotersAPI) AddVoterPoll(c *gin.Context) {
+	id := c.Param("voterId")
+	if id == "" {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		return
+	}
+
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid voter id",
+		})
+		v.invalidCall()
+		return
+	}
+
+	var voterPoll schema.VoterPoll
+	err = c.ShouldBindJSON(&voterPoll)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Error unmarshalling voter poll\n" + err.Error(),
+		})
+		v.invalidCall()
+		return
+	}
+
+	if voterPoll.VotedAt.IsZero() {
+		voterPoll.VotedAt = time.Now()
+	}
+
+	voter, err := v.updateVoterAtomic(id, "", func(voter *schema.Voter) error {
+		// the votes api may put an entry back twice when it undoes a delete
+		for i, vp := range voter.VoterPolls {
+			if vp.VoteId == voterPoll.VoteId {
+				voter.VoterPolls[i] = voterPoll
+				return nil
+			}
+		}
+		voter.VoterPolls = append(voter.VoterPolls, voterPoll)
+		voter.Meta.TotalVotes++
+		return nil
+	})
+	if err == redis.Nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"msg": "No such voter in the cache",
+		})
+		v.invalidCall()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error updating voter\n" + err.Error(),
+		})
+		v.invalidCall()
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, voter)
+}
+
+// RemoveVoterPoll removes the history entry for a vote from the voter.
+func (v *VotersAPI) RemoveVoterPoll(c *gin.Context) {
+	id := c.Param("voterId")
+	if id == "" {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		return
+	}
+
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid voter id",
+		})
+		v.invalidCall()
+		return
+	}
+
+	voteId, err := strconv.Atoi(c.Param("voteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid vote id",
+		})
+		v.invalidCall()
+		return
+	}
+
+	voter, err := v.updateVoterAtomic(id, "", func(voter *schema.Voter) error {
+		for i, vp := range voter.VoterPolls {
+			if vp.VoteId == voteId {
+				voter.VoterPolls = append(voter.VoterPolls[:i], voter.VoterPolls[i+1:]...)
+				voter.Meta.TotalVotes--
+				return nil
+			}
+		}
+		return errVoteNotFound
+	})
+	if err == redis.Nil || err == errVoteNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"msg": "No such voter or vote in the cache",
+		})
+		v.invalidCall()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error updating voter\n" + err.Error(),
+		})
+		v.invalidCall()
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, voter)
+}
+
+// UpdateVoterPoll replaces the history entry for a vote, used when a voter
+// changes their vote.
+func (v *VotersAPI) UpdateVoterPoll(c *gin.Context) {
+	id := c.Param("voterId")
+	if id == "" {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		return
+	}
+
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid voter id",
+		})
+		v.invalidCall()
+		return
+	}
+
+	voteId, err := strconv.Atoi(c.Param("voteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Invalid vote id",
+		})
+		v.invalidCall()
+		return
+	}
+
+	var voterPoll schema.VoterPoll
+	err = c.ShouldBindJSON(&voterPoll)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"msg": "Error unmarshalling voter poll\n" + err.Error(),
+		})
+		v.invalidCall()
+		return
+	}
+
+	voterPoll.VoteId = voteId
+	if voterPoll.VotedAt.IsZero() {
+		voterPoll.VotedAt = time.Now()
+	}
+
+	voter, err := v.updateVoterAtomic(id, "", func(voter *schema.Voter) error {
+		for i, vp := range voter.VoterPolls {
+			if vp.VoteId == voteId {
+				voter.VoterPolls[i] = voterPoll
+				return nil
+			}
+		}
+		return errVoteNotFound
+	})
+	if err == redis.Nil || err == errVoteNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"msg": "No such voter or vote in the cache",
+		})
+		v.invalidCall()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "Error updating voter\n" + err.Error(),
+		})
+		v.invalidCall()
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, voter)
+}
+
+// updateVoterAtomic applies fn to the stored voter using WATCH/MULTI, so the
+// write only goes through if nobody else changed the voter in the meantime.
+// On a conflict the voter is re-read and fn is applied again. An eventType
+// other than "" is recorded with the write.
+func (v *VotersAPI) updateVoterAtomic(id string, eventType string, fn func(voter *schema.Voter) error) (schema.Voter, error) {
+	var voter schema.Voter
+	voterKey := RedisKeyPrefix + id
+
+	txf := func(tx *redis.Tx) error {
+		get := redis.NewStringCmd(v.context, "JSON.GET", voterKey, ".")
+		err := tx.Process(v.context, get)
+		if err != nil {
+			return err
+		}
+		voterJSON := get.Val()
+
+		voter = schema.Voter{}
+		err = json.Unmarshal([]byte(voterJSON), &voter)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&voter)
+		if err != nil {
+			return err
+		}
+		voter.Meta.UpdatedAt = time.Now()
+		genHalJSONResponse(&voter, v)
+
+		newJSON, err := json.Marshal(voter)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(v.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(v.context, "JSON.SET", voterKey, ".", string(newJSON))
+			if eventType == "" {
+				return nil
+			}
+			return v.outbox.Add(v.context, pipe, eventType, voterSubject(voter.Id), gin.H{"voter": voter})
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := v.client.Watch(v.context, txf, voterKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return voter, err
+	}
+
+	return voter, redis.TxFailedErr
+}
+
+// prepareVoter starts the history and meta of a new voter, and sets its
+// links
+func (v *VotersAPI) prepareVoter(voter *schema.Voter) {
+	voter.VoterPolls = []schema.VoterPoll{}
+	voter.Meta.TotalVotes = 0
+	voter.Meta.CreatedAt = time.Now()
+	voter.Meta.UpdatedAt = time.Now()
+
+	genHalJSONResponse(voter, v)
+}
+
+// saveVoter stores a new voter and its event in one transaction, it returns
+// service.ErrExists if the id is taken
+func (v *VotersAPI) saveVoter(voter *schema.Voter, eventType string) error {
+	key := RedisKeyPrefix + strconv.Itoa(voter.Id)
+	_, err := service.Create(v.context, v.client, []string{key}, func(pipe redis.Pipeliner, taken []bool) error {
+		if taken[0] {
+			return service.ErrExists
+		}
+		return v.writeVoter(pipe, voter, eventType)
+	})
+	return err
+}
+
+// writeVoter adds the commands that store the voter and its event to pipe. A
+// new voter is only set if its key does not exist.
+func (v *VotersAPI) writeVoter(pipe redis.Pipeliner, voter *schema.Voter, eventType string) error {
+	// save voter in redis with voters:<id> as key
 	cacheKey := RedisKeyPrefix + strconv.Itoa(voter.Id)
-	_, err := v.helper.JSONSet(cacheKey, ".", voter)
+	voterJSON, err := json.Marshal(voter)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if eventType == events.VoterCreated {
+		pipe.Do(v.context, "JSON.SET", cacheKey, ".", string(voterJSON), "NX")
+		service.Index(v.context, pipe, schema.VoterIdsKey, voter.Id)
+	} else {
+		pipe.Do(v.context, "JSON.SET", cacheKey, ".", string(voterJSON))
+	}
+	return v.outbox.Add(v.context, pipe, eventType, voterSubject(voter.Id), gin.H{"voter": voter})
+}
+
+// deleteVoter deletes the voter, and records voter.deleted with the voter
+// as it was. Deleting a voter that does not exist does nothing.
+func (v *VotersAPI) deleteVoter(id string) error {
+	voterKey := RedisKeyPrefix + id
+
+	txf := func(tx *redis.Tx) error {
+		get := redis.NewStringCmd(v.context, "JSON.GET", voterKey, ".")
+		err := tx.Process(v.context, get)
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var voter schema.Voter
+		err = json.Unmarshal([]byte(get.Val()), &voter)
+		if err != nil {
+			return err
+		}
+		genHalJSONResponse(&voter, v)
+
+		_, err = tx.TxPipelined(v.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(v.context, "JSON.DEL", voterKey, ".")
+			service.Unindex(v.context, pipe, schema.VoterIdsKey, voter.Id)
+			return v.outbox.Add(v.context, pipe, events.VoterDeleted, voterSubject(voter.Id), gin.H{"voter": voter})
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := v.client.Watch(v.context, txf, voterKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return err
+	}
+	return redis.TxFailedErr
+}
+
+func voterSubject(id int) string {
+	return "voters/" + strconv.Itoa(id)
+}
+
+// StartEventRelay moves the events of the voter api to the event stream in
+// the background
+func (v *VotersAPI) StartEventRelay() {
+	go v.outbox.Relay(v.context)
 }
 
 func getItemFromRedis(id string, p *VotersAPI, voter *schema.Voter) error {
@@ -339,9 +893,11 @@ func getItemFromRedis(id string, p *VotersAPI, voter *schema.Voter) error {
 	return nil
 }
 
+// endpoints are the public urls the shared link builders work from
+func (v *VotersAPI) endpoints() schema.Endpoints {
+	return schema.Endpoints{Polls: v.API.Polls, Voters: v.API.Self + "/voters", Votes: v.API.Votes}
+}
+
 func genHalJSONResponse(voter *schema.Voter, v *VotersAPI) {
-	voter.Links.Self.Href = v.API.Self + "/voters/" + strconv.Itoa(voter.Id)
-	voter.Links.Polls.Href = v.API.Polls
-	voter.Links.Votes.Href = v.API.Votes + "/voters/" + strconv.Itoa(voter.Id)
-	voter.Links.Vote.Href = v.API.Votes + "/voters/" + strconv.Itoa(voter.Id)
+	schema.SetVoterLinks(v.endpoints(), voter)
 }
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// ImportVoters handles POST /voters/import. The body is NDJSON or CSV (see
+// schema.ImportReader), a voter per row. A row with an id is created as
+// POST /voters/:voterId would, one without as POST /voters. Every batch of
+// rows is written in one transaction. With ?dryRun=true the rows are only
+// checked.
+func (v *VotersAPI) ImportVoters(c *gin.Context) {
+	format, err := schema.ImportFormat(c.Query("format"), c.ContentType())
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	reader, err := schema.NewImportReader(c.Request.Body, format, &schema.Voter{})
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Could not read import\n" + err.Error()})
+		return
+	}
+
+	report := schema.NewImportReport(c.Query("dryRun") == "true")
+	seen := map[int]bool{}
+	check := func(voter *schema.Voter) error {
+		if voter.Id < 0 {
+			return errors.New("invalid voter id")
+		}
+		if voter.Id == 0 {
+			return nil
+		}
+		if seen[voter.Id] {
+			return fmt.Errorf("voter %d is in the import twice", voter.Id)
+		}
+		seen[voter.Id] = true
+
+		// confirm that the voter does not exist
+		exists, err := v.client.Exists(v.context, RedisKeyPrefix+strconv.Itoa(voter.Id)).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return errors.New("voter already exists")
+		}
+		return nil
+	}
+	write := func(voters []*schema.Voter) []error {
+		for _, voter := range voters {
+			if voter.Id != 0 {
+				continue
+			}
+			// an id the counter hands out may be picked by a later row
+			var err error
+			for voter.Id == 0 || seen[voter.Id] {
+				voter.Id, err = service.NextId(v.context, v.client, RedisKeyPrefix)
+				if err != nil {
+					return schema.BatchErrors(len(voters), err)
+				}
+			}
+			seen[voter.Id] = true
+		}
+
+		keys := make([]string, len(voters))
+		for i, voter := range voters {
+			keys[i] = RedisKeyPrefix + strconv.Itoa(voter.Id)
+		}
+		// a row whose key was taken since it was checked fails on its own
+		taken, err := service.Create(v.context, v.client, keys, func(pipe redis.Pipeliner, taken []bool) error {
+			for i, voter := range voters {
+				if taken[i] {
+					continue
+				}
+				v.prepareVoter(voter)
+				err := v.writeVoter(pipe, voter, events.VoterCreated)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return schema.BatchErrors(len(voters), err)
+		}
+		return schema.ClaimErrors(taken, errors.New("voter already exists"))
+	}
+
+	err = schema.ImportRows(reader, &report, check, write, func(voter *schema.Voter) int { return voter.Id })
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Could not read import\n" + err.Error(), "report": report})
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"drexel.edu/schema"
+	"drexel.edu/service"
+)
+
+// The ids of the voters are kept in the sorted set schema.VoterIdsKey, the
+// index the list of voters is paged from, see service.PageIds. A voter is
+// added in the transaction that creates it and removed in the one that
+// deletes it.
+
+// TrackIds adds the voters that are stored to the index of voter ids, for
+// voters created before the index was kept. Run it before the api serves
+// requests, see service.TrackIds.
+func (v *VotersAPI) TrackIds() error {
+	return service.TrackIds(v.context, v.client, RedisKeyPrefix, schema.VoterIdsKey)
+}
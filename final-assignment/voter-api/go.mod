@@ -3,6 +3,9 @@ module drexel.edu/voters
 go 1.20
 
 require (
+	drexel.edu/events v0.0.0
+	drexel.edu/schema v0.0.0
+	drexel.edu/service v0.0.0
 	github.com/gin-contrib/cors v1.4.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-redis/redis/v8 v8.11.5
@@ -39,3 +42,9 @@ require (
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	drexel.edu/events v0.0.0 => ../events
+	drexel.edu/schema v0.0.0 => ../schema
+	drexel.edu/service v0.0.0 => ../service
+)
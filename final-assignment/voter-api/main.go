@@ -15,6 +15,8 @@ var (
 	hostFlag string
 	cacheURL string
 	portFlag uint
+	votesURL string
+	pollsURL string
 )
 
 func processCmdLineFlags() {
@@ -24,6 +26,9 @@ func processCmdLineFlags() {
 	flag.UintVar(&portFlag, "p", 1081, "Default Port (cannot be changed)")
 	flag.StringVar(&cacheURL, "c", "localhost:6379", "Default cache location")
 
+	// flags for internal api
+	flag.StringVar(&votesURL, "votes", "http://localhost:1080/votes", "Default votes location")
+	flag.StringVar(&pollsURL, "polls", "http://localhost:1082/polls", "Default polls location")
 	flag.Parse()
 }
 
@@ -42,6 +47,8 @@ func setupParms() {
 	//now process any environment variables
 	cacheURL = envVarOrDefault("REDIS_URL", cacheURL)
 	hostFlag = envVarOrDefault("RLAPI_HOST", hostFlag)
+	votesURL = envVarOrDefault("VOTES_API_URL", votesURL)
+	pollsURL = envVarOrDefault("POLL_API_URL", pollsURL)
 
 	// pfNew, err := strconv.Atoi(envVarOrDefault("RLAPI_PORT", fmt.Sprintf("%d", portFlag)))
 	// //only update the port if we were able to convert the env var to an int, else
@@ -65,20 +72,40 @@ func main() {
 		Self:  "http://localhost:1081",
 		Polls: "http://localhost:1082/polls",
 		Votes: "http://localhost:1080/votes",
-	})
+	},
+		api.API{
+			Votes: votesURL,
+			Polls: pollsURL,
+		},
+	)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	// count the voters created before their ids were kept
+	err = apiHandler.TrackIds()
+	if err != nil {
+		log.Println("Could not track the voter ids: " + err.Error())
+	}
+
+	// move voter events from the outbox to the event stream
+	apiHandler.StartEventRelay()
+
 	r.GET("/", apiHandler.GetVoters)
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/voters/health", apiHandler.HealthCheck)
 	r.GET("/voters/:voterId", apiHandler.GetVoter)
+	r.GET("/voters/:voterId/analytics", apiHandler.GetVoterAnalytics)
 	// r.GET("/voters/:voterId/polls/:pollsId", apiHandler.GetPoll)
 	r.GET("/voters", apiHandler.GetVoters)
+	r.POST("/voters", apiHandler.Idempotent(apiHandler.CreateVoter))
+	r.POST("/voters/import", apiHandler.ImportVoters)
 	r.POST("/voters/:voterId", apiHandler.PostVoter)
 	r.PUT("/voters/:voterId", apiHandler.UpdateVoter)
+	r.POST("/voters/:voterId/polls", apiHandler.AddVoterPoll)
+	r.PUT("/voters/:voterId/polls/:voteId", apiHandler.UpdateVoterPoll)
+	r.DELETE("/voters/:voterId/polls/:voteId", apiHandler.RemoveVoterPoll)
 	r.DELETE("/voters/:voterId", apiHandler.DeleteVoter)
 
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"drexel.edu/schema"
+	"github.com/go-redis/redis/v8"
+)
+
+// The activity of a poll (schema.ActivityKey) counts its votes by the minute
+// they were cast in, for the votes over time of the poll analytics. It is
+// changed in the transaction that completes the saga of a vote, with the
+// ledger: a cast adds the vote to its minute and a delete takes it out
+// again. A change keeps the time the vote was cast, so it moves nothing, and
+// a remove leaves the vote in, like the results of its closed poll.
+
+// activityScript adds ARGV[2] to the field ARGV[1] of the activity, and
+// drops fields that reach 0. Votes of a poll that was deleted, with its
+// activity, are not taken out of it, so that it is not made again.
+var activityScript = redis.NewScript(`
+if tonumber(ARGV[2]) < 0 and redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local n = redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2])
+if n <= 0 then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// recordActivity adds the commands that count the vote of a completed saga
+// in the activity of its poll to pipe. votedAt is from the voter history,
+// for votes that have no CreatedAt.
+func (v *VotesAPI) recordActivity(ctx context.Context, pipe redis.Pipeliner, record *ledgerRecord, votedAt time.Time) {
+	var delta int
+	switch record.Action {
+	case schema.LedgerCast:
+		delta = 1
+	case schema.LedgerDelete:
+		delta = -1
+	default:
+		return
+	}
+
+	vote := record.Vote
+	field := schema.ActivityField(schema.CastAt(vote, votedAt))
+	activityScript.Eval(ctx, pipe, []string{schema.ActivityKey(vote.PollId)}, field, delta)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,17 +11,29 @@ import (
 	"sync"
 	"time"
 
-	"drexel.edu/votes/schema"
+	"drexel.edu/client"
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"drexel.edu/service"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
-	"github.com/go-resty/resty/v2"
 	"github.com/nitishm/go-rejson/v4"
+	"github.com/nitishm/go-rejson/v4/rjs"
 )
 
 const (
 	RedisKeyPrefix = "votes:"
+
+	// the events of votes wait here for the relay, the key does not end in
+	// a number so service.ScanIds skips it
+	outboxKey = RedisKeyPrefix + "outbox"
 )
 
+// errNotFound is wrapped by the calls to the other apis when they answer 404
+var errNotFound = errors.New("not found")
+
+var errPollNotOpen = errors.New("poll does not take votes")
+
 type cache struct {
 	client  *redis.Client
 	helper  *rejson.Handler
@@ -43,14 +56,22 @@ type API struct {
 type VotesAPI struct {
 	cache
 	health      Health
-	apiClient   *resty.Client
+	voterClient *client.Client
+	pollClient  *client.Client
 	API         API
 	InternalAPI API
-}
 
-type Embedded struct {
-	Voter schema.Voter
-	Poll  schema.Poll
+	// open while the voter or poll api is failing, see peers.go
+	voterBreaker *client.Breaker
+	pollBreaker  *client.Breaker
+
+	// short lived copies of the voters and polls, used to render votes
+	voterCache *ttlCache[schema.Voter]
+	pollCache  *ttlCache[schema.Poll]
+
+	// vote.cast, vote.changed and vote.deleted are written here when their
+	// saga completes
+	outbox *events.Outbox
 }
 
 func (v *VotesAPI) validCall() {
@@ -65,9 +86,16 @@ func (v *VotesAPI) invalidCall() {
 	v.health.mu.Unlock()
 }
 
+// Idempotent adds Idempotency-Key support to a POST handler, see
+// service.Idempotent
+func (v *VotesAPI) Idempotent(next gin.HandlerFunc) gin.HandlerFunc {
+	return service.Idempotent(v.context, v.client, RedisKeyPrefix, next)
+}
+
 func New(location string, api API, internalAPI API) (*VotesAPI, error) {
 
-	apiClient := resty.New()
+	voterClient, voterBreaker := newPeerClient("voter-api", client.Config{VotersURL: internalAPI.Voters}, voterAPITimeout)
+	pollClient, pollBreaker := newPeerClient("poll-api", client.Config{PollsURL: internalAPI.Polls}, pollAPITimeout)
 	//Connect to redis.  Other options can be provided, but the
 	//defaults are OK
 	client := redis.NewClient(&redis.Options{
@@ -102,8 +130,14 @@ func New(location string, api API, internalAPI API) (*VotesAPI, error) {
 			totalApiCallsWithErrors: 0,
 			totalValidApiCalls:      0,
 		},
-		InternalAPI: internalAPI,
-		apiClient:   apiClient,
+		InternalAPI:  internalAPI,
+		voterClient:  voterClient,
+		pollClient:   pollClient,
+		voterBreaker: voterBreaker,
+		pollBreaker:  pollBreaker,
+		voterCache:   newTTLCache[schema.Voter](embedCacheTTL),
+		pollCache:    newTTLCache[schema.Poll](embedCacheTTL),
+		outbox:       events.NewOutbox(client, outboxKey, "votes-api"),
 	}, nil
 }
 
@@ -112,11 +146,18 @@ func (v *VotesAPI) CrashSim(c *gin.Context) {
 }
 
 func (v *VotesAPI) HealthCheck(c *gin.Context) {
+	dependencies, degraded := v.breakerStatus()
+	status, msg := "ok", "Currently healthy"
+	if degraded {
+		status, msg = "degraded", "A dependency is failing"
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"api":                  "Votes API",
-		"status":               "ok",
+		"status":               status,
 		"uptime":               time.Since(v.health.startTime).String(),
-		"msg":                  "Currently healthy",
+		"msg":                  msg,
+		"dependencies":         dependencies,
 		"totalValidCalls":      v.health.totalValidApiCalls,
 		"totalCallsWithErrors": v.health.totalApiCallsWithErrors,
 		"totalCalls":           v.health.totalApiCallsWithErrors + v.health.totalValidApiCalls,
@@ -131,6 +172,14 @@ func (v *VotesAPI) GetVote(c *gin.Context) {
 		return
 	}
 
+	embed, err := service.ParseEmbed(c, service.EmbedAll)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := service.ParseFields(c)
+
 	cacheKey := "votes:" + id
 	rawVotes, err := v.helper.JSONGet(cacheKey, ".")
 	if err != nil {
@@ -148,44 +197,83 @@ func (v *VotesAPI) GetVote(c *gin.Context) {
 	}
 
 	//generate the latest HAL JSON response
-	err = v.generateHALJSONResponse(&vote)
+	err = v.generateHALJSONResponse(c.Request.Context(), &vote, embed)
+	if v.peerUnavailable(c, err) {
+		return
+	}
 	if err != nil {
 		v.invalidCall()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response"})
 		return
 	}
 
+	result, err := schema.SelectFields(vote, fields)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
+	}
+
 	v.validCall()
-	c.JSON(http.StatusOK, vote)
+	c.JSON(http.StatusOK, result)
 }
 
 func (v *VotesAPI) GetVotes(c *gin.Context) {
+	req, err := service.ParsePageRequest(c)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	var votes []schema.Vote
-	var vote schema.Vote
+	embed, err := service.ParseEmbed(c, service.EmbedAll)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields := service.ParseFields(c)
+
+	pg, err := service.PageIds(c.Request.Context(), v.client, voteIdsKey, req)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list votes in cache"})
+		return
+	}
 
-	//Lets query redis for all of the items
-	pattern := "votes:*"
-	ks, _ := v.client.Keys(v.context, pattern).Result()
-	for _, key := range ks {
+	votes := make([]schema.Vote, 0, len(pg.Ids))
+	for _, id := range pg.Ids {
+		var vote schema.Vote
+		key := redisKeyFromId(id)
 		err := v.getItemFromRedis(key, &vote)
 		if err != nil {
 			v.invalidCall()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find vote in cache with id=" + key})
 			return
 		}
-		//generate the latest HAL JSON response
-		err = v.generateHALJSONResponse(&vote)
-		if err != nil {
-			v.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response\n" + err.Error()})
-			return
-		}
 		votes = append(votes, vote)
 	}
 
+	//generate the latest HAL JSON response, each voter and poll is looked up once
+	err = v.newResolver(c.Request.Context(), embed).resolve(votes)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response\n" + err.Error()})
+		return
+	}
+
+	result, err := schema.SelectFieldsList(votes, fields)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
+	}
+
 	v.validCall()
-	c.JSON(http.StatusOK, votes)
+	c.JSON(http.StatusOK, schema.NewList(v.API.Self+"/votes", "votes", result, pg, req))
 }
 
 func (v *VotesAPI) GetVotesByPolls(c *gin.Context) {
@@ -205,38 +293,42 @@ func (v *VotesAPI) GetVotesByPolls(c *gin.Context) {
 		return
 	}
 
-	var votes []schema.Vote
-	var vote schema.Vote
-	// get all votes
-	pattern := "votes:*"
-	ks, _ := v.client.Keys(v.context, pattern).Result()
-	for _, key := range ks {
-		err := v.getItemFromRedis(key, &vote)
-		if err != nil {
-			v.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find vote in cache with id=" + key})
-			return
-		}
-		//generate the latest HAL JSON response
-		err = v.generateHALJSONResponse(&vote)
-		if err != nil {
-			v.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response\n" + err.Error()})
-			return
-		}
-		votes = append(votes, vote)
+	// only the votes in the poll index are read
+	embed, err := service.ParseEmbed(c, service.EmbedAll)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	fields := service.ParseFields(c)
 
-	// find all votes with pollId
-	var votesWithPollId []schema.Vote
-	for _, vote := range votes {
-		if vote.PollId == pollId {
-			votesWithPollId = append(votesWithPollId, vote)
-		}
+	votes, err := v.getVotesFromIndex(pollIndexKey(pollId))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read votes from cache\n" + err.Error()})
+		return
+	}
+
+	//generate the latest HAL JSON response, each voter and poll is looked up once
+	err = v.newResolver(c.Request.Context(), embed).resolve(votes)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response\n" + err.Error()})
+		return
+	}
+
+	result, err := schema.SelectFieldsList(votes, fields)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
 	}
 
 	v.validCall()
-	c.JSON(http.StatusOK, votesWithPollId)
+	c.JSON(http.StatusOK, result)
 }
 
 func (v *VotesAPI) GetVotesByVoter(c *gin.Context) {
@@ -256,38 +348,42 @@ func (v *VotesAPI) GetVotesByVoter(c *gin.Context) {
 		return
 	}
 
-	var votes []schema.Vote
-	var vote schema.Vote
-	// get all votes
-	pattern := "votes:*"
-	ks, _ := v.client.Keys(v.context, pattern).Result()
-	for _, key := range ks {
-		err := v.getItemFromRedis(key, &vote)
-		if err != nil {
-			v.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find vote in cache with id=" + key})
-			return
-		}
-		//generate the latest HAL JSON response
-		err = v.generateHALJSONResponse(&vote)
-		if err != nil {
-			v.invalidCall()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response\n" + err.Error()})
-			return
-		}
-		votes = append(votes, vote)
+	// only the votes in the voter index are read
+	embed, err := service.ParseEmbed(c, service.EmbedAll)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	fields := service.ParseFields(c)
 
-	// find all votes with voterId
-	var votesWithVoterId []schema.Vote
-	for _, vote := range votes {
-		if vote.VoterId == voterId {
-			votesWithVoterId = append(votesWithVoterId, vote)
-		}
+	votes, err := v.getVotesFromIndex(voterIndexKey(voterId))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read votes from cache\n" + err.Error()})
+		return
+	}
+
+	//generate the latest HAL JSON response, each voter and poll is looked up once
+	err = v.newResolver(c.Request.Context(), embed).resolve(votes)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate HAL JSON response\n" + err.Error()})
+		return
+	}
+
+	result, err := schema.SelectFieldsList(votes, fields)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not select fields\n" + err.Error()})
+		return
 	}
 
 	v.validCall()
-	c.JSON(http.StatusOK, votesWithVoterId)
+	c.JSON(http.StatusOK, result)
 }
 
 func (v *VotesAPI) PostVote(c *gin.Context) {
@@ -298,7 +394,7 @@ func (v *VotesAPI) PostVote(c *gin.Context) {
 		return
 	}
 
-	_, err := strconv.Atoi(id)
+	voteId, err := strconv.Atoi(id)
 	if err != nil {
 		v.invalidCall()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vote ID provided"})
@@ -321,65 +417,146 @@ func (v *VotesAPI) PostVote(c *gin.Context) {
 		return
 	}
 
-	//confirm voter and poll exist
-	var voter schema.Voter
-	var poll schema.Poll
-	err = getVoterAndPoll(&vote, v, &voter, &poll)
+	// the id in the url is the one that counts
+	if vote.Id == 0 {
+		vote.Id = voteId
+	}
+	if vote.Id != voteId {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Vote id in the body does not match the url"})
+		return
+	}
+
+	v.castVote(c, vote, http.StatusOK)
+}
+
+// CreateVote handles POST /votes, the id is allocated by the api
+func (v *VotesAPI) CreateVote(c *gin.Context) {
+	var vote schema.Vote
+	err := c.ShouldBindJSON(&vote)
 	if err != nil {
 		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter or poll\n" + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse JSON"})
 		return
 	}
 
-	// check if the option exists
-	if vote.VoteValue < 0 || vote.VoteValue >= len(poll.Options) {
+	if vote.Id != 0 {
 		v.invalidCall()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vote value"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The vote id is assigned by the api, use POST /votes/:voteId to pick one"})
 		return
 	}
-	// update the poll results
-	poll.Results[vote.VoteValue].Votes++
 
-	// update poll
-	err = updatePollCounts(&vote, v, &poll)
+	vote.Id, err = service.NextId(c.Request.Context(), v.client, RedisKeyPrefix)
 	if err != nil {
 		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update poll results in cache"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not allocate vote id\n" + err.Error()})
 		return
 	}
 
-	// update the total votes count on Voter
-	voter.Meta.TotalVotes++
-	voter.VoterPolls = append(voter.VoterPolls, schema.VoterPoll{
-		PollId:  poll.Id,
-		VoteId:  vote.Id,
-		VotedAt: time.Now(),
-	})
+	v.castVote(c, vote, http.StatusCreated)
+}
 
-	//update voter
-	err = updateVoter(&vote, v, &voter)
+// castVote checks the voter and poll of a new vote, saves it and sends it
+// with status. With 201 the Location header is set as well.
+func (v *VotesAPI) castVote(c *gin.Context, vote schema.Vote, status int) {
+	var voter schema.Voter
+	var poll schema.Poll
+	ctx := c.Request.Context()
+	err := v.checkVote(ctx, &vote, &voter, &poll)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if errors.Is(err, errPollNotOpen) && v.pollNotOpen(c, &poll) {
+		return
+	}
+	if errors.Is(err, schema.ErrInvalidBallot) {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vote value\n" + err.Error()})
+		return
+	}
 	if err != nil {
 		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update voter in cache"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter or poll\n" + err.Error()})
 		return
 	}
 
-	// set up links and embedded
-	setLinkAndEmbeddedProps(v, &vote, voter, poll)
-
-	vote.Meta.CreatedAt = time.Now()
-
-	//save the vote
-	err = v.saveVote(&vote)
+	err = v.cast(ctx, &vote, &voter, &poll)
+	if v.peerUnavailable(c, err) {
+		return
+	}
 	if err != nil {
+		status, msg := sagaFailure(err, &vote)
 		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save vote to cache"})
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
 
 	// send it
+	if status == http.StatusCreated {
+		c.Header("Location", vote.Links.Self.Href)
+	}
 	v.validCall()
-	c.JSON(http.StatusOK, vote)
+	c.JSON(status, vote)
+}
+
+// checkVote reads the voter and poll of a new vote, and checks that the
+// poll takes votes and that the vote fits its ballot
+func (v *VotesAPI) checkVote(ctx context.Context, vote *schema.Vote, voter *schema.Voter, poll *schema.Poll) error {
+	//confirm voter and poll exist
+	err := getVoterAndPoll(ctx, vote, v, voter, poll)
+	if err != nil {
+		return err
+	}
+
+	if !poll.AcceptsVotes() {
+		return fmt.Errorf("%w, it is %s", errPollNotOpen, poll.CurrentState())
+	}
+
+	// check the vote against the ballot of the poll
+	return poll.ValidateVote(vote)
+}
+
+// cast runs the saga that saves a checked vote, ctx is the one of the
+// request it is cast in
+func (v *VotesAPI) cast(ctx context.Context, vote *schema.Vote, voter *schema.Voter, poll *schema.Poll) error {
+	vote.Meta.CreatedAt = time.Now()
+	voterPoll := schema.VoterPoll{
+		PollId:  poll.Id,
+		VoteId:  vote.Id,
+		VotedAt: vote.Meta.CreatedAt,
+	}
+
+	// the writes below go to three different places, the saga undoes the
+	// earlier ones if a later one fails
+	s, err := v.newSaga(ctx, sagaCastVote, vote, voterPoll)
+	if err != nil {
+		return fmt.Errorf("could not start vote: %w", err)
+	}
+	s.emit(events.VoteCast, gin.H{"vote": vote})
+	s.record(schema.LedgerCast, vote)
+
+	return s.run(
+		// claim the vote id, so two concurrent requests for the same id cannot both count
+		v.claimStep(vote),
+		// one vote per voter and poll, even when the same voter votes twice at once
+		v.uniqueStep(ctx, vote),
+		// update the poll results, the increment happens atomically in the poll api
+		sagaStep{Name: stepIncrementPoll, Action: func() error {
+			return updatePollCounts(ctx, vote, v, 1, s.changeKey(stepIncrementPoll), poll)
+		}},
+		// add the vote to the voter history, this also updates the total votes count
+		sagaStep{Name: stepAddVoterPoll, Action: func() error {
+			return addVoterPoll(ctx, vote, v, voterPoll, voter)
+		}},
+		sagaStep{Name: stepSaveVote, Action: func() error {
+			// the poll was read back by the increment, so this is the
+			// revision the vote was counted in
+			vote.PollRevision = poll.CurrentRevision()
+			// set up links and embedded
+			setLinkAndEmbeddedProps(v, vote, voter, poll)
+			return v.saveVote(ctx, vote)
+		}},
+	)
 }
 
 func (v *VotesAPI) DeleteVote(c *gin.Context) {
@@ -401,49 +578,78 @@ func (v *VotesAPI) DeleteVote(c *gin.Context) {
 		return
 	}
 
+	// a change of the same vote must not run at the same time
+	unlock, err := v.lockVote(vote.Id)
+	if err != nil {
+		v.sendLockFailure(c, err)
+		return
+	}
+	defer unlock()
+
 	// get the voter and poll
+	ctx := c.Request.Context()
 	var voter schema.Voter
 	var poll schema.Poll
-	err = getVoterAndPoll(&vote, v, &voter, &poll)
+	err = getVoterAndPoll(ctx, &vote, v, &voter, &poll)
+	if v.peerUnavailable(c, err) {
+		return
+	}
 	if err != nil {
 		v.invalidCall()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter or poll\n" + err.Error()})
 		return
 	}
 
-	// update the poll results
-	poll.Results[vote.VoteValue].Votes--
-
-	// update in redis
-	err = updatePollCounts(&vote, v, &poll)
-	if err != nil {
-		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update poll results in cache"})
+	// the results of a closed poll are final
+	if v.pollNotOpen(c, &poll) {
 		return
 	}
 
-	// update the total votes count on Voter
-	voter.Meta.TotalVotes--
-	for i, vp := range voter.VoterPolls {
+	// keep the history entry, so it can be put back if the delete fails
+	voterPoll := schema.VoterPoll{PollId: vote.PollId, VoteId: vote.Id, VotedAt: vote.Meta.CreatedAt}
+	for _, vp := range voter.VoterPolls {
 		if vp.VoteId == vote.Id {
-			voter.VoterPolls = append(voter.VoterPolls[:i], voter.VoterPolls[i+1:]...)
+			voterPoll = vp
 			break
 		}
 	}
 
-	//update in redis
-	err = updateVoter(&vote, v, &voter)
+	s, err := v.newSaga(ctx, sagaDeleteVote, &vote, voterPoll)
 	if err != nil {
 		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update voter in cache"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start vote delete\n" + err.Error()})
+		return
+	}
+	s.emit(events.VoteDeleted, gin.H{"vote": vote})
+	s.record(schema.LedgerDelete, &vote)
+
+	var steps []sagaStep
+	// a vote that is not counted since the poll was edited has no count to take back
+	if poll.Counts(&vote) {
+		// update the poll results
+		steps = append(steps, sagaStep{Name: stepDecrementPoll, Action: func() error {
+			return updatePollCounts(ctx, &vote, v, -1, s.changeKey(stepDecrementPoll), &poll)
+		}})
+	}
+	steps = append(steps,
+		// remove the vote from the voter history, this also updates the total votes count
+		sagaStep{Name: stepRemoveVoter, Action: func() error {
+			return removeVoterPoll(ctx, &vote, v, &voter)
+		}},
+		// delete the vote
+		sagaStep{Name: stepDeleteVote, Action: func() error {
+			return v.deleteVote(ctx, &vote)
+		}},
+	)
+
+	err = s.run(steps...)
+	if v.peerUnavailable(c, err) {
 		return
 	}
-
-	// delete the vote
-	_, err = v.helper.JSONDel(cacheKey, ".")
 	if err != nil {
+		status, msg := sagaFailure(err, &vote)
 		v.invalidCall()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete vote from cache"})
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
 
@@ -451,31 +657,36 @@ func (v *VotesAPI) DeleteVote(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Vote deleted"})
 }
 
-func (v *VotesAPI) generateHALJSONResponse(vote *schema.Vote) error {
-	var poll schema.Poll
-	var voter schema.Voter
-
-	//First, we need to get the poll
-	//Now we need to get the voter
-	// unmarshal the voter and poll
-	err := getVoterAndPoll(vote, v, &voter, &poll)
+func (v *VotesAPI) generateHALJSONResponse(ctx context.Context, vote *schema.Vote, embed service.Embed) error {
+	votes := []schema.Vote{*vote}
+	err := v.newResolver(ctx, embed).resolve(votes)
 	if err != nil {
 		return err
 	}
 
-	// set up links and embedded
-	setLinkAndEmbeddedProps(v, vote, voter, poll)
-
+	*vote = votes[0]
 	return nil
 }
 
-func getVoterAndPoll(vote *schema.Vote, v *VotesAPI, voter *schema.Voter, poll *schema.Poll) error {
-	err := getVoter(vote, v, voter)
+// pollNotOpen answers a request for a vote on a poll that does not take
+// votes right now, and reports whether it did
+func (v *VotesAPI) pollNotOpen(c *gin.Context, poll *schema.Poll) bool {
+	if poll.AcceptsVotes() {
+		return false
+	}
+
+	v.invalidCall()
+	c.JSON(http.StatusConflict, gin.H{"error": "Poll is " + poll.CurrentState() + ", it does not take votes"})
+	return true
+}
+
+func getVoterAndPoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, voter *schema.Voter, poll *schema.Poll) error {
+	err := getVoter(ctx, vote, v, voter)
 	if err != nil {
 		return err
 	}
 
-	err = getPoll(vote, v, poll)
+	err = getPoll(ctx, vote, v, poll)
 	if err != nil {
 		return err
 	}
@@ -483,104 +694,175 @@ func getVoterAndPoll(vote *schema.Vote, v *VotesAPI, voter *schema.Voter, poll *
 	return nil
 }
 
-func getVoter(vote *schema.Vote, v *VotesAPI, voter *schema.Voter) error {
+func getVoter(ctx context.Context, vote *schema.Vote, v *VotesAPI, voter *schema.Voter) error {
 	voterId := vote.VoterId
-	voterUrl := v.InternalAPI.Voters + "/" + fmt.Sprint(voterId)
-	voterResp, err := v.apiClient.R().Get(voterUrl)
+	found, err := v.voterClient.GetVoter(ctx, voterId, nil)
 	if err != nil {
-		return err
+		return peerError(err, "could not find voter with id=%d", voterId)
 	}
 
-	if voterResp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("could not find voter with id=%d", voterId)
-	}
+	*voter = found
+	v.voterCache.set(voterId, *voter)
+	return nil
+}
 
-	err = json.Unmarshal(voterResp.Body(), &voter)
+// addVoterPoll appends voterPoll to the voter's history through the voter api,
+// which applies the change atomically.
+func addVoterPoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, voterPoll schema.VoterPoll, voter *schema.Voter) error {
+	voterId := vote.VoterId
+	updated, err := v.voterClient.AddVoterPoll(ctx, voterId, voterPoll)
+	// whatever happened, the cached copy may be out of date now
+	v.voterCache.invalidate(voterId)
 	if err != nil {
-		return err
+		return peerError(err, "could not update voter with id=%d", voterId)
 	}
+
+	*voter = updated
 	return nil
 }
-func updateVoter(vote *schema.Vote, v *VotesAPI, voter *schema.Voter) error {
+
+// removeVoterPoll removes the vote from the voter's history through the voter api.
+func removeVoterPoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, voter *schema.Voter) error {
 	voterId := vote.VoterId
-	voterUrl := v.InternalAPI.Voters + "/" + fmt.Sprint(voterId)
-	// update voter
-	voterResp, err := v.apiClient.R().SetBody(voter).Put(voterUrl)
+	updated, err := v.voterClient.RemoveVoterPoll(ctx, voterId, vote.Id)
+	// whatever happened, the cached copy may be out of date now
+	v.voterCache.invalidate(voterId)
 	if err != nil {
-		return err
+		return peerError(err, "could not remove vote with id=%d from voter with id=%d", vote.Id, voterId)
 	}
 
-	if voterResp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("could not update voter with id=%d", voterId)
-	}
+	*voter = updated
+	return nil
+}
 
-	err = json.Unmarshal(voterResp.Body(), &voter)
+// updatePollCounts adds delta to the count of the option the vote was cast for.
+// The poll api does the increment in redis, so concurrent votes are not lost.
+// key is the Idempotency-Key of the change, see sagaChangeKey.
+func updatePollCounts(ctx context.Context, vote *schema.Vote, v *VotesAPI, delta int, key string, poll *schema.Poll) error {
+	pollId := vote.PollId
+	updated, err := v.pollClient.IncrementOptionCount(ctx, pollId, vote.VoteValue, delta, key)
+	// whatever happened, the cached copy may be out of date now
+	v.pollCache.invalidate(pollId)
+	if client.StatusCode(err) == http.StatusConflict {
+		// the poll closed since it was read
+		return fmt.Errorf("poll with id=%d: %w", pollId, errPollNotOpen)
+	}
 	if err != nil {
-		return err
+		return peerError(err, "could not update poll with id=%d", pollId)
 	}
 
+	*poll = updated
 	return nil
 }
-func updatePollCounts(vote *schema.Vote, v *VotesAPI, poll *schema.Poll) error {
+
+// undoPollCounts takes back the updatePollCounts made with key
+func undoPollCounts(ctx context.Context, vote *schema.Vote, v *VotesAPI, delta int, key string) error {
 	pollId := vote.PollId
-	pollUrl := v.InternalAPI.Polls + "/counts/" + fmt.Sprint(pollId)
-	// update poll
-	pollResp, err := v.apiClient.R().SetBody(poll).Put(pollUrl)
+	_, err := v.pollClient.UndoOptionCount(ctx, pollId, vote.VoteValue, delta, key)
+	v.pollCache.invalidate(pollId)
 	if err != nil {
-		return err
+		return peerError(err, "could not update poll with id=%d", pollId)
 	}
+	return nil
+}
 
-	if pollResp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("could not update poll with id=%d", pollId)
+// moveVotePoll moves the vote from the option from to the option it is cast
+// for now. The poll api changes both counts in one script.
+func moveVotePoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, from int, key string, poll *schema.Poll) error {
+	pollId := vote.PollId
+	updated, err := v.pollClient.MoveOptionCount(ctx, pollId, from, vote.VoteValue, key)
+	// whatever happened, the cached copy may be out of date now
+	v.pollCache.invalidate(pollId)
+	if client.StatusCode(err) == http.StatusConflict {
+		// the poll closed since it was read
+		return fmt.Errorf("poll with id=%d: %w", pollId, errPollNotOpen)
 	}
-
-	err = json.Unmarshal(pollResp.Body(), &poll)
 	if err != nil {
-		return err
+		return peerError(err, "could not update poll with id=%d", pollId)
 	}
 
+	*poll = updated
 	return nil
 }
-func getPoll(vote *schema.Vote, v *VotesAPI, poll *schema.Poll) error {
+
+// undoMoveVotePoll takes back the moveVotePoll made with key
+func undoMoveVotePoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, from int, key string) error {
 	pollId := vote.PollId
-	pollUrl := v.InternalAPI.Polls + "/" + fmt.Sprint(pollId)
-	pollResp, err := v.apiClient.R().Get(pollUrl)
+	_, err := v.pollClient.UndoMoveOptionCount(ctx, pollId, from, vote.VoteValue, key)
+	v.pollCache.invalidate(pollId)
 	if err != nil {
-		return err
+		return peerError(err, "could not update poll with id=%d", pollId)
 	}
-	if pollResp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("could not find voter with id=%d", pollId)
+	return nil
+}
+
+// updateVoterPoll replaces the history entry of the vote through the voter api.
+func updateVoterPoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, voterPoll schema.VoterPoll, voter *schema.Voter) error {
+	voterId := vote.VoterId
+	updated, err := v.voterClient.UpdateVoterPoll(ctx, voterId, voterPoll)
+	// whatever happened, the cached copy may be out of date now
+	v.voterCache.invalidate(voterId)
+	if err != nil {
+		return peerError(err, "could not update vote with id=%d of voter with id=%d", vote.Id, voterId)
 	}
-	err = json.Unmarshal(pollResp.Body(), &poll)
+
+	*voter = updated
+	return nil
+}
+
+func getPoll(ctx context.Context, vote *schema.Vote, v *VotesAPI, poll *schema.Poll) error {
+	pollId := vote.PollId
+	found, err := v.pollClient.GetPoll(ctx, pollId, nil)
 	if err != nil {
-		return err
+		return peerError(err, "could not find poll with id=%d", pollId)
 	}
+
+	*poll = found
+	v.pollCache.set(pollId, *poll)
 	return nil
 }
 
-func setLinkAndEmbeddedProps(v *VotesAPI, vote *schema.Vote, voter schema.Voter, poll schema.Poll) {
-	var links schema.Links
-	links.Self.Href = v.API.Self + "/votes/" + fmt.Sprint(vote.Id)
-	links.Voter.Href = v.API.Voters + "/" + fmt.Sprint(vote.VoterId)
-	links.Poll.Href = v.API.Polls + "/" + fmt.Sprint(vote.PollId)
-	links.Results.Href = v.API.Polls + "/" + fmt.Sprint(vote.PollId) + "/results"
-	vote.Links = links
+// peerError describes a failed call to the voter or poll api. A 404 is
+// wrapped as errNotFound, anything else keeps the error of the client.
+func peerError(err error, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if client.IsNotFound(err) {
+		return fmt.Errorf("%s: %w", msg, errNotFound)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// setLinkAndEmbeddedProps sets the links of the vote and embeds the voter and
+// poll that are not nil. With neither, the vote has no _embedded.
+func setLinkAndEmbeddedProps(v *VotesAPI, vote *schema.Vote, voter *schema.Voter, poll *schema.Poll) {
+	schema.SetVoteLinksAndEmbedded(v.endpoints(), vote, voter, poll)
+}
 
-	var embedded Embedded
-	embedded.Voter = voter
-	embedded.Poll = poll
-	vote.Embedded = embedded
+// endpoints are the public urls the shared link builders work from
+func (v *VotesAPI) endpoints() schema.Endpoints {
+	return schema.Endpoints{Polls: v.API.Polls, Voters: v.API.Voters, Votes: v.API.Self + "/votes"}
 }
 
-func (v *VotesAPI) saveVote(vote *schema.Vote) error {
-	// save vote in redis with votes:<id> as key
+// claimVote reserves the redis key of the vote with JSON.SET NX. It returns
+// false if a vote with the same id already exists.
+func (v *VotesAPI) claimVote(vote *schema.Vote) (bool, error) {
 	cacheKey := redisKeyFromId(vote.Id)
-	_, err := v.helper.JSONSet(cacheKey, ".", vote)
+	res, err := v.helper.JSONSet(cacheKey, ".", vote, rjs.SetOptionNX)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return res != nil, nil
+}
+
+func voteSubject(id int) string {
+	return "votes/" + strconv.Itoa(id)
+}
+
+// StartEventRelay moves the events of the votes api to the event stream in
+// the background
+func (v *VotesAPI) StartEventRelay() {
+	go v.outbox.Relay(v.context)
 }
 
 func redisKeyFromId(id int) string {
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/client"
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+)
+
+const sagaCascadeVote = "vote.cascade"
+
+// CascadeReport lists everything that was removed or changed because a poll
+// or voter was deleted.
+type CascadeReport struct {
+	VotesDeleted  []int    `json:"votesDeleted"`
+	VotersUpdated []int    `json:"votersUpdated"`
+	PollsUpdated  []int    `json:"pollsUpdated"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+func newCascadeReport() CascadeReport {
+	return CascadeReport{
+		VotesDeleted:  []int{},
+		VotersUpdated: []int{},
+		PollsUpdated:  []int{},
+	}
+}
+
+// DeleteVotesByPoll removes every vote cast on a poll and strips the votes
+// from the voters' histories. The poll api calls this before deleting a poll,
+// so the poll results themselves are left alone.
+func (v *VotesAPI) DeleteVotesByPoll(c *gin.Context) {
+	pollId, err := strconv.Atoi(c.Param("pollId"))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poll id"})
+		return
+	}
+
+	votes, err := v.getVotesFromIndex(pollIndexKey(pollId))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read votes from cache\n" + err.Error()})
+		return
+	}
+
+	report := newCascadeReport()
+	for i := range votes {
+		err := v.cascadeVote(c.Request.Context(), &votes[i], false, true, &report)
+		if v.cascadeUnavailable(c, err, report) {
+			return
+		}
+	}
+
+	v.sendCascadeReport(c, report)
+}
+
+// DeleteVotesByVoter removes every vote cast by a voter and takes the votes
+// back out of the poll results. The voter api calls this before deleting a
+// voter, so the voter history is left alone.
+func (v *VotesAPI) DeleteVotesByVoter(c *gin.Context) {
+	voterId, err := strconv.Atoi(c.Param("voterId"))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid voter id"})
+		return
+	}
+
+	votes, err := v.getVotesFromIndex(voterIndexKey(voterId))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read votes from cache\n" + err.Error()})
+		return
+	}
+
+	report := newCascadeReport()
+	for i := range votes {
+		err := v.cascadeVote(c.Request.Context(), &votes[i], true, false, &report)
+		if v.cascadeUnavailable(c, err, report) {
+			return
+		}
+	}
+
+	v.sendCascadeReport(c, report)
+}
+
+func (v *VotesAPI) sendCascadeReport(c *gin.Context, report CascadeReport) {
+	if len(report.Errors) > 0 {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, report)
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, report)
+}
+
+// cascadeUnavailable stops a cascade when a breaker is open, as the rest of
+// the votes would fail the same way. It answers 503 with the report so far.
+func (v *VotesAPI) cascadeUnavailable(c *gin.Context, err error, report CascadeReport) bool {
+	retryAfter, open := client.RetryAfter(err)
+	if !open {
+		return false
+	}
+
+	v.invalidCall()
+	setRetryAfter(c, retryAfter)
+	c.JSON(http.StatusServiceUnavailable, report)
+	return true
+}
+
+// cascadeVote deletes one vote as a saga, optionally fixing the poll results
+// and the voter history on the way. The outcome is added to report, and the
+// error is returned as well.
+func (v *VotesAPI) cascadeVote(ctx context.Context, vote *schema.Vote, updatePoll bool, updateVoter bool, report *CascadeReport) error {
+	voterPoll := schema.VoterPoll{PollId: vote.PollId, VoteId: vote.Id, VotedAt: vote.Meta.CreatedAt}
+
+	if updatePoll {
+		// a vote on a deleted poll, or not counted since the poll was
+		// edited, has no count to take back. A poll that closed keeps its
+		// results as they were.
+		var poll schema.Poll
+		err := getPoll(ctx, vote, v, &poll)
+		if err != nil && !errors.Is(err, errNotFound) {
+			report.Errors = append(report.Errors, "vote "+strconv.Itoa(vote.Id)+": "+err.Error())
+			return err
+		}
+		updatePoll = err == nil && poll.AcceptsVotes() && poll.Counts(vote)
+	}
+
+	s, err := v.newSaga(ctx, sagaCascadeVote, vote, voterPoll)
+	if err != nil {
+		report.Errors = append(report.Errors, "vote "+strconv.Itoa(vote.Id)+": "+err.Error())
+		return err
+	}
+	s.emit(events.VoteDeleted, gin.H{"vote": vote})
+	// the ledger only takes the vote out of the results if the poll did,
+	// see schema.LedgerRemove
+	s.record(schema.LedgerRemove, vote)
+
+	var steps []sagaStep
+	if updatePoll {
+		s.record(schema.LedgerDelete, vote)
+		steps = append(steps, sagaStep{Name: stepDecrementPoll, Action: func() error {
+			var poll schema.Poll
+			err := updatePollCounts(ctx, vote, v, -1, s.changeKey(stepDecrementPoll), &poll)
+			if errors.Is(err, errPollNotOpen) || errors.Is(err, errNotFound) {
+				// the poll closed or was deleted in the meantime, its
+				// results stay
+				s.record(schema.LedgerRemove, vote)
+				return nil
+			}
+			return err
+		}})
+	}
+	if updateVoter {
+		steps = append(steps, sagaStep{Name: stepRemoveVoter, Action: func() error {
+			var voter schema.Voter
+			return ignoreNotFound(removeVoterPoll(ctx, vote, v, &voter))
+		}})
+	}
+	steps = append(steps, sagaStep{Name: stepDeleteVote, Action: func() error {
+		return v.deleteVote(ctx, vote)
+	}})
+
+	err = s.run(steps...)
+	if err != nil {
+		report.Errors = append(report.Errors, "vote "+strconv.Itoa(vote.Id)+": "+err.Error())
+		return err
+	}
+
+	report.VotesDeleted = append(report.VotesDeleted, vote.Id)
+	if updatePoll {
+		report.PollsUpdated = appendUnique(report.PollsUpdated, vote.PollId)
+	}
+	if updateVoter {
+		report.VotersUpdated = appendUnique(report.VotersUpdated, vote.VoterId)
+	}
+	return nil
+}
+
+// ignoreNotFound treats a missing poll or voter as already cleaned up, which
+// happens for votes orphaned by deletes made before cascading existed.
+func ignoreNotFound(err error) error {
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
+
+func appendUnique(ids []int, id int) []int {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
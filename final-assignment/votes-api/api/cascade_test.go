@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestCascadeOnClosedPollKeepsLedgerValid(t *testing.T) {
+	v, p := testVotesAPI(t)
+
+	// a closed poll keeps the results it closed with
+	poll := schema.Poll{
+		Id:      1,
+		Options: []schema.PollOption{{Id: 1}, {Id: 2}},
+		Results: []schema.Results{{OptionId: 1, Votes: 1}, {OptionId: 2, Votes: 1}},
+		State:   schema.PollClosed,
+	}
+	p.polls[poll.Id] = poll
+
+	votes := []schema.Vote{
+		{Id: 1, PollId: 1, VoterId: 1, VoteValue: 1},
+		{Id: 2, PollId: 1, VoterId: 2, VoteValue: 2},
+	}
+	for i := range votes {
+		err := v.saveVote(v.context, &votes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = v.appendLedger(v.context, &ledgerRecord{Action: schema.LedgerCast, Vote: &votes[i]}, func(pipe redis.Pipeliner) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// DELETE /voters/1?cascade=true
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/votes/voters/1", nil)
+	c.Params = gin.Params{{Key: "voterId", Value: "1"}}
+	v.DeleteVotesByVoter(c)
+
+	var report CascadeReport
+	err := json.Unmarshal(w.Body.Bytes(), &report)
+	if w.Code != http.StatusOK || err != nil {
+		t.Fatalf("cascade answered %d: %s", w.Code, w.Body.String())
+	}
+	if len(report.VotesDeleted) != 1 || report.VotesDeleted[0] != 1 || len(report.PollsUpdated) != 0 {
+		t.Errorf("cascade report is %+v, want vote 1 deleted and no poll updated", report)
+	}
+
+	raw, err := v.client.LRange(v.context, schema.LedgerKey(poll.Id), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]schema.LedgerEntry, len(raw))
+	for i := range raw {
+		err := json.Unmarshal([]byte(raw[i]), &entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(entries) != 3 || entries[2].Action != schema.LedgerRemove || entries[2].VoteId != 1 {
+		t.Fatalf("ledger is %+v, want the two casts and a remove of vote 1", entries)
+	}
+
+	verification := schema.VerifyLedger(&poll, entries)
+	if !verification.Valid {
+		t.Errorf("ledger of the closed poll does not verify: %+v", verification)
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+)
+
+// A voter changes their vote with PUT /votes/:voteId. The count moves from
+// the old option to the new one and the history entry gets a new VotedAt,
+// as a saga like casting a vote. A change or delete holds a short lock on
+// the vote, so two changes of the same vote cannot both move the count away
+// from the same old option.
+const (
+	voteLockPrefix = RedisKeyPrefix + "lock:"
+	// a lock is dropped after this, in case its holder crashed
+	voteLockTTL = 30 * time.Second
+)
+
+var errVoteLocked = errors.New("vote is being changed by another request")
+
+// lockVote takes the lock of a vote, the returned func releases it
+func (v *VotesAPI) lockVote(id int) (func(), error) {
+	key := voteLockPrefix + strconv.Itoa(id)
+	token := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	locked, err := v.client.SetNX(v.context, key, token, voteLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, errVoteLocked
+	}
+
+	return func() {
+		delIfEqualScript.Run(v.context, v.client, []string{key}, token)
+	}, nil
+}
+
+// sendLockFailure answers a failed lockVote
+func (v *VotesAPI) sendLockFailure(c *gin.Context, err error) {
+	v.invalidCall()
+	if err == errVoteLocked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vote is being changed, try again"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not lock vote\n" + err.Error()})
+}
+
+func (v *VotesAPI) UpdateVote(c *gin.Context) {
+	id := c.Param("voteId")
+	if id == "" {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No vote ID provided"})
+		return
+	}
+
+	voteId, err := strconv.Atoi(id)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vote ID provided"})
+		return
+	}
+
+	var change schema.Vote
+	err = c.ShouldBindJSON(&change)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse JSON"})
+		return
+	}
+
+	unlock, err := v.lockVote(voteId)
+	if err != nil {
+		v.sendLockFailure(c, err)
+		return
+	}
+	defer unlock()
+
+	var prev schema.Vote
+	err = v.getItemFromRedis(redisKeyFromId(voteId), &prev)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find vote in cache with id=" + redisKeyFromId(voteId)})
+		return
+	}
+
+	// only the chosen option can change
+	if (change.PollId != 0 && change.PollId != prev.PollId) || (change.VoterId != 0 && change.VoterId != prev.VoterId) {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only the vote value of a vote can be changed"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var voter schema.Voter
+	var poll schema.Poll
+	err = getVoterAndPoll(ctx, &prev, v, &voter, &poll)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter or poll\n" + err.Error()})
+		return
+	}
+
+	if v.pollNotOpen(c, &poll) {
+		return
+	}
+
+	vote := prev
+	vote.VoteValue = change.VoteValue
+	vote.Ballot = change.Ballot
+	vote.Meta.UpdatedAt = time.Now()
+
+	// check the new vote against the ballot of the poll
+	err = poll.ValidateVote(&vote)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vote value\n" + err.Error()})
+		return
+	}
+
+	// a vote cast before the poll was edited may not be counted anymore,
+	// changing it casts it against the current revision
+	counted := poll.Counts(&prev)
+	vote.PollRevision = poll.CurrentRevision()
+
+	if counted && vote.VoteValue == prev.VoteValue && reflect.DeepEqual(vote.Ballot, prev.Ballot) {
+		// nothing to move
+		setLinkAndEmbeddedProps(v, &prev, &voter, &poll)
+		v.validCall()
+		c.JSON(http.StatusOK, prev)
+		return
+	}
+
+	prevVoterPoll := schema.VoterPoll{PollId: prev.PollId, VoteId: prev.Id, VotedAt: prev.Meta.CreatedAt}
+	for _, vp := range voter.VoterPolls {
+		if vp.VoteId == prev.Id {
+			prevVoterPoll = vp
+			break
+		}
+	}
+	voterPoll := prevVoterPoll
+	voterPoll.VotedAt = vote.Meta.UpdatedAt
+
+	s, err := v.startSaga(ctx, sagaLog{
+		Kind:          sagaChangeVote,
+		Vote:          vote,
+		VoterPoll:     voterPoll,
+		PrevVote:      &prev,
+		PrevVoterPoll: &prevVoterPoll,
+	})
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start vote change\n" + err.Error()})
+		return
+	}
+	s.emit(events.VoteChanged, gin.H{"vote": &vote, "previous": prev})
+	s.record(schema.LedgerChange, &vote)
+
+	var steps []sagaStep
+	if !counted {
+		// only the new choice is in the results
+		steps = append(steps, sagaStep{Name: stepIncrementPoll, Action: func() error {
+			return updatePollCounts(ctx, &vote, v, 1, s.changeKey(stepIncrementPoll), &poll)
+		}})
+	} else if vote.VoteValue != prev.VoteValue {
+		// a new ballot may keep its first choice, then no count moves
+		// move the count to the new option, both counts change at once in the poll api
+		steps = append(steps, sagaStep{Name: stepMovePoll, Action: func() error {
+			return moveVotePoll(ctx, &vote, v, prev.VoteValue, s.changeKey(stepMovePoll), &poll)
+		}})
+	}
+	steps = append(steps,
+		// update when the voter voted
+		sagaStep{Name: stepUpdateVoter, Action: func() error {
+			return updateVoterPoll(ctx, &vote, v, voterPoll, &voter)
+		}},
+		sagaStep{Name: stepUpdateVote, Action: func() error {
+			setLinkAndEmbeddedProps(v, &vote, &voter, &poll)
+			return v.saveVote(ctx, &vote)
+		}},
+	)
+
+	err = s.run(steps...)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if err != nil {
+		status, msg := sagaFailure(err, &vote)
+		v.invalidCall()
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, vote)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// an export reads this many votes from redis at a time, and flushes them to
+// the client before it reads the next ones
+const exportBatch = 100
+
+// votedAt is when the vote was cast, or last changed
+func votedAt(vote *schema.Vote) time.Time {
+	if vote.Meta.UpdatedAt.IsZero() {
+		return vote.Meta.CreatedAt
+	}
+	return vote.Meta.UpdatedAt
+}
+
+// ExportVotesByPoll handles GET /votes/polls/:pollId/export, every vote on
+// the poll as a csv, ndjson or xlsx file (?format=, csv by default). Only
+// the ids of the votes are held in memory, the votes themselves are read and
+// written a batch at a time. The option text is taken from the poll's
+// current options, and is empty for an option that was removed.
+func (v *VotesAPI) ExportVotesByPoll(c *gin.Context) {
+	pollId, err := strconv.Atoi(c.Param("pollId"))
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid poll id"})
+		return
+	}
+	format := c.DefaultQuery("format", schema.ExportCSV)
+	err = schema.CheckExportFormat(format)
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	var poll schema.Poll
+	err = getPoll(c.Request.Context(), &schema.Vote{PollId: pollId}, v, &poll)
+	if v.peerUnavailable(c, err) {
+		return
+	}
+	if errors.Is(err, errNotFound) {
+		v.invalidCall()
+		c.JSON(http.StatusNotFound, gin.H{"msg": "Could not find poll with id=" + strconv.Itoa(pollId)})
+		return
+	}
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read poll\n" + err.Error()})
+		return
+	}
+
+	members, err := v.client.SMembers(v.context, pollIndexKey(pollId)).Result()
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Could not read votes from cache\n" + err.Error()})
+		return
+	}
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.Atoi(member)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	c.Header("Content-Type", schema.ExportContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="poll-`+strconv.Itoa(pollId)+`-votes.`+format+`"`)
+	w, err := schema.NewTableWriter(c.Writer, format, "voteId", "voterId", "optionId", "option", "votedAt")
+	for start := 0; start < len(ids) && err == nil; start += exportBatch {
+		end := start + exportBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err = v.exportVotes(w, &poll, ids[start:end])
+		if err == nil {
+			err = w.Flush()
+			c.Writer.Flush()
+		}
+		if err == nil {
+			// stop if the client went away
+			err = c.Request.Context().Err()
+		}
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		// the headers are sent, all that is left is to stop
+		log.Printf("Could not export votes of poll %d: %v", pollId, err)
+		v.invalidCall()
+		return
+	}
+	v.validCall()
+}
+
+// exportVotes reads the votes with the ids in one round trip and writes a
+// row for each. Votes deleted since the index was read are skipped.
+func (v *VotesAPI) exportVotes(w schema.TableWriter, poll *schema.Poll, ids []int) error {
+	cmds := make([]*redis.Cmd, len(ids))
+	_, err := v.client.Pipelined(v.context, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Do(v.context, "JSON.GET", redisKeyFromId(id), ".")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		raw, err := cmd.Text()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var vote schema.Vote
+		err = json.Unmarshal([]byte(raw), &vote)
+		if err != nil {
+			return err
+		}
+		err = w.WriteRow(vote.Id, vote.VoterId, vote.VoteValue, poll.OptionText(vote.VoteValue), votedAt(&vote))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,568 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/go-redis/redis/v8"
+)
+
+// Fsck reads the polls and voters straight from redis, as all three apis
+// share it. These are the key prefixes the poll and voter apis use.
+const (
+	pollKeyPrefix  = "polls:"
+	voterKeyPrefix = "voters:"
+)
+
+// fsck holds what one Fsck run read, and the discrepancies it found
+type fsck struct {
+	v      *VotesAPI
+	repair bool
+
+	votes  []schema.Vote // sorted by id
+	polls  map[int]*schema.Poll
+	voters map[int]*schema.Voter
+	found  []schema.Discrepancy
+}
+
+// Fsck checks the results, total votes and activity of every poll, the
+// history of every voter and the vote indexes against the vote documents,
+// and the sets of poll and voter ids against the polls and voters. It
+// returns every discrepancy it finds. With repair set they are rebuilt from the
+// votes. Unreadable votes, votes whose poll or voter is gone and voters
+// with several votes on one poll are only reported, fixing them means
+// deleting votes. Run it with no votes being cast, a vote in flight shows
+// up as a discrepancy.
+func (v *VotesAPI) Fsck(repair bool) ([]schema.Discrepancy, error) {
+	inflight, err := v.client.SCard(v.context, sagaInFlightKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if inflight > 0 {
+		log.Printf("%d sagas are still in flight, their votes may show up as discrepancies", inflight)
+	}
+
+	f := &fsck{v: v, repair: repair, found: []schema.Discrepancy{}}
+	err = f.load()
+	if err == nil {
+		err = f.checkPolls()
+	}
+	if err == nil {
+		err = f.checkVoters()
+	}
+	if err == nil {
+		err = f.checkIndexes()
+	}
+	if err == nil {
+		err = f.checkUniqueKeys()
+	}
+	if err == nil {
+		err = f.checkActivity()
+	}
+	if err == nil {
+		err = f.checkIds()
+	}
+	return f.found, err
+}
+
+func (f *fsck) report(kind string, id int, repaired bool, format string, args ...any) {
+	f.found = append(f.found, schema.Discrepancy{
+		Kind:     kind,
+		Id:       id,
+		Message:  fmt.Sprintf(format, args...),
+		Repaired: repaired,
+	})
+}
+
+// readJSON reads the document at key into item, it returns false if the
+// key does not exist
+func (f *fsck) readJSON(key string, item any) (bool, error) {
+	raw, err := f.v.helper.JSONGet(key, ".")
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw.([]byte), item)
+}
+
+// scanKeys lists the keys that start with prefix
+func (f *fsck) scanKeys(prefix string) ([]string, error) {
+	var keys []string
+	iter := f.v.client.Scan(f.v.context, 0, prefix+"*", service.ScanCount).Iterator()
+	for iter.Next(f.v.context) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// load reads every vote, poll and voter
+func (f *fsck) load() error {
+	ids, err := service.ScanIds(f.v.context, f.v.client, RedisKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		raw, err := f.v.helper.JSONGet(redisKeyFromId(id), ".")
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var vote schema.Vote
+		err = json.Unmarshal(raw.([]byte), &vote)
+		if err != nil {
+			f.report(schema.DiscrepancyUnreadable, id, false, "%s", err)
+			continue
+		}
+		f.votes = append(f.votes, vote)
+	}
+
+	f.polls = map[int]*schema.Poll{}
+	ids, err = service.ScanIds(f.v.context, f.v.client, pollKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var poll schema.Poll
+		ok, err := f.readJSON(pollKeyPrefix+strconv.Itoa(id), &poll)
+		if err != nil {
+			return fmt.Errorf("poll %d: %w", id, err)
+		}
+		if ok {
+			f.polls[id] = &poll
+		}
+	}
+
+	f.voters = map[int]*schema.Voter{}
+	ids, err = service.ScanIds(f.v.context, f.v.client, voterKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var voter schema.Voter
+		ok, err := f.readJSON(voterKeyPrefix+strconv.Itoa(id), &voter)
+		if err != nil {
+			return fmt.Errorf("voter %d: %w", id, err)
+		}
+		if ok {
+			f.voters[id] = &voter
+		}
+	}
+
+	// orphans and duplicates are only reported
+	byPair := map[[2]int][]int{}
+	for _, vote := range f.votes {
+		if f.polls[vote.PollId] == nil {
+			f.report(schema.DiscrepancyOrphan, vote.Id, false, "poll %d does not exist", vote.PollId)
+		}
+		if f.voters[vote.VoterId] == nil {
+			f.report(schema.DiscrepancyOrphan, vote.Id, false, "voter %d does not exist", vote.VoterId)
+		}
+		pair := [2]int{vote.PollId, vote.VoterId}
+		byPair[pair] = append(byPair[pair], vote.Id)
+	}
+	for _, vote := range f.votes {
+		ids := byPair[[2]int{vote.PollId, vote.VoterId}]
+		if len(ids) > 1 && ids[0] != vote.Id {
+			f.report(schema.DiscrepancyDuplicate, vote.Id, false, "voter %d already voted on poll %d with vote %d", vote.VoterId, vote.PollId, ids[0])
+		}
+	}
+	return nil
+}
+
+// checkPolls recounts every poll
+func (f *fsck) checkPolls() error {
+	byPoll := map[int][]schema.Vote{}
+	for _, vote := range f.votes {
+		byPoll[vote.PollId] = append(byPoll[vote.PollId], vote)
+	}
+
+	for _, id := range sortedKeys(f.polls) {
+		recount := schema.RecountPoll(f.polls[id], byPoll[id])
+		if len(recount.Discrepancies) == 0 {
+			continue
+		}
+
+		if f.repair {
+			results, err := json.Marshal(recount.Results)
+			if err != nil {
+				return err
+			}
+			key := pollKeyPrefix + strconv.Itoa(id)
+			_, err = f.v.client.TxPipelined(f.v.context, func(pipe redis.Pipeliner) error {
+				pipe.Do(f.v.context, "JSON.SET", key, ".results", string(results))
+				pipe.Do(f.v.context, "JSON.SET", key, "._meta.TotalVotes", recount.TotalVotes)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, d := range recount.Discrepancies {
+			d.Repaired = f.repair
+			f.found = append(f.found, d)
+		}
+	}
+	return nil
+}
+
+// checkVoters compares the history of every voter with their votes. The
+// history has one entry per vote, VotedAt is not checked.
+func (f *fsck) checkVoters() error {
+	byVoter := map[int][]schema.Vote{}
+	for _, vote := range f.votes {
+		byVoter[vote.VoterId] = append(byVoter[vote.VoterId], vote)
+	}
+
+	for _, id := range sortedKeys(f.voters) {
+		voter := f.voters[id]
+		votes := byVoter[id]
+
+		voteIds := map[int]*schema.Vote{}
+		for i := range votes {
+			voteIds[votes[i].Id] = &votes[i]
+		}
+
+		var problems []string
+		seen := map[int]bool{}
+		for _, vp := range voter.VoterPolls {
+			vote, ok := voteIds[vp.VoteId]
+			switch {
+			case seen[vp.VoteId]:
+				problems = append(problems, fmt.Sprintf("has vote %d twice", vp.VoteId))
+			case !ok:
+				problems = append(problems, fmt.Sprintf("has vote %d, which does not exist", vp.VoteId))
+			case vote.PollId != vp.PollId:
+				problems = append(problems, fmt.Sprintf("has vote %d on poll %d, it is on poll %d", vp.VoteId, vp.PollId, vote.PollId))
+			}
+			seen[vp.VoteId] = true
+		}
+		for _, vote := range votes {
+			if !seen[vote.Id] {
+				problems = append(problems, fmt.Sprintf("is missing vote %d on poll %d", vote.Id, vote.PollId))
+			}
+		}
+
+		if f.repair && len(problems) > 0 {
+			// entries that were right keep their VotedAt
+			votedAts := map[int]time.Time{}
+			for _, vp := range voter.VoterPolls {
+				votedAts[vp.VoteId] = vp.VotedAt
+			}
+			history := make([]schema.VoterPoll, 0, len(votes))
+			for i, vote := range votes {
+				at, ok := votedAts[vote.Id]
+				if !ok {
+					at = votedAt(&votes[i])
+				}
+				history = append(history, schema.VoterPoll{PollId: vote.PollId, VoteId: vote.Id, VotedAt: at})
+			}
+			err := f.setVoter(id, ".voterPolls", history)
+			if err != nil {
+				return err
+			}
+		}
+		for _, problem := range problems {
+			f.report(schema.DiscrepancyHistory, id, f.repair, "history %s", problem)
+		}
+
+		if voter.Meta.TotalVotes != len(votes) {
+			if f.repair {
+				err := f.setVoter(id, "._meta.TotalVotes", len(votes))
+				if err != nil {
+					return err
+				}
+			}
+			f.report(schema.DiscrepancyVoterTotal, id, f.repair, "total votes is %d, the voter has %d votes", voter.Meta.TotalVotes, len(votes))
+		}
+	}
+	return nil
+}
+
+func (f *fsck) setVoter(id int, path string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return f.v.client.Do(f.v.context, "JSON.SET", voterKeyPrefix+strconv.Itoa(id), path, string(raw)).Err()
+}
+
+// checkIndexes compares the by-poll and by-voter sets with the votes,
+// including sets of polls and voters that have no votes anymore
+func (f *fsck) checkIndexes() error {
+	byPoll := map[string]map[int]bool{}
+	byVoter := map[string]map[int]bool{}
+	for _, vote := range f.votes {
+		add(byPoll, pollIndexKey(vote.PollId), vote.Id)
+		add(byVoter, voterIndexKey(vote.VoterId), vote.Id)
+	}
+
+	for _, index := range []struct {
+		prefix string
+		want   map[string]map[int]bool
+	}{{PollIndexPrefix, byPoll}, {VoterIndexPrefix, byVoter}} {
+		keys, err := f.scanKeys(index.prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if _, ok := index.want[key]; !ok {
+				index.want[key] = map[int]bool{}
+			}
+		}
+
+		for _, key := range sortedKeys(index.want) {
+			members, err := f.v.client.SMembers(f.v.context, key).Result()
+			if err != nil {
+				return err
+			}
+			has := map[int]bool{}
+			var stale []any
+			for _, member := range members {
+				id, err := strconv.Atoi(member)
+				if err == nil {
+					has[id] = true
+				}
+				if err != nil || !index.want[key][id] {
+					stale = append(stale, member)
+				}
+			}
+			var missing []any
+			for _, id := range sortedKeys(index.want[key]) {
+				if !has[id] {
+					missing = append(missing, id)
+				}
+			}
+
+			if f.repair && len(missing) > 0 {
+				err = f.v.client.SAdd(f.v.context, key, missing...).Err()
+			}
+			if f.repair && len(stale) > 0 && err == nil {
+				err = f.v.client.SRem(f.v.context, key, stale...).Err()
+			}
+			if err != nil {
+				return err
+			}
+			for _, id := range missing {
+				f.report(schema.DiscrepancyIndex, id.(int), f.repair, "not in %s", key)
+			}
+			for _, member := range stale {
+				id, _ := strconv.Atoi(member.(string))
+				f.report(schema.DiscrepancyIndex, id, f.repair, "%s lists it, but the vote does not belong there", key)
+			}
+		}
+	}
+	return nil
+}
+
+// checkUniqueKeys checks that every poll and voter pair with a vote has a
+// unique key holding one of its votes, and that no other unique keys are
+// left. A missing key goes to the lowest vote id, as RebuildIndexes does.
+func (f *fsck) checkUniqueKeys() error {
+	want := map[string][]int{}
+	for _, vote := range f.votes {
+		key := uniqueKey(vote.PollId, vote.VoterId)
+		want[key] = append(want[key], vote.Id)
+	}
+
+	keys, err := f.scanKeys(UniqueKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := want[key]; !ok {
+			want[key] = nil
+		}
+	}
+
+	for _, key := range sortedKeys(want) {
+		ids := want[key]
+		holder, err := f.v.client.Get(f.v.context, key).Int()
+		if err == redis.Nil {
+			holder = 0
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case len(ids) == 0:
+			if f.repair {
+				err = f.v.client.Del(f.v.context, key).Err()
+			}
+			f.report(schema.DiscrepancyIndex, holder, f.repair, "%s holds it, but it does not exist", key)
+		case holder == 0:
+			if f.repair {
+				err = f.v.client.Set(f.v.context, key, ids[0], 0).Err()
+			}
+			f.report(schema.DiscrepancyIndex, ids[0], f.repair, "%s is not set", key)
+		case !containsInt(ids, holder):
+			if f.repair {
+				err = f.v.client.Set(f.v.context, key, ids[0], 0).Err()
+			}
+			f.report(schema.DiscrepancyIndex, ids[0], f.repair, "%s holds vote %d instead", key, holder)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkActivity compares the activity of every poll with the times its
+// votes were cast
+func (f *fsck) checkActivity() error {
+	votedAts := map[int]time.Time{}
+	for _, voter := range f.voters {
+		for _, vp := range voter.VoterPolls {
+			votedAts[vp.VoteId] = vp.VotedAt
+		}
+	}
+	want := map[int]map[string]int{}
+	for i, vote := range f.votes {
+		if want[vote.PollId] == nil {
+			want[vote.PollId] = map[string]int{}
+		}
+		want[vote.PollId][schema.ActivityField(schema.CastAt(&f.votes[i], votedAts[vote.Id]))]++
+	}
+
+	for _, id := range sortedKeys(f.polls) {
+		key := schema.ActivityKey(id)
+		has, err := f.v.client.HGetAll(f.v.context, key).Result()
+		if err != nil {
+			return err
+		}
+
+		wrong := 0
+		for field, n := range want[id] {
+			if has[field] != strconv.Itoa(n) {
+				wrong++
+			}
+		}
+		for field := range has {
+			if _, ok := want[id][field]; !ok {
+				wrong++
+			}
+		}
+		if wrong == 0 {
+			continue
+		}
+
+		if f.repair {
+			_, err = f.v.client.TxPipelined(f.v.context, func(pipe redis.Pipeliner) error {
+				pipe.Del(f.v.context, key)
+				for field, n := range want[id] {
+					pipe.HSet(f.v.context, key, field, n)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		f.report(schema.DiscrepancyActivity, id, f.repair, "activity is wrong in %d minutes, the votes were cast in %d", wrong, len(want[id]))
+	}
+	return nil
+}
+
+// checkIds compares the indexes of poll, voter and vote ids with the polls,
+// voters and votes
+func (f *fsck) checkIds() error {
+	voteIds := make([]int, 0, len(f.votes))
+	for _, vote := range f.votes {
+		voteIds = append(voteIds, vote.Id)
+	}
+
+	for _, set := range []struct {
+		key  string
+		kind string
+		want []int
+	}{
+		{schema.PollIdsKey, schema.DiscrepancyPollIds, sortedKeys(f.polls)},
+		{schema.VoterIdsKey, schema.DiscrepancyVoterIds, sortedKeys(f.voters)},
+		{voteIdsKey, schema.DiscrepancyIndex, voteIds},
+	} {
+		members, err := f.v.client.ZRange(f.v.context, set.key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		exists := map[int]bool{}
+		for _, id := range set.want {
+			exists[id] = true
+		}
+		has := map[int]bool{}
+		var stale []any
+		for _, member := range members {
+			id, err := strconv.Atoi(member)
+			if err == nil {
+				has[id] = true
+			}
+			if err != nil || !exists[id] {
+				stale = append(stale, member)
+			}
+		}
+		var missing []any
+		for _, id := range set.want {
+			if !has[id] {
+				missing = append(missing, id)
+			}
+		}
+
+		if f.repair && len(missing) > 0 {
+			_, err = f.v.client.Pipelined(f.v.context, func(pipe redis.Pipeliner) error {
+				for _, id := range missing {
+					service.Index(f.v.context, pipe, set.key, id.(int))
+				}
+				return nil
+			})
+		}
+		if f.repair && len(stale) > 0 && err == nil {
+			err = f.v.client.ZRem(f.v.context, set.key, stale...).Err()
+		}
+		if err != nil {
+			return err
+		}
+		for _, id := range missing {
+			f.report(set.kind, id.(int), f.repair, "not in %s", set.key)
+		}
+		for _, member := range stale {
+			id, _ := strconv.Atoi(member.(string))
+			f.report(set.kind, id, f.repair, "%s lists it, but it does not exist", set.key)
+		}
+	}
+	return nil
+}
+
+func add(sets map[string]map[int]bool, key string, id int) {
+	if sets[key] == nil {
+		sets[key] = map[int]bool{}
+	}
+	sets[key][id] = true
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order, so the report is stable
+func sortedKeys[K int | string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
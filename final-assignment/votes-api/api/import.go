@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/gin-gonic/gin"
+)
+
+// how many votes of a batch an import casts at the same time
+const importWorkers = 8
+
+// ImportVotes handles POST /votes/import. The body is NDJSON or CSV (see
+// schema.ImportReader), a vote per row. Each row is checked as POST /votes
+// checks a vote, and a voter may only vote once per poll across the import
+// as well. A vote touches the poll and the voter too, so unlike voters and
+// polls each vote is cast through its own saga, a batch of them at a time.
+// With ?dryRun=true the rows are only checked.
+func (v *VotesAPI) ImportVotes(c *gin.Context) {
+	format, err := schema.ImportFormat(c.Query("format"), c.ContentType())
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reader, err := schema.NewImportReader(c.Request.Body, format, &schema.Vote{})
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read import\n" + err.Error()})
+		return
+	}
+
+	// the voter and poll read by the check of each vote, for its saga
+	type checked struct {
+		voter schema.Voter
+		poll  schema.Poll
+	}
+	found := map[*schema.Vote]*checked{}
+
+	ctx := c.Request.Context()
+	report := schema.NewImportReport(c.Query("dryRun") == "true")
+	seen := map[int]bool{}
+	seenPairs := map[string]bool{}
+	check := func(vote *schema.Vote) error {
+		if vote.Id < 0 {
+			return errors.New("invalid vote id")
+		}
+		if vote.Id != 0 {
+			if seen[vote.Id] {
+				return fmt.Errorf("vote %d is in the import twice", vote.Id)
+			}
+			seen[vote.Id] = true
+			exists, err := v.client.Exists(ctx, redisKeyFromId(vote.Id)).Result()
+			if err != nil {
+				return err
+			}
+			if exists > 0 {
+				return errors.New("vote id already exists")
+			}
+		}
+
+		pair := uniqueKey(vote.PollId, vote.VoterId)
+		if seenPairs[pair] {
+			return fmt.Errorf("voter %d votes on poll %d twice in the import", vote.VoterId, vote.PollId)
+		}
+		seenPairs[pair] = true
+		exists, err := v.client.Exists(ctx, pair).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return errors.New("voter has already voted on this poll")
+		}
+
+		var ck checked
+		err = v.checkVote(ctx, vote, &ck.voter, &ck.poll)
+		if err != nil {
+			return err
+		}
+		if !report.DryRun {
+			found[vote] = &ck
+		}
+		return nil
+	}
+	write := func(votes []*schema.Vote) []error {
+		errs := make([]error, len(votes))
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < importWorkers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					errs[i] = v.importVote(ctx, votes[i], &found[votes[i]].voter, &found[votes[i]].poll)
+				}
+			}()
+		}
+		for i := range votes {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		for _, vote := range votes {
+			delete(found, vote)
+		}
+		return errs
+	}
+
+	err = schema.ImportRows(reader, &report, check, write, func(vote *schema.Vote) int { return vote.Id })
+	if err != nil {
+		v.invalidCall()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read import\n" + err.Error(), "report": report})
+		return
+	}
+
+	v.validCall()
+	c.JSON(http.StatusOK, report)
+}
+
+// importVote gives the vote an id if it has none, and casts it
+func (v *VotesAPI) importVote(ctx context.Context, vote *schema.Vote, voter *schema.Voter, poll *schema.Poll) error {
+	var err error
+	if vote.Id == 0 {
+		vote.Id, err = service.NextId(ctx, v.client, RedisKeyPrefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = v.cast(ctx, vote, voter, poll)
+	var se *sagaError
+	if errors.As(err, &se) {
+		_, msg := sagaFailure(err, vote)
+		return errors.New(msg)
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/go-redis/redis/v8"
+)
+
+// The votes of a poll and of a voter are kept in redis sets, so looking them
+// up does not need to read every vote. The sets are updated in the same
+// MULTI as the vote document itself. Their keys share the votes: prefix but
+// do not end in a number, so service.ScanIds skips them. The ids of all
+// votes are in the index votes:ids, which the list of votes is paged from.
+//
+// votes:by-poll-voter:<pollId>:<voterId> holds the id of the one vote a
+// voter may cast on a poll. It is claimed with SETNX before anything else is
+// written, so of two concurrent votes by the same voter only one gets it.
+const (
+	PollIndexPrefix  = RedisKeyPrefix + "by-poll:"
+	VoterIndexPrefix = RedisKeyPrefix + "by-voter:"
+	UniqueKeyPrefix  = RedisKeyPrefix + "by-poll-voter:"
+
+	voteIdsKey = RedisKeyPrefix + "ids"
+)
+
+// delIfEqualScript deletes a key only if it still holds the given value, so
+// a vote cannot free a unique key or lock that was taken over by another
+var delIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func pollIndexKey(pollId int) string {
+	return PollIndexPrefix + strconv.Itoa(pollId)
+}
+
+func voterIndexKey(voterId int) string {
+	return VoterIndexPrefix + strconv.Itoa(voterId)
+}
+
+func uniqueKey(pollId int, voterId int) string {
+	return UniqueKeyPrefix + strconv.Itoa(pollId) + ":" + strconv.Itoa(voterId)
+}
+
+// claimUnique reserves the poll and voter pair for the vote. It returns the
+// id of the vote that already holds it, or 0 if the claim worked.
+func (v *VotesAPI) claimUnique(ctx context.Context, vote *schema.Vote) (int, error) {
+	key := uniqueKey(vote.PollId, vote.VoterId)
+	for {
+		claimed, err := v.client.SetNX(ctx, key, vote.Id, 0).Result()
+		if err != nil || claimed {
+			return 0, err
+		}
+
+		holder, err := v.client.Get(ctx, key).Int()
+		if err == redis.Nil {
+			// released between the SETNX and the GET, try again
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if holder == vote.Id {
+			return 0, nil
+		}
+		return holder, nil
+	}
+}
+
+// releaseUnique frees the poll and voter pair, if the vote still holds it
+func (v *VotesAPI) releaseUnique(ctx context.Context, vote *schema.Vote) error {
+	key := uniqueKey(vote.PollId, vote.VoterId)
+	return delIfEqualScript.Run(ctx, v.client, []string{key}, vote.Id).Err()
+}
+
+// saveVote stores the vote and adds it to the poll and voter indexes
+func (v *VotesAPI) saveVote(ctx context.Context, vote *schema.Vote) error {
+	voteJSON, err := json.Marshal(vote)
+	if err != nil {
+		return err
+	}
+
+	// save vote in redis with votes:<id> as key
+	cacheKey := redisKeyFromId(vote.Id)
+	_, err = v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Do(ctx, "JSON.SET", cacheKey, ".", string(voteJSON))
+		pipe.SAdd(ctx, pollIndexKey(vote.PollId), vote.Id)
+		pipe.SAdd(ctx, voterIndexKey(vote.VoterId), vote.Id)
+		pipe.Set(ctx, uniqueKey(vote.PollId, vote.VoterId), vote.Id, 0)
+		service.Index(ctx, pipe, voteIdsKey, vote.Id)
+		return nil
+	})
+	return err
+}
+
+// deleteVote removes the vote and takes it out of the indexes
+func (v *VotesAPI) deleteVote(ctx context.Context, vote *schema.Vote) error {
+	cacheKey := redisKeyFromId(vote.Id)
+	_, err := v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Do(ctx, "JSON.DEL", cacheKey, ".")
+		pipe.SRem(ctx, pollIndexKey(vote.PollId), vote.Id)
+		pipe.SRem(ctx, voterIndexKey(vote.VoterId), vote.Id)
+		service.Unindex(ctx, pipe, voteIdsKey, vote.Id)
+		delIfEqualScript.Eval(ctx, pipe, []string{uniqueKey(vote.PollId, vote.VoterId)}, vote.Id)
+		return nil
+	})
+	return err
+}
+
+// getVotesFromIndex loads the votes listed in an index set, sorted by id
+func (v *VotesAPI) getVotesFromIndex(indexKey string) ([]schema.Vote, error) {
+	members, err := v.client.SMembers(v.context, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	votes := make([]schema.Vote, 0, len(ids))
+	for _, id := range ids {
+		var vote schema.Vote
+		err := v.getItemFromRedis(redisKeyFromId(id), &vote)
+		if err == redis.Nil {
+			// the index is ahead of a delete, skip it
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		votes = append(votes, vote)
+	}
+
+	return votes, nil
+}
+
+// TrackIds adds the votes that are stored to the index of vote ids, for
+// votes written before the index was kept. It runs before the api serves
+// requests, see service.TrackIds.
+func (v *VotesAPI) TrackIds() error {
+	return service.TrackIds(v.context, v.client, RedisKeyPrefix, voteIdsKey)
+}
+
+// RebuildIndexes drops the poll and voter indexes, the unique keys and the
+// index of vote ids, and builds them again from the stored votes. Use it
+// once for votes written before the indexes existed. If a voter has several
+// votes on one poll from before votes were unique, the lowest vote id keeps
+// the unique key.
+func (v *VotesAPI) RebuildIndexes() error {
+	err := v.client.Del(v.context, voteIdsKey).Err()
+	if err != nil {
+		return err
+	}
+	for _, prefix := range []string{PollIndexPrefix, VoterIndexPrefix, UniqueKeyPrefix} {
+		iter := v.client.Scan(v.context, 0, prefix+"*", service.ScanCount).Iterator()
+		for iter.Next(v.context) {
+			err := v.client.Del(v.context, iter.Val()).Err()
+			if err != nil {
+				return err
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	ids, err := service.ScanIds(v.context, v.client, RedisKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var vote schema.Vote
+		err := v.getItemFromRedis(redisKeyFromId(id), &vote)
+		if err != nil {
+			return err
+		}
+
+		_, err = v.client.TxPipelined(v.context, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(v.context, pollIndexKey(vote.PollId), vote.Id)
+			pipe.SAdd(v.context, voterIndexKey(vote.VoterId), vote.Id)
+			pipe.SetNX(v.context, uniqueKey(vote.PollId, vote.VoterId), vote.Id, 0)
+			service.Index(v.context, pipe, voteIdsKey, vote.Id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Rebuilt indexes for %d votes", len(ids))
+	return nil
+}
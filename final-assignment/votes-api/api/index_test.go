@@ -0,0 +1,161 @@
+package api
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/go-redis/redis/v8"
+	"github.com/nitishm/go-rejson/v4"
+)
+
+// testVotesAPI is testPeers with database 15 of the redis at REDIS_URL,
+// which needs RedisJSON. Without one the test is skipped.
+func testVotesAPI(t *testing.T) (*VotesAPI, *peers) {
+	t.Helper()
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	v, p := testPeers(t)
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+	err := client.Ping(v.context).Err()
+	if err != nil {
+		t.Skipf("redis at %s not reachable: %s", addr, err.Error())
+	}
+	err = client.FlushDB(v.context).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB(v.context)
+		client.Close()
+	})
+
+	v.client = client
+	v.helper = rejson.NewReJSONHandler()
+	v.helper.SetGoRedisClientWithContext(v.context, client)
+	v.outbox = events.NewOutbox(client, outboxKey, "votes-api")
+	return v, p
+}
+
+// members reads an index set as sorted ids
+func members(t *testing.T, v *VotesAPI, key string) []int {
+	t.Helper()
+	raw, err := v.client.SMembers(v.context, key).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, member := range raw {
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// checkIndexes checks the poll, voter and unique indexes and the index of
+// vote ids against the votes
+func checkIndexes(t *testing.T, v *VotesAPI, byPoll map[int][]int, byVoter map[int][]int, unique map[string]int, all []int) {
+	t.Helper()
+	for pollId, want := range byPoll {
+		if got := members(t, v, pollIndexKey(pollId)); !reflect.DeepEqual(got, want) {
+			t.Errorf("votes of poll %d are %v, want %v", pollId, got, want)
+		}
+	}
+	for voterId, want := range byVoter {
+		if got := members(t, v, voterIndexKey(voterId)); !reflect.DeepEqual(got, want) {
+			t.Errorf("votes of voter %d are %v, want %v", voterId, got, want)
+		}
+	}
+	for key, want := range unique {
+		got, err := v.client.Get(v.context, UniqueKeyPrefix+key).Int()
+		if err != nil || got != want {
+			t.Errorf("unique key %s holds %d (%v), want %d", key, got, err, want)
+		}
+	}
+	ids, err := service.Ids(v.context, v.client, voteIdsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, all) {
+		t.Errorf("vote ids are %v, want %v", ids, all)
+	}
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	v, _ := testVotesAPI(t)
+
+	votes := []schema.Vote{
+		{Id: 1, PollId: 1, VoterId: 1, VoteValue: 1},
+		{Id: 2, PollId: 1, VoterId: 2, VoteValue: 2},
+		{Id: 3, PollId: 2, VoterId: 1, VoteValue: 1},
+	}
+	for i := range votes {
+		err := v.saveVote(v.context, &votes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a vote from before votes were unique, written without any index.
+	// Vote 1 has the lower id, it keeps the unique key.
+	_, err := v.helper.JSONSet(redisKeyFromId(4), ".", schema.Vote{Id: 4, PollId: 1, VoterId: 1, VoteValue: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byPoll := map[int][]int{1: {1, 2, 4}, 2: {3}}
+	byVoter := map[int][]int{1: {1, 3, 4}, 2: {2}}
+	unique := map[string]int{"1:1": 1, "1:2": 2, "2:1": 3}
+	all := []int{1, 2, 3, 4}
+
+	err = v.RebuildIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, v, byPoll, byVoter, unique, all)
+
+	// every index key is deleted, or holds something stale
+	keys, err := v.client.Keys(v.context, RedisKeyPrefix+"by-*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = append(keys, voteIdsKey)
+	err = v.client.Del(v.context, keys...).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.client.SAdd(v.context, pollIndexKey(2), 9).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.client.Set(v.context, uniqueKey(1, 1), 9, 0).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = v.RebuildIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, v, byPoll, byVoter, unique, all)
+
+	// the rebuilt indexes are what the routes read
+	found, err := v.getVotesFromIndex(pollIndexKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || found[0].Id != 1 || found[2].Id != 4 {
+		t.Errorf("votes of poll 1 read from the index are %+v", found)
+	}
+}
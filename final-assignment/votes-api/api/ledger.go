@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"drexel.edu/schema"
+	"github.com/go-redis/redis/v8"
+)
+
+// A vote that is cast, changed or deleted is appended to the ledger of its
+// poll (schema.LedgerKey) in the transaction that completes its saga, so the
+// ledger has exactly the votes that were not undone. Each entry is chained
+// to the one before it, so the ledger is watched while the entry is made,
+// and the transaction is tried again if another vote on the poll got there
+// first. If it never gets through, the vote fails with errLedgerBusy and
+// its saga is undone.
+const maxLedgerRetries = 100
+
+var errLedgerBusy = errors.New("too many votes on the poll at once")
+
+// ledgerRecord is what a saga appends to the ledger when it completes
+type ledgerRecord struct {
+	Action string
+	// read when the saga completes, so it can point at the vote the steps
+	// fill in
+	Vote *schema.Vote
+}
+
+// record sets the ledger entry appended when the saga completes, see emit.
+// A step may change it, it is saved in the log with the step.
+func (s *saga) record(action string, vote *schema.Vote) {
+	s.entry = &ledgerRecord{Action: action, Vote: vote}
+	s.log.LedgerAction = action
+}
+
+// appendLedger appends record to the ledger of its poll, in one transaction
+// with what write adds to the pipeline
+func (v *VotesAPI) appendLedger(ctx context.Context, record *ledgerRecord, write func(pipe redis.Pipeliner) error) error {
+	key := schema.LedgerKey(record.Vote.PollId)
+
+	txf := func(tx *redis.Tx) error {
+		var prev *schema.LedgerEntry
+		last, err := tx.LIndex(ctx, key, -1).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			prev = &schema.LedgerEntry{}
+			err = json.Unmarshal([]byte(last), prev)
+			if err != nil {
+				return err
+			}
+		}
+
+		entry, err := schema.NewLedgerEntry(prev, record.Action, record.Vote, time.Now())
+		if err != nil {
+			return err
+		}
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.RPush(ctx, key, entryJSON)
+			return write(pipe)
+		})
+		return err
+	}
+
+	for i := 0; i < maxLedgerRetries; i++ {
+		err := v.client.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		// votes that lost together should not retry together
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+	}
+	return fmt.Errorf("%w, the ledger changed on each of %d tries", errLedgerBusy, maxLedgerRetries)
+}
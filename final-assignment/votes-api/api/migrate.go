@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"drexel.edu/client"
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/go-redis/redis/v8"
+)
+
+// optionIdsMigratedKey is set once all stored votes refer to options by id.
+// Running the migration a second time would read the ids as positions, so
+// it is skipped while the key exists. Until then the ids of the votes that
+// were rewritten are kept in migratedVotesKey, so a run after some votes
+// were skipped only rewrites the others.
+const (
+	optionIdsMigratedKey = RedisKeyPrefix + "migrated:option-ids"
+	migratedVotesKey     = RedisKeyPrefix + "migrated:option-ids:votes"
+)
+
+// legacyVote is a vote stored while votes referred to an option by its
+// position in the poll's options
+type legacyVote struct {
+	PollId    int `json:"pollId"`
+	VoteValue int `json:"voteValue"`
+	Ballot    *struct {
+		Choices []int `json:"choices,omitempty"`
+		Scores  []int `json:"scores,omitempty"`
+	} `json:"ballot,omitempty"`
+}
+
+// MigrateOptionIds rewrites the votes stored before votes referred to
+// options by id, replacing every option position with the id of the option
+// at that position. Run it with the votes api stopped, before votes are
+// cast again. Votes whose poll is gone or whose position is out of range
+// are left as they are, as are votes on a poll whose options have no id,
+// and the error lists them. Once they are fixed or deleted it can be run
+// again, and only the skipped votes are rewritten.
+func (v *VotesAPI) MigrateOptionIds() error {
+	done, err := v.client.Exists(v.context, optionIdsMigratedKey).Result()
+	if err != nil {
+		return err
+	}
+	if done > 0 {
+		log.Println("Votes already refer to options by id")
+		return nil
+	}
+
+	ids, err := service.ScanIds(v.context, v.client, RedisKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	polls := make(map[int]*schema.Poll)
+	migrated := 0
+	var skipped []string
+	for _, id := range ids {
+		done, err := v.client.SIsMember(v.context, migratedVotesKey, id).Result()
+		if err != nil {
+			return err
+		}
+		if done {
+			migrated++
+			continue
+		}
+
+		raw, err := v.helper.JSONGet(redisKeyFromId(id), ".")
+		if err != nil {
+			return err
+		}
+		var old legacyVote
+		err = json.Unmarshal(raw.([]byte), &old)
+		if err != nil {
+			return err
+		}
+
+		poll, ok := polls[old.PollId]
+		if !ok {
+			found, err := v.pollClient.GetPoll(v.context, old.PollId, &client.GetOptions{Embed: "none"})
+			if err != nil && !client.IsNotFound(err) {
+				return err
+			}
+			if err == nil {
+				poll = &found
+			}
+			polls[old.PollId] = poll
+		}
+		if poll == nil {
+			skipped = append(skipped, fmt.Sprintf("vote %d, poll %d does not exist", id, old.PollId))
+			continue
+		}
+
+		optionId := func(position int) (int, bool) {
+			if position < 0 || position >= len(poll.Options) {
+				return 0, false
+			}
+			// options of old polls may not have an id yet
+			id := poll.Options[position].Id
+			return id, id > 0
+		}
+
+		vote := schema.Vote{}
+		valid := true
+		vote.VoteValue, valid = optionId(old.VoteValue)
+		if old.Ballot != nil {
+			vote.Ballot = &schema.Ballot{}
+			for _, position := range old.Ballot.Choices {
+				choice, ok := optionId(position)
+				valid = valid && ok
+				vote.Ballot.Choices = append(vote.Ballot.Choices, choice)
+			}
+			if old.Ballot.Scores != nil {
+				vote.Ballot.Scores = make(map[int]int, len(old.Ballot.Scores))
+				for position, score := range old.Ballot.Scores {
+					option, ok := optionId(position)
+					valid = valid && ok
+					vote.Ballot.Scores[option] = score
+				}
+			}
+		}
+		if !valid {
+			skipped = append(skipped, fmt.Sprintf("vote %d, poll %d has no option with an id at its position", id, old.PollId))
+			continue
+		}
+
+		ballot, err := json.Marshal(vote.Ballot)
+		if err != nil {
+			return err
+		}
+		key := redisKeyFromId(id)
+		_, err = v.client.TxPipelined(v.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(v.context, "JSON.SET", key, ".voteValue", strconv.Itoa(vote.VoteValue))
+			if vote.Ballot != nil {
+				pipe.Do(v.context, "JSON.SET", key, ".ballot", string(ballot))
+			}
+			pipe.SAdd(v.context, migratedVotesKey, id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	log.Printf("Migrated %d of %d votes to option ids", migrated, len(ids))
+	if len(skipped) > 0 {
+		return fmt.Errorf("%d votes not migrated, run again once they are fixed:\n%s", len(skipped), strings.Join(skipped, "\n"))
+	}
+
+	_, err = v.client.TxPipelined(v.context, func(pipe redis.Pipeliner) error {
+		pipe.Set(v.context, optionIdsMigratedKey, migrated, 0)
+		pipe.Del(v.context, migratedVotesKey)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/client"
+	"github.com/gin-gonic/gin"
+)
+
+// The votes api cannot do anything useful while the voter or poll api is
+// down, so calls to each of them have a timeout and a circuit breaker. When
+// a breaker is open, requests that need that api fail straight away with
+// 503 and a Retry-After header, instead of waiting on an api that is down.
+const (
+	voterAPITimeout = 2 * time.Second
+	pollAPITimeout  = 2 * time.Second
+
+	// retries of GETs to the voter and poll apis, with jittered backoff
+	peerRetries      = 2
+	peerRetryWait    = 100 * time.Millisecond
+	peerRetryMaxWait = time.Second
+
+	// failed calls in a row that open a breaker, and how long it stays open
+	breakerThreshold = 5
+	breakerCooldown  = 10 * time.Second
+)
+
+// newPeerClient returns a client for the voter or poll api with its own breaker
+func newPeerClient(name string, config client.Config, timeout time.Duration) (*client.Client, *client.Breaker) {
+	breaker := client.NewBreaker(name, breakerThreshold, breakerCooldown)
+
+	config.Timeout = timeout
+	config.Retries = peerRetries
+	config.RetryWait = peerRetryWait
+	config.RetryMaxWait = peerRetryMaxWait
+	config.Breaker = breaker
+	return client.New(config), breaker
+}
+
+// breakerStatus lists the breakers for the health check, degraded is set
+// when any of them is not closed
+func (v *VotesAPI) breakerStatus() ([]client.BreakerStatus, bool) {
+	statuses := []client.BreakerStatus{v.voterBreaker.Status(), v.pollBreaker.Status()}
+
+	degraded := false
+	for _, status := range statuses {
+		if status.State != client.BreakerClosed {
+			degraded = true
+		}
+	}
+	return statuses, degraded
+}
+
+// peerUnavailable answers 503 if err comes from an open breaker, and
+// reports whether it did
+func (v *VotesAPI) peerUnavailable(c *gin.Context, err error) bool {
+	retryAfter, open := client.RetryAfter(err)
+	if !open {
+		return false
+	}
+
+	v.invalidCall()
+	setRetryAfter(c, retryAfter)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable\n" + err.Error()})
+	return true
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, at least 1
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"drexel.edu/schema"
+	"drexel.edu/service"
+)
+
+// Rendering a list of votes needs the voter and poll of every vote. The
+// resolver looks each distinct voter and poll up once per request, with a
+// bounded number of lookups running at the same time. Below it, a short
+// lived cache shared by all requests saves the round trip to the voter and
+// poll apis altogether. The votes api drops an entry from the cache whenever
+// it changes that voter or poll itself.
+const (
+	// lookups to the voter and poll apis running at once per request
+	resolverParallelism = 8
+	// how long a voter or poll is served from the cache
+	embedCacheTTL = 5 * time.Second
+)
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// ttlCache is a small map based cache where entries expire after ttl
+type ttlCache[T any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int]cacheEntry[T]
+}
+
+func newTTLCache[T any](ttl time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{
+		ttl:     ttl,
+		entries: make(map[int]cacheEntry[T]),
+	}
+}
+
+func (c *ttlCache[T]) get(id int) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, id)
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *ttlCache[T]) set(id int, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[id] = cacheEntry[T]{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *ttlCache[T]) invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
+
+// resolver de-duplicates the voter and poll lookups of one request. Only
+// what embed asks for is looked up, with embed=none nothing is.
+type resolver struct {
+	api    *VotesAPI
+	ctx    context.Context
+	embed  service.Embed
+	voters map[int]schema.Voter
+	polls  map[int]schema.Poll
+}
+
+func (v *VotesAPI) newResolver(ctx context.Context, embed service.Embed) *resolver {
+	return &resolver{
+		api:    v,
+		ctx:    ctx,
+		embed:  embed,
+		voters: make(map[int]schema.Voter),
+		polls:  make(map[int]schema.Poll),
+	}
+}
+
+// resolve fetches the voters and polls of the votes and sets up their links
+// and embedded properties. The first lookup error is returned.
+func (r *resolver) resolve(votes []schema.Vote) error {
+	voterIds := make(map[int]bool)
+	pollIds := make(map[int]bool)
+	for _, vote := range votes {
+		if _, ok := r.voters[vote.VoterId]; r.embed.Voter && !ok {
+			voterIds[vote.VoterId] = true
+		}
+		if _, ok := r.polls[vote.PollId]; r.embed.Poll && !ok {
+			pollIds[vote.PollId] = true
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, resolverParallelism)
+	)
+
+	lookup := func(fetch func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			err := fetch()
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for id := range voterIds {
+		id := id
+		lookup(func() error {
+			voter, err := r.api.cachedVoter(r.ctx, id)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			r.voters[id] = voter
+			mu.Unlock()
+			return nil
+		})
+	}
+	for id := range pollIds {
+		id := id
+		lookup(func() error {
+			poll, err := r.api.cachedPoll(r.ctx, id)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			r.polls[id] = poll
+			mu.Unlock()
+			return nil
+		})
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	for i := range votes {
+		var voter *schema.Voter
+		var poll *schema.Poll
+		if r.embed.Voter {
+			found := r.voters[votes[i].VoterId]
+			voter = &found
+		}
+		if r.embed.Poll {
+			found := r.polls[votes[i].PollId]
+			poll = &found
+		}
+		setLinkAndEmbeddedProps(r.api, &votes[i], voter, poll)
+	}
+	return nil
+}
+
+// cachedVoter returns the voter from the cache, or from the voter api
+func (v *VotesAPI) cachedVoter(ctx context.Context, id int) (schema.Voter, error) {
+	if voter, ok := v.voterCache.get(id); ok {
+		return voter, nil
+	}
+
+	var voter schema.Voter
+	err := getVoter(ctx, &schema.Vote{VoterId: id}, v, &voter)
+	if err != nil {
+		return voter, err
+	}
+	return voter, nil
+}
+
+// cachedPoll returns the poll from the cache, or from the poll api
+func (v *VotesAPI) cachedPoll(ctx context.Context, id int) (schema.Poll, error) {
+	if poll, ok := v.pollCache.get(id); ok {
+		return poll, nil
+	}
+
+	var poll schema.Poll
+	err := getPoll(ctx, &schema.Vote{PollId: id}, v, &poll)
+	if err != nil {
+		return poll, err
+	}
+	return poll, nil
+}
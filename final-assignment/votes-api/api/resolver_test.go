@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"drexel.edu/client"
+	"drexel.edu/schema"
+	"drexel.edu/service"
+	"github.com/gin-gonic/gin"
+)
+
+// peers stands in for the voter and poll apis, it serves GET /voters/:id
+// and GET /polls/:id and counts the requests. A poll set in polls is served
+// as it is.
+type peers struct {
+	mu       sync.Mutex
+	requests map[string]int
+	polls    map[int]schema.Poll
+}
+
+func (p *peers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.requests[r.URL.Path]++
+	p.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.Atoi(parts[len(parts)-1])
+	if len(parts) != 2 || err != nil || id > 100 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch parts[0] {
+	case "voters":
+		json.NewEncoder(w).Encode(schema.Voter{Id: id, Name: "voter " + parts[1]})
+	case "polls":
+		p.mu.Lock()
+		poll, ok := p.polls[id]
+		p.mu.Unlock()
+		if !ok {
+			poll = schema.Poll{Id: id, Title: "poll " + parts[1]}
+		}
+		json.NewEncoder(w).Encode(poll)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (p *peers) count(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[path]
+}
+
+func (p *peers) total() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, count := range p.requests {
+		n += count
+	}
+	return n
+}
+
+// testPeers returns a VotesAPI whose voter and poll apis are a peers server.
+// It has no redis, tests that need one use testVotesAPI.
+func testPeers(t *testing.T) (*VotesAPI, *peers) {
+	t.Helper()
+	p := &peers{requests: map[string]int{}, polls: map[int]schema.Poll{}}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+
+	api := API{Polls: "http://polls.test/polls", Voters: "http://voters.test/voters", Self: "http://votes.test"}
+	internal := API{Polls: srv.URL + "/polls", Voters: srv.URL + "/voters"}
+	voterClient, voterBreaker := newPeerClient("voter-api", client.Config{VotersURL: internal.Voters}, voterAPITimeout)
+	pollClient, pollBreaker := newPeerClient("poll-api", client.Config{PollsURL: internal.Polls}, pollAPITimeout)
+	v := &VotesAPI{
+		API:          api,
+		InternalAPI:  internal,
+		voterClient:  voterClient,
+		pollClient:   pollClient,
+		voterBreaker: voterBreaker,
+		pollBreaker:  pollBreaker,
+		voterCache:   newTTLCache[schema.Voter](embedCacheTTL),
+		pollCache:    newTTLCache[schema.Poll](embedCacheTTL),
+	}
+	v.context = context.Background()
+	return v, p
+}
+
+// embedOf parses ?embed= the way GET /votes does
+func embedOf(t *testing.T, query string) service.Embed {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/votes?"+query, nil)
+	embed, err := service.ParseEmbed(c, service.EmbedAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return embed
+}
+
+func TestResolverEmbed(t *testing.T) {
+	tests := []struct {
+		query  string
+		voters bool
+		polls  bool
+	}{
+		{"embed=none", false, false},
+		{"embed=voter", true, false},
+		{"embed=poll", false, true},
+		{"embed=voter,poll", true, true},
+		{"", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			v, p := testPeers(t)
+			// two voters and two polls, each used twice
+			votes := []schema.Vote{
+				{Id: 1, VoterId: 1, PollId: 1},
+				{Id: 2, VoterId: 1, PollId: 2},
+				{Id: 3, VoterId: 2, PollId: 1},
+				{Id: 4, VoterId: 2, PollId: 2},
+			}
+			err := v.newResolver(v.context, embedOf(t, tt.query)).resolve(votes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// every distinct voter and poll is looked up once, and only
+			// when it is embedded
+			for _, id := range []string{"1", "2"} {
+				if got, want := p.count("/voters/"+id), boolToInt(tt.voters); got != want {
+					t.Errorf("voter %s looked up %d times, want %d", id, got, want)
+				}
+				if got, want := p.count("/polls/"+id), boolToInt(tt.polls); got != want {
+					t.Errorf("poll %s looked up %d times, want %d", id, got, want)
+				}
+			}
+
+			for _, vote := range votes {
+				if want := "http://votes.test/votes/" + strconv.Itoa(vote.Id); vote.Links.Self.Href != want {
+					t.Errorf("vote %d links to %q, want %q", vote.Id, vote.Links.Self.Href, want)
+				}
+				if !tt.voters && !tt.polls {
+					if vote.Embedded != nil {
+						t.Errorf("vote %d embeds %+v, want nothing", vote.Id, vote.Embedded)
+					}
+					continue
+				}
+
+				embedded, ok := vote.Embedded.(schema.VoteEmbedded)
+				if !ok {
+					t.Fatalf("vote %d embeds %T, want schema.VoteEmbedded", vote.Id, vote.Embedded)
+				}
+				if (embedded.Voter != nil) != tt.voters || (embedded.Poll != nil) != tt.polls {
+					t.Errorf("vote %d embeds voter %v and poll %v, want %v and %v",
+						vote.Id, embedded.Voter != nil, embedded.Poll != nil, tt.voters, tt.polls)
+				}
+				if embedded.Voter != nil && embedded.Voter.Id != vote.VoterId {
+					t.Errorf("vote %d embeds voter %d, want %d", vote.Id, embedded.Voter.Id, vote.VoterId)
+				}
+				if embedded.Poll != nil && embedded.Poll.Id != vote.PollId {
+					t.Errorf("vote %d embeds poll %d, want %d", vote.Id, embedded.Poll.Id, vote.PollId)
+				}
+			}
+		})
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	v, p := testPeers(t)
+	embed := embedOf(t, "embed=voter,poll")
+
+	// a second request is served from the cache
+	for i := 0; i < 2; i++ {
+		err := v.newResolver(v.context, embed).resolve([]schema.Vote{{Id: 1, VoterId: 1, PollId: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := p.total(); n != 2 {
+		t.Errorf("peers got %d requests, want 2", n)
+	}
+
+	// until the votes api changes the voter itself
+	v.voterCache.invalidate(1)
+	err := v.newResolver(v.context, embed).resolve([]schema.Vote{{Id: 1, VoterId: 1, PollId: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.count("/voters/1"); n != 2 {
+		t.Errorf("voter looked up %d times after it changed, want 2", n)
+	}
+}
+
+func TestResolverNotFound(t *testing.T) {
+	v, _ := testPeers(t)
+	votes := []schema.Vote{{Id: 1, VoterId: 1, PollId: 1}, {Id: 2, VoterId: 1, PollId: 404}}
+
+	err := v.newResolver(v.context, embedOf(t, "embed=poll")).resolve(votes)
+	if err == nil || !strings.Contains(err.Error(), "poll with id=404") {
+		t.Errorf("resolving a missing poll returned %v, want it named", err)
+	}
+
+	// a missing poll does not matter when it is not embedded
+	err = v.newResolver(v.context, embedOf(t, "embed=none")).resolve(votes)
+	if err != nil {
+		t.Errorf("resolving without embedding returned %v", err)
+	}
+}
+
+func TestResolverRequestContext(t *testing.T) {
+	v, p := testPeers(t)
+
+	// the lookups run in the context of the request, a client that hung up
+	// stops them
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := v.newResolver(ctx, embedOf(t, "embed=voter")).resolve([]schema.Vote{{Id: 1, VoterId: 1, PollId: 1}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("resolving for a canceled request returned %v, want context.Canceled", err)
+	}
+	if n := p.total(); n != 0 {
+		t.Errorf("peers got %d requests for a canceled request, want none", n)
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,567 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/client"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// A vote touches three services (poll counts, voter history and the vote
+// itself), so it cannot be written in one transaction. Instead every write
+// runs as a saga: each step is recorded in redis before it starts and again
+// when it completed, and if a later step fails the completed ones are undone
+// in reverse order. Sagas that are still in the log after a crash are
+// finished by RecoverSagas if all their steps completed, and compensated
+// otherwise. A compensation may run more than once, after a crash or when
+// the step it undoes may not have been made, so every compensation is safe
+// to repeat: the poll api takes back a change of its counts at most once,
+// see sagaChangeKey, and the others set the voter or vote to what it was.
+
+const (
+	SagaKeyPrefix = "sagas:"
+
+	sagaIdKey       = SagaKeyPrefix + "next-id"
+	sagaInFlightKey = SagaKeyPrefix + "inflight"
+
+	// sagas younger than this are assumed to still be running on some replica
+	sagaRecoveryAge = 30 * time.Second
+	// how often the log is checked for abandoned sagas
+	sagaRecoveryInterval = time.Minute
+	// a replica recovering a saga holds sagas:<id>:lease for this long, so
+	// no other replica recovers it at the same time
+	sagaLeaseTTL = time.Minute
+)
+
+const (
+	sagaCastVote   = "vote.cast"
+	sagaChangeVote = "vote.change"
+	sagaDeleteVote = "vote.delete"
+)
+
+// step names, the compensation for each is looked up by name so it can
+// still be run after a restart
+const (
+	stepClaimVote     = "vote.claim"
+	stepClaimUnique   = "vote.unique"
+	stepIncrementPoll = "poll.increment"
+	stepDecrementPoll = "poll.decrement"
+	stepMovePoll      = "poll.move"
+	stepAddVoterPoll  = "voter.add"
+	stepRemoveVoter   = "voter.remove"
+	stepUpdateVoter   = "voter.update"
+	stepSaveVote      = "vote.save"
+	stepUpdateVote    = "vote.update"
+	stepDeleteVote    = "vote.delete"
+
+	// not a step, the failure of finish is reported as one
+	stepFinish = "saga.finish"
+)
+
+var (
+	errVoteExists    = errors.New("vote already exists")
+	errDuplicateVote = errors.New("voter already voted on this poll")
+)
+
+// sagaLog is the durable record of a saga, stored as sagas:<id>
+type sagaLog struct {
+	Id        int              `json:"id"`
+	Kind      string           `json:"kind"`
+	Vote      schema.Vote      `json:"vote"`
+	VoterPoll schema.VoterPoll `json:"voterPoll"`
+	// the steps of the saga in order, the ones that completed, and the one
+	// that was started last if it did not complete, it may have been made
+	Steps     []string  `json:"steps"`
+	Completed []string  `json:"completed"`
+	Started   string    `json:"started,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+
+	// what the saga records when it completes, see emit and record, so
+	// recovery can complete it
+	EventType    string `json:"eventType,omitempty"`
+	LedgerAction string `json:"ledgerAction,omitempty"`
+
+	// the vote and history entry before a change, only set for vote.change
+	PrevVote      *schema.Vote      `json:"prevVote,omitempty"`
+	PrevVoterPoll *schema.VoterPoll `json:"prevVoterPoll,omitempty"`
+}
+
+type sagaStep struct {
+	Name   string
+	Action func() error
+}
+
+// sagaError is returned by run, it names the step that failed
+type sagaError struct {
+	Step string
+	Err  error
+}
+
+func (e *sagaError) Error() string {
+	return fmt.Sprintf("saga step %s failed: %s", e.Step, e.Err.Error())
+}
+
+func (e *sagaError) Unwrap() error {
+	return e.Err
+}
+
+type saga struct {
+	api *VotesAPI
+	// the context of the request that runs the saga, or of the api for one
+	// that is recovered
+	ctx context.Context
+	log sagaLog
+	// written to the outbox if the saga completes, see emit
+	event *sagaEvent
+	// appended to the ledger of the poll if the saga completes, see record
+	entry *ledgerRecord
+}
+
+// sagaEvent is the event of a saga, Data is marshalled when the saga
+// completes, so it can point at the vote the steps fill in
+type sagaEvent struct {
+	Type string
+	Data any
+}
+
+// compensations undo a completed step using only what is in the saga log
+var compensations = map[string]func(ctx context.Context, v *VotesAPI, l *sagaLog) error{
+	stepClaimVote: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		_, err := v.helper.JSONDel(redisKeyFromId(l.Vote.Id), ".")
+		return err
+	},
+	stepClaimUnique: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return v.releaseUnique(ctx, &l.Vote)
+	},
+	stepIncrementPoll: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return undoPollCounts(ctx, &l.Vote, v, 1, sagaChangeKey(l, stepIncrementPoll))
+	},
+	stepDecrementPoll: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return undoPollCounts(ctx, &l.Vote, v, -1, sagaChangeKey(l, stepDecrementPoll))
+	},
+	stepMovePoll: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return undoMoveVotePoll(ctx, &l.Vote, v, l.PrevVote.VoteValue, sagaChangeKey(l, stepMovePoll))
+	},
+	stepAddVoterPoll: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		var voter schema.Voter
+		return removeVoterPoll(ctx, &l.Vote, v, &voter)
+	},
+	stepRemoveVoter: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		var voter schema.Voter
+		return addVoterPoll(ctx, &l.Vote, v, l.VoterPoll, &voter)
+	},
+	stepUpdateVoter: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		var voter schema.Voter
+		return updateVoterPoll(ctx, &l.Vote, v, *l.PrevVoterPoll, &voter)
+	},
+	stepSaveVote: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return v.deleteVote(ctx, &l.Vote)
+	},
+	stepUpdateVote: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return v.saveVote(ctx, l.PrevVote)
+	},
+	stepDeleteVote: func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+		return v.saveVote(ctx, &l.Vote)
+	},
+}
+
+// newSaga starts a saga and writes it to the in flight log
+func (v *VotesAPI) newSaga(ctx context.Context, kind string, vote *schema.Vote, voterPoll schema.VoterPoll) (*saga, error) {
+	return v.startSaga(ctx, sagaLog{Kind: kind, Vote: *vote, VoterPoll: voterPoll})
+}
+
+// startSaga gives the log an id and writes it to the in flight log
+func (v *VotesAPI) startSaga(ctx context.Context, l sagaLog) (*saga, error) {
+	id, err := v.client.Incr(ctx, sagaIdKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	l.Id = int(id)
+	l.Completed = []string{}
+	l.StartedAt = time.Now()
+	s := &saga{api: v, ctx: ctx, log: l}
+
+	_, err = v.helper.JSONSet(s.key(), ".", s.log)
+	if err != nil {
+		return nil, err
+	}
+	err = v.client.SAdd(ctx, sagaInFlightKey, s.log.Id).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *saga) key() string {
+	return SagaKeyPrefix + strconv.Itoa(s.log.Id)
+}
+
+// save writes the log of the saga
+func (s *saga) save() error {
+	_, err := s.api.helper.JSONSet(s.key(), ".", s.log)
+	return err
+}
+
+// sagaChangeKey is the Idempotency-Key of a change a step makes to the
+// counts of a poll. The poll api makes the change once, and its
+// compensation takes it back once, however often either is sent.
+func sagaChangeKey(l *sagaLog, step string) string {
+	return "saga-" + strconv.Itoa(l.Id) + "-" + step
+}
+
+// changeKey is sagaChangeKey for a step of this saga
+func (s *saga) changeKey(step string) string {
+	return sagaChangeKey(&s.log, step)
+}
+
+// run executes the steps in order. If a step fails, the steps that already
+// completed are compensated and the error of the failed step is returned.
+// It only returns nil once the saga is finished, with its event, ledger
+// entry and activity written.
+func (s *saga) run(steps ...sagaStep) error {
+	s.log.Steps = make([]string, len(steps))
+	for i, step := range steps {
+		s.log.Steps[i] = step.Name
+	}
+	if s.event != nil {
+		s.log.EventType = s.event.Type
+	}
+	if s.entry != nil {
+		s.log.LedgerAction = s.entry.Action
+	}
+
+	for _, step := range steps {
+		// a crash from here on leaves the step to recovery, which undoes it
+		// in case it was made
+		s.log.Started = step.Name
+		err := s.save()
+		if err != nil {
+			s.log.Started = ""
+			return s.fail(step.Name, fmt.Errorf("could not record the step: %w", err))
+		}
+
+		err = step.Action()
+		if err != nil {
+			// a step that timed out may still have been made, it stays
+			// started so it is undone with the others
+			if !maybeApplied(err) {
+				s.log.Started = ""
+			}
+			return s.fail(step.Name, err)
+		}
+
+		s.log.Completed = append(s.log.Completed, step.Name)
+		s.log.Started = ""
+		err = s.save()
+		if err != nil {
+			// recovery would undo the step, so it is undone now
+			s.log.Started = step.Name
+			s.log.Completed = s.log.Completed[:len(s.log.Completed)-1]
+			return s.fail(step.Name, fmt.Errorf("could not record the step: %w", err))
+		}
+	}
+
+	err := s.finish(true)
+	if err != nil {
+		// nothing the saga records was written, undo it so the client can
+		// try again
+		return s.fail(stepFinish, err)
+	}
+	return nil
+}
+
+// maybeApplied says if a step that failed with err may have been made
+// anyway. A step that was turned down was not, a timeout or a broken
+// connection leaves it open, so it has to be undone. The undo of a change
+// of the poll counts is keyed like the change, and fences a change that
+// only arrives after it.
+func maybeApplied(err error) bool {
+	if errors.Is(err, errVoteExists) || errors.Is(err, errDuplicateVote) ||
+		errors.Is(err, errNotFound) || errors.Is(err, errPollNotOpen) {
+		return false
+	}
+	if _, open := client.RetryAfter(err); open {
+		return false
+	}
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// fail compensates the saga after step failed, and returns the error for
+// the client
+func (s *saga) fail(step string, err error) error {
+	log.Printf("Saga %d (%s): step %s failed: %s", s.log.Id, s.log.Kind, step, err.Error())
+	s.compensate()
+	return &sagaError{Step: step, Err: err}
+}
+
+// emit sets the event recorded when the saga completes. It is written in
+// the transaction that takes the saga off the log, so a vote that is not
+// undone always has its event.
+func (s *saga) emit(eventType string, data any) {
+	s.event = &sagaEvent{Type: eventType, Data: data}
+}
+
+// compensate undoes the started step and the completed steps in reverse
+// order. Each one is taken off the log once it is undone, so a recovery
+// that is cut short does not undo it again. If a compensation fails the
+// saga stays in the log, so recovery can try again later. A client that
+// hangs up does not stop the compensations, see detached.
+func (s *saga) compensate() {
+	s.ctx = detached{s.ctx}
+	for s.log.Started != "" || len(s.log.Completed) > 0 {
+		name := s.log.Started
+		if name == "" {
+			name = s.log.Completed[len(s.log.Completed)-1]
+		}
+
+		err := compensations[name](s.ctx, s.api, &s.log)
+		// if the voter or poll is gone there is nothing left to restore
+		if err != nil && !errors.Is(err, errNotFound) {
+			log.Printf("Saga %d (%s): compensation of %s failed: %s", s.log.Id, s.log.Kind, name, err.Error())
+			return
+		}
+
+		if s.log.Started != "" {
+			s.log.Started = ""
+		} else {
+			s.log.Completed = s.log.Completed[:len(s.log.Completed)-1]
+		}
+		err = s.save()
+		if err != nil {
+			log.Printf("Saga %d (%s): could not record the compensation of %s: %s", s.log.Id, s.log.Kind, name, err.Error())
+			return
+		}
+	}
+
+	err := s.finish(false)
+	if err != nil {
+		log.Printf("Saga %d (%s): could not finish: %s", s.log.Id, s.log.Kind, err.Error())
+	}
+}
+
+// completed reports whether every step of the saga completed
+func (s *saga) completed() bool {
+	return len(s.log.Steps) > 0 && s.log.Started == "" && len(s.log.Completed) == len(s.log.Steps)
+}
+
+// restore sets the event and ledger entry of a saga read back from the log.
+// They point at the vote as the saga saved it, or as it was before the saga
+// if it deleted the vote.
+func (s *saga) restore() {
+	vote := s.log.Vote
+	var saved schema.Vote
+	if s.api.getItemFromRedis(redisKeyFromId(vote.Id), &saved) == nil {
+		vote = saved
+	}
+
+	if s.log.EventType != "" {
+		data := gin.H{"vote": &vote}
+		if s.log.PrevVote != nil {
+			data["previous"] = s.log.PrevVote
+		}
+		s.emit(s.log.EventType, data)
+	}
+	if s.log.LedgerAction != "" {
+		s.record(s.log.LedgerAction, &vote)
+	}
+}
+
+// finish removes the saga from the in flight log. If the saga completed,
+// its event is added to the outbox, its entry to the ledger and its vote to
+// the activity of the poll in the same transaction.
+func (s *saga) finish(completed bool) error {
+	v := s.api
+	write := func(pipe redis.Pipeliner) error {
+		pipe.SRem(s.ctx, sagaInFlightKey, s.log.Id)
+		pipe.Do(s.ctx, "JSON.DEL", s.key(), ".")
+		if completed && s.entry != nil {
+			v.recordActivity(s.ctx, pipe, s.entry, s.log.VoterPoll.VotedAt)
+		}
+		if !completed || s.event == nil {
+			return nil
+		}
+		return v.outbox.Add(s.ctx, pipe, s.event.Type, voteSubject(s.log.Vote.Id), s.event.Data)
+	}
+
+	if completed && s.entry != nil {
+		err := v.appendLedger(s.ctx, s.entry, write)
+		if err != nil {
+			return fmt.Errorf("could not append to the ledger: %w", err)
+		}
+		return nil
+	}
+	_, err := v.client.TxPipelined(s.ctx, write)
+	return err
+}
+
+// detached is the context of a request without its deadline and
+// cancellation, for work that has to finish after the client left
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// RecoverSagas finishes or compensates sagas that were left in the log,
+// for example because the service crashed in the middle of a vote. A saga
+// whose steps all completed only missed finish, so it is finished, the
+// others are compensated.
+func (v *VotesAPI) RecoverSagas() {
+	ids, err := v.client.SMembers(v.context, sagaInFlightKey).Result()
+	if err != nil {
+		log.Println("Error reading saga log: " + err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		v.recoverSaga(id)
+	}
+}
+
+// recoverSaga recovers one saga, if no other replica is recovering it
+func (v *VotesAPI) recoverSaga(id string) {
+	leaseKey := SagaKeyPrefix + id + ":lease"
+	token := strconv.FormatInt(time.Now().UnixNano(), 10)
+	leased, err := v.client.SetNX(v.context, leaseKey, token, sagaLeaseTTL).Result()
+	if err != nil {
+		log.Println("Error leasing saga " + id + ": " + err.Error())
+		return
+	}
+	if !leased {
+		return
+	}
+	defer delIfEqualScript.Run(v.context, v.client, []string{leaseKey}, token)
+
+	// read with the lease held, another replica may have just finished it
+	raw, err := v.helper.JSONGet(SagaKeyPrefix+id, ".")
+	if err == redis.Nil {
+		v.client.SRem(v.context, sagaInFlightKey, id)
+		return
+	}
+	if err != nil {
+		log.Println("Error reading saga " + id + ": " + err.Error())
+		return
+	}
+
+	s := &saga{api: v, ctx: v.context}
+	err = json.Unmarshal(raw.([]byte), &s.log)
+	if err != nil {
+		log.Println("Error reading saga " + id + ": " + err.Error())
+		return
+	}
+
+	if time.Since(s.log.StartedAt) < sagaRecoveryAge {
+		return
+	}
+
+	if s.completed() {
+		log.Printf("Saga %d (%s): recovering, all steps completed, finishing", s.log.Id, s.log.Kind)
+		s.restore()
+		err = s.finish(true)
+		if err != nil {
+			log.Printf("Saga %d (%s): could not finish: %s", s.log.Id, s.log.Kind, err.Error())
+		}
+		return
+	}
+
+	log.Printf("Saga %d (%s): recovering, completed steps %v, started %q", s.log.Id, s.log.Kind, s.log.Completed, s.log.Started)
+	s.compensate()
+}
+
+// StartSagaRecovery runs RecoverSagas now and then periodically in the background.
+func (v *VotesAPI) StartSagaRecovery() {
+	v.RecoverSagas()
+	go func() {
+		ticker := time.NewTicker(sagaRecoveryInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			v.RecoverSagas()
+		}
+	}()
+}
+
+// claimStep reserves the vote id, it fails with errVoteExists if it is taken
+func (v *VotesAPI) claimStep(vote *schema.Vote) sagaStep {
+	return sagaStep{Name: stepClaimVote, Action: func() error {
+		claimed, err := v.claimVote(vote)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return errVoteExists
+		}
+		return nil
+	}}
+}
+
+// uniqueStep reserves the poll and voter pair, it fails with errDuplicateVote
+// if the voter already has a vote on the poll
+func (v *VotesAPI) uniqueStep(ctx context.Context, vote *schema.Vote) sagaStep {
+	return sagaStep{Name: stepClaimUnique, Action: func() error {
+		holder, err := v.claimUnique(ctx, vote)
+		if err != nil {
+			return err
+		}
+		if holder != 0 {
+			return fmt.Errorf("%w, vote id=%d", errDuplicateVote, holder)
+		}
+		return nil
+	}}
+}
+
+// sagaFailure picks the status and message sent to the client for a failed saga
+func sagaFailure(err error, vote *schema.Vote) (int, string) {
+	var se *sagaError
+	if !errors.As(err, &se) {
+		return http.StatusInternalServerError, "Could not update vote\n" + err.Error()
+	}
+
+	switch se.Step {
+	case stepClaimVote:
+		if errors.Is(se.Err, errVoteExists) {
+			return http.StatusNotFound, "Vote Id already exists in cache with id=" + redisKeyFromId(vote.Id)
+		}
+		return http.StatusInternalServerError, "Could not save vote to cache"
+	case stepClaimUnique:
+		if errors.Is(se.Err, errDuplicateVote) {
+			return http.StatusConflict, "Voter has already voted on this poll\n" + se.Err.Error()
+		}
+		return http.StatusInternalServerError, "Could not save vote to cache"
+	case stepIncrementPoll, stepDecrementPoll, stepMovePoll:
+		if errors.Is(se.Err, errPollNotOpen) {
+			return http.StatusConflict, "Poll closed, it does not take votes"
+		}
+		return http.StatusInternalServerError, "Could not update poll results in cache"
+	case stepAddVoterPoll, stepRemoveVoter, stepUpdateVoter:
+		return http.StatusInternalServerError, "Could not update voter in cache"
+	case stepSaveVote, stepUpdateVote:
+		return http.StatusInternalServerError, "Could not save vote to cache"
+	case stepDeleteVote:
+		return http.StatusInternalServerError, "Could not delete vote from cache"
+	case stepFinish:
+		// the vote was undone, so the client can send it again
+		if errors.Is(se.Err, errLedgerBusy) {
+			return http.StatusServiceUnavailable, "Nothing was changed, " + se.Err.Error() + ", try again"
+		}
+		return http.StatusInternalServerError, "Nothing was changed, the vote could not be recorded\n" + se.Err.Error()
+	}
+
+	return http.StatusInternalServerError, se.Error()
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/events"
+	"drexel.edu/schema"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// undone records the compensations that ran, in order. The steps that call
+// the voter and poll apis are only recorded, the others run as usual.
+type undone struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (u *undone) list() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string{}, u.steps...)
+}
+
+func recordCompensations(t *testing.T) *undone {
+	t.Helper()
+	u := &undone{}
+	saved := make(map[string]func(ctx context.Context, v *VotesAPI, l *sagaLog) error, len(compensations))
+	for name, compensate := range compensations {
+		saved[name] = compensate
+		name, compensate := name, compensate
+		switch name {
+		case stepIncrementPoll, stepDecrementPoll, stepMovePoll, stepAddVoterPoll, stepRemoveVoter, stepUpdateVoter:
+			compensate = func(ctx context.Context, v *VotesAPI, l *sagaLog) error { return nil }
+		}
+		compensations[name] = func(ctx context.Context, v *VotesAPI, l *sagaLog) error {
+			u.mu.Lock()
+			u.steps = append(u.steps, name)
+			u.mu.Unlock()
+			return compensate(ctx, v, l)
+		}
+	}
+	t.Cleanup(func() {
+		for name, compensate := range saved {
+			compensations[name] = compensate
+		}
+	})
+	return u
+}
+
+// castSteps are the steps of a cast, with the poll and voter steps replaced
+// by fail, which returns the error of the step or nil
+func castSteps(v *VotesAPI, vote *schema.Vote, fail func(step string) error) []sagaStep {
+	step := func(name string, action func() error) sagaStep {
+		return sagaStep{Name: name, Action: func() error {
+			if err := fail(name); err != nil {
+				if action != nil && maybeApplied(err) {
+					// made, but the answer did not arrive
+					action()
+				}
+				return err
+			}
+			if action != nil {
+				return action()
+			}
+			return nil
+		}}
+	}
+	return []sagaStep{
+		v.claimStep(vote),
+		v.uniqueStep(v.context, vote),
+		step(stepIncrementPoll, nil),
+		step(stepAddVoterPoll, nil),
+		step(stepSaveVote, func() error { return v.saveVote(v.context, vote) }),
+	}
+}
+
+// checkUndone checks that nothing the saga wrote is left
+func checkUndone(t *testing.T, v *VotesAPI, s *saga) {
+	t.Helper()
+	vote := s.log.Vote
+	for _, key := range []string{redisKeyFromId(vote.Id), uniqueKey(vote.PollId, vote.VoterId), s.key()} {
+		n, err := v.client.Exists(v.context, key).Result()
+		if err != nil || n != 0 {
+			t.Errorf("%s is left after the saga was undone", key)
+		}
+	}
+	for _, key := range []string{pollIndexKey(vote.PollId), voterIndexKey(vote.VoterId)} {
+		if ids := members(t, v, key); len(ids) != 0 {
+			t.Errorf("%s holds %v after the saga was undone", key, ids)
+		}
+	}
+	inflight, err := v.client.SIsMember(v.context, sagaInFlightKey, s.log.Id).Result()
+	if err != nil || inflight {
+		t.Errorf("saga %d is still in flight after it was undone", s.log.Id)
+	}
+}
+
+func TestDetached(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), key{}, "request"), time.Minute)
+	cancel()
+
+	d := detached{ctx}
+	if d.Err() != nil || d.Done() != nil {
+		t.Errorf("detached context is done: %v", d.Err())
+	}
+	if _, ok := d.Deadline(); ok {
+		t.Errorf("detached context has a deadline")
+	}
+	if d.Value(key{}) != "request" {
+		t.Errorf("detached context lost the values of the request")
+	}
+}
+
+func TestSagaCompensates(t *testing.T) {
+	timeout := errors.New("i/o timeout")
+	tests := []struct {
+		name   string
+		step   string
+		err    error
+		undone []string
+		status int
+	}{
+		{
+			name:   "voter not found",
+			step:   stepAddVoterPoll,
+			err:    fmt.Errorf("could not update voter with id=1: %w", errNotFound),
+			undone: []string{stepIncrementPoll, stepClaimUnique, stepClaimVote},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "poll closed",
+			step:   stepIncrementPoll,
+			err:    fmt.Errorf("poll with id=1: %w", errPollNotOpen),
+			undone: []string{stepClaimUnique, stepClaimVote},
+			status: http.StatusConflict,
+		},
+		{
+			// the step may have been made, so it is undone as well
+			name:   "voter api timed out",
+			step:   stepAddVoterPoll,
+			err:    timeout,
+			undone: []string{stepAddVoterPoll, stepIncrementPoll, stepClaimUnique, stepClaimVote},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "vote saved but the answer was lost",
+			step:   stepSaveVote,
+			err:    timeout,
+			undone: []string{stepSaveVote, stepAddVoterPoll, stepIncrementPoll, stepClaimUnique, stepClaimVote},
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, _ := testVotesAPI(t)
+			u := recordCompensations(t)
+
+			vote := &schema.Vote{Id: 7, PollId: 1, VoterId: 1, VoteValue: 2}
+			s, err := v.newSaga(v.context, sagaCastVote, vote, schema.VoterPoll{PollId: 1, VoteId: 7, VotedAt: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.emit(events.VoteCast, gin.H{"vote": vote})
+
+			err = s.run(castSteps(v, vote, func(step string) error {
+				if step == tt.step {
+					return tt.err
+				}
+				return nil
+			})...)
+
+			var se *sagaError
+			if !errors.As(err, &se) || se.Step != tt.step || !errors.Is(err, tt.err) {
+				t.Fatalf("run returned %v, want the error of %s", err, tt.step)
+			}
+			if status, _ := sagaFailure(err, vote); status != tt.status {
+				t.Errorf("failure answers %d, want %d", status, tt.status)
+			}
+			if got := u.list(); !reflect.DeepEqual(got, tt.undone) {
+				t.Errorf("undone %v, want %v", got, tt.undone)
+			}
+			checkUndone(t, v, s)
+			if n, _ := v.client.LLen(v.context, outboxKey).Result(); n != 0 {
+				t.Errorf("%d events in the outbox after the saga was undone, want none", n)
+			}
+
+			// once undone the vote can be cast again
+			again, err := v.newSaga(v.context, sagaCastVote, vote, schema.VoterPoll{PollId: 1, VoteId: 7, VotedAt: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = again.run(castSteps(v, vote, func(string) error { return nil })...)
+			if err != nil {
+				t.Errorf("casting the vote again failed: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestSagaCompletes(t *testing.T) {
+	v, _ := testVotesAPI(t)
+	u := recordCompensations(t)
+
+	vote := &schema.Vote{Id: 7, PollId: 1, VoterId: 1, VoteValue: 2}
+	s, err := v.newSaga(v.context, sagaCastVote, vote, schema.VoterPoll{PollId: 1, VoteId: 7, VotedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.emit(events.VoteCast, gin.H{"vote": vote})
+
+	err = s.run(castSteps(v, vote, func(string) error { return nil })...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.list(); len(got) != 0 {
+		t.Errorf("undone %v, want nothing", got)
+	}
+
+	var saved schema.Vote
+	err = v.getItemFromRedis(redisKeyFromId(vote.Id), &saved)
+	if err != nil || saved.Id != vote.Id {
+		t.Errorf("vote is not saved: %v", err)
+	}
+	if n, _ := v.client.Exists(v.context, s.key()).Result(); n != 0 {
+		t.Errorf("saga is still in the log")
+	}
+	if n, _ := v.client.LLen(v.context, outboxKey).Result(); n != 1 {
+		t.Errorf("%d events in the outbox, want 1", n)
+	}
+
+	// a second cast of the same vote id is turned down before it writes
+	// anything, and undoes nothing of the first
+	second := &schema.Vote{Id: 7, PollId: 2, VoterId: 2, VoteValue: 1}
+	s, err = v.newSaga(v.context, sagaCastVote, second, schema.VoterPoll{PollId: 2, VoteId: 7, VotedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.run(castSteps(v, second, func(string) error { return nil })...)
+	if !errors.Is(err, errVoteExists) {
+		t.Fatalf("casting vote 7 twice returned %v, want errVoteExists", err)
+	}
+	if status, _ := sagaFailure(err, second); status != http.StatusNotFound {
+		t.Errorf("failure of the second cast answers %d, want 404", status)
+	}
+	err = v.getItemFromRedis(redisKeyFromId(vote.Id), &saved)
+	if err != nil || saved.PollId != vote.PollId {
+		t.Errorf("the first vote was changed: %+v, %v", saved, err)
+	}
+}
+
+func TestRecoverSagas(t *testing.T) {
+	v, _ := testVotesAPI(t)
+	u := recordCompensations(t)
+
+	// a saga that crashed while the voter was updated, and one that crashed
+	// after its last step
+	crashed := func(vote *schema.Vote, completed []string, started string) *saga {
+		t.Helper()
+		s, err := v.newSaga(v.context, sagaCastVote, vote, schema.VoterPoll{PollId: vote.PollId, VoteId: vote.Id, VotedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.emit(events.VoteCast, gin.H{"vote": vote})
+		for _, step := range castSteps(v, vote, func(string) error { return nil }) {
+			s.log.Steps = append(s.log.Steps, step.Name)
+			if step.Name == started || len(s.log.Completed) < len(completed) {
+				err = step.Action()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(s.log.Completed) < len(completed) {
+				s.log.Completed = append(s.log.Completed, step.Name)
+			}
+		}
+		s.log.Started = started
+		s.log.EventType = events.VoteCast
+		s.log.StartedAt = time.Now().Add(-2 * sagaRecoveryAge)
+		err = s.save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	halfway := crashed(&schema.Vote{Id: 1, PollId: 1, VoterId: 1, VoteValue: 1},
+		[]string{stepClaimVote, stepClaimUnique, stepIncrementPoll}, stepAddVoterPoll)
+	done := crashed(&schema.Vote{Id: 2, PollId: 2, VoterId: 2, VoteValue: 1},
+		[]string{stepClaimVote, stepClaimUnique, stepIncrementPoll, stepAddVoterPoll, stepSaveVote}, "")
+
+	// a saga that is younger than sagaRecoveryAge is left to its replica
+	young, err := v.newSaga(v.context, sagaCastVote, &schema.Vote{Id: 3, PollId: 1, VoterId: 3}, schema.VoterPoll{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v.RecoverSagas()
+
+	want := []string{stepAddVoterPoll, stepIncrementPoll, stepClaimUnique, stepClaimVote}
+	if got := u.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("recovery undid %v, want %v", got, want)
+	}
+	checkUndone(t, v, halfway)
+
+	// the completed saga is finished, with its event
+	var saved schema.Vote
+	err = v.getItemFromRedis(redisKeyFromId(2), &saved)
+	if err != nil {
+		t.Errorf("vote of the completed saga is gone: %s", err.Error())
+	}
+	if n, _ := v.client.Exists(v.context, done.key()).Result(); n != 0 {
+		t.Errorf("completed saga is still in the log")
+	}
+	if n, _ := v.client.LLen(v.context, outboxKey).Result(); n != 1 {
+		t.Errorf("%d events in the outbox, want the one of the completed saga", n)
+	}
+
+	inflight := members(t, v, sagaInFlightKey)
+	if !reflect.DeepEqual(inflight, []int{young.log.Id}) {
+		t.Errorf("sagas in flight are %v, want only %d", inflight, young.log.Id)
+	}
+	if _, err := v.client.Get(v.context, SagaKeyPrefix+strconv.Itoa(young.log.Id)+":lease").Result(); err != redis.Nil {
+		t.Errorf("lease of saga %d is held after recovery", young.log.Id)
+	}
+}
@@ -3,10 +3,13 @@ module drexel.edu/votes
 go 1.20
 
 require (
+	drexel.edu/client v0.0.0
+	drexel.edu/events v0.0.0
+	drexel.edu/schema v0.0.0
+	drexel.edu/service v0.0.0
 	github.com/gin-contrib/cors v1.4.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-redis/redis/v8 v8.11.5
-	github.com/go-resty/resty/v2 v2.7.0
 	github.com/nitishm/go-rejson/v4 v4.1.0
 )
 
@@ -21,6 +24,7 @@ require (
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/go-playground/validator/v10 v10.14.1 // indirect
+	github.com/go-resty/resty/v2 v2.7.0 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
@@ -39,3 +43,10 @@ require (
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	drexel.edu/client v0.0.0 => ../client
+	drexel.edu/events v0.0.0 => ../events
+	drexel.edu/schema v0.0.0 => ../schema
+	drexel.edu/service v0.0.0 => ../service
+)
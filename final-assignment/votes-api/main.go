@@ -17,6 +17,11 @@ var (
 	portFlag  uint
 	pollsURL  string
 	votersURL string
+
+	rebuildIndexes   bool
+	migrateOptionIds bool
+	fsck             bool
+	repair           bool
 )
 
 func processCmdLineFlags() {
@@ -29,6 +34,12 @@ func processCmdLineFlags() {
 	// flags for internal api
 	flag.StringVar(&pollsURL, "polls", "http://localhost:1082/polls", "Default polls location")
 	flag.StringVar(&votersURL, "voters", "http://localhost:1081/voters", "Default voters location")
+
+	// maintenance commands, these run and exit instead of starting the server
+	flag.BoolVar(&rebuildIndexes, "rebuild-indexes", false, "Rebuild the votes by poll/voter indexes and exit")
+	flag.BoolVar(&migrateOptionIds, "migrate-option-ids", false, "Change the stored votes from option positions to option ids and exit")
+	flag.BoolVar(&fsck, "fsck", false, "Check the poll results, voter histories and vote indexes against the votes, print what differs and exit")
+	flag.BoolVar(&repair, "repair", false, "With -fsck, also fix what differs")
 	flag.Parse()
 }
 
@@ -83,6 +94,56 @@ func main() {
 		os.Exit(1)
 	}
 
+	if rebuildIndexes {
+		err = apiHandler.RebuildIndexes()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if migrateOptionIds {
+		err = apiHandler.MigrateOptionIds()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if fsck {
+		found, err := apiHandler.Fsck(repair)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		unrepaired := 0
+		for _, d := range found {
+			fmt.Println(d)
+			if !d.Repaired {
+				unrepaired++
+			}
+		}
+		fmt.Printf("%d discrepancies, %d repaired\n", len(found), len(found)-unrepaired)
+		if unrepaired > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// page votes created before their ids were kept
+	err = apiHandler.TrackIds()
+	if err != nil {
+		log.Println("Could not track the vote ids: " + err.Error())
+	}
+
+	// undo votes that were left half written by a crash
+	apiHandler.StartSagaRecovery()
+
+	// move vote events from the outbox to the event stream
+	apiHandler.StartEventRelay()
+
 	// r.DELETE("/voters", apiHandler.DeleteAllVoter)
 	r.GET("/", apiHandler.GetVotes)
 	r.GET("/crash", apiHandler.CrashSim)
@@ -91,8 +152,14 @@ func main() {
 	r.GET("/votes", apiHandler.GetVotes)
 	r.GET("/votes/voters/:voterId", apiHandler.GetVotesByVoter)
 	r.GET("/votes/polls/:pollId", apiHandler.GetVotesByPolls)
+	r.GET("/votes/polls/:pollId/export", apiHandler.ExportVotesByPoll)
+	r.POST("/votes", apiHandler.Idempotent(apiHandler.CreateVote))
+	r.POST("/votes/import", apiHandler.ImportVotes)
 	r.POST("/votes/:voteId", apiHandler.PostVote)
+	r.PUT("/votes/:voteId", apiHandler.UpdateVote)
 	r.DELETE("/votes/:voteId", apiHandler.DeleteVote)
+	r.DELETE("/votes/voters/:voterId", apiHandler.DeleteVotesByVoter)
+	r.DELETE("/votes/polls/:pollId", apiHandler.DeleteVotesByPoll)
 
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	r.Run(serverPath)